@@ -41,8 +41,7 @@ func (m *UuidModule) AllocateUUID(ctx context.Context, name string) (*msg.UuidRa
 	}
 
 	// 获取或创建 UUID 记录
-	pk := &center.UuidPK{Name: name}
-	record, err := xdb.Get[*entity.UUIDEntity](ctx, pk)
+	record, err := center.GetUuid[*entity.UUIDEntity](ctx, name)
 	if err != nil {
 		clog.Errorf("[UuidModule] get uuid record failed: %v", err)
 		return nil, ErrDBError
@@ -69,15 +68,20 @@ func (m *UuidModule) AllocateUUID(ctx context.Context, name string) (*msg.UuidRa
 	startValue := record.Value + 1
 	endValue := record.Value + UUIDBatchSize
 
-	// 更新记录
-	record.Value = endValue
-	record.Mtime = time.Now().Unix()
-
-	// 标记字段变更
-	record.GetHeader().SetChanged(center.UuidFieldValue, center.UuidFieldMtime)
+	// 更新记录，setter 自动标记字段变更
+	if err = record.SetValue(endValue); err == nil {
+		err = record.SetMtime(time.Now().Unix())
+	}
+	if err != nil {
+		clog.Errorf("[UuidModule] update uuid record failed: %v", err)
+		return nil, ErrDBError
+	}
 
 	// 保存到数据库
-	xdb.Save(ctx, record)
+	if err = xdb.Save(ctx, record); err != nil {
+		clog.Errorf("[UuidModule] save uuid record failed: %v", err)
+		return nil, ErrDBError
+	}
 
 	clog.Infof("[UuidModule] allocated UUID range: name=%s, start=%d, end=%d", name, startValue, endValue)
 
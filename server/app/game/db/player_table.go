@@ -1,9 +1,12 @@
 package db
 
 import (
+	"context"
+
 	ctime "github.com/cherry-game/cherry/extend/time"
 	clog "github.com/cherry-game/cherry/logger"
 	cproto "github.com/cherry-game/cherry/net/proto"
+	gendb "lucky/server/gen/db"
 	"lucky/server/pkg/code"
 	"lucky/server/pkg/data"
 	"lucky/server/pkg/guid"
@@ -93,9 +96,18 @@ func PlayerNameIsExist(playerName string) (int64, bool) {
 		return playerId, true
 	}
 
-	// TODO 从数据库查，数据存在先保存到 playerNameCache
+	// 按 name 唯一索引从数据库查，查询失败时按已存在处理，避免创建重名角色
+	player, err := gendb.GetPlayerByName[*gendb.PlayerRecord](context.Background(), playerName)
+	if err != nil {
+		clog.Warnf("get player by name fail. [name = %s, err = %v]", playerName, err)
+		return 0, true
+	}
+	if player == nil {
+		return 0, false
+	}
 
-	return 0, false
+	playerNameCache.Put(playerName, player.PlayerId)
+	return player.PlayerId, true
 }
 
 // GetPlayerIds 批量查询玩家id(过滤不存在的)
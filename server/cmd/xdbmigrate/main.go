@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "lucky/server/gen/db"        // 注册数据源
+	_ "lucky/server/gen/db/center" // 注册数据源
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/mysql"
+)
+
+// xdbmigrate 比较已注册数据源与 MySQL 中的表结构，默认只输出差异，-apply 时执行
+func main() {
+	host := flag.String("host", "127.0.0.1", "MySQL host")
+	port := flag.Int("port", 3306, "MySQL port")
+	user := flag.String("user", "root", "MySQL user")
+	password := flag.String("password", "", "MySQL password")
+	dbName := flag.String("db", "", "database name")
+	tables := flag.String("tables", "", "comma separated tables to migrate, all registered tables by default")
+	shards := flag.String("shards", "", "comma separated table:count, migrate every physical table of the sharded tables")
+	apply := flag.Bool("apply", false, "execute the changes instead of printing the diff only")
+	destructive := flag.Bool("allow-destructive", false, "allow dropping columns, changing column types, primary keys and indexes")
+	flag.Parse()
+
+	if *dbName == "" {
+		fmt.Fprintln(os.Stderr, "missing -db")
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	dao, err := xdb.GetDriver("mysql").NewDao(ctx, &mysql.DaoOptions{
+		DBName:       *dbName,
+		Host:         *host,
+		Port:         int32(*port),
+		Username:     *user,
+		Password:     *password,
+		MaxOpenConns: 1,
+		QueryTimeout: 10 * time.Second,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	srcs, err := shardSources(selectSources(*tables), *shards)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	changes, err := dao.(xdb.Migrator).Migrate(ctx, srcs, &xdb.MigrateOptions{
+		DryRun:           !*apply,
+		AllowDestructive: *destructive,
+	})
+	for _, change := range changes {
+		if change.Destructive {
+			fmt.Println("-- destructive")
+		}
+		fmt.Println(change.SQL + ";")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(os.Stderr, "schema is up to date")
+	}
+}
+
+// selectSources 有表名的数据源，按表名排序
+func selectSources(tables string) []*xdb.Source {
+	only := map[string]bool{}
+	for _, table := range strings.Split(tables, ",") {
+		if table = strings.TrimSpace(table); table != "" {
+			only[table] = true
+		}
+	}
+
+	var srcs []*xdb.Source
+	for _, src := range xdb.Sources() {
+		if src.TableName != "" && (len(only) == 0 || only[src.TableName]) {
+			srcs = append(srcs, src)
+		}
+	}
+	sort.Slice(srcs, func(i, j int) bool { return srcs[i].TableName < srcs[j].TableName })
+	return srcs
+}
+
+// shardSources 把分表的数据源替换为各个分片，shards 格式为 table:count，以逗号分隔
+func shardSources(srcs []*xdb.Source, shards string) ([]*xdb.Source, error) {
+	counts := map[string]int{}
+	for _, part := range strings.Split(shards, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		i := strings.LastIndex(part, ":")
+		count, err := strconv.Atoi(part[i+1:])
+		if i <= 0 || err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid -shards %q, expect table:count", part)
+		}
+		counts[part[:i]] = count
+	}
+
+	var ret []*xdb.Source
+	for _, src := range srcs {
+		count, ok := counts[src.TableName]
+		if !ok {
+			ret = append(ret, src)
+			continue
+		}
+		list, err := src.Shards(&xdb.ShardOptions{Count: count}, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, shard := range list {
+			ret = append(ret, shard.Source)
+		}
+	}
+	return ret, nil
+}
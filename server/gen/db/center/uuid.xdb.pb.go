@@ -26,6 +26,13 @@ type UuidPK struct {
 	Name string
 }
 
+// NewUuidPK 创建完整的主键
+func NewUuidPK(name string) *UuidPK {
+	return &UuidPK{
+		Name: name,
+	}
+}
+
 func (pk *UuidPK) Source() *xdb.Source {
 	return _UuidSource
 }
@@ -35,29 +42,29 @@ func (pk *UuidPK) String() string {
 }
 
 func (pk *UuidPK) HashGroup() int {
-	return int(pk.Name % 16)
+	return int(xdb.HashString(pk.Name) % 16)
 }
 
 func (pk *UuidPK) Empty() bool {
-	return pk.Name == 0
+	return pk.Name == ""
 }
 
+// PrefixOf 本主键的有效字段与 key 的对应字段全部相等
 func (pk *UuidPK) PrefixOf(key xdb.Key) bool {
-	_, ok := key.(*UuidPK)
+	other, ok := key.(*UuidPK)
 	if !ok {
 		return false
 	}
-	// TODO: 实现前缀匹配逻辑
-	return false
+	return pk.Empty() || pk.Name == other.Name
 }
 
 func (pk *UuidPK) Full() bool {
-	return pk.Name != 0
+	return pk.Name != ""
 }
 
 func (pk *UuidPK) FetchFilter() interface{} {
 	filter := make(map[string]interface{})
-	if pk.Name != 0 {
+	if !pk.Empty() {
 		filter["name"] = pk.Name
 	}
 	return filter
@@ -86,7 +93,11 @@ func (r *UuidRecord) Snapshoot() interface{} {
 }
 
 func (r *UuidRecord) XVersion() int64 {
-	return 0
+	return r.Uuid.XVersion
+}
+
+func (r *UuidRecord) SetXVersion(version int64) {
+	r.Uuid.XVersion = version
 }
 
 func (r *UuidRecord) GetHeader() *xdb.Header {
@@ -140,12 +151,17 @@ func (r *UuidRecord) Delete(ctx context.Context) bool {
 }
 
 func (r *UuidRecord) Commit(ctx context.Context) (xdb.Commitment, xdb.FieldSet) {
+	changes := r.Header.Changes()
 	commitment := &UuidCommitment{
 		data:      &r.Uuid,
-		changes:   r.Header.Changes(),
+		changes:   changes,
 		lifecycle: r.Header.Lifecycle(),
+		base:      r.Uuid.XVersion,
+		version:   r.Uuid.XVersion + 1,
 	}
-	return commitment, r.Header.Changes()
+	r.Uuid.XVersion = commitment.version
+	r.Header.Commit()()
+	return commitment, changes
 }
 
 func (r *UuidRecord) Committing() bool {
@@ -164,6 +180,60 @@ func (r *UuidRecord) SetSavingIndex(idx int32) {
 	r.Header.SavingIndex = idx
 }
 
+// SetValue 设置 value
+func (r *UuidRecord) SetValue(v int64) error {
+	if r.Value == v {
+		return nil
+	}
+	if err := r.Header.CheckWrite("value", false); err != nil {
+		return err
+	}
+	r.Value = v
+	r.Header.SetChanged(UuidFieldValue)
+	return nil
+}
+
+// AddValue value 增加 delta
+func (r *UuidRecord) AddValue(delta int64) error {
+	return r.SetValue(r.Value + delta)
+}
+
+// SetCtime 设置 ctime
+func (r *UuidRecord) SetCtime(v int64) error {
+	if r.Ctime == v {
+		return nil
+	}
+	if err := r.Header.CheckWrite("ctime", false); err != nil {
+		return err
+	}
+	r.Ctime = v
+	r.Header.SetChanged(UuidFieldCtime)
+	return nil
+}
+
+// AddCtime ctime 增加 delta
+func (r *UuidRecord) AddCtime(delta int64) error {
+	return r.SetCtime(r.Ctime + delta)
+}
+
+// SetMtime 设置 mtime
+func (r *UuidRecord) SetMtime(v int64) error {
+	if r.Mtime == v {
+		return nil
+	}
+	if err := r.Header.CheckWrite("mtime", false); err != nil {
+		return err
+	}
+	r.Mtime = v
+	r.Header.SetChanged(UuidFieldMtime)
+	return nil
+}
+
+// AddMtime mtime 增加 delta
+func (r *UuidRecord) AddMtime(delta int64) error {
+	return r.SetMtime(r.Mtime + delta)
+}
+
 var _UuidSource = &xdb.Source{
 	ProtoType:  reflect.TypeOf((*Uuid)(nil)).Elem(),
 	RecordType: reflect.TypeOf((*UuidRecord)(nil)).Elem(),
@@ -172,14 +242,26 @@ var _UuidSource = &xdb.Source{
 	DriverName: "none",
 	TableName:  "uuid",
 	KeySize:    1,
+	Versioned:  true,
+	Fields: []*xdb.FieldDesc{
+		{Code: UuidFieldName, Name: "name", Key: true},
+		{Code: UuidFieldValue, Name: "value"},
+		{Code: UuidFieldCtime, Name: "ctime"},
+		{Code: UuidFieldMtime, Name: "mtime"},
+	},
+	FieldSetSave: xdb.MakeFieldSet(UuidFieldName, UuidFieldValue, UuidFieldCtime, UuidFieldMtime),
 
+	// 参数按主键字段的类型无损转换，类型不符时返回错误，见 xdb.PKArg
 	PKCreator: func(args []interface{}) (xdb.PK, error) {
 		if len(args) < 1 {
 			return nil, fmt.Errorf("invalid args count")
 		}
-		return &UuidPK{
-			Name: args[0].(string),
-		}, nil
+		pk := &UuidPK{}
+		var err error
+		if pk.Name, err = xdb.PKArg[string](args, 0, "name"); err != nil {
+			return nil, err
+		}
+		return pk, nil
 	},
 
 	PKOf: func(obj interface{}) xdb.PK {
@@ -189,8 +271,14 @@ var _UuidSource = &xdb.Source{
 				Name: r.Name,
 			}
 		}
-		// 支持 proto 类型
-		if p, ok := obj.(*Uuid); ok {
+		// 支持 proto 类型，以及嵌入 Record 的 Model 类型
+		p, ok := obj.(*Uuid)
+		if !ok {
+			if r, isRecord := obj.(xdb.Record); isRecord {
+				p, ok = r.Snapshoot().(*Uuid)
+			}
+		}
+		if ok {
 			return &UuidPK{
 				Name: p.Name,
 			}
@@ -225,6 +313,8 @@ type UuidCommitment struct {
 	data      *Uuid
 	changes   xdb.FieldSet
 	lifecycle xdb.Lifecycle
+	base      int64
+	version   int64
 }
 
 func (c *UuidCommitment) Source() *xdb.Source {
@@ -237,6 +327,7 @@ func (c *UuidCommitment) Merge(other xdb.Commitment) bool {
 		return false
 	}
 	c.changes = c.changes.Union(otherC.changes)
+	c.version = otherC.version
 	return true
 }
 
@@ -261,12 +352,38 @@ func (c *UuidCommitment) Unmarshal(data []byte) error {
 	return proto.Unmarshal(data, c.data)
 }
 
-func init() {
-	_UuidSource.PKOf = func(obj interface{}) xdb.PK {
-		r := obj.(*UuidRecord)
-		return &UuidPK{
-			Name: r.Name,
-		}
+func (c *UuidCommitment) RecoverMeta(lifecycle xdb.Lifecycle, changes xdb.FieldSet, base int64) {
+	c.lifecycle = lifecycle
+	c.changes = changes
+	c.version = c.data.XVersion
+	c.base = base
+	if lifecycle == xdb.LifecycleNew {
+		c.base = 0
+	} else if base < 0 {
+		// 没有记录基准版本时按快照中的版本推算
+		c.base = c.version - 1
 	}
+}
+
+func (c *UuidCommitment) BaseVersion() int64 {
+	return c.base
+}
+
+func (c *UuidCommitment) Version() int64 {
+	return c.version
+}
+
+// Rebase 只修改提交对象的版本，数据是模型持有的消息，由保存协程调用时不能写入
+func (c *UuidCommitment) Rebase(version int64) {
+	c.base = version
+	c.version = version + 1
+}
+
+// GetUuid 按主键获取记录，T 为 *UuidRecord 或注册的 Model 类型，不存在时返回 nil
+func GetUuid[T xdb.Record](ctx context.Context, name string) (T, error) {
+	return xdb.Get[T](ctx, NewUuidPK(name))
+}
+
+func init() {
 	xdb.RegisterSource(_UuidSource)
 }
@@ -2,7 +2,7 @@
 // versions:
 // 	protoc-gen-go v1.36.10
 // 	protoc        v6.33.0
-// source: player.proto
+// source: db/proto/player.proto
 
 package db
 
@@ -38,7 +38,7 @@ type Player struct {
 
 func (x *Player) Reset() {
 	*x = Player{}
-	mi := &file_player_proto_msgTypes[0]
+	mi := &file_db_proto_player_proto_msgTypes[0]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -50,7 +50,7 @@ func (x *Player) String() string {
 func (*Player) ProtoMessage() {}
 
 func (x *Player) ProtoReflect() protoreflect.Message {
-	mi := &file_player_proto_msgTypes[0]
+	mi := &file_db_proto_player_proto_msgTypes[0]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -63,7 +63,7 @@ func (x *Player) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Player.ProtoReflect.Descriptor instead.
 func (*Player) Descriptor() ([]byte, []int) {
-	return file_player_proto_rawDescGZIP(), []int{0}
+	return file_db_proto_player_proto_rawDescGZIP(), []int{0}
 }
 
 func (x *Player) GetPlayerId() int64 {
@@ -130,7 +130,7 @@ type Item struct {
 
 func (x *Item) Reset() {
 	*x = Item{}
-	mi := &file_player_proto_msgTypes[1]
+	mi := &file_db_proto_player_proto_msgTypes[1]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -142,7 +142,7 @@ func (x *Item) String() string {
 func (*Item) ProtoMessage() {}
 
 func (x *Item) ProtoReflect() protoreflect.Message {
-	mi := &file_player_proto_msgTypes[1]
+	mi := &file_db_proto_player_proto_msgTypes[1]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -155,7 +155,7 @@ func (x *Item) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Item.ProtoReflect.Descriptor instead.
 func (*Item) Descriptor() ([]byte, []int) {
-	return file_player_proto_rawDescGZIP(), []int{1}
+	return file_db_proto_player_proto_rawDescGZIP(), []int{1}
 }
 
 func (x *Item) GetPlayerId() int64 {
@@ -200,45 +200,45 @@ func (x *Item) GetMtime() int64 {
 	return 0
 }
 
-var File_player_proto protoreflect.FileDescriptor
+var File_db_proto_player_proto protoreflect.FileDescriptor
 
-const file_player_proto_rawDesc = "" +
+const file_db_proto_player_proto_rawDesc = "" +
 	"\n" +
-	"\fplayer.proto\x12\x02db\x1a\x0fextension.proto\"\xab\x02\n" +
-	"\x06Player\x12-\n" +
-	"\tplayer_id\x18\x01 \x01(\x03B\x10\x88\x94#\x01ʔ#\b玩家IDR\bplayerId\x12$\n" +
-	"\x04name\x18\x02 \x01(\tB\x10ʔ#\f玩家名称R\x04name\x12&\n" +
+	"\x15db/proto/player.proto\x12\x02db\x1a\x0fextension.proto\"\xb3\x02\n" +
+	"\x06Player\x121\n" +
+	"\tplayer_id\x18\x01 \x01(\x03B\x14\x88\x94#\x01\xc0\x94#\x01ʔ#\b玩家IDR\bplayerId\x12(\n" +
+	"\x04name\x18\x02 \x01(\tB\x14\x90\x94#\x01ʔ#\f玩家名称R\x04name\x12&\n" +
 	"\x05level\x18\x03 \x01(\x05B\x10ʔ#\f玩家等级R\x05level\x12\x1f\n" +
 	"\x03exp\x18\x04 \x01(\x03B\rʔ#\t经验值R\x03exp\x12\x1f\n" +
 	"\b_version\x18\x05 \x01(\x03B\x04\x98\x94#\x01R\aVersion\x12&\n" +
 	"\x05ctime\x18\x06 \x01(\x03B\x10ʔ#\f创建时间R\x05ctime\x12&\n" +
-	"\x05mtime\x18\a \x01(\x03B\x10ʔ#\f修改时间R\x05mtime:\x12\xd2\xd5\"\x06player\xd8\xd5\"\x01\xf0\xd5\"\x01\"\x87\x02\n" +
-	"\x04Item\x12-\n" +
-	"\tplayer_id\x18\x01 \x01(\x03B\x10\x88\x94#\x01ʔ#\b玩家IDR\bplayerId\x12)\n" +
-	"\aitem_id\x18\x02 \x01(\x05B\x10\x88\x94#\x01ʔ#\b道具IDR\x06itemId\x12&\n" +
-	"\x05count\x18\x03 \x01(\x03B\x10ʔ#\f道具数量R\x05count\x12\x1f\n" +
+	"\x05mtime\x18\a \x01(\x03B\x10ʔ#\f修改时间R\x05mtime:\x12\xd2\xd5\"\x06player\xd8\xd5\"\x01\xf0\xd5\"\x01\"\x93\x02\n" +
+	"\x04Item\x121\n" +
+	"\tplayer_id\x18\x01 \x01(\x03B\x14\x88\x94#\x01\xc0\x94#\x01ʔ#\b玩家IDR\bplayerId\x12)\n" +
+	"\aitem_id\x18\x02 \x01(\x05B\x10\x88\x94#\x01ʔ#\b道具IDR\x06itemId\x12*\n" +
+	"\x05count\x18\x03 \x01(\x03B\x14\xb8\x94#\x01ʔ#\f道具数量R\x05count\x12\x1f\n" +
 	"\b_version\x18\x04 \x01(\x03B\x04\x98\x94#\x01R\aVersion\x12&\n" +
 	"\x05ctime\x18\x05 \x01(\x03B\x10ʔ#\f创建时间R\x05ctime\x12&\n" +
-	"\x05mtime\x18\x06 \x01(\x03B\x10ʔ#\f修改时间R\x05mtime:\f\xd2\xd5\"\x04item\xd8\xd5\"\x01B\x15Z\x13lucky/server/gen/dbb\x06proto3"
+	"\x05mtime\x18\x06 \x01(\x03B\x10ʔ#\f修改时间R\x05mtime:\x10\xd2\xd5\"\x04item\xd8\xd5\"\x01\x88\xd6\"\x01B\x15Z\x13lucky/server/gen/dbb\x06proto3"
 
 var (
-	file_player_proto_rawDescOnce sync.Once
-	file_player_proto_rawDescData []byte
+	file_db_proto_player_proto_rawDescOnce sync.Once
+	file_db_proto_player_proto_rawDescData []byte
 )
 
-func file_player_proto_rawDescGZIP() []byte {
-	file_player_proto_rawDescOnce.Do(func() {
-		file_player_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_player_proto_rawDesc), len(file_player_proto_rawDesc)))
+func file_db_proto_player_proto_rawDescGZIP() []byte {
+	file_db_proto_player_proto_rawDescOnce.Do(func() {
+		file_db_proto_player_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_db_proto_player_proto_rawDesc), len(file_db_proto_player_proto_rawDesc)))
 	})
-	return file_player_proto_rawDescData
+	return file_db_proto_player_proto_rawDescData
 }
 
-var file_player_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
-var file_player_proto_goTypes = []any{
+var file_db_proto_player_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_db_proto_player_proto_goTypes = []any{
 	(*Player)(nil), // 0: db.Player
 	(*Item)(nil),   // 1: db.Item
 }
-var file_player_proto_depIdxs = []int32{
+var file_db_proto_player_proto_depIdxs = []int32{
 	0, // [0:0] is the sub-list for method output_type
 	0, // [0:0] is the sub-list for method input_type
 	0, // [0:0] is the sub-list for extension type_name
@@ -246,26 +246,26 @@ var file_player_proto_depIdxs = []int32{
 	0, // [0:0] is the sub-list for field type_name
 }
 
-func init() { file_player_proto_init() }
-func file_player_proto_init() {
-	if File_player_proto != nil {
+func init() { file_db_proto_player_proto_init() }
+func file_db_proto_player_proto_init() {
+	if File_db_proto_player_proto != nil {
 		return
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
-			RawDescriptor: unsafe.Slice(unsafe.StringData(file_player_proto_rawDesc), len(file_player_proto_rawDesc)),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_db_proto_player_proto_rawDesc), len(file_db_proto_player_proto_rawDesc)),
 			NumEnums:      0,
 			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
-		GoTypes:           file_player_proto_goTypes,
-		DependencyIndexes: file_player_proto_depIdxs,
-		MessageInfos:      file_player_proto_msgTypes,
+		GoTypes:           file_db_proto_player_proto_goTypes,
+		DependencyIndexes: file_db_proto_player_proto_depIdxs,
+		MessageInfos:      file_db_proto_player_proto_msgTypes,
 	}.Build()
-	File_player_proto = out.File
-	file_player_proto_goTypes = nil
-	file_player_proto_depIdxs = nil
+	File_db_proto_player_proto = out.File
+	file_db_proto_player_proto_goTypes = nil
+	file_db_proto_player_proto_depIdxs = nil
 }
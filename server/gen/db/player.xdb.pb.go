@@ -28,6 +28,13 @@ type PlayerPK struct {
 	PlayerId int64
 }
 
+// NewPlayerPK 创建完整的主键
+func NewPlayerPK(playerId int64) *PlayerPK {
+	return &PlayerPK{
+		PlayerId: playerId,
+	}
+}
+
 func (pk *PlayerPK) Source() *xdb.Source {
 	return _PlayerSource
 }
@@ -44,13 +51,13 @@ func (pk *PlayerPK) Empty() bool {
 	return pk.PlayerId == 0
 }
 
+// PrefixOf 本主键的有效字段与 key 的对应字段全部相等
 func (pk *PlayerPK) PrefixOf(key xdb.Key) bool {
-	_, ok := key.(*PlayerPK)
+	other, ok := key.(*PlayerPK)
 	if !ok {
 		return false
 	}
-	// TODO: 实现前缀匹配逻辑
-	return false
+	return pk.Empty() || pk.PlayerId == other.PlayerId
 }
 
 func (pk *PlayerPK) Full() bool {
@@ -59,7 +66,7 @@ func (pk *PlayerPK) Full() bool {
 
 func (pk *PlayerPK) FetchFilter() interface{} {
 	filter := make(map[string]interface{})
-	if pk.PlayerId != 0 {
+	if !pk.Empty() {
 		filter["player_id"] = pk.PlayerId
 	}
 	return filter
@@ -88,7 +95,11 @@ func (r *PlayerRecord) Snapshoot() interface{} {
 }
 
 func (r *PlayerRecord) XVersion() int64 {
-	return 0
+	return r.Player.XVersion
+}
+
+func (r *PlayerRecord) SetXVersion(version int64) {
+	r.Player.XVersion = version
 }
 
 func (r *PlayerRecord) GetHeader() *xdb.Header {
@@ -148,12 +159,17 @@ func (r *PlayerRecord) Delete(ctx context.Context) bool {
 }
 
 func (r *PlayerRecord) Commit(ctx context.Context) (xdb.Commitment, xdb.FieldSet) {
+	changes := r.Header.Changes()
 	commitment := &PlayerCommitment{
 		data:      &r.Player,
-		changes:   r.Header.Changes(),
+		changes:   changes,
 		lifecycle: r.Header.Lifecycle(),
+		base:      r.Player.XVersion,
+		version:   r.Player.XVersion + 1,
 	}
-	return commitment, r.Header.Changes()
+	r.Player.XVersion = commitment.version
+	r.Header.Commit()()
+	return commitment, changes
 }
 
 func (r *PlayerRecord) Committing() bool {
@@ -172,6 +188,91 @@ func (r *PlayerRecord) SetSavingIndex(idx int32) {
 	r.Header.SavingIndex = idx
 }
 
+// SetName 设置 name
+func (r *PlayerRecord) SetName(v string) error {
+	if r.Name == v {
+		return nil
+	}
+	if err := r.Header.CheckWrite("name", false); err != nil {
+		return err
+	}
+	r.Name = v
+	r.Header.SetChanged(PlayerFieldName)
+	return nil
+}
+
+// SetLevel 设置 level
+func (r *PlayerRecord) SetLevel(v int32) error {
+	if r.Level == v {
+		return nil
+	}
+	if err := r.Header.CheckWrite("level", false); err != nil {
+		return err
+	}
+	r.Level = v
+	r.Header.SetChanged(PlayerFieldLevel)
+	return nil
+}
+
+// AddLevel level 增加 delta
+func (r *PlayerRecord) AddLevel(delta int32) error {
+	return r.SetLevel(r.Level + delta)
+}
+
+// SetExp 设置 exp
+func (r *PlayerRecord) SetExp(v int64) error {
+	if r.Exp == v {
+		return nil
+	}
+	if err := r.Header.CheckWrite("exp", false); err != nil {
+		return err
+	}
+	r.Exp = v
+	r.Header.SetChanged(PlayerFieldExp)
+	return nil
+}
+
+// AddExp exp 增加 delta
+func (r *PlayerRecord) AddExp(delta int64) error {
+	return r.SetExp(r.Exp + delta)
+}
+
+// SetCtime 设置 ctime
+func (r *PlayerRecord) SetCtime(v int64) error {
+	if r.Ctime == v {
+		return nil
+	}
+	if err := r.Header.CheckWrite("ctime", false); err != nil {
+		return err
+	}
+	r.Ctime = v
+	r.Header.SetChanged(PlayerFieldCtime)
+	return nil
+}
+
+// AddCtime ctime 增加 delta
+func (r *PlayerRecord) AddCtime(delta int64) error {
+	return r.SetCtime(r.Ctime + delta)
+}
+
+// SetMtime 设置 mtime
+func (r *PlayerRecord) SetMtime(v int64) error {
+	if r.Mtime == v {
+		return nil
+	}
+	if err := r.Header.CheckWrite("mtime", false); err != nil {
+		return err
+	}
+	r.Mtime = v
+	r.Header.SetChanged(PlayerFieldMtime)
+	return nil
+}
+
+// AddMtime mtime 增加 delta
+func (r *PlayerRecord) AddMtime(delta int64) error {
+	return r.SetMtime(r.Mtime + delta)
+}
+
 var _PlayerSource = &xdb.Source{
 	ProtoType:  reflect.TypeOf((*Player)(nil)).Elem(),
 	RecordType: reflect.TypeOf((*PlayerRecord)(nil)).Elem(),
@@ -180,14 +281,32 @@ var _PlayerSource = &xdb.Source{
 	DriverName: "none",
 	TableName:  "player",
 	KeySize:    1,
+	Versioned:  true,
+	ShardField: "player_id",
+	Fields: []*xdb.FieldDesc{
+		{Code: PlayerFieldPlayerId, Name: "player_id", Key: true},
+		{Code: PlayerFieldName, Name: "name"},
+		{Code: PlayerFieldLevel, Name: "level"},
+		{Code: PlayerFieldExp, Name: "exp"},
+		{Code: PlayerFieldCtime, Name: "ctime"},
+		{Code: PlayerFieldMtime, Name: "mtime"},
+	},
+	Indexes: []*xdb.IndexDesc{
+		{Name: "uk_name", Columns: []string{"name"}, Unique: true},
+	},
+	FieldSetSave: xdb.MakeFieldSet(PlayerFieldPlayerId, PlayerFieldName, PlayerFieldLevel, PlayerFieldExp, PlayerFieldCtime, PlayerFieldMtime),
 
+	// 参数按主键字段的类型无损转换，类型不符时返回错误，见 xdb.PKArg
 	PKCreator: func(args []interface{}) (xdb.PK, error) {
 		if len(args) < 1 {
 			return nil, fmt.Errorf("invalid args count")
 		}
-		return &PlayerPK{
-			PlayerId: args[0].(int64),
-		}, nil
+		pk := &PlayerPK{}
+		var err error
+		if pk.PlayerId, err = xdb.PKArg[int64](args, 0, "player_id"); err != nil {
+			return nil, err
+		}
+		return pk, nil
 	},
 
 	PKOf: func(obj interface{}) xdb.PK {
@@ -197,8 +316,14 @@ var _PlayerSource = &xdb.Source{
 				PlayerId: r.PlayerId,
 			}
 		}
-		// 支持 proto 类型
-		if p, ok := obj.(*Player); ok {
+		// 支持 proto 类型，以及嵌入 Record 的 Model 类型
+		p, ok := obj.(*Player)
+		if !ok {
+			if r, isRecord := obj.(xdb.Record); isRecord {
+				p, ok = r.Snapshoot().(*Player)
+			}
+		}
+		if ok {
 			return &PlayerPK{
 				PlayerId: p.PlayerId,
 			}
@@ -233,6 +358,8 @@ type PlayerCommitment struct {
 	data      *Player
 	changes   xdb.FieldSet
 	lifecycle xdb.Lifecycle
+	base      int64
+	version   int64
 }
 
 func (c *PlayerCommitment) Source() *xdb.Source {
@@ -245,6 +372,7 @@ func (c *PlayerCommitment) Merge(other xdb.Commitment) bool {
 		return false
 	}
 	c.changes = c.changes.Union(otherC.changes)
+	c.version = otherC.version
 	return true
 }
 
@@ -269,13 +397,44 @@ func (c *PlayerCommitment) Unmarshal(data []byte) error {
 	return proto.Unmarshal(data, c.data)
 }
 
-func init() {
-	_PlayerSource.PKOf = func(obj interface{}) xdb.PK {
-		r := obj.(*PlayerRecord)
-		return &PlayerPK{
-			PlayerId: r.PlayerId,
-		}
+func (c *PlayerCommitment) RecoverMeta(lifecycle xdb.Lifecycle, changes xdb.FieldSet, base int64) {
+	c.lifecycle = lifecycle
+	c.changes = changes
+	c.version = c.data.XVersion
+	c.base = base
+	if lifecycle == xdb.LifecycleNew {
+		c.base = 0
+	} else if base < 0 {
+		// 没有记录基准版本时按快照中的版本推算
+		c.base = c.version - 1
 	}
+}
+
+func (c *PlayerCommitment) BaseVersion() int64 {
+	return c.base
+}
+
+func (c *PlayerCommitment) Version() int64 {
+	return c.version
+}
+
+// Rebase 只修改提交对象的版本，数据是模型持有的消息，由保存协程调用时不能写入
+func (c *PlayerCommitment) Rebase(version int64) {
+	c.base = version
+	c.version = version + 1
+}
+
+// GetPlayer 按主键获取记录，T 为 *PlayerRecord 或注册的 Model 类型，不存在时返回 nil
+func GetPlayer[T xdb.Record](ctx context.Context, playerId int64) (T, error) {
+	return xdb.Get[T](ctx, NewPlayerPK(playerId))
+}
+
+// GetPlayerByName 按全局唯一的 name 获取记录，T 为 *PlayerRecord 或注册的 Model 类型，不存在时返回 nil
+func GetPlayerByName[T xdb.Record](ctx context.Context, value string) (T, error) {
+	return xdb.GetByUniqueS[T](ctx, _PlayerSource, "name", value)
+}
+
+func init() {
 	xdb.RegisterSource(_PlayerSource)
 }
 
@@ -295,6 +454,15 @@ type ItemPK struct {
 	validFieldNum int
 }
 
+// NewItemPK 创建完整的主键
+func NewItemPK(playerId int64, itemId int32) *ItemPK {
+	return &ItemPK{
+		PlayerId:      playerId,
+		ItemId:        itemId,
+		validFieldNum: 2,
+	}
+}
+
 func (pk *ItemPK) Source() *xdb.Source {
 	return _ItemSource
 }
@@ -314,13 +482,19 @@ func (pk *ItemPK) Empty() bool {
 	return pk.validFieldNum == 0
 }
 
+// PrefixOf 本主键的有效字段与 key 的对应字段全部相等
 func (pk *ItemPK) PrefixOf(key xdb.Key) bool {
-	_, ok := key.(*ItemPK)
+	other, ok := key.(*ItemPK)
 	if !ok {
 		return false
 	}
-	// TODO: 实现前缀匹配逻辑
-	return false
+	if pk.validFieldNum > 0 && pk.PlayerId != other.PlayerId {
+		return false
+	}
+	if pk.validFieldNum > 1 && pk.ItemId != other.ItemId {
+		return false
+	}
+	return pk.validFieldNum <= other.validFieldNum
 }
 
 func (pk *ItemPK) Full() bool {
@@ -329,10 +503,10 @@ func (pk *ItemPK) Full() bool {
 
 func (pk *ItemPK) FetchFilter() interface{} {
 	filter := make(map[string]interface{})
-	if pk.PlayerId != 0 {
+	if pk.validFieldNum > 0 {
 		filter["player_id"] = pk.PlayerId
 	}
-	if pk.ItemId != 0 {
+	if pk.validFieldNum > 1 {
 		filter["item_id"] = pk.ItemId
 	}
 	return filter
@@ -364,7 +538,11 @@ func (r *ItemRecord) Snapshoot() interface{} {
 }
 
 func (r *ItemRecord) XVersion() int64 {
-	return 0
+	return r.Item.XVersion
+}
+
+func (r *ItemRecord) SetXVersion(version int64) {
+	r.Item.XVersion = version
 }
 
 func (r *ItemRecord) GetHeader() *xdb.Header {
@@ -421,12 +599,17 @@ func (r *ItemRecord) Delete(ctx context.Context) bool {
 }
 
 func (r *ItemRecord) Commit(ctx context.Context) (xdb.Commitment, xdb.FieldSet) {
+	changes := r.Header.Changes()
 	commitment := &ItemCommitment{
 		data:      &r.Item,
-		changes:   r.Header.Changes(),
+		changes:   changes,
 		lifecycle: r.Header.Lifecycle(),
+		base:      r.Item.XVersion,
+		version:   r.Item.XVersion + 1,
 	}
-	return commitment, r.Header.Changes()
+	r.Item.XVersion = commitment.version
+	r.Header.Commit()()
+	return commitment, changes
 }
 
 func (r *ItemRecord) Committing() bool {
@@ -445,6 +628,60 @@ func (r *ItemRecord) SetSavingIndex(idx int32) {
 	r.Header.SavingIndex = idx
 }
 
+// SetCount 设置 count
+func (r *ItemRecord) SetCount(v int64) error {
+	if r.Count == v {
+		return nil
+	}
+	if err := r.Header.CheckWrite("count", false); err != nil {
+		return err
+	}
+	r.Count = v
+	r.Header.SetChanged(ItemFieldCount)
+	return nil
+}
+
+// AddCount count 增加 delta
+func (r *ItemRecord) AddCount(delta int64) error {
+	return r.SetCount(r.Count + delta)
+}
+
+// SetCtime 设置 ctime
+func (r *ItemRecord) SetCtime(v int64) error {
+	if r.Ctime == v {
+		return nil
+	}
+	if err := r.Header.CheckWrite("ctime", false); err != nil {
+		return err
+	}
+	r.Ctime = v
+	r.Header.SetChanged(ItemFieldCtime)
+	return nil
+}
+
+// AddCtime ctime 增加 delta
+func (r *ItemRecord) AddCtime(delta int64) error {
+	return r.SetCtime(r.Ctime + delta)
+}
+
+// SetMtime 设置 mtime
+func (r *ItemRecord) SetMtime(v int64) error {
+	if r.Mtime == v {
+		return nil
+	}
+	if err := r.Header.CheckWrite("mtime", false); err != nil {
+		return err
+	}
+	r.Mtime = v
+	r.Header.SetChanged(ItemFieldMtime)
+	return nil
+}
+
+// AddMtime mtime 增加 delta
+func (r *ItemRecord) AddMtime(delta int64) error {
+	return r.SetMtime(r.Mtime + delta)
+}
+
 var _ItemSource = &xdb.Source{
 	ProtoType:  reflect.TypeOf((*Item)(nil)).Elem(),
 	RecordType: reflect.TypeOf((*ItemRecord)(nil)).Elem(),
@@ -453,16 +690,38 @@ var _ItemSource = &xdb.Source{
 	DriverName: "none",
 	TableName:  "item",
 	KeySize:    2,
+	Versioned:  true,
+	ShardField: "player_id",
+	History:    true,
+	Fields: []*xdb.FieldDesc{
+		{Code: ItemFieldPlayerId, Name: "player_id", Key: true},
+		{Code: ItemFieldItemId, Name: "item_id", Key: true},
+		{Code: ItemFieldCount, Name: "count"},
+		{Code: ItemFieldCtime, Name: "ctime"},
+		{Code: ItemFieldMtime, Name: "mtime"},
+	},
+	FieldSetSave:     xdb.MakeFieldSet(ItemFieldPlayerId, ItemFieldItemId, ItemFieldCount, ItemFieldCtime, ItemFieldMtime),
+	FieldSetCritical: xdb.MakeFieldSet(ItemFieldCount),
 
+	// 参数按主键字段的类型无损转换，类型不符时返回错误，见 xdb.PKArg
 	PKCreator: func(args []interface{}) (xdb.PK, error) {
-		if len(args) < 2 {
+		// 参数少于主键字段数时创建前缀主键
+		if len(args) < 1 || len(args) > 2 {
 			return nil, fmt.Errorf("invalid args count")
 		}
-		return &ItemPK{
-			PlayerId:      args[0].(int64),
-			ItemId:        args[1].(int32),
-			validFieldNum: 2,
-		}, nil
+		pk := &ItemPK{validFieldNum: len(args)}
+		var err error
+		if len(args) > 0 {
+			if pk.PlayerId, err = xdb.PKArg[int64](args, 0, "player_id"); err != nil {
+				return nil, err
+			}
+		}
+		if len(args) > 1 {
+			if pk.ItemId, err = xdb.PKArg[int32](args, 1, "item_id"); err != nil {
+				return nil, err
+			}
+		}
+		return pk, nil
 	},
 
 	PKOf: func(obj interface{}) xdb.PK {
@@ -474,8 +733,14 @@ var _ItemSource = &xdb.Source{
 				validFieldNum: 2,
 			}
 		}
-		// 支持 proto 类型
-		if p, ok := obj.(*Item); ok {
+		// 支持 proto 类型，以及嵌入 Record 的 Model 类型
+		p, ok := obj.(*Item)
+		if !ok {
+			if r, isRecord := obj.(xdb.Record); isRecord {
+				p, ok = r.Snapshoot().(*Item)
+			}
+		}
+		if ok {
 			return &ItemPK{
 				PlayerId:      p.PlayerId,
 				ItemId:        p.ItemId,
@@ -518,6 +783,8 @@ type ItemCommitment struct {
 	data      *Item
 	changes   xdb.FieldSet
 	lifecycle xdb.Lifecycle
+	base      int64
+	version   int64
 }
 
 func (c *ItemCommitment) Source() *xdb.Source {
@@ -530,6 +797,7 @@ func (c *ItemCommitment) Merge(other xdb.Commitment) bool {
 		return false
 	}
 	c.changes = c.changes.Union(otherC.changes)
+	c.version = otherC.version
 	return true
 }
 
@@ -554,14 +822,38 @@ func (c *ItemCommitment) Unmarshal(data []byte) error {
 	return proto.Unmarshal(data, c.data)
 }
 
-func init() {
-	_ItemSource.PKOf = func(obj interface{}) xdb.PK {
-		r := obj.(*ItemRecord)
-		return &ItemPK{
-			PlayerId:      r.PlayerId,
-			ItemId:        r.ItemId,
-			validFieldNum: 2,
-		}
+func (c *ItemCommitment) RecoverMeta(lifecycle xdb.Lifecycle, changes xdb.FieldSet, base int64) {
+	c.lifecycle = lifecycle
+	c.changes = changes
+	c.version = c.data.XVersion
+	c.base = base
+	if lifecycle == xdb.LifecycleNew {
+		c.base = 0
+	} else if base < 0 {
+		// 没有记录基准版本时按快照中的版本推算
+		c.base = c.version - 1
 	}
+}
+
+func (c *ItemCommitment) BaseVersion() int64 {
+	return c.base
+}
+
+func (c *ItemCommitment) Version() int64 {
+	return c.version
+}
+
+// Rebase 只修改提交对象的版本，数据是模型持有的消息，由保存协程调用时不能写入
+func (c *ItemCommitment) Rebase(version int64) {
+	c.base = version
+	c.version = version + 1
+}
+
+// GetItem 按主键获取记录，T 为 *ItemRecord 或注册的 Model 类型，不存在时返回 nil
+func GetItem[T xdb.Record](ctx context.Context, playerId int64, itemId int32) (T, error) {
+	return xdb.Get[T](ctx, NewItemPK(playerId, itemId))
+}
+
+func init() {
 	xdb.RegisterSource(_ItemSource)
 }
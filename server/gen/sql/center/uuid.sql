@@ -6,5 +6,6 @@ CREATE TABLE `uuid` (
     `value` BIGINT(20) NOT NULL DEFAULT 0,
     `ctime` BIGINT(20) NOT NULL DEFAULT 0,
     `mtime` BIGINT(20) NOT NULL DEFAULT 0,
+    `_version` BIGINT(20) NOT NULL DEFAULT 0,
     PRIMARY KEY(`name`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
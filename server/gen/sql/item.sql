@@ -7,5 +7,20 @@ CREATE TABLE `item` (
     `count` BIGINT(20) NOT NULL DEFAULT 0,
     `ctime` BIGINT(20) NOT NULL DEFAULT 0,
     `mtime` BIGINT(20) NOT NULL DEFAULT 0,
+    `_version` BIGINT(20) NOT NULL DEFAULT 0,
     PRIMARY KEY(`player_id`, `item_id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `item_history` (
+    `id` BIGINT(20) NOT NULL AUTO_INCREMENT,
+    `pk` VARCHAR(255) NOT NULL DEFAULT '',
+    `version` BIGINT(20) NOT NULL DEFAULT 0,
+    `lifecycle` TINYINT(4) NOT NULL DEFAULT 0,
+    `changes` VARBINARY(136) NOT NULL DEFAULT '',
+    `diff` TEXT NOT NULL,
+    `data` MEDIUMBLOB NOT NULL,
+    `ctime` BIGINT(20) NOT NULL DEFAULT 0,
+    `origin` VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY(`id`),
+    KEY `idx_pk_version` (`pk`, `version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
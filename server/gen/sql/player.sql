@@ -8,5 +8,7 @@ CREATE TABLE `player` (
     `exp` BIGINT(20) NOT NULL DEFAULT 0,
     `ctime` BIGINT(20) NOT NULL DEFAULT 0,
     `mtime` BIGINT(20) NOT NULL DEFAULT 0,
-    PRIMARY KEY(`player_id`)
+    `_version` BIGINT(20) NOT NULL DEFAULT 0,
+    PRIMARY KEY(`player_id`),
+    UNIQUE KEY `uk_name` (`name`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -47,6 +47,12 @@ if ! command -v protoc &> /dev/null; then
     exit 1
 fi
 
+# 分表时为每个物理表生成建表语句，例如 XDB_SHARDS="item:32 player:4"
+XDB_OPT=""
+for shard in $XDB_SHARDS; do
+    XDB_OPT="${XDB_OPT:+$XDB_OPT,}shards=$shard"
+done
+
 # 临时目录用于生成 SQL
 TEMP_DIR=$(mktemp -d)
 trap "rm -rf $TEMP_DIR" EXIT
@@ -63,6 +69,7 @@ for proto_file in $PROTO_FILES; do
       --proto_path="$XDB_DIR" \
       --plugin=protoc-gen-xdb="$PROTOC_GEN_XDB" \
       --xdb_out="$TEMP_DIR" \
+      ${XDB_OPT:+--xdb_opt="$XDB_OPT"} \
       "$proto_file"
     
     # 查找生成的 SQL 文件
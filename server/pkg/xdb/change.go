@@ -0,0 +1,231 @@
+package xdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	clog "github.com/cherry-game/cherry/logger"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// defaultChangeSubject 变更事件跨节点转发的默认主题前缀，完整主题为 <prefix>.<namespace>
+const defaultChangeSubject = "xdb.change"
+
+// ChangeEvent 记录的变更事件，在提交入库后发布
+type ChangeEvent struct {
+	Origin    string      `json:"origin,omitempty"` // 发布事件的节点，仅跨节点转发的事件
+	Namespace string      `json:"ns"`
+	Key       string      `json:"pk"`
+	PK        PK          `json:"-"` // 跨节点的事件在本节点注册了数据源时才有
+	Version   int64       `json:"version,omitempty"`
+	Lifecycle Lifecycle   `json:"lifecycle"`
+	Changes   FieldSet    `json:"changes"`
+	Snapshot  interface{} `json:"-"`              // 入库的数据（proto 消息的副本），只读
+	Data      []byte      `json:"data,omitempty"` // 快照的编码，仅跨节点转发的事件
+
+	commitment Commitment
+}
+
+// ChangeHandler 变更事件的回调
+type ChangeHandler func(ctx context.Context, ev *ChangeEvent)
+
+type changeSubscriber struct {
+	namespace string
+	fn        ChangeHandler
+}
+
+var (
+	changeMu          sync.RWMutex
+	changeSeq         int
+	changeSubscribers = map[int]*changeSubscriber{}
+)
+
+// Subscribe 订阅数据源的变更事件，namespace 为空时订阅所有数据源，返回取消订阅的函数
+// 回调在保存协程中、提交入库后同步执行，同一记录的事件有序，同一批次内的多次提交合并为一个事件；
+// 只发布写入存储的提交，事件不持久化，入库后、回调前进程退出时丢失；
+// 重做日志恢复的提交入库后也会回调，同一变更可能收到多次。回调不能阻塞保存协程，耗时的处理应转到其他协程
+func Subscribe(namespace string, fn ChangeHandler) func() {
+	changeMu.Lock()
+	defer changeMu.Unlock()
+
+	changeSeq++
+	id := changeSeq
+	changeSubscribers[id] = &changeSubscriber{namespace: namespace, fn: fn}
+
+	return func() {
+		changeMu.Lock()
+		defer changeMu.Unlock()
+		delete(changeSubscribers, id)
+	}
+}
+
+func getChangeHandlers(ns string) []ChangeHandler {
+	changeMu.RLock()
+	defer changeMu.RUnlock()
+
+	var handlers []ChangeHandler
+	for _, sub := range changeSubscribers {
+		if sub.namespace == "" || sub.namespace == ns {
+			handlers = append(handlers, sub.fn)
+		}
+	}
+	return handlers
+}
+
+// publishChanges 发布已写入存储的提交，由保存协程在批次写入后、恢复重做日志后调用
+func publishChanges(ctx context.Context, src *Source, commitments []Commitment) {
+	handlers := getChangeHandlers(src.Namespace)
+	if len(handlers) == 0 {
+		return
+	}
+
+	for _, c := range commitments {
+		ev := newChangeEvent(src, c)
+		for _, fn := range handlers {
+			callChangeHandler(ctx, ev, fn)
+		}
+	}
+}
+
+func newChangeEvent(src *Source, c Commitment) *ChangeEvent {
+	pk := PKOf(c)
+	data, _ := c.PrepareWrite()
+	// 提交中的数据是模型的 proto，所在的 actor 会继续修改，事件持有副本
+	if msg, ok := data.(proto.Message); ok {
+		data = proto.Clone(msg)
+	}
+	ev := &ChangeEvent{
+		Namespace: src.Namespace,
+		Key:       keyString(pk),
+		PK:        pk,
+		Lifecycle: c.Lifecycle(),
+		Changes:   c.Changes(),
+		Snapshot:  data,
+
+		commitment: c,
+	}
+	if _, version, ok := VersionOf(c); ok {
+		ev.Version = version
+	}
+	return ev
+}
+
+// callChangeHandler 回调的 panic 不影响保存协程
+func callChangeHandler(ctx context.Context, ev *ChangeEvent, fn ChangeHandler) {
+	defer func() {
+		if r := recover(); r != nil {
+			clog.Errorf("[xdb] change handler panic. [ns = %s, pk = %s, err = %v]", ev.Namespace, ev.Key, r)
+		}
+	}()
+	fn(ctx, ev)
+}
+
+// ChangeOptions 变更事件跨节点转发的选项
+type ChangeOptions struct {
+	NodeId     string   // 本节点标识，写入事件的 Origin，为空时随机生成
+	Subject    string   // 主题前缀，为空时使用 xdb.change
+	Namespaces []string // 转发的数据源，为空时转发所有数据源
+}
+
+func (opts *ChangeOptions) subject(ns string) string {
+	if opts == nil || opts.Subject == "" {
+		return defaultChangeSubject + "." + ns
+	}
+	return opts.Subject + "." + ns
+}
+
+// PublishChanges 把本节点的变更事件转发到传输层，主题为 <Subject>.<namespace>，返回停止转发的函数
+func PublishChanges(transport ReplicaTransport, opts *ChangeOptions) (func(), error) {
+	if transport == nil {
+		return nil, errors.New("change transport is nil")
+	}
+
+	o := ChangeOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.NodeId == "" {
+		o.NodeId = fmt.Sprintf("%x-%x", time.Now().UnixNano(), rand.Int63())
+	}
+
+	forward := func(ctx context.Context, ev *ChangeEvent) {
+		remote := *ev
+		remote.Origin = o.NodeId
+		if msg, ok := ev.Snapshot.(proto.Message); ok {
+			remote.Data, _ = proto.Marshal(msg)
+		} else if ev.commitment != nil {
+			remote.Data, _ = ev.commitment.Marshal()
+		}
+
+		data, err := json.Marshal(&remote)
+		if err != nil {
+			clog.Warnf("[xdb] change event marshal failed. [ns = %s, pk = %s, err = %v]", ev.Namespace, ev.Key, err)
+			return
+		}
+		if err = transport.Publish(o.subject(ev.Namespace), data); err != nil {
+			clog.Warnf("[xdb] change event publish failed. [ns = %s, pk = %s, err = %v]", ev.Namespace, ev.Key, err)
+		}
+	}
+
+	if len(o.Namespaces) == 0 {
+		return Subscribe("", forward), nil
+	}
+
+	unsubs := make([]func(), len(o.Namespaces))
+	for i, ns := range o.Namespaces {
+		unsubs[i] = Subscribe(ns, forward)
+	}
+	return func() {
+		for _, unsub := range unsubs {
+			unsub()
+		}
+	}, nil
+}
+
+// SubscribeRemote 订阅其他节点通过 PublishChanges 转发的变更事件，subject 为空时使用 xdb.change
+// 本节点注册了数据源时解码事件的快照和主键
+func SubscribeRemote(transport ReplicaTransport, subject string, namespace string, fn ChangeHandler) (func(), error) {
+	if transport == nil {
+		return nil, errors.New("change transport is nil")
+	}
+
+	opts := &ChangeOptions{Subject: subject}
+	return transport.Subscribe(opts.subject(namespace), func(data []byte) {
+		ev := &ChangeEvent{}
+		if err := json.Unmarshal(data, ev); err != nil {
+			clog.Warnf("[xdb] change event unmarshal failed. [ns = %s, err = %v]", namespace, err)
+			return
+		}
+		if err := decodeChangeEvent(ev); err != nil {
+			clog.Warnf("[xdb] change event decode failed. [ns = %s, pk = %s, err = %v]", ev.Namespace, ev.Key, err)
+		}
+		callChangeHandler(context.Background(), ev, fn)
+	})
+}
+
+// decodeChangeEvent 以本节点的数据源解码跨节点事件的快照
+func decodeChangeEvent(ev *ChangeEvent) error {
+	if len(ev.Data) == 0 {
+		return nil
+	}
+	src, err := getNSSource(ev.Namespace)
+	if err != nil || src.CreateCommitment == nil {
+		return nil
+	}
+
+	c := src.CreateCommitment()
+	if err = c.Unmarshal(ev.Data); err != nil {
+		return errors.Wrapf(err, "unmarshal change event of [%s]", ev.Namespace)
+	}
+	if rc, ok := c.(RecoverableCommitment); ok {
+		rc.RecoverMeta(ev.Lifecycle, ev.Changes, -1)
+	}
+	ev.PK = PKOf(c)
+	ev.Snapshot, _ = c.PrepareWrite()
+	return nil
+}
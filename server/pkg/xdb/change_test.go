@@ -0,0 +1,98 @@
+package xdb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChange_SubscribeAndForward(t *testing.T) {
+	nsSrcMap[redoTestSource.Namespace] = redoTestSource
+	defer delete(nsSrcMap, redoTestSource.Namespace)
+
+	var local, other []*ChangeEvent
+	unsub := Subscribe(redoTestSource.Namespace, func(ctx context.Context, ev *ChangeEvent) {
+		local = append(local, ev)
+	})
+	defer unsub()
+	unsubOther := Subscribe("other", func(ctx context.Context, ev *ChangeEvent) {
+		other = append(other, ev)
+	})
+	defer unsubOther()
+
+	bus := &replicaTestBus{subs: map[string][]func(data []byte){}}
+	stop, err := PublishChanges(bus, &ChangeOptions{NodeId: "local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	var remote []*ChangeEvent
+	if _, err = SubscribeRemote(bus, "", redoTestSource.Namespace, func(ctx context.Context, ev *ChangeEvent) {
+		remote = append(remote, ev)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &redoTestCommitment{data: &redoTestData{Id: 7, Value: "a"}, lifecycle: LifecycleNormal, changes: MakeFieldSet(1)}
+	publishChanges(context.Background(), redoTestSource, []Commitment{c})
+
+	if len(other) != 0 || len(local) != 1 || local[0].Key != keyString(&redoTestPK{Id: 7}) || local[0].Snapshot != c.data || !local[0].Changes.Contains(1) {
+		t.Fatalf("unexpected local events: %+v", local)
+	}
+
+	// 其他节点收到的事件按本节点的数据源解码快照
+	if len(remote) != 1 || remote[0].Origin != "local" || remote[0].Lifecycle != LifecycleNormal || remote[0].PK.(*redoTestPK).Id != 7 {
+		t.Fatalf("unexpected remote events: %+v", remote)
+	}
+	if data, ok := remote[0].Snapshot.(*redoTestData); !ok || data.Value != "a" {
+		t.Errorf("unexpected remote snapshot: %+v", remote[0].Snapshot)
+	}
+
+	// 回调的 panic 不影响其他回调
+	unsubPanic := Subscribe("", func(ctx context.Context, ev *ChangeEvent) { panic("boom") })
+	defer unsubPanic()
+	publishChanges(context.Background(), redoTestSource, []Commitment{c})
+	if len(local) != 2 {
+		t.Errorf("expected event delivered despite panic, got %d", len(local))
+	}
+}
+
+func TestChange_WrittenOnly(t *testing.T) {
+	redoOptions = &RedoOptions{Dir: t.TempDir()}
+	nsSrcMap[redoTestSource.Namespace] = redoTestSource
+	redoTestSource.table = &failureTestTable{errs: []error{nil, Permanent(errors.New("bad value"))}}
+	redoTestSource.repo = &Repo{}
+	redoTestSource.repo.Init(true, nil, "redo_test", nil, &sync.WaitGroup{})
+	redoTestSource.saver = NewSaver(redoTestSource, 1, time.Second, time.Hour)
+	defer func() {
+		redoOptions = nil
+		delete(nsSrcMap, redoTestSource.Namespace)
+		redoTestSource.table = nil
+		redoTestSource.repo = nil
+		redoTestSource.saver = nil
+	}()
+
+	var events []*ChangeEvent
+	unsub := Subscribe(redoTestSource.Namespace, func(ctx context.Context, ev *ChangeEvent) {
+		events = append(events, ev)
+	})
+	defer unsub()
+
+	ctx, wg := context.Background(), &sync.WaitGroup{}
+	redoTestSource.saver.Run(ctx, wg)
+	defer func() {
+		redoTestSource.saver.Close()
+		wg.Wait()
+	}()
+
+	// 写入死信的提交没有入库，不发布事件
+	redoTestSource.saver.Put(ctx, &redoTestCommitment{data: &redoTestData{Id: 1}, lifecycle: LifecycleNew}, -1)
+	redoTestSource.saver.Put(ctx, &redoTestCommitment{data: &redoTestData{Id: 2}, lifecycle: LifecycleNew}, -1)
+	redoTestSource.saver.Sync(nil)
+	if len(events) != 1 || events[0].Key != keyString(&redoTestPK{Id: 1}) {
+		t.Errorf("expected only the written commitment published: %+v", events)
+	}
+}
@@ -17,9 +17,10 @@ type Commitment interface {
 
 // RedoOptions 重做日志选项
 type RedoOptions struct {
-	Dir          string
-	Enabled      bool
-	SyncInterval time.Duration // if < 0: depend on os, if == 0: sync on write, if > 0: sync with period
+	Dir           string
+	Enabled       bool
+	SyncInterval  time.Duration // if < 0: depend on os, if == 0: sync on write, if > 0: sync with period
+	DeadLetterDir string        // 永久写入失败的提交存放目录，为空时使用 Dir/deadletter，都为空时不写死信（数据丢失，只记录错误日志）
 }
 
 var redoOptions *RedoOptions
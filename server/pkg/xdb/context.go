@@ -4,33 +4,112 @@ import (
 	"context"
 )
 
-// CacheContext 缓存上下文
+type cacheContextKey struct{}
+type bufferContextKey struct{}
+
+// WithUnitOfWork 为请求绑定工作单元（缓冲上下文 + 读缓存）
+// 工作单元内的 Save 只会暂存记录，在 Flush 时统一提交，Discard 时全部丢弃
+// 已经绑定过工作单元的 ctx 原样返回
+func WithUnitOfWork(ctx context.Context) context.Context {
+	if getBufferContext(ctx) != nil {
+		return ctx
+	}
+
+	ctx = context.WithValue(ctx, cacheContextKey{}, newCacheContext())
+	return context.WithValue(ctx, bufferContextKey{}, newBufferContext())
+}
+
+// RunInUnitOfWork 在工作单元中执行 fn，fn 返回 nil 时提交暂存的记录，返回错误或 panic 时丢弃
+// 嵌套调用时加入外层的工作单元，由最外层统一提交或丢弃
+func RunInUnitOfWork(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if getBufferContext(ctx) != nil {
+		return fn(ctx)
+	}
+	ctx = WithUnitOfWork(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			Discard(ctx)
+			panic(r)
+		}
+
+		if err != nil {
+			Discard(ctx)
+		} else {
+			err = Flush(ctx)
+		}
+	}()
+
+	return fn(ctx)
+}
+
+// Flush 提交工作单元中暂存的全部记录，返回第一个保存失败的错误
+func Flush(ctx context.Context) (err error) {
+	if b := getBufferContext(ctx); b != nil {
+		err = b.Flush(ctx)
+	}
+
+	if cc := getCacheContext(ctx); cc != nil {
+		cc.Reset()
+	}
+	return err
+}
+
+// Discard 丢弃工作单元中暂存的全部记录
+// 内存中的修改无法回滚，因此已缓存的模型会从 Repo 中过期，下次访问时重新从数据库加载
+func Discard(ctx context.Context) {
+	if b := getBufferContext(ctx); b != nil {
+		b.Discard()
+	}
+
+	if cc := getCacheContext(ctx); cc != nil {
+		cc.Reset()
+	}
+}
+
+// CacheContext 缓存上下文（请求级别的读缓存）
 type CacheContext struct {
-	cache map[PK]any
+	cache map[string]any
 }
 
 func newCacheContext() *CacheContext {
 	return &CacheContext{
-		cache: make(map[PK]any),
+		cache: make(map[string]any),
 	}
 }
 
 func getCacheContext(ctx context.Context) *CacheContext {
-	// TODO: 从 context 中获取缓存上下文
-	// 这里简化实现，实际应该从 context.Value 中获取
-	return nil
+	cc, _ := ctx.Value(cacheContextKey{}).(*CacheContext)
+	return cc
+}
+
+// Get 获取缓存的记录，nil 值表示记录不存在
+func (cc *CacheContext) Get(pk PK) (any, bool) {
+	v, ok := cc.cache[pk.String()]
+	return v, ok
+}
+
+// Put 缓存记录
+func (cc *CacheContext) Put(pk PK, v any) {
+	cc.cache[pk.String()] = v
+}
+
+// Reset 清空缓存
+func (cc *CacheContext) Reset() {
+	clear(cc.cache)
 }
 
 func cacheInvalidate(ctx context.Context, src *Source, m Model) {
 	cc := getCacheContext(ctx)
 	if cc != nil {
-		delete(cc.cache, src.PKOf(m))
+		delete(cc.cache, src.PKOf(m).String())
 	}
 }
 
 // BufferContext 缓冲上下文
 type BufferContext struct {
 	safeMode bool
+	flushing bool
 	rs       []MutableRecord
 	ms       map[MutableRecord]Model
 }
@@ -42,9 +121,8 @@ func newBufferContext() *BufferContext {
 }
 
 func getBufferContext(ctx context.Context) *BufferContext {
-	// TODO: 从 context 中获取缓冲上下文
-	// 这里简化实现，实际应该从 context.Value 中获取
-	return nil
+	b, _ := ctx.Value(bufferContextKey{}).(*BufferContext)
+	return b
 }
 
 // IsWithORM 检查是否使用 ORM
@@ -92,7 +170,7 @@ func (b *BufferContext) SafeMode() bool {
 }
 
 func (b *BufferContext) Stash(r MutableRecord) {
-	if r.Committing() || b.ms[r] != nil {
+	if _, ok := b.ms[r]; r.Committing() || ok {
 		return
 	}
 
@@ -101,7 +179,33 @@ func (b *BufferContext) Stash(r MutableRecord) {
 	b.rs = append(b.rs, r)
 }
 
-func (b *BufferContext) Flush(ctx context.Context) {
+// Stashed 检查记录是否已暂存
+func (b *BufferContext) Stashed(r MutableRecord) bool {
+	_, ok := b.ms[r]
+	return ok
+}
+
+// Unstash 取消暂存
+func (b *BufferContext) Unstash(r MutableRecord) {
+	if _, ok := b.ms[r]; !ok {
+		return
+	}
+
+	delete(b.ms, r)
+	for i, v := range b.rs {
+		if v == r {
+			b.rs = append(b.rs[:i], b.rs[i+1:]...)
+			break
+		}
+	}
+}
+
+func (b *BufferContext) Flush(ctx context.Context) (err error) {
+	b.flushing = true
+	defer func() {
+		b.flushing = false
+	}()
+
 	for len(b.rs) != 0 {
 		r := b.rs[0]
 		b.rs = b.rs[1:]
@@ -109,12 +213,39 @@ func (b *BufferContext) Flush(ctx context.Context) {
 		m := b.ms[r]
 		delete(b.ms, r)
 
-		if m == nil {
-			Save(ctx, r)
-		} else {
-			Save(ctx, m.(MutableRecord))
+		mr, ok := m.(MutableRecord)
+		if !ok {
+			mr = r
+		}
+		if serr := Save(ctx, mr); serr != nil && err == nil {
+			err = serr
 		}
 	}
+	return err
+}
+
+// Discard 丢弃暂存的记录
+func (b *BufferContext) Discard() {
+	for _, r := range b.rs {
+		header := r.GetHeader()
+		if header.IsExpired() {
+			continue
+		}
+
+		src := r.Source()
+		if r.Lifecycle() != LifecycleNew && src.repo != nil {
+			src.repo.Expire(src.PKOf(r))
+		}
+		header.SetExpired(true)
+	}
+
+	b.rs = b.rs[:0]
+	clear(b.ms)
+}
+
+// buffering 检查 Save 是否应该只暂存记录
+func (b *BufferContext) buffering() bool {
+	return b != nil && !b.flushing
 }
 
 // LockContext 锁上下文接口
@@ -0,0 +1,131 @@
+package xdb_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"lucky/server/gen/db"
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/memory"
+)
+
+func TestUnitOfWork_Nested(t *testing.T) {
+	ctx := setupMemory(t, &memory.Configurator{SyncInterval: time.Hour})
+
+	// 内层返回 nil 时不提交，外层返回错误时内层暂存的记录一并丢弃
+	create := func(ctx context.Context, id int64, name string) (player *db.PlayerRecord, err error) {
+		err = xdb.RunInUnitOfWork(ctx, func(ctx context.Context) error {
+			if player, err = xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: id, Name: name}); err != nil {
+				return err
+			}
+			xdb.Save(ctx, player)
+			return nil
+		})
+		return
+	}
+
+	errAbort := errors.New("abort")
+	var discarded *db.PlayerRecord
+	err := xdb.RunInUnitOfWork(ctx, func(ctx context.Context) (err error) {
+		if discarded, err = create(ctx, 1, "a"); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !discarded.GetHeader().IsExpired() {
+		t.Error("expected nested save discarded by the outer unit")
+	}
+
+	var flushed *db.PlayerRecord
+	err = xdb.RunInUnitOfWork(ctx, func(ctx context.Context) (err error) {
+		flushed, err = create(ctx, 2, "b")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xdb.Sync(ctx, flushed)
+	if _, ok := memory.Lookup(xdb.PKOf(flushed)); !ok {
+		t.Error("expected nested save flushed by the outer unit")
+	}
+}
+
+func TestUnitOfWork_Discard(t *testing.T) {
+	ctx := setupMemory(t, &memory.Configurator{SyncInterval: time.Hour})
+
+	player, err := xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: 1, Name: "a", Level: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xdb.Save(ctx, player)
+	xdb.Sync(ctx, player)
+
+	// 内层的错误被外层返回时，内外层暂存的记录全部丢弃
+	errAbort := errors.New("abort")
+	err = xdb.RunInUnitOfWork(ctx, func(ctx context.Context) error {
+		_ = player.SetLevel(2)
+		xdb.Save(ctx, player)
+		return xdb.RunInUnitOfWork(ctx, func(ctx context.Context) error {
+			item, err := xdb.Create[*db.ItemRecord](ctx, &db.Item{PlayerId: 1, ItemId: 2, Count: 3})
+			if err != nil {
+				return err
+			}
+			xdb.Save(ctx, item)
+			return errAbort
+		})
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !player.GetHeader().IsExpired() {
+		t.Error("expected discarded player expired")
+	}
+	if _, ok := memory.Lookup(db.NewItemPK(1, 2)); ok {
+		t.Error("expected item discarded")
+	}
+	reloaded, err := xdb.Get[*db.PlayerRecord](ctx, 1)
+	if err != nil || reloaded == player || reloaded.GetLevel() != 1 {
+		t.Errorf("expected player reloaded with level 1: %v %v", reloaded, err)
+	}
+}
+
+func TestUnitOfWork_ReadCache(t *testing.T) {
+	ctx := setupMemory(t, &memory.Configurator{SyncInterval: time.Hour})
+
+	err := xdb.RunInUnitOfWork(ctx, func(uctx context.Context) error {
+		// 不存在的记录也会缓存，工作单元内不会看到单元外的写入
+		if player, err := xdb.Get[*db.PlayerRecord](uctx, 1); err != nil || player != nil {
+			t.Fatalf("unexpected player: %v %v", player, err)
+		}
+
+		player, err := xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: 1, Name: "a"})
+		if err != nil {
+			return err
+		}
+		xdb.Save(ctx, player)
+		xdb.Sync(ctx, player)
+
+		if cached, err := xdb.Get[*db.PlayerRecord](uctx, 1); err != nil || cached != nil {
+			t.Errorf("expected cached miss: %v %v", cached, err)
+		}
+		if found, err := xdb.Get[*db.PlayerRecord](ctx, 1); err != nil || found == nil {
+			t.Errorf("expected player outside the unit: %v %v", found, err)
+		}
+
+		// Flush 后读缓存清空
+		xdb.Flush(uctx)
+		if found, err := xdb.Get[*db.PlayerRecord](uctx, 1); err != nil || found == nil {
+			t.Errorf("expected player after flush: %v %v", found, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,42 @@
+package xdb_test
+
+import (
+	"testing"
+	"time"
+
+	"lucky/server/gen/db"
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/memory"
+)
+
+func TestCritical_FlushImmediately(t *testing.T) {
+	// 保存队列只在停止时刷新，只有关键字段的提交立即入库
+	ctx := setupMemory(t, &memory.Configurator{SyncInterval: time.Hour, CriticalSync: true})
+
+	player, err := xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: 1, Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xdb.Save(ctx, player)
+	item, err := xdb.Create[*db.ItemRecord](ctx, &db.Item{PlayerId: 1, ItemId: 1, Count: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xdb.Save(ctx, item)
+	if row, ok := memory.Lookup(xdb.PKOf(item)); !ok || row.(*db.Item).Count != 10 {
+		t.Fatalf("expected new item saved: %v", row)
+	}
+
+	_ = item.AddCount(-1)
+	_ = item.SetMtime(1)
+	xdb.Save(ctx, item)
+	if row, _ := memory.Lookup(xdb.PKOf(item)); row.(*db.Item).Count != 9 || row.(*db.Item).Mtime != 1 {
+		t.Errorf("expected critical change saved: %v", row)
+	}
+
+	_ = item.SetMtime(2)
+	xdb.Save(ctx, item)
+	if row, _ := memory.Lookup(xdb.PKOf(item)); row.(*db.Item).Mtime != 1 {
+		t.Errorf("expected cosmetic change batched: %v", row)
+	}
+}
@@ -0,0 +1,64 @@
+package xdb_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"lucky/server/gen/db/center"
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/memory"
+)
+
+func init() {
+	xdb.RegisterModel[*evictTestModel](nil)
+}
+
+// evictTestModel 缓存在 Repo 中的模型，其他测试不使用 center.Uuid
+type evictTestModel struct {
+	center.UuidRecord
+}
+
+func (m *evictTestModel) ValidateAffinity() bool                        { return m.GetHeader().ValidateAffinity() }
+func (m *evictTestModel) OnCreate(ctx context.Context)                  {}
+func (m *evictTestModel) OnLoad(ctx context.Context)                    {}
+func (m *evictTestModel) OnUpdate(ctx context.Context, fs xdb.FieldSet) {}
+func (m *evictTestModel) OnDelete(ctx context.Context)                  {}
+func (m *evictTestModel) OnReload(ctx context.Context)                  {}
+func (m *evictTestModel) OnRefresh(ctx context.Context)                 {}
+
+func TestEvict_SaveStaleModel(t *testing.T) {
+	ctx := setupMemory(t, &memory.Configurator{SyncInterval: time.Hour})
+
+	model, err := xdb.Create[*evictTestModel](ctx, &center.Uuid{Name: "a", Value: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xdb.Save(ctx, model)
+	xdb.Sync(ctx, model)
+
+	// 逐出后没有重新加载，持有者的保存把模型放回 Repo
+	pk := xdb.PKOf(model)
+	repo := pk.Source().Repo()
+	repo.Expire(pk)
+	_ = model.SetValue(2)
+	if err = xdb.Save(ctx, model); err != nil {
+		t.Fatalf("expected evicted model saved: %v", err)
+	}
+	xdb.Sync(ctx, model)
+
+	// 逐出后已经重新加载，旧引用的保存返回错误
+	repo.Expire(pk)
+	reloaded, err := xdb.Get[*evictTestModel](ctx, "a")
+	if err != nil || reloaded == model {
+		t.Fatalf("expected reloaded model: %v", err)
+	}
+	_ = model.SetValue(3)
+	if err = xdb.Save(ctx, model); !errors.Is(err, xdb.ErrStale) {
+		t.Errorf("expected stale save rejected: %v", err)
+	}
+	if row, _ := memory.Lookup(pk); row.(*center.Uuid).Value != 2 {
+		t.Errorf("unexpected row: %v", row)
+	}
+}
@@ -0,0 +1,288 @@
+package xdb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	clog "github.com/cherry-game/cherry/logger"
+	"github.com/pkg/errors"
+)
+
+// maxRetryInterval 写入失败重试的最大间隔
+const maxRetryInterval = 30 * time.Second
+
+// deadLetterExt 死信文件扩展名，文件格式与重做日志相同
+const deadLetterExt = ".dead"
+
+var ErrPermanent = errors.New("permanent save error")
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string        { return e.err.Error() }
+func (e *permanentError) Unwrap() error        { return e.err }
+func (e *permanentError) Is(target error) bool { return target == ErrPermanent }
+
+// Permanent 标记不可重试的错误（数据本身有问题，重试也不会成功），由驱动在 Table.Save 中区分
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent 是否为不可重试的错误
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrPermanent)
+}
+
+// SaveFailure 写入失败的信息
+type SaveFailure struct {
+	Commitment Commitment
+	Err        error
+	Attempt    int  // 第几次失败
+	Dead       bool // 已放弃重试并写入死信
+	Discarded  bool // 版本冲突时放弃了提交（Err 为 ErrVersionConflict）
+}
+
+// SaveErrorHandler 写入失败回调
+type SaveErrorHandler func(ctx context.Context, f *SaveFailure)
+
+var (
+	saveErrorMu       sync.RWMutex
+	saveErrorHandlers []SaveErrorHandler
+	deadLetterMu      sync.Mutex
+)
+
+// OnSaveError 注册写入失败回调，每次失败（包括会重试的失败和版本冲突）都会调用
+func OnSaveError(fn SaveErrorHandler) {
+	saveErrorMu.Lock()
+	defer saveErrorMu.Unlock()
+	saveErrorHandlers = append(saveErrorHandlers, fn)
+}
+
+func notifySaveError(ctx context.Context, f *SaveFailure) {
+	saveErrorMu.RLock()
+	handlers := saveErrorHandlers
+	saveErrorMu.RUnlock()
+
+	for _, fn := range handlers {
+		fn(ctx, f)
+	}
+}
+
+// SaveOne 按失败策略写入单个提交，驱动的 Table.Save 对每个提交调用
+// 临时错误以指数退避重试，直到 running 返回 false 时放弃并返回 false（批次保留在重做日志中）
+// 永久错误写入死信后返回 true
+func SaveOne(ctx context.Context, c Commitment, timeout time.Duration, retryInterval time.Duration, running func() bool, write func(ctx context.Context) error) bool {
+	interval := retryInterval
+	if interval <= 0 {
+		interval = RetryInterval
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := writeWithTimeout(ctx, timeout, write)
+		if err == nil {
+			return true
+		}
+
+		src := c.Source()
+		f := &SaveFailure{Commitment: c, Err: err, Attempt: attempt, Dead: IsPermanent(err)}
+		if f.Dead {
+			if derr := writeDeadLetter(c); derr != nil {
+				clog.Errorf("[xdb] dead letter write failed, data lost. [ns = %s, pk = %s, err = %v]", src.Namespace, PKOf(c), derr)
+			}
+			clog.Errorf("[xdb] save failed permanently. [ns = %s, pk = %s, err = %v]", src.Namespace, PKOf(c), err)
+		} else {
+			clog.Warnf("[xdb] save failed, retry in %s. [ns = %s, pk = %s, attempt = %d, err = %v]", interval, src.Namespace, PKOf(c), attempt, err)
+		}
+
+		notifySaveError(ctx, f)
+		if f.Dead {
+			markDropped(ctx, c)
+			metricInc(MetricDeadLetters, src.Namespace, 1)
+			return true
+		}
+		metricInc(MetricRetries, src.Namespace, 1)
+
+		if !backoff(ctx, interval, running) {
+			return false
+		}
+
+		interval *= 2
+		if interval > maxRetryInterval {
+			interval = maxRetryInterval
+		}
+	}
+}
+
+func writeWithTimeout(ctx context.Context, timeout time.Duration, write func(ctx context.Context) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return write(ctx)
+}
+
+// backoff 等待重试间隔，期间停止运行时返回 false
+func backoff(ctx context.Context, interval time.Duration, running func() bool) bool {
+	const tick = 100 * time.Millisecond
+
+	deadline := time.Now().Add(interval)
+	for {
+		if !running() || ctx.Err() != nil {
+			return false
+		}
+
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			return true
+		}
+		if remain > tick {
+			remain = tick
+		}
+		time.Sleep(remain)
+	}
+}
+
+// deadLetterDir 死信目录，RedoOptions 中没有配置目录时为空，此时不写死信
+func deadLetterDir() string {
+	switch {
+	case redoOptions == nil:
+		return ""
+	case redoOptions.DeadLetterDir != "":
+		return redoOptions.DeadLetterDir
+	case redoOptions.Dir != "":
+		return filepath.Join(redoOptions.Dir, "deadletter")
+	}
+	return ""
+}
+
+// logDeadLetterDir Setup 时记录死信目录，没有配置时告警
+func logDeadLetterDir() {
+	dir := deadLetterDir()
+	if dir == "" {
+		clog.Warnf("[xdb] dead letter dir not configured, permanent save errors lose data, set RedoOptions.Dir or RedoOptions.DeadLetterDir")
+		return
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	clog.Infof("[xdb] dead letter dir. [dir = %s]", dir)
+}
+
+func deadLetterPath(src *Source) string {
+	return filepath.Join(deadLetterDir(), src.Namespace+deadLetterExt)
+}
+
+// writeDeadLetter 追加到数据源的死信文件
+func writeDeadLetter(c Commitment) error {
+	if deadLetterDir() == "" {
+		return errors.New("dead letter dir not configured")
+	}
+
+	payload, err := c.Marshal()
+	if err != nil {
+		return errors.Wrap(err, "marshal commitment")
+	}
+
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+
+	if err = os.MkdirAll(deadLetterDir(), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(deadLetterPath(c.Source()), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.Write(encodeCommitmentFrame(c, payload)); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// DeadLetters 读取数据源的死信，同一主键的多次提交合并为一个
+func DeadLetters(ns string) ([]Commitment, error) {
+	src, err := getNSSource(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadLetterDir() == "" {
+		return nil, nil
+	}
+
+	path := deadLetterPath(src)
+	if _, err = os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return loadRedoCommitments(src, []string{path})
+}
+
+// ReplayDeadLetters 重新写入数据源的死信，返回重放的提交数
+// 仍然失败的提交会再次写入死信，有提交没有入库时原文件改名保留并返回错误；ctx 取消时中止，未完成的部分在下次重放时继续
+func ReplayDeadLetters(ctx context.Context, ns string) (int, error) {
+	src, err := getNSSource(ns)
+	if err != nil {
+		return 0, err
+	}
+
+	if deadLetterDir() == "" {
+		return 0, nil
+	}
+
+	path := deadLetterPath(src)
+	replaying := path + ".replaying"
+
+	// 上一次重放中断时，继续重放残留的文件
+	deadLetterMu.Lock()
+	if _, err = os.Stat(replaying); os.IsNotExist(err) {
+		err = os.Rename(path, replaying)
+	}
+	deadLetterMu.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	commitments, err := loadRedoCommitments(src, []string{replaying})
+	if err != nil {
+		return 0, err
+	}
+
+	timeout := 5 * time.Second
+	if src.saver != nil && src.saver.timeout > 0 {
+		timeout = src.saver.timeout
+	}
+
+	running := func() bool { return ctx.Err() == nil }
+	rctx, result := withSaveResult(ctx)
+	if !src.Table().Save(rctx, commitments, timeout, RetryInterval, running) {
+		return 0, errors.Wrapf(ctx.Err(), "replay dead letters of [%s] interrupted", ns)
+	}
+
+	// 有提交因冲突或再次写入死信没有入库时保留文件，由人工处理
+	written := len(result.written(commitments))
+	if written < len(commitments) {
+		if err = keepFailedFile(replaying); err != nil {
+			return written, err
+		}
+		return written, errors.Errorf("%d dead letters of [%s] not replayed", len(commitments)-written, ns)
+	}
+
+	if err = os.Remove(replaying); err != nil {
+		return written, err
+	}
+	return written, nil
+}
@@ -0,0 +1,162 @@
+package xdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// failureTestTable 按顺序返回预设的写入错误
+type failureTestTable struct {
+	NoStorageTable
+	errs  []error
+	saved []Commitment
+}
+
+func (t *failureTestTable) Save(ctx context.Context, commitments []Commitment, timeout time.Duration, retryInterval time.Duration, running func() bool) bool {
+	for _, c := range commitments {
+		ok := SaveOne(ctx, c, timeout, retryInterval, running, func(ctx context.Context) error {
+			if len(t.errs) > 0 {
+				err := t.errs[0]
+				t.errs = t.errs[1:]
+				if err != nil {
+					return err
+				}
+			}
+			t.saved = append(t.saved, c)
+			return nil
+		})
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSaveOne_RetryAndDeadLetter(t *testing.T) {
+	redoOptions = &RedoOptions{Dir: t.TempDir()}
+	saveErrorHandlers = nil
+	defer func() {
+		redoOptions = nil
+		saveErrorHandlers = nil
+	}()
+
+	var failures []*SaveFailure
+	OnSaveError(func(ctx context.Context, f *SaveFailure) {
+		failures = append(failures, f)
+	})
+
+	ctx := context.Background()
+	running := func() bool { return true }
+	table := &failureTestTable{errs: []error{errors.New("timeout"), errors.New("timeout"), nil, Permanent(errors.New("bad value"))}}
+
+	c1 := &redoTestCommitment{data: &redoTestData{Id: 1, Value: "a"}, lifecycle: LifecycleNew}
+	c2 := &redoTestCommitment{data: &redoTestData{Id: 2, Value: "b"}, lifecycle: LifecycleNormal, changes: MakeFieldSet(1)}
+	if !table.Save(ctx, []Commitment{c1, c2}, time.Second, time.Millisecond, running) {
+		t.Fatal("expected save to finish")
+	}
+
+	if len(table.saved) != 1 || table.saved[0] != c1 {
+		t.Fatalf("expected c1 saved after retries, got %v", table.saved)
+	}
+	if len(failures) != 3 || failures[1].Attempt != 2 || failures[1].Dead || !failures[2].Dead {
+		t.Fatalf("unexpected failures: %+v", failures)
+	}
+
+	// 停止运行后放弃重试
+	table.errs = []error{errors.New("timeout")}
+	if table.Save(ctx, []Commitment{c1}, time.Second, time.Millisecond, func() bool { return false }) {
+		t.Error("expected save to give up when stopped")
+	}
+
+	// 重放死信
+	nsSrcMap[redoTestSource.Namespace] = redoTestSource
+	redoTestSource.table = table
+	defer func() {
+		delete(nsSrcMap, redoTestSource.Namespace)
+		redoTestSource.table = nil
+	}()
+
+	dead, err := DeadLetters(redoTestSource.Namespace)
+	if err != nil || len(dead) != 1 || dead[0].(*redoTestCommitment).data.Value != "b" || !dead[0].Changes().Contains(1) {
+		t.Fatalf("unexpected dead letters: %v %v", dead, err)
+	}
+
+	table.errs = nil
+	table.saved = nil
+	n, err := ReplayDeadLetters(ctx, redoTestSource.Namespace)
+	if err != nil || n != 1 || len(table.saved) != 1 {
+		t.Fatalf("unexpected replay: n=%d err=%v saved=%v", n, err, table.saved)
+	}
+
+	if dead, _ = DeadLetters(redoTestSource.Namespace); len(dead) != 0 {
+		t.Errorf("expected no dead letters after replay, got %d", len(dead))
+	}
+}
+
+func TestSaveOne_NoDeadLetterDir(t *testing.T) {
+	redoOptions = &RedoOptions{Enabled: false}
+	defer func() { redoOptions = nil }()
+
+	// 没有配置目录时不在工作目录下写死信
+	wd, _ := os.Getwd()
+	table := &failureTestTable{errs: []error{Permanent(errors.New("bad value"))}}
+	c := &redoTestCommitment{data: &redoTestData{Id: 1}, lifecycle: LifecycleNew}
+	if !table.Save(context.Background(), []Commitment{c}, time.Second, time.Millisecond, func() bool { return true }) {
+		t.Fatal("expected save to finish")
+	}
+	if _, err := os.Stat(filepath.Join(wd, "deadletter")); !os.IsNotExist(err) {
+		t.Errorf("expected no relative dead letter dir: %v", err)
+	}
+
+	nsSrcMap[redoTestSource.Namespace] = redoTestSource
+	defer delete(nsSrcMap, redoTestSource.Namespace)
+	if dead, err := DeadLetters(redoTestSource.Namespace); err != nil || len(dead) != 0 {
+		t.Errorf("unexpected dead letters: %v %v", dead, err)
+	}
+}
+
+func TestShardTable_SaveAllShards(t *testing.T) {
+	redoTestSource.ShardField = "id"
+	defer func() { redoTestSource.ShardField = "" }()
+
+	interrupted := &failureTestTable{errs: []error{errors.New("timeout")}}
+	written := &failureTestTable{}
+	table := newShardTable(redoTestSource, &ShardOptions{Count: 2}, []Table{interrupted, written})
+
+	// 第一个分片的写入被中断，第二个分片仍然写入
+	c1 := &redoTestCommitment{data: &redoTestData{Id: 2}, lifecycle: LifecycleNew}
+	c2 := &redoTestCommitment{data: &redoTestData{Id: 1}, lifecycle: LifecycleNew}
+	if table.Save(context.Background(), []Commitment{c1, c2}, time.Second, time.Millisecond, func() bool { return false }) {
+		t.Error("expected interrupted save reported")
+	}
+	if len(interrupted.saved) != 0 || len(written.saved) != 1 || written.saved[0] != c2 {
+		t.Errorf("expected other shards saved: %v %v", interrupted.saved, written.saved)
+	}
+}
+
+func TestShardTable_SaveUnrouted(t *testing.T) {
+	redoOptions = &RedoOptions{Dir: t.TempDir()}
+	defer func() { redoOptions = nil }()
+
+	// 主键不包含分片字段，无法路由的提交写入死信而不是当作写入成功
+	src := *redoTestSource
+	src.ShardField = "player_id"
+	table := &failureTestTable{}
+	shards := newShardTable(&src, &ShardOptions{Count: 2}, []Table{table, table})
+
+	ctx, result := withSaveResult(context.Background())
+	c := &redoTestCommitment{data: &redoTestData{Id: 1, Value: "a"}, lifecycle: LifecycleNew}
+	if !shards.Save(ctx, []Commitment{c}, time.Second, time.Millisecond, func() bool { return true }) {
+		t.Fatal("expected save to finish")
+	}
+	if len(table.saved) != 0 || len(result.written([]Commitment{c})) != 0 {
+		t.Fatalf("expected unrouted commitment dropped, saved %v", table.saved)
+	}
+	if files, _ := filepath.Glob(filepath.Join(redoOptions.Dir, "*")); len(files) == 0 {
+		t.Error("expected dead letter written")
+	}
+}
@@ -1,16 +1,36 @@
 package xdb
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
 )
 
-const FieldSetAll = FieldSet(math.MaxUint64)
-const FieldSetEmpty = FieldSet(0)
+// MaxFields 记录最多的字段数
+const MaxFields = 1024
+
+// FieldSetEmpty 空集合
+var FieldSetEmpty = FieldSet{}
+
+// FieldSetAll 包含所有字段的集合
+var FieldSetAll = FieldSet{lo: math.MaxUint64, hi: strings.Repeat("\xff", (MaxFields-64)/8)}
 
-type Field int8
+type Field int16
 
-type FieldSet uint64
+// FieldSet 字段集合，值类型，可以用 == 比较
+// 0~63 号字段保存在 lo 中，字段不超过 64 个的记录不分配内存；
+// 64 号以后的字段按位保存在不可变的 hi 中，第 i 号字段位于第 (i-64)/8 个字节，末尾没有零字节
+type FieldSet struct {
+	lo uint64
+	hi string
+}
 
 // MakeFieldSet 创建字段集合
 func MakeFieldSet(fields ...Field) FieldSet {
@@ -19,11 +39,25 @@ func MakeFieldSet(fields ...Field) FieldSet {
 
 // Add 添加字段（注意：不会修改原值）
 func (fs FieldSet) Add(fields ...Field) FieldSet {
-	nfs := fs
+	var hi []byte
 	for _, f := range fields {
-		nfs = nfs | (1 << f)
+		if f < 64 {
+			fs.lo |= 1 << f
+			continue
+		}
+		if hi == nil {
+			hi = []byte(fs.hi)
+		}
+		i := int(f-64) / 8
+		for len(hi) <= i {
+			hi = append(hi, 0)
+		}
+		hi[i] |= 1 << (f % 8)
+	}
+	if hi != nil {
+		fs.hi = string(hi)
 	}
-	return nfs
+	return fs
 }
 
 // Del 删除字段（不会改变原值）
@@ -31,10 +65,18 @@ func (fs FieldSet) Del(fields ...Field) FieldSet {
 	return fs.Subtract(MakeFieldSet(fields...))
 }
 
+func (fs FieldSet) has(f Field) bool {
+	if f < 64 {
+		return fs.lo&(1<<f) != 0
+	}
+	i := int(f-64) / 8
+	return i < len(fs.hi) && fs.hi[i]&(1<<(f%8)) != 0
+}
+
 // Contains 检查是否包含指定字段
 func (fs FieldSet) Contains(fields ...Field) bool {
 	for _, f := range fields {
-		if fs&(1<<f) == 0 {
+		if !fs.has(f) {
 			return false
 		}
 	}
@@ -44,7 +86,7 @@ func (fs FieldSet) Contains(fields ...Field) bool {
 // ContainsAny 检查是否包含任意一个字段
 func (fs FieldSet) ContainsAny(fields ...Field) bool {
 	for _, f := range fields {
-		if fs&(1<<f) != 0 {
+		if fs.has(f) {
 			return true
 		}
 	}
@@ -56,19 +98,145 @@ func (fs FieldSet) ContainsExact(fields ...Field) bool {
 	return fs == MakeFieldSet(fields...)
 }
 
+// IsEmpty 是否为空集合
+func (fs FieldSet) IsEmpty() bool {
+	return fs == FieldSetEmpty
+}
+
 // Union 并集
 func (fs FieldSet) Union(other FieldSet) FieldSet {
-	return fs | other
+	fs.lo |= other.lo
+	if other.hi == "" || fs.hi == other.hi {
+		return fs
+	}
+	if fs.hi == "" {
+		fs.hi = other.hi
+		return fs
+	}
+	fs.hi = combineHi(fs.hi, other.hi, func(a, b byte) byte { return a | b })
+	return fs
 }
 
 // Intersect 交集
 func (fs FieldSet) Intersect(other FieldSet) FieldSet {
-	return fs & other
+	fs.lo &= other.lo
+	if fs.hi == "" || other.hi == "" {
+		fs.hi = ""
+		return fs
+	}
+	fs.hi = combineHi(fs.hi, other.hi, func(a, b byte) byte { return a & b })
+	return fs
 }
 
 // Subtract 差集
 func (fs FieldSet) Subtract(other FieldSet) FieldSet {
-	return fs & ^other
+	fs.lo &^= other.lo
+	if fs.hi == "" || other.hi == "" {
+		return fs
+	}
+	fs.hi = combineHi(fs.hi, other.hi, func(a, b byte) byte { return a &^ b })
+	return fs
+}
+
+// combineHi 逐字节合并 hi，去掉末尾的零字节
+func combineHi(a, b string, op func(a, b byte) byte) string {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	ret := make([]byte, n)
+	for i := range ret {
+		var x, y byte
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		ret[i] = op(x, y)
+	}
+	for n > 0 && ret[n-1] == 0 {
+		n--
+	}
+	return string(ret[:n])
+}
+
+// Fields 集合中的字段，从小到大
+func (fs FieldSet) Fields() []Field {
+	var ret []Field
+	for f := Field(0); f < 64; f++ {
+		if fs.lo&(1<<f) != 0 {
+			ret = append(ret, f)
+		}
+	}
+	for i := 0; i < len(fs.hi); i++ {
+		for j := 0; j < 8; j++ {
+			if fs.hi[i]&(1<<j) != 0 {
+				ret = append(ret, Field(64+i*8+j))
+			}
+		}
+	}
+	return ret
+}
+
+// String 字段编号列表，用于日志
+func (fs FieldSet) String() string {
+	fields := fs.Fields()
+	codes := make([]string, len(fields))
+	for i, f := range fields {
+		codes[i] = strconv.Itoa(int(f))
+	}
+	return "[" + strings.Join(codes, ",") + "]"
+}
+
+// MarshalBinary 编码为 8 字节大端的 lo 和 hi，字段不超过 64 个时与原 uint64 的编码相同
+func (fs FieldSet) MarshalBinary() ([]byte, error) {
+	return fs.appendBinary(nil), nil
+}
+
+func (fs FieldSet) appendBinary(b []byte) []byte {
+	b = binary.BigEndian.AppendUint64(b, fs.lo)
+	return append(b, fs.hi...)
+}
+
+// UnmarshalBinary 解码 MarshalBinary 的编码
+func (fs *FieldSet) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.Errorf("invalid field set length %d", len(data))
+	}
+	n := len(data)
+	for n > 8 && data[n-1] == 0 {
+		n--
+	}
+	fs.lo, fs.hi = binary.BigEndian.Uint64(data[:8]), string(data[8:n])
+	return nil
+}
+
+// MarshalJSON 字段不超过 64 个时编码为数字，与原 uint64 的编码相同，否则编码为字段编号的数组
+func (fs FieldSet) MarshalJSON() ([]byte, error) {
+	if fs.hi == "" {
+		return strconv.AppendUint(nil, fs.lo, 10), nil
+	}
+	return json.Marshal(fs.Fields())
+}
+
+// UnmarshalJSON 解码数字或字段编号的数组
+func (fs *FieldSet) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '[' {
+		var fields []Field
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return err
+		}
+		*fs = MakeFieldSet(fields...)
+		return nil
+	}
+
+	lo, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "invalid field set")
+	}
+	*fs = FieldSet{lo: lo}
+	return nil
 }
 
 // Debug 调试，返回字段描述
@@ -138,6 +306,65 @@ func (h *Header) SetChanged(fields ...Field) {
 	h.setFlag(FlagDirty, true)
 }
 
+// CheckWrite 生成的 setter 修改字段前检查，只读的记录返回 ErrReadonly，
+// readonly 为 (xdb.readonly) 字段，只能在新建的记录上修改
+func (h *Header) CheckWrite(field string, readonly bool) error {
+	switch h.lifecycle {
+	case LifecycleDeleted:
+		return errors.Errorf("record has been deleted, field %s", field)
+	case LifecycleUnavailable:
+		return errors.Errorf("record is unavailable, field %s", field)
+	}
+
+	if h.Readonly() || readonly && h.lifecycle != LifecycleNew {
+		return errors.Wrapf(ErrReadonly, "field %s", field)
+	}
+	return nil
+}
+
+// EqualList 比较 repeated 字段，元素为 proto 消息时按 proto.Equal 比较
+func EqualList[E any](a, b []E) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !equalValue(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// EqualMap 比较 map 字段，值为 proto 消息时按 proto.Equal 比较
+func EqualMap[K comparable, V any](a, b map[K]V) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, av := range a {
+		bv, ok := b[k]
+		if !ok || !equalValue(av, bv) {
+			return false
+		}
+	}
+	return true
+}
+
+// EqualValue 比较字段的值，proto 消息按 proto.Equal 比较
+func EqualValue[V any](a, b V) bool {
+	return equalValue(a, b)
+}
+
+func equalValue(a, b interface{}) bool {
+	switch av := a.(type) {
+	case proto.Message:
+		bv, _ := b.(proto.Message)
+		return proto.Equal(av, bv)
+	case []byte:
+		return bytes.Equal(av, b.([]byte))
+	}
+	return a == b
+}
+
 // SetChanges 设置变更集合
 func (h *Header) SetChanges(fields FieldSet) {
 	h.changes = fields
@@ -219,7 +446,7 @@ func (h *Header) Commit() func() {
 			h.lifecycle = LifecycleNormal
 		}
 
-		h.changes = 0
+		h.changes = FieldSetEmpty
 		h.setFlag(FlagDirty, false)
 		h.setFlag(FlagCommitting, false)
 	}
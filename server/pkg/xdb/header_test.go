@@ -0,0 +1,74 @@
+package xdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestFieldSet_Large(t *testing.T) {
+	fs := MakeFieldSet(1, 63, 64, 200)
+	if !fs.Contains(1, 63, 64, 200) || fs.ContainsAny(0, 65, 199, 1000) {
+		t.Fatalf("unexpected fields: %v", fs)
+	}
+	if fs.Add(200) != fs || fs.Del(200) == fs || fs.Del(64, 200) != MakeFieldSet(1, 63) {
+		t.Errorf("expected comparable field sets: %v", fs.Del(64, 200))
+	}
+
+	other := MakeFieldSet(63, 300)
+	if union := fs.Union(other); !union.ContainsExact(1, 63, 64, 200, 300) {
+		t.Errorf("unexpected union: %v", union)
+	}
+	if inter := fs.Intersect(other); inter != MakeFieldSet(63) {
+		t.Errorf("unexpected intersect: %v", inter)
+	}
+	if sub := fs.Subtract(MakeFieldSet(200)); sub != MakeFieldSet(1, 63, 64) {
+		t.Errorf("unexpected subtract: %v", sub)
+	}
+	if FieldSetAll.Intersect(fs) != fs || !fs.Subtract(FieldSetAll).IsEmpty() {
+		t.Errorf("unexpected all: %v", FieldSetAll.Intersect(fs))
+	}
+}
+
+func TestFieldSet_SmallNoAlloc(t *testing.T) {
+	a, b := MakeFieldSet(1, 2), MakeFieldSet(3)
+	allocs := testing.AllocsPerRun(100, func() {
+		fs := a.Add(5).Union(b).Intersect(FieldSetAll).Subtract(b)
+		if !fs.Contains(1, 2, 5) || fs == FieldSetEmpty {
+			t.Fatal("unexpected field set")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocation, got %v", allocs)
+	}
+}
+
+func TestFieldSet_Encoding(t *testing.T) {
+	// 字段不超过 64 个时 JSON 仍为数字
+	data, _ := json.Marshal(MakeFieldSet(0, 2))
+	if string(data) != "5" {
+		t.Errorf("unexpected json: %s", data)
+	}
+
+	for _, fs := range []FieldSet{FieldSetEmpty, MakeFieldSet(0, 2), MakeFieldSet(3, 64, 1023)} {
+		var decoded FieldSet
+		data, _ = json.Marshal(fs)
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded != fs {
+			t.Errorf("unexpected json of %v: %s %v", fs, data, err)
+		}
+
+		data, _ = fs.MarshalBinary()
+		if err := decoded.UnmarshalBinary(data); err != nil || decoded != fs {
+			t.Errorf("unexpected binary of %v: %v", fs, err)
+		}
+
+		for _, base := range []int64{-1, 0, 7} {
+			frame := encodeRedoFrame(LifecycleNormal, fs, base, []byte("payload"))
+			decoded, ok := decodeRedoBody(frame[redoFrameHeaderSize:])
+			if !ok || decoded.lifecycle != LifecycleNormal || decoded.changes != fs || decoded.base != base ||
+				!bytes.Equal(decoded.payload, []byte("payload")) {
+				t.Errorf("unexpected redo frame of %v: %+v", fs, decoded)
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package xdb
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	clog "github.com/cherry-game/cherry/logger"
+	"github.com/pkg/errors"
+)
+
+// HistoryRow 历史表中的一行，(xdb.history) 数据源的每个提交追加一行
+type HistoryRow struct {
+	Key       string      // 记录的主键
+	Version   int64       // 提交后的版本
+	Lifecycle Lifecycle   // 提交时的生命周期
+	Changes   FieldSet    // 变更的字段，新建时为空
+	Diff      string      // 变更字段的新值，JSON 对象，新建时为所有入库字段，删除时为空
+	Data      []byte      // 提交时的快照，Commitment.Marshal 的编码，用于恢复
+	Time      time.Time   // 提交时间
+	Origin    string      // WithOrigin 绑定的写入来源
+	Snapshot  interface{} // 读取时按数据源解码的快照
+
+	entry Commitment // 批次中合并了该提交的提交对象，没有写入存储时不追加历史
+}
+
+// HistoryTable 历史表，只追加
+type HistoryTable interface {
+	Append(ctx context.Context, rows []*HistoryRow) error
+	// List 按写入顺序从新到旧列出主键的历史，maxVersion > 0 时只返回不大于它的版本，limit <= 0 时不限制
+	List(ctx context.Context, key string, maxVersion int64, limit int) ([]*HistoryRow, error)
+}
+
+// HistoryDao 支持历史表的 DAO，Setup 为 (xdb.history) 数据源创建历史表
+type HistoryDao interface {
+	History(ctx context.Context, src *Source) (HistoryTable, error)
+}
+
+// HistoryTableName 数据源的历史表名，分表的数据源每个分片一个历史表（如 item_01_history）
+func HistoryTableName(src *Source) string {
+	return src.TableName + "_history"
+}
+
+// initHistory 为 (xdb.history) 数据源创建历史表，DAO 不支持历史表时返回错误，DryRun 时不写入历史
+// shards 为数据源的各个分片，没有分片时为数据源本身；分片的历史表建在分片的 DAO 上，按主键路由
+func (src *Source) initHistory(ctx context.Context, shards []*Shard, daoOf func(key interface{}) Dao, dryRun bool) error {
+	src.history = nil
+	if !src.History || dryRun {
+		return nil
+	}
+
+	tables := make([]HistoryTable, len(shards))
+	for i, shard := range shards {
+		hd, ok := daoOf(shard.DaoKey).(HistoryDao)
+		if !ok {
+			return errors.Errorf("driver %s does not support history of [%s]", src.DriverName, src.Namespace)
+		}
+
+		history, err := hd.History(ctx, shard.Source)
+		if err != nil {
+			return errors.Wrapf(err, "create history of [%s] %s", src.Namespace, shard.Source.TableName)
+		}
+		tables[i] = history
+	}
+
+	if st, ok := src.table.(*shardTable); ok {
+		src.history = &shardHistory{table: st, tables: tables}
+	} else {
+		src.history = tables[0]
+	}
+	return nil
+}
+
+type originContextKey struct{}
+
+// WithOrigin 为 ctx 绑定写入来源（如请求的路由、actor），写入历史表
+func WithOrigin(ctx context.Context, origin string) context.Context {
+	return context.WithValue(ctx, originContextKey{}, origin)
+}
+
+// OriginOf 获取 ctx 绑定的写入来源
+func OriginOf(ctx context.Context) string {
+	origin, _ := ctx.Value(originContextKey{}).(string)
+	return origin
+}
+
+// newHistoryRow 提交放入保存队列时生成历史，此时快照与提交一致，数据源没有历史表时返回 nil
+func newHistoryRow(ctx context.Context, c Commitment) *HistoryRow {
+	src := c.Source()
+	if src.history == nil {
+		return nil
+	}
+
+	row := &HistoryRow{
+		Key:       keyString(PKOf(c)),
+		Lifecycle: c.Lifecycle(),
+		Changes:   c.Changes(),
+		Time:      time.Now(),
+		Origin:    OriginOf(ctx),
+	}
+	if _, version, ok := VersionOf(c); ok {
+		row.Version = version
+	}
+
+	var err error
+	if row.Data, err = c.Marshal(); err != nil {
+		clog.Warnf("[xdb] history marshal failed. [ns = %s, pk = %s, err = %v]", src.Namespace, row.Key, err)
+		return nil
+	}
+	if row.Lifecycle != LifecycleDeleted {
+		data, _ := c.PrepareWrite()
+		row.Diff = historyDiff(src, data, row.Lifecycle, row.Changes)
+	}
+	return row
+}
+
+// historyDiff 变更字段的新值，key 为 proto 字段名
+func historyDiff(src *Source, data interface{}, lif Lifecycle, changes FieldSet) string {
+	diff := map[string]interface{}{}
+	for _, field := range src.Fields {
+		if !src.FieldSetSave.Contains(field.Code) || lif != LifecycleNew && !changes.Contains(field.Code) {
+			continue
+		}
+		if v, ok := columnValue(data, field.Name); ok {
+			diff[field.Name] = v.Interface()
+		}
+	}
+
+	ret, err := json.Marshal(diff)
+	if err != nil {
+		return ""
+	}
+	return string(ret)
+}
+
+// writeHistory 批次入库后追加已写入存储的提交的历史，写入失败只告警，不影响数据的保存
+func writeHistory(ctx context.Context, src *Source, rows []*HistoryRow) {
+	if len(rows) == 0 || src.history == nil {
+		return
+	}
+	if err := src.history.Append(ctx, rows); err != nil {
+		clog.Warnf("[xdb] history append failed. [ns = %s, rows = %d, err = %v]", src.Namespace, len(rows), err)
+	}
+}
+
+// ListHistory 从新到旧列出记录的历史，limit <= 0 时不限制
+func ListHistory[T Record](ctx context.Context, pk PK, limit int) ([]*HistoryRow, error) {
+	return ListHistoryS(ctx, getTypeSource[T](), pk, limit)
+}
+
+// ListHistoryS 根据源列出记录的历史
+func ListHistoryS(ctx context.Context, src *Source, pk PK, limit int) ([]*HistoryRow, error) {
+	return listHistory(ctx, src, pk, 0, limit)
+}
+
+func listHistory(ctx context.Context, src *Source, pk PK, maxVersion int64, limit int) ([]*HistoryRow, error) {
+	if src.history == nil {
+		return nil, errors.Errorf("no history of [%s]", src.Namespace)
+	}
+
+	history := src.history
+	if sh, ok := history.(*shardHistory); ok {
+		var err error
+		if history, err = sh.of(pk); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := history.List(ctx, keyString(pk), maxVersion, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		c := src.CreateCommitment()
+		if err = c.Unmarshal(row.Data); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal history of [%s] %s version %d", src.Namespace, row.Key, row.Version)
+		}
+		row.Snapshot, _ = c.PrepareWrite()
+	}
+	return rows, nil
+}
+
+// Restore 把记录恢复为 version 时的状态并保存，恢复本身也是新的提交，产生新的版本和历史
+// version 时记录已删除则删除记录，返回 nil；没有不大于 version 的历史时返回错误
+func Restore[T MutableRecord](ctx context.Context, pk PK, version int64) (T, error) {
+	return RestoreS[T](ctx, getTypeSource[T](), pk, version)
+}
+
+// RestoreS 根据源恢复记录
+func RestoreS[T MutableRecord](ctx context.Context, src *Source, pk PK, version int64) (T, error) {
+	rows, err := listHistory(ctx, src, pk, version, 1)
+	if err != nil {
+		return zero[T](), err
+	}
+	if len(rows) == 0 {
+		return zero[T](), errors.Errorf("no history of %s before version %d [%s]", pk, version, src.Namespace)
+	}
+	row := rows[0]
+
+	current, err := GetS[T](ctx, src, pk)
+	if err != nil {
+		return zero[T](), err
+	}
+	exists := !isNil(current) && !IsDeleted(current)
+
+	if row.Lifecycle == LifecycleDeleted {
+		if exists && current.Delete(ctx) {
+			err = Save(ctx, current)
+		}
+		return zero[T](), err
+	}
+	if !exists {
+		return CreateS[T](ctx, src, row.Snapshot)
+	}
+
+	var fields []Field
+	for _, field := range src.Fields {
+		if !field.Key && src.FieldSetSave.Contains(field.Code) {
+			fields = append(fields, field.Code)
+		}
+	}
+	if err = current.Update(ctx, row.Snapshot, MakeFieldSet(fields...)); err != nil {
+		return zero[T](), err
+	}
+	current.GetHeader().SetChanged(fields...)
+	if err = Save(ctx, current); err != nil {
+		return zero[T](), err
+	}
+	return current, nil
+}
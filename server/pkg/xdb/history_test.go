@@ -0,0 +1,68 @@
+package xdb_test
+
+import (
+	"testing"
+
+	"lucky/server/gen/db"
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/memory"
+)
+
+func TestHistory_ListRestore(t *testing.T) {
+	ctx := xdb.WithOrigin(setupMemory(t, &memory.Configurator{}), "bag.use")
+
+	item, err := xdb.Create[*db.ItemRecord](ctx, &db.Item{PlayerId: 9, ItemId: 1, Count: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = xdb.Sync(ctx, item)
+	_ = item.AddCount(-3)
+	_ = xdb.Sync(ctx, item)
+	item.Delete(ctx)
+	_ = xdb.Sync(ctx, item)
+
+	pk := xdb.PKOf(item)
+	rows, err := xdb.ListHistory[*db.ItemRecord](ctx, pk, 0)
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("unexpected history: %v %v", rows, err)
+	}
+	if rows[0].Lifecycle != xdb.LifecycleDeleted || rows[2].Lifecycle != xdb.LifecycleNew || rows[2].Version != 1 {
+		t.Errorf("unexpected history order: %+v", rows)
+	}
+	if r := rows[1]; r.Version != 2 || r.Diff != `{"count":7}` || r.Origin != "bag.use" || r.Snapshot.(*db.Item).Count != 7 {
+		t.Errorf("unexpected update history: %+v", r)
+	}
+
+	// 恢复到删除前的版本
+	restored, err := xdb.Restore[*db.ItemRecord](ctx, pk, 2)
+	if err != nil || restored == nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	_ = xdb.Sync(ctx, restored)
+	if row, ok := memory.Lookup(pk); !ok || row.(*db.Item).Count != 7 {
+		t.Errorf("unexpected restored item: %v", row)
+	}
+	if rows, _ = xdb.ListHistory[*db.ItemRecord](ctx, pk, 1); len(rows) != 1 || rows[0].Lifecycle != xdb.LifecycleNew {
+		t.Errorf("expected restore recorded: %+v", rows)
+	}
+}
+
+func TestHistory_Shard(t *testing.T) {
+	ctx := setupMemory(t, &memory.Configurator{Shards: map[string]*xdb.ShardOptions{"item": {Count: 2}}})
+
+	for _, id := range []int64{1, 2} {
+		item, err := xdb.Create[*db.ItemRecord](ctx, &db.Item{PlayerId: id, ItemId: 1, Count: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = xdb.Sync(ctx, item)
+	}
+
+	// 历史按分片写入各自的历史表
+	if memory.HistoryRows("item_00_history") != 1 || memory.HistoryRows("item_01_history") != 1 || memory.HistoryRows("item_history") != 0 {
+		t.Errorf("unexpected history rows: %d %d", memory.HistoryRows("item_00_history"), memory.HistoryRows("item_01_history"))
+	}
+	if rows, err := xdb.ListHistory[*db.ItemRecord](ctx, db.NewItemPK(1, 1), 0); err != nil || len(rows) != 1 || rows[0].Snapshot.(*db.Item).PlayerId != 1 {
+		t.Errorf("unexpected history: %v %v", rows, err)
+	}
+}
@@ -0,0 +1,241 @@
+package xdb
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Layout 子消息字段的存储布局，与 extension.proto 中的 LayoutType 对应
+type Layout int8
+
+const (
+	LayoutFlat   Layout = 0 // 子消息的字段展开为 <字段名>_<子字段名> 列
+	LayoutNested Layout = 1 // 子消息保存在一列中
+)
+
+// Column 入库的列，不含乐观锁版本号
+// 嵌套布局的子消息，以及 repeated 和 map 字段（任何布局下）的值编码为 JSON
+type Column struct {
+	Name    string       // 列名，即 proto 字段名，展开的子消息字段带上级字段名前缀
+	Field   string       // 所属的顶层字段名，用于按变更字段更新
+	Type    reflect.Type // 字段的 Go 类型
+	Encoded bool         // 值编码为 JSON
+	GoPath  []string     // 字段路径上的 Go 字段名
+	path    []int
+}
+
+// Columns 按布局展开 proto 结构体中入库的列，顺序与字段定义一致
+func (src *Source) Columns() []*Column {
+	if src.ProtoType == nil {
+		return nil
+	}
+
+	var columns []*Column
+	for i := 0; i < src.ProtoType.NumField(); i++ {
+		f := src.ProtoType.Field(i)
+		name := protoFieldName(f)
+		if !f.IsExported() || f.Name == "XVersion" || name == "" || !src.saved(name) {
+			continue
+		}
+		columns = src.appendColumns(columns, f, name, name, []int{i}, []string{f.Name}, nil)
+	}
+	return columns
+}
+
+// saved 字段是否入库，没有字段描述时所有字段都入库
+func (src *Source) saved(name string) bool {
+	if len(src.Fields) == 0 {
+		return true
+	}
+	for _, field := range src.Fields {
+		if field.Name == name {
+			return src.FieldSetSave.Contains(field.Code)
+		}
+	}
+	return false
+}
+
+// appendColumns 追加字段对应的列，parents 为展开路径上的子消息类型，递归引用的子消息不再展开
+func (src *Source) appendColumns(columns []*Column, f reflect.StructField, top string, name string, path []int, goPath []string, parents []reflect.Type) []*Column {
+	typ := f.Type
+	if src.Layout == LayoutFlat && isMessage(typ) && !containsType(parents, typ) {
+		parents = append(parents[:len(parents):len(parents)], typ)
+		for i := 0; i < typ.Elem().NumField(); i++ {
+			sub := typ.Elem().Field(i)
+			if subName := protoFieldName(sub); sub.IsExported() && subName != "" {
+				columns = src.appendColumns(columns, sub, top, name+"_"+subName,
+					append(path[:len(path):len(path)], i), append(goPath[:len(goPath):len(goPath)], sub.Name), parents)
+			}
+		}
+		return columns
+	}
+
+	encoded := false
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Interface:
+		encoded = true
+	case reflect.Slice:
+		encoded = typ.Elem().Kind() != reflect.Uint8
+	}
+	return append(columns, &Column{Name: name, Field: top, Type: typ, Encoded: encoded, GoPath: goPath, path: path})
+}
+
+// isMessage 字段是否为子消息
+func isMessage(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Ptr && typ.Elem().Kind() == reflect.Struct
+}
+
+func containsType(types []reflect.Type, typ reflect.Type) bool {
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Value 取列值，msg 为 proto 结构体；展开路径上的子消息为空时返回零值，编码的字段为空时返回空字符串
+func (c *Column) Value(msg reflect.Value) (interface{}, error) {
+	v := reflect.Indirect(msg)
+	for i, index := range c.path {
+		if i > 0 {
+			if v.IsNil() && c.Encoded {
+				return "", nil
+			} else if v.IsNil() {
+				return reflect.Zero(c.Type).Interface(), nil
+			}
+			v = v.Elem()
+		}
+		v = v.Field(index)
+	}
+
+	if !c.Encoded {
+		return v.Interface(), nil
+	}
+	if v.IsNil() {
+		return "", nil
+	}
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode column %s", c.Name)
+	}
+	return string(data), nil
+}
+
+// SetValue 将驱动读出的列值写回 proto，展开路径上为空的子消息只在值非零时创建
+func (c *Column) SetValue(msg reflect.Value, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	v := reflect.Indirect(msg)
+	for i, index := range c.path {
+		if i > 0 {
+			if v.IsNil() {
+				if isZero(value) {
+					return nil
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(index)
+	}
+
+	if err := setValue(v, value, c.Encoded); err != nil {
+		return errors.Wrapf(err, "failed to decode column %s", c.Name)
+	}
+	return nil
+}
+
+func setValue(field reflect.Value, value interface{}, encoded bool) error {
+	if encoded {
+		var data []byte
+		switch s := value.(type) {
+		case string:
+			data = []byte(s)
+		case []byte:
+			data = s
+		default:
+			return errors.Errorf("unexpected %T for %s", value, field.Type())
+		}
+		field.Set(reflect.Zero(field.Type()))
+		if len(data) == 0 {
+			return nil
+		}
+		return json.Unmarshal(data, field.Addr().Interface())
+	}
+
+	// 文本协议读出的数值为 []byte
+	if b, ok := value.([]byte); ok {
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(string(b))
+			return nil
+		case reflect.Slice:
+			field.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+		value = string(b)
+	}
+	if s, ok := value.(string); ok && field.Kind() != reflect.String {
+		return parseValue(field, s)
+	}
+
+	rv := reflect.ValueOf(value)
+	switch {
+	case field.Kind() == reflect.Bool:
+		n, ok := toInt(rv)
+		if rv.Kind() == reflect.Bool {
+			field.SetBool(rv.Bool())
+		} else if ok {
+			field.SetBool(n != 0)
+		} else {
+			return errors.Errorf("unexpected %T for bool", value)
+		}
+	case rv.Type().ConvertibleTo(field.Type()) && (field.Kind() != reflect.String || rv.Kind() == reflect.String):
+		field.Set(rv.Convert(field.Type()))
+	default:
+		return errors.Errorf("unexpected %T for %s", value, field.Type())
+	}
+	return nil
+}
+
+func parseValue(field reflect.Value, s string) error {
+	var err error
+	switch field.Kind() {
+	case reflect.Bool:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, 64)
+		field.SetBool(n != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, 64)
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		n, err = strconv.ParseUint(s, 10, 64)
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		var n float64
+		n, err = strconv.ParseFloat(s, 64)
+		field.SetFloat(n)
+	default:
+		err = errors.Errorf("unexpected string for %s", field.Type())
+	}
+	return err
+}
+
+// isZero 列值是否为零值（包括空字符串）
+func isZero(value interface{}) bool {
+	switch v := value.(type) {
+	case []byte:
+		return len(v) == 0
+	case string:
+		return v == ""
+	}
+	return reflect.ValueOf(value).IsZero()
+}
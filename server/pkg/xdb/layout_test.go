@@ -0,0 +1,87 @@
+package xdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+type layoutReward struct {
+	ItemId int32   `protobuf:"varint,1,opt,name=item_id,proto3"`
+	Count  int64   `protobuf:"varint,2,opt,name=count,proto3"`
+	Bonus  []int32 `protobuf:"varint,3,rep,packed,name=bonus,proto3"`
+}
+
+type layoutProto struct {
+	Id     int64            `protobuf:"varint,1,opt,name=id,proto3"`
+	Reward *layoutReward    `protobuf:"bytes,2,opt,name=reward,proto3"`
+	Bag    map[int32]int64  `protobuf:"bytes,3,rep,name=bag,proto3"`
+	Tags   []string         `protobuf:"bytes,4,rep,name=tags,proto3"`
+	Flag   bool             `protobuf:"varint,5,opt,name=flag,proto3"`
+	state  map[string]int64 // 未导出的字段不入库
+}
+
+// roundTrip 按列取值后写回新对象，模拟驱动读出的类型（整数为 int64，文本为 []byte）
+func roundTrip(t *testing.T, columns []*Column, msg *layoutProto) *layoutProto {
+	ret := &layoutProto{}
+	for _, column := range columns {
+		v, err := column.Value(reflect.ValueOf(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch n := v.(type) {
+		case int32:
+			v = int64(n)
+		case bool:
+			v = map[bool]int64{false: 0, true: 1}[n]
+		case string:
+			v = []byte(n)
+		}
+		if err = column.SetValue(reflect.ValueOf(ret), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ret
+}
+
+func TestColumns_Layout(t *testing.T) {
+	src := &Source{ProtoType: reflect.TypeOf(layoutProto{})}
+	msg := &layoutProto{Id: 1, Reward: &layoutReward{ItemId: 2, Count: 3, Bonus: []int32{4}}, Bag: map[int32]int64{7: 8}, Tags: []string{"a"}, Flag: true}
+
+	names := func(columns []*Column) []string {
+		var ret []string
+		for _, column := range columns {
+			ret = append(ret, column.Name)
+		}
+		return ret
+	}
+
+	// 扁平布局：子消息展开，map 和 repeated 编码
+	flat := src.Columns()
+	if got := names(flat); !reflect.DeepEqual(got, []string{"id", "reward_item_id", "reward_count", "reward_bonus", "bag", "tags", "flag"}) {
+		t.Fatalf("unexpected flat columns: %v", got)
+	}
+	if flat[1].Field != "reward" || flat[1].Encoded || !flat[3].Encoded || !flat[4].Encoded {
+		t.Errorf("unexpected flat column: %+v %+v %+v", flat[1], flat[3], flat[4])
+	}
+	if got := roundTrip(t, flat, msg); !reflect.DeepEqual(got, msg) {
+		t.Errorf("unexpected flat round trip: %+v", got)
+	}
+	if got := roundTrip(t, flat, &layoutProto{Id: 1}); got.Reward != nil || got.Bag != nil {
+		t.Errorf("expected empty reward kept nil: %+v", got)
+	}
+
+	// 子消息为空时，展开的编码列取值为空字符串而不是 nil 切片
+	if v, err := flat[3].Value(reflect.ValueOf(&layoutProto{Id: 1})); err != nil || v != "" {
+		t.Errorf("unexpected encoded value under nil reward: %#v %v", v, err)
+	}
+
+	// 嵌套布局：子消息编码为一列
+	src.Layout = LayoutNested
+	nested := src.Columns()
+	if got := names(nested); !reflect.DeepEqual(got, []string{"id", "reward", "bag", "tags", "flag"}) || !nested[1].Encoded {
+		t.Fatalf("unexpected nested columns: %v", got)
+	}
+	if got := roundTrip(t, nested, msg); !reflect.DeepEqual(got, msg) {
+		t.Errorf("unexpected nested round trip: %+v", got)
+	}
+}
@@ -0,0 +1,284 @@
+package xdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// 指标名称，标签只有 namespace
+const (
+	MetricQueued      = "xdb_commitments_queued_total" // 放入保存队列的提交数
+	MetricMerged      = "xdb_commitments_merged_total" // 与队列中同一对象的提交合并的次数
+	MetricCritical    = "xdb_critical_flushes_total"   // 关键字段的提交触发的立即入库次数
+	MetricBatchSize   = "xdb_batch_size"               // 每次入库的批次大小
+	MetricFlush       = "xdb_flush_seconds"            // 每个批次的入库耗时
+	MetricRetries     = "xdb_save_retries_total"       // 临时错误导致的重试次数
+	MetricDeadLetters = "xdb_dead_letters_total"       // 写入死信的提交数
+	MetricPending     = "xdb_saver_pending"            // 保存队列中尚未入库的提交数
+	MetricRepoEntries = "xdb_repo_entries"             // 缓存对象数
+	MetricRepoHits    = "xdb_repo_hits_total"          // 缓存命中次数
+	MetricRepoMisses  = "xdb_repo_misses_total"        // 缓存未命中次数
+	MetricEvictions   = "xdb_repo_evictions_total"     // 缓存逐出次数
+)
+
+// Metrics 指标注册表，默认实现为 TextMetrics，可以替换为其他监控系统的适配
+type Metrics interface {
+	Inc(name string, ns string, delta float64)     // 计数器
+	Observe(name string, ns string, value float64) // 直方图
+	Set(name string, ns string, value float64)     // 仪表
+}
+
+type metricsHolder struct {
+	m Metrics
+}
+
+var metrics atomic.Value
+
+func init() {
+	metrics.Store(metricsHolder{m: NewTextMetrics()})
+}
+
+// SetMetrics 替换指标注册表，nil 表示关闭指标
+func SetMetrics(m Metrics) {
+	metrics.Store(metricsHolder{m: m})
+}
+
+// GetMetrics 当前的指标注册表
+func GetMetrics() Metrics {
+	return metrics.Load().(metricsHolder).m
+}
+
+func metricInc(name string, ns string, delta float64) {
+	if m := GetMetrics(); m != nil {
+		m.Inc(name, ns, delta)
+	}
+}
+
+func metricObserve(name string, ns string, value float64) {
+	if m := GetMetrics(); m != nil {
+		m.Observe(name, ns, value)
+	}
+}
+
+// CollectMetrics 采集仪表类指标（队列积压、缓存大小）和缓存命中次数的增量，由 MetricsHandler 在每次抓取时调用
+func CollectMetrics() {
+	m := GetMetrics()
+	if m == nil {
+		return
+	}
+
+	for ns, src := range nsSrcMap {
+		if src.saver != nil {
+			m.Set(MetricPending, ns, float64(src.saver.OnGoingCount()))
+		}
+		if src.repo != nil && src.repo.initialized {
+			m.Set(MetricRepoEntries, ns, float64(src.repo.Len()))
+			src.repo.collectMetrics(m)
+		}
+	}
+}
+
+// MetricsHandler 输出指标的 HTTP 处理器，注册表需要实现 http.Handler（TextMetrics 输出 Prometheus 文本格式）
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		CollectMetrics()
+
+		h, ok := GetMetrics().(http.Handler)
+		if !ok {
+			http.Error(w, "metrics registry is not exposed over http", http.StatusNotFound)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+type metricKind int8
+
+const (
+	kindCounter metricKind = iota
+	kindGauge
+	kindHistogram
+)
+
+func (k metricKind) String() string {
+	switch k {
+	case kindGauge:
+		return "gauge"
+	case kindHistogram:
+		return "histogram"
+	}
+	return "counter"
+}
+
+type metricDesc struct {
+	help    string
+	kind    metricKind
+	buckets []float64
+}
+
+var metricDescs = map[string]metricDesc{
+	MetricQueued:      {help: "Commitments put into the save queue.", kind: kindCounter},
+	MetricMerged:      {help: "Commitments merged into a queued commitment of the same record.", kind: kindCounter},
+	MetricCritical:    {help: "Immediate flushes triggered by commitments touching critical fields.", kind: kindCounter},
+	MetricBatchSize:   {help: "Commitments per flushed batch.", kind: kindHistogram, buckets: []float64{1, 2, 4, 8, 16, 32, 64, 128, 256}},
+	MetricFlush:       {help: "Time spent flushing a batch to storage.", kind: kindHistogram, buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}},
+	MetricRetries:     {help: "Save retries caused by transient errors.", kind: kindCounter},
+	MetricDeadLetters: {help: "Commitments written to the dead letter store.", kind: kindCounter},
+	MetricPending:     {help: "Commitments waiting in the save queue.", kind: kindGauge},
+	MetricRepoEntries: {help: "Objects cached in the repo.", kind: kindGauge},
+	MetricRepoHits:    {help: "Repo lookups served from cache.", kind: kindCounter},
+	MetricRepoMisses:  {help: "Repo lookups that missed the cache.", kind: kindCounter},
+	MetricEvictions:   {help: "Objects evicted from the repo.", kind: kindCounter},
+}
+
+type metricSeries struct {
+	value   float64
+	sum     float64
+	count   uint64
+	buckets []uint64
+}
+
+type metricFamily struct {
+	name   string
+	desc   metricDesc
+	series map[string]*metricSeries
+}
+
+// TextMetrics 内置的指标注册表，以 Prometheus 文本格式输出
+type TextMetrics struct {
+	mu       sync.Mutex
+	families map[string]*metricFamily
+}
+
+// NewTextMetrics 创建内置的指标注册表
+func NewTextMetrics() *TextMetrics {
+	return &TextMetrics{families: map[string]*metricFamily{}}
+}
+
+func (t *TextMetrics) series(name string, ns string, kind metricKind) *metricSeries {
+	f, ok := t.families[name]
+	if !ok {
+		desc, known := metricDescs[name]
+		if !known {
+			desc = metricDesc{help: name, kind: kind}
+		}
+		f = &metricFamily{name: name, desc: desc, series: map[string]*metricSeries{}}
+		t.families[name] = f
+	}
+
+	s, ok := f.series[ns]
+	if !ok {
+		s = &metricSeries{}
+		if f.desc.kind == kindHistogram {
+			s.buckets = make([]uint64, len(f.desc.buckets))
+		}
+		f.series[ns] = s
+	}
+	return s
+}
+
+func (t *TextMetrics) Inc(name string, ns string, delta float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.series(name, ns, kindCounter).value += delta
+}
+
+func (t *TextMetrics) Set(name string, ns string, value float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.series(name, ns, kindGauge).value = value
+}
+
+func (t *TextMetrics) Observe(name string, ns string, value float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.series(name, ns, kindHistogram)
+	s.sum += value
+	s.count++
+	for i, le := range t.families[name].desc.buckets {
+		if value <= le {
+			s.buckets[i]++
+		}
+	}
+}
+
+// WriteTo 以 Prometheus 文本格式输出所有指标
+func (t *TextMetrics) WriteTo(w io.Writer) (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	names := make([]string, 0, len(t.families))
+	for name := range t.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, name := range names {
+		f := t.families[name]
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", name, f.desc.help, name, f.desc.kind)
+
+		nss := make([]string, 0, len(f.series))
+		for ns := range f.series {
+			nss = append(nss, ns)
+		}
+		sort.Strings(nss)
+
+		for _, ns := range nss {
+			s := f.series[ns]
+			label := strconv.Quote(ns)
+			if f.desc.kind != kindHistogram {
+				fmt.Fprintf(cw, "%s{namespace=%s} %s\n", name, label, formatFloat(s.value))
+				continue
+			}
+
+			for i, le := range f.desc.buckets {
+				fmt.Fprintf(cw, "%s_bucket{namespace=%s,le=\"%s\"} %d\n", name, label, formatFloat(le), s.buckets[i])
+			}
+			fmt.Fprintf(cw, "%s_bucket{namespace=%s,le=\"+Inf\"} %d\n", name, label, s.count)
+			fmt.Fprintf(cw, "%s_sum{namespace=%s} %s\n", name, label, formatFloat(s.sum))
+			fmt.Fprintf(cw, "%s_count{namespace=%s} %d\n", name, label, s.count)
+		}
+	}
+
+	if err := cw.w.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+func (t *TextMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = t.WriteTo(w)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,67 @@
+package xdb
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTextMetrics(t *testing.T) {
+	m := NewTextMetrics()
+	m.Inc(MetricQueued, "player", 3)
+	m.Inc(MetricQueued, "item", 1)
+	m.Set(MetricPending, "player", 7)
+	m.Observe(MetricBatchSize, "player", 3)
+	m.Observe(MetricBatchSize, "player", 100)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+
+	for _, line := range []string{
+		"# TYPE xdb_commitments_queued_total counter",
+		`xdb_commitments_queued_total{namespace="item"} 1`,
+		`xdb_commitments_queued_total{namespace="player"} 3`,
+		"# TYPE xdb_saver_pending gauge",
+		`xdb_saver_pending{namespace="player"} 7`,
+		"# TYPE xdb_batch_size histogram",
+		`xdb_batch_size_bucket{namespace="player",le="2"} 0`,
+		`xdb_batch_size_bucket{namespace="player",le="4"} 1`,
+		`xdb_batch_size_bucket{namespace="player",le="128"} 2`,
+		`xdb_batch_size_bucket{namespace="player",le="+Inf"} 2`,
+		`xdb_batch_size_sum{namespace="player"} 103`,
+		`xdb_batch_size_count{namespace="player"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in output:\n%s", line, out)
+		}
+	}
+}
+
+func TestRepo_CollectHits(t *testing.T) {
+	repo := &Repo{}
+	repo.Init(true, &RepoOptions{GroupSize: 1}, "repo_test", nil, &sync.WaitGroup{})
+	repo.SetOnFetch(&redoTestPK{Id: 1}, "a", false, nil)
+	repo.Get(&redoTestPK{Id: 1})
+	repo.Get(&redoTestPK{Id: 1})
+	repo.Get(&redoTestPK{Id: 2})
+
+	// 每次采集只上报增量
+	m := NewTextMetrics()
+	repo.collectMetrics(m)
+	repo.Get(&redoTestPK{Id: 2})
+	repo.collectMetrics(m)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+	for _, line := range []string{
+		`xdb_repo_hits_total{namespace="repo_test"} 2`,
+		`xdb_repo_misses_total{namespace="repo_test"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in output:\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package xdb
+
+import (
+	"context"
+	"sort"
+
+	clog "github.com/cherry-game/cherry/logger"
+	"github.com/pkg/errors"
+)
+
+// ErrDestructive 存在可能丢失数据的表结构变更
+var ErrDestructive = errors.New("destructive schema change")
+
+// SchemaChange 表结构变更
+type SchemaChange struct {
+	Table       string
+	SQL         string
+	Destructive bool // 删除列、修改列类型、主键或索引，可能丢失数据
+}
+
+// MigrateOptions 表结构迁移选项
+type MigrateOptions struct {
+	DryRun           bool // 只比较并返回变更，不执行
+	AllowDestructive bool // 允许执行破坏性变更，否则存在破坏性变更时不执行任何变更，返回 ErrDestructive
+}
+
+// Migrator 支持表结构迁移的 DAO，比较数据源与数据库中的表结构，返回并按选项执行变更
+type Migrator interface {
+	Migrate(ctx context.Context, srcs []*Source, opts *MigrateOptions) ([]*SchemaChange, error)
+}
+
+// MigrateConfig 配置器可选实现，返回非空时 Setup 在创建 DAO 之后、加载数据之前迁移表结构
+type MigrateConfig interface {
+	MigrateOptions() *MigrateOptions
+}
+
+// migrate 按 DAO 分组迁移数据源的表结构，不支持迁移的 DAO 被忽略
+func migrate(ctx context.Context, opts *MigrateOptions, options map[*Source]*TableOptions, creations map[interface{}]*daoCreation) error {
+	groups := map[interface{}][]*Source{}
+	for src, tableOpts := range options {
+		groups[tableOpts.DaoKey] = append(groups[tableOpts.DaoKey], src)
+	}
+
+	for daoKey, srcs := range groups {
+		migrator, ok := creations[daoKey].dao.(Migrator)
+		if !ok {
+			continue
+		}
+
+		sort.Slice(srcs, func(i, j int) bool {
+			if srcs[i].Namespace != srcs[j].Namespace {
+				return srcs[i].Namespace < srcs[j].Namespace
+			}
+			return srcs[i].TableName < srcs[j].TableName
+		})
+		changes, err := migrator.Migrate(ctx, srcs, opts)
+		for _, change := range changes {
+			clog.Infof("[xdb] schema change. [table = %s, sql = %s, destructive = %v, dry_run = %v]", change.Table, change.SQL, change.Destructive, opts.DryRun)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to migrate schema, dao: %v", daoKey)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package xdb
+
+import (
+	"math"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// CreatePK 根据参数创建主键，参数为主键字段的值（可以只有前缀），或者一个本数据源的主键
+func (src *Source) CreatePK(args []interface{}) (PK, error) {
+	if len(args) == 1 {
+		if pk, ok := args[0].(PK); ok {
+			if pk.Source() != src {
+				return nil, errors.Errorf("pk %s is not of [%s]", pk, src.Namespace)
+			}
+			return pk, nil
+		}
+	}
+	return src.PKCreator(args)
+}
+
+// PKArg 生成的 PKCreator 读取第 i 个参数作为主键字段 name 的值
+// 整数之间按值转换，超出目标类型的范围时返回错误；底层类型相同的类型（如 int 和自定义的整数类型）直接转换
+func PKArg[V any](args []interface{}, i int, name string) (V, error) {
+	var ret V
+	arg := args[i]
+	if v, ok := arg.(V); ok {
+		return v, nil
+	}
+
+	target := reflect.ValueOf(&ret).Elem()
+	if arg != nil {
+		rv := reflect.ValueOf(arg)
+		switch {
+		case isSignedKind(rv.Kind()) && setInt(target, rv.Int()):
+			return ret, nil
+		case isUnsignedKind(rv.Kind()) && setUint(target, rv.Uint()):
+			return ret, nil
+		case rv.Kind() == target.Kind() && rv.Type().ConvertibleTo(target.Type()):
+			target.Set(rv.Convert(target.Type()))
+			return ret, nil
+		}
+	}
+	return ret, errors.Errorf("invalid pk arg %s: %T(%v) is not %s", name, arg, arg, target.Type())
+}
+
+func isSignedKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUnsignedKind(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+// setInt 整数转换为目标类型，超出范围时返回 false
+func setInt(target reflect.Value, n int64) bool {
+	switch {
+	case isSignedKind(target.Kind()) && !target.OverflowInt(n):
+		target.SetInt(n)
+		return true
+	case isUnsignedKind(target.Kind()) && n >= 0 && !target.OverflowUint(uint64(n)):
+		target.SetUint(uint64(n))
+		return true
+	}
+	return false
+}
+
+func setUint(target reflect.Value, n uint64) bool {
+	if isSignedKind(target.Kind()) {
+		return n <= math.MaxInt64 && setInt(target, int64(n))
+	}
+	if isUnsignedKind(target.Kind()) && !target.OverflowUint(n) {
+		target.SetUint(n)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package xdb
+
+import (
+	"testing"
+)
+
+type pkTestID int64
+
+func TestPKArg(t *testing.T) {
+	if v, err := PKArg[int64]([]interface{}{int32(-3)}, 0, "id"); err != nil || v != -3 {
+		t.Errorf("unexpected int32 to int64: %v %v", v, err)
+	}
+	if v, err := PKArg[int32]([]interface{}{7}, 0, "id"); err != nil || v != 7 {
+		t.Errorf("unexpected int to int32: %v %v", v, err)
+	}
+	if v, err := PKArg[uint32]([]interface{}{uint64(9)}, 0, "id"); err != nil || v != 9 {
+		t.Errorf("unexpected uint64 to uint32: %v %v", v, err)
+	}
+	if v, err := PKArg[int64]([]interface{}{pkTestID(5)}, 0, "id"); err != nil || v != 5 {
+		t.Errorf("unexpected named to int64: %v %v", v, err)
+	}
+
+	for _, arg := range []interface{}{int64(1) << 40, "1", 1.0, nil} {
+		if _, err := PKArg[int32]([]interface{}{arg}, 0, "id"); err == nil {
+			t.Errorf("expected error of %T(%v)", arg, arg)
+		}
+	}
+	if _, err := PKArg[uint64]([]interface{}{-1}, 0, "id"); err == nil {
+		t.Error("expected error of negative uint")
+	}
+}
+
+func TestSource_CreatePK(t *testing.T) {
+	src := &Source{Namespace: "pk_test", PKCreator: func(args []interface{}) (PK, error) {
+		return &redoTestPK{Id: args[0].(int64)}, nil
+	}}
+
+	// PKCreator 的 panic 是代码错误，不转为错误返回
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic of PKCreator surfaced")
+			}
+		}()
+		_, _ = src.CreatePK([]interface{}{"1"})
+	}()
+	if _, err := src.CreatePK([]interface{}{&redoTestPK{Id: 1}}); err == nil {
+		t.Error("expected error of pk from other source")
+	}
+
+	pk := &redoTestPK{Id: 1}
+	if got, err := redoTestSource.CreatePK([]interface{}{pk}); err != nil || got != pk {
+		t.Errorf("unexpected pk: %v %v", got, err)
+	}
+}
@@ -4,19 +4,28 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"go/token"
+	"strconv"
 	"strings"
 	"text/template"
 
 	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/pluginpb"
 )
 
 const version = "v1.0.0"
 
+// maxFields 记录最多的入库字段数，与 xdb.MaxFields 一致
+const maxFields = 1024
+
 var (
 	showVersion = flag.Bool("version", false, "show version")
 
+	// 物理分表数，SQL 为每个分片生成建表语句，如 --xdb_opt=shards=item:32,shards=player:4
+	shards = shardFlag{}
+
 	// SQL schema buffers for MySQL
 	// key: relativePath/tableName, value: buffer
 	sqlSchemaBuffers = make(map[string]*bytes.Buffer)
@@ -25,6 +34,10 @@ var (
 	sqlFilePaths = make(map[string]string)
 )
 
+func init() {
+	flag.Var(shards, "shards", "table:count, may be repeated")
+}
+
 func main() {
 	flag.Parse()
 	if *showVersion {
@@ -89,9 +102,8 @@ func generateXdbFile(gen *protogen.Plugin, f *protogen.File) error {
 			filename = filename[idx+1:]
 		}
 	}
-	// 使用空字符串作为 import path，让 protoc 根据 filename 路径自动处理
-	// 这样可以避免生成到 lucky/server/gen/db 这样的完整路径
-	g := gen.NewGeneratedFile(filename, "")
+	// 输出路径由 filename 决定，import path 只用于判断同包的消息类型（setter 的参数）不需要导入
+	g := gen.NewGeneratedFile(filename, f.GoImportPath)
 
 	// 收集所有需要生成的 message
 	messagesToGenerate := []*protogen.Message{}
@@ -155,8 +167,11 @@ func generateMessageCode(g *protogen.GeneratedFile, gen *protogen.Plugin, f *pro
 		"pk":             pkTemplate,
 		"record":         recordTemplate,
 		"mutableRecord":  mutableRecordTemplate,
+		"setter":         setterTemplate,
 		"source":         sourceTemplate,
 		"commitment":     commitmentTemplate,
+		"get":            getTemplate,
+		"unique":         uniqueTemplate,
 		"init":           initTemplate,
 	}
 
@@ -170,7 +185,7 @@ func generateMessageCode(g *protogen.GeneratedFile, gen *protogen.Plugin, f *pro
 	}
 
 	// 按顺序生成代码
-	generateOrder := []string{"fieldConstants", "pk", "record", "mutableRecord", "source", "commitment", "init"}
+	generateOrder := []string{"fieldConstants", "pk", "record", "mutableRecord", "setter", "source", "commitment", "get", "unique", "init"}
 	for _, templateName := range generateOrder {
 		tmpl := parsedTemplates[templateName]
 		code, err := executeTemplate(tmpl, data)
@@ -212,6 +227,8 @@ func buildTemplateData(g *protogen.GeneratedFile, f *protogen.File, msg *protoge
 	// 构建字段信息
 	fields := []FieldInfo{}
 	pkFieldInfos := []FieldInfo{}
+	var uniqueFields []FieldInfo
+	var criticalFields []FieldInfo
 	fieldPrefix := msg.GoIdent.GoName + "Field"
 
 	for _, field := range msg.Fields {
@@ -231,6 +248,7 @@ func buildTemplateData(g *protogen.GeneratedFile, f *protogen.File, msg *protoge
 			IsPK:      false,
 			IsRuntime: isRuntime,
 			ConstName: constName,
+			ArgName:   toArgName(fieldName),
 			Comment:   getFieldComment(field),
 		}
 
@@ -243,8 +261,24 @@ func buildTemplateData(g *protogen.GeneratedFile, f *protogen.File, msg *protoge
 			}
 		}
 
+		if isGKField(field) {
+			fieldInfo.IsGK = true
+			uniqueFields = append(uniqueFields, fieldInfo)
+		}
+
+		if !fieldInfo.IsPK {
+			setSetterInfo(g, field, &fieldInfo)
+		}
+
+		if isCriticalField(field) {
+			criticalFields = append(criticalFields, fieldInfo)
+		}
+
 		fields = append(fields, fieldInfo)
 	}
+	if len(fields) > maxFields {
+		return nil, fmt.Errorf("too many fields: %d > %d", len(fields), maxFields)
+	}
 
 	// 构建模板数据
 	data := &TemplateData{
@@ -262,6 +296,12 @@ func buildTemplateData(g *protogen.GeneratedFile, f *protogen.File, msg *protoge
 		DriverName:     driverName,
 		Namespace:      tableName,
 		KeySize:        len(pkFields),
+		Versioned:      findField(msg, versionFieldName) != nil,
+		Nested:         getLayout(msg) == layoutNested,
+		UniqueFields:   uniqueFields,
+		ShardField:     getShardField(msg),
+		History:        isHistory(msg),
+		CriticalFields: criticalFields,
 	}
 
 	return data, nil
@@ -315,6 +355,175 @@ func getDriver(msg *protogen.Message) string {
 	return "none"
 }
 
+// xdb 扩展选项的字段号，与 extension.proto 一致
+const (
+	optionLayout    protowire.Number = 71004
+	optionHistory   protowire.Number = 71009
+	optionGK        protowire.Number = 72002
+	optionReadonly  protowire.Number = 72005
+	optionCritical  protowire.Number = 72007
+	optionShardHint protowire.Number = 72008
+)
+
+// 布局类型，与 extension.proto 中的 LayoutType 一致
+const (
+	layoutFlat   = 0
+	layoutNested = 1
+)
+
+// getLayout 读取 (xdb.layout)，默认为扁平布局
+func getLayout(msg *protogen.Message) uint64 {
+	v, _ := optionVarint(msg.Desc.Options(), optionLayout)
+	return v
+}
+
+// isHistory 读取 (xdb.history)
+func isHistory(msg *protogen.Message) bool {
+	v, _ := optionVarint(msg.Desc.Options(), optionHistory)
+	return v != 0
+}
+
+// isGKField 是否为 (xdb.gk) 全局唯一字段，只支持标量字段
+func isGKField(field *protogen.Field) bool {
+	if field.Message != nil || field.Desc.IsList() || field.Desc.IsMap() {
+		return false
+	}
+	v, _ := optionVarint(field.Desc.Options(), optionGK)
+	return v != 0
+}
+
+// isCriticalField 是否为 (xdb.critical) 关键字段
+func isCriticalField(field *protogen.Field) bool {
+	v, _ := optionVarint(field.Desc.Options(), optionCritical)
+	return v != 0
+}
+
+// setSetterInfo 根据字段类型填充 setter 信息
+func setSetterInfo(g *protogen.GeneratedFile, field *protogen.Field, info *FieldInfo) {
+	v, _ := optionVarint(field.Desc.Options(), optionReadonly)
+	info.Readonly = v != 0
+
+	switch {
+	case field.Desc.IsMap():
+		info.SetterKind = "map"
+		info.KeyType = elemGoType(g, field.Message.Fields[0])
+		info.ValueType = elemGoType(g, field.Message.Fields[1])
+	case field.Desc.IsList():
+		info.SetterKind = "list"
+		info.ValueType = elemGoType(g, field)
+	case field.Message != nil:
+		info.SetterKind = "message"
+		info.ValueType = elemGoType(g, field)
+	case field.Desc.HasOptionalKeyword():
+		info.SetterKind = "optional"
+		info.ValueType = fieldGoType(g, field)
+	case field.Desc.Kind() == protoreflect.BytesKind:
+		info.SetterKind = "bytes"
+	default:
+		info.SetterKind = "scalar"
+		info.ValueType = fieldGoType(g, field)
+		switch field.Desc.Kind() {
+		case protoreflect.BoolKind, protoreflect.StringKind, protoreflect.EnumKind:
+		default:
+			info.Numeric = true
+		}
+	}
+}
+
+// elemGoType 单个值的 Go 类型，消息为指针类型
+func elemGoType(g *protogen.GeneratedFile, field *protogen.Field) string {
+	if field.Message != nil {
+		return "*" + fieldGoType(g, field)
+	}
+	return fieldGoType(g, field)
+}
+
+// getShardField 读取 (xdb.shard_hint) 主键字段的列名，没有时返回空
+func getShardField(msg *protogen.Message) string {
+	for _, field := range getPKFields(msg) {
+		if v, _ := optionVarint(field.Desc.Options(), optionShardHint); v != 0 {
+			return string(field.Desc.Name())
+		}
+	}
+	return ""
+}
+
+// shardFlag 表名到分片数的映射，参数格式为 table:count，可以重复
+type shardFlag map[string]int
+
+func (f shardFlag) String() string {
+	parts := make([]string, 0, len(f))
+	for table, count := range f {
+		parts = append(parts, fmt.Sprintf("%s:%d", table, count))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f shardFlag) Set(v string) error {
+	i := strings.LastIndex(v, ":")
+	if i <= 0 {
+		return fmt.Errorf("invalid shards %q, expect table:count", v)
+	}
+	count, err := strconv.Atoi(v[i+1:])
+	if err != nil || count <= 0 {
+		return fmt.Errorf("invalid shards %q, expect table:count", v)
+	}
+	f[v[:i]] = count
+	return nil
+}
+
+// shardTableNames 表的所有物理表名，命名与 xdb.ShardTableName 一致，例如 item_00..item_31
+func shardTableNames(table string) []string {
+	count, ok := shards[table]
+	if !ok {
+		return []string{table}
+	}
+
+	width := len(strconv.Itoa(count - 1))
+	if width < 2 {
+		width = 2
+	}
+	names := make([]string, count)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s_%0*d", table, width, i)
+	}
+	return names
+}
+
+// optionVarint 读取 varint 类型的扩展选项
+// 插件没有编译 extension.proto，选项中的 xdb 扩展以未知字段保存，重复出现时以最后一次为准
+func optionVarint(opts protoreflect.ProtoMessage, num protowire.Number) (uint64, bool) {
+	if opts == nil || !opts.ProtoReflect().IsValid() {
+		return 0, false
+	}
+
+	var ret uint64
+	var found bool
+	b := opts.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			break
+		}
+		b = b[l:]
+
+		if n == num && typ == protowire.VarintType {
+			v, l := protowire.ConsumeVarint(b)
+			if l < 0 {
+				break
+			}
+			ret, found = v, true
+		}
+
+		l = protowire.ConsumeFieldValue(n, typ, b)
+		if l < 0 {
+			break
+		}
+		b = b[l:]
+	}
+	return ret, found
+}
+
 func getPKFields(msg *protogen.Message) []*protogen.Field {
 	var pkFields []*protogen.Field
 	for _, field := range msg.Fields {
@@ -342,6 +551,9 @@ func getPKFields(msg *protogen.Message) []*protogen.Field {
 	return pkFields
 }
 
+// versionFieldName 乐观锁版本号字段，proto 中声明为运行时字段，但会作为 _version 列持久化
+const versionFieldName = "_version"
+
 func isRuntimeField(field *protogen.Field) bool {
 	// 检查是否是运行时字段（以 _ 开头）
 	return strings.HasPrefix(string(field.Desc.Name()), "_")
@@ -395,6 +607,22 @@ func toUpperCamelCase(s string) string {
 	return strings.ToUpper(s[:1]) + s[1:]
 }
 
+// toArgName 主键字段的参数名，与关键字或生成代码中的标识符冲突时加后缀
+func toArgName(s string) string {
+	if len(s) == 0 {
+		return s
+	}
+	name := strings.ToLower(s[:1]) + s[1:]
+	switch name {
+	case "ctx", "context", "fmt", "xdb", "proto", "pk", "err":
+		return name + "Arg"
+	}
+	if token.IsKeyword(name) {
+		return name + "Arg"
+	}
+	return name
+}
+
 // getSQLSchemaBuffer 获取 SQL schema buffer
 func getSQLSchemaBuffer(srcFileName, tableName string) *bytes.Buffer {
 	buff, ok := sqlSchemaBuffers[tableName]
@@ -426,9 +654,8 @@ func generateSQLSchema(gen *protogen.Plugin, f *protogen.File, msg *protogen.Mes
 	relativePath := getRelativePathForSQL(srcFileName)
 	sqlFilePaths[tableName] = relativePath
 
-	// 使用 tableName 作为 key，但记录路径信息
-	schema := getSQLSchemaBuffer(srcFileName, tableName)
-	schema.WriteString("\nCREATE TABLE `" + tableName + "` (")
+	// 列定义写入临时缓冲，分表时每个物理表使用相同的定义
+	schema := &bytes.Buffer{}
 
 	pks := []string{}
 	idx := 0
@@ -447,63 +674,136 @@ func generateSQLSchema(gen *protogen.Plugin, f *protogen.File, msg *protogen.Mes
 			}
 		}
 
-		schema.WriteString("\n    `")
-		schema.WriteString(string(field.Desc.Name()))
-		schema.WriteString("` ")
-
-		// 根据字段类型生成 SQL 类型
-		switch field.Desc.Kind() {
-		case protoreflect.FloatKind:
-			schema.WriteString("FLOAT NOT NULL")
-		case protoreflect.DoubleKind:
-			schema.WriteString("DOUBLE NOT NULL")
-		case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
-			schema.WriteString("BIGINT(20) NOT NULL DEFAULT 0")
-		case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind, protoreflect.EnumKind:
-			schema.WriteString("INT(11) NOT NULL DEFAULT 0")
-		case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
-			schema.WriteString("BIGINT(20) UNSIGNED NOT NULL DEFAULT 0")
-		case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
-			schema.WriteString("INT(11) UNSIGNED NOT NULL DEFAULT 0")
-		case protoreflect.BoolKind:
-			schema.WriteString("TINYINT NOT NULL DEFAULT 0")
-		case protoreflect.StringKind:
-			// 默认 VARCHAR(255)，可以根据需要调整
-			schema.WriteString("VARCHAR(255) NOT NULL DEFAULT ''")
-		case protoreflect.BytesKind:
-			schema.WriteString("BLOB NOT NULL")
-		case protoreflect.MessageKind:
-			// 消息类型使用 TEXT 存储 JSON
-			schema.WriteString("TEXT NOT NULL")
-		default:
-			schema.WriteString("TEXT NOT NULL")
-		}
-
-		// 添加注释
-		if comment := getFieldComment(field); comment != "" {
-			schema.WriteString(" COMMENT '")
-			schema.WriteString(comment)
-			schema.WriteString("'")
-		}
-
-		schema.WriteString(",")
+		writeSQLColumn(schema, field, "", getLayout(msg) == layoutFlat, nil)
 
 		if isPK {
 			pks = append(pks, string(field.Desc.Name()))
 		}
 	}
 
+	// 乐观锁版本号
+	if findField(msg, versionFieldName) != nil {
+		schema.WriteString("\n    `" + versionFieldName + "` BIGINT(20) NOT NULL DEFAULT 0,")
+	}
+
 	// 添加主键
 	if len(pks) > 0 {
 		schema.WriteString("\n    PRIMARY KEY(`")
 		schema.WriteString(strings.Join(pks, "`, `"))
-		schema.WriteString("`)\n")
-	} else if idx > 0 {
+		schema.WriteString("`),")
+	}
+
+	// 全局唯一键
+	for _, field := range msg.Fields {
+		if !isRuntimeField(field) && isGKField(field) {
+			name := string(field.Desc.Name())
+			schema.WriteString("\n    UNIQUE KEY `uk_" + name + "` (`" + name + "`),")
+		}
+	}
+
+	if len(pks) > 0 || idx > 0 {
 		// 移除最后一个逗号
 		schema.Truncate(schema.Len() - 1)
+		schema.WriteString("\n")
 	}
 
 	schema.WriteString(") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n")
+
+	// 使用 tableName 作为 key，但记录路径信息
+	out := getSQLSchemaBuffer(srcFileName, tableName)
+	for _, name := range shardTableNames(tableName) {
+		out.WriteString("\nCREATE TABLE `" + name + "` (")
+		out.Write(schema.Bytes())
+	}
+
+	// 历史表，分表时共用一个，与 MySQL 驱动创建的一致
+	if isHistory(msg) {
+		out.WriteString("\nCREATE TABLE `" + tableName + "_history` (" + historySchema)
+	}
+}
+
+// historySchema (xdb.history) 历史表的列定义
+const historySchema = "" +
+	"\n    `id` BIGINT(20) NOT NULL AUTO_INCREMENT," +
+	"\n    `pk` VARCHAR(255) NOT NULL DEFAULT ''," +
+	"\n    `version` BIGINT(20) NOT NULL DEFAULT 0," +
+	"\n    `lifecycle` TINYINT(4) NOT NULL DEFAULT 0," +
+	"\n    `changes` VARBINARY(136) NOT NULL DEFAULT ''," +
+	"\n    `diff` TEXT NOT NULL," +
+	"\n    `data` MEDIUMBLOB NOT NULL," +
+	"\n    `ctime` BIGINT(20) NOT NULL DEFAULT 0," +
+	"\n    `origin` VARCHAR(255) NOT NULL DEFAULT ''," +
+	"\n    PRIMARY KEY(`id`)," +
+	"\n    KEY `idx_pk_version` (`pk`, `version`)" +
+	"\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n"
+
+// writeSQLColumn 写入字段对应的列，扁平布局下子消息的字段展开为 <字段名>_<子字段名> 列，
+// repeated、map、optional 和嵌套布局的子消息以 JSON 保存在 TEXT 列中
+func writeSQLColumn(schema *bytes.Buffer, field *protogen.Field, prefix string, flat bool, parents []*protogen.Message) {
+	name := prefix + string(field.Desc.Name())
+	if flat && field.Message != nil && !field.Desc.IsList() && !field.Desc.IsMap() && !containsMessage(parents, field.Message) {
+		for _, sub := range field.Message.Fields {
+			writeSQLColumn(schema, sub, name+"_", flat, append(parents, field.Message))
+		}
+		return
+	}
+
+	schema.WriteString("\n    `")
+	schema.WriteString(name)
+	schema.WriteString("` ")
+
+	// 根据字段类型生成 SQL 类型
+	switch {
+	case field.Desc.IsList() || field.Desc.IsMap() || field.Desc.HasOptionalKeyword():
+		schema.WriteString("TEXT NOT NULL")
+	default:
+		schema.WriteString(sqlColumnType(field.Desc.Kind()))
+	}
+
+	// 添加注释
+	if comment := getFieldComment(field); comment != "" {
+		schema.WriteString(" COMMENT '")
+		schema.WriteString(comment)
+		schema.WriteString("'")
+	}
+
+	schema.WriteString(",")
+}
+
+// sqlColumnType 标量字段的 SQL 类型
+func sqlColumnType(kind protoreflect.Kind) string {
+	switch kind {
+	case protoreflect.FloatKind:
+		return "FLOAT NOT NULL"
+	case protoreflect.DoubleKind:
+		return "DOUBLE NOT NULL"
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return "BIGINT(20) NOT NULL DEFAULT 0"
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind, protoreflect.EnumKind:
+		return "INT(11) NOT NULL DEFAULT 0"
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return "BIGINT(20) UNSIGNED NOT NULL DEFAULT 0"
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return "INT(11) UNSIGNED NOT NULL DEFAULT 0"
+	case protoreflect.BoolKind:
+		return "TINYINT NOT NULL DEFAULT 0"
+	case protoreflect.StringKind:
+		// 默认 VARCHAR(255)，可以根据需要调整
+		return "VARCHAR(255) NOT NULL DEFAULT ''"
+	case protoreflect.BytesKind:
+		return "BLOB NOT NULL"
+	}
+	// 消息类型使用 TEXT 存储 JSON
+	return "TEXT NOT NULL"
+}
+
+func containsMessage(msgs []*protogen.Message, msg *protogen.Message) bool {
+	for _, m := range msgs {
+		if m == msg {
+			return true
+		}
+	}
+	return false
 }
 
 // getFieldComment 获取字段注释
@@ -28,6 +28,20 @@ type TemplateData struct {
 	DriverName string
 	Namespace  string
 	KeySize    int
+	Versioned  bool // 是否包含 _version 运行时字段（乐观锁）
+	Nested     bool // (xdb.layout) = LAYOUT_NESTED，子消息保存在一列中
+
+	// (xdb.gk) 全局唯一字段，生成唯一索引和 Get<Message>By<Field>
+	UniqueFields []FieldInfo
+
+	// (xdb.shard_hint) 主键字段的列名，表选项配置分片时按它路由
+	ShardField string
+
+	// (xdb.history) 每个提交追加一行历史
+	History bool
+
+	// (xdb.critical) 关键字段，修改它们的提交立即入库
+	CriticalFields []FieldInfo
 
 	// 导入包
 	Imports []ImportInfo
@@ -39,9 +53,18 @@ type FieldInfo struct {
 	ProtoName string // Proto 字段名
 	GoType    string // Go 类型
 	IsPK      bool   // 是否为主键
+	IsGK      bool   // 是否为全局唯一键
+	Readonly  bool   // 是否为 (xdb.readonly) 只读字段
 	IsRuntime bool   // 是否为运行时字段
 	ConstName string // 常量名，如 "PlayerFieldPlayerId"
+	ArgName   string // 主键字段在 New<PK> 和 Get<Message> 中的参数名，如 "playerId"
 	Comment   string // 字段注释
+
+	// setter 信息，主键字段不生成 setter
+	SetterKind string // scalar、bytes、optional、message、list、map
+	ValueType  string // scalar/optional 为值类型，message 为指针类型，list 为元素类型，map 为值类型
+	KeyType    string // map 的键类型
+	Numeric    bool   // 数值类型，额外生成 Add<Field>
 }
 
 // ImportInfo 导入包信息
@@ -96,6 +119,18 @@ type {{.PKName}} struct {
 {{- end}}
 }
 
+// New{{.PKName}} 创建完整的主键
+func New{{.PKName}}({{range $i, $field := .PKFields}}{{if $i}}, {{end}}{{$field.ArgName}} {{$field.GoType}}{{end}}) *{{.PKName}} {
+	return &{{.PKName}}{
+		{{- range .PKFields}}
+		{{.GoName}}: {{.ArgName}},
+		{{- end}}
+		{{- if gt (len .PKFields) 1}}
+		validFieldNum: {{len .PKFields}},
+		{{- end}}
+	}
+}
+
 func (pk *{{.PKName}}) Source() *xdb.Source {
 	return {{.SourceName}}
 }
@@ -118,7 +153,12 @@ func (pk *{{.PKName}}) String() string {
 
 func (pk *{{.PKName}}) HashGroup() int {
 	{{- if gt (len .PKFields) 0}}
-	return int(pk.{{(index .PKFields 0).GoName}} % 16)
+	{{- $first := index .PKFields 0}}
+	{{- if eq $first.GoType "string"}}
+	return int(xdb.HashString(pk.{{$first.GoName}}) % 16)
+	{{- else}}
+	return int(pk.{{$first.GoName}} % 16)
+	{{- end}}
 	{{- else}}
 	return 0
 	{{- end}}
@@ -126,24 +166,33 @@ func (pk *{{.PKName}}) HashGroup() int {
 
 func (pk *{{.PKName}}) Empty() bool {
 	{{- if eq (len .PKFields) 1}}
-	return pk.{{(index .PKFields 0).GoName}} == 0
+	return pk.{{(index .PKFields 0).GoName}} == {{zero (index .PKFields 0).GoType}}
 	{{- else}}
 	return pk.validFieldNum == 0
 	{{- end}}
 }
 
+// PrefixOf 本主键的有效字段与 key 的对应字段全部相等
 func (pk *{{.PKName}}) PrefixOf(key xdb.Key) bool {
-	_, ok := key.(*{{.PKName}})
+	other, ok := key.(*{{.PKName}})
 	if !ok {
 		return false
 	}
-	// TODO: 实现前缀匹配逻辑
-	return false
+	{{- if eq (len .PKFields) 1}}
+	return pk.Empty() || pk.{{(index .PKFields 0).GoName}} == other.{{(index .PKFields 0).GoName}}
+	{{- else}}
+	{{- range $i, $field := .PKFields}}
+	if pk.validFieldNum > {{$i}} && pk.{{$field.GoName}} != other.{{$field.GoName}} {
+		return false
+	}
+	{{- end}}
+	return pk.validFieldNum <= other.validFieldNum
+	{{- end}}
 }
 
 func (pk *{{.PKName}}) Full() bool {
 	{{- if eq (len .PKFields) 1}}
-	return pk.{{(index .PKFields 0).GoName}} != 0
+	return pk.{{(index .PKFields 0).GoName}} != {{zero (index .PKFields 0).GoType}}
 	{{- else}}
 	return pk.validFieldNum == {{len .PKFields}}
 	{{- end}}
@@ -151,10 +200,16 @@ func (pk *{{.PKName}}) Full() bool {
 
 func (pk *{{.PKName}}) FetchFilter() interface{} {
 	filter := make(map[string]interface{})
-	{{- range .PKFields}}
-	if pk.{{.GoName}} != 0 {
-		filter["{{.ProtoName}}"] = pk.{{.GoName}}
+	{{- if eq (len .PKFields) 1}}
+	if !pk.Empty() {
+		filter["{{(index .PKFields 0).ProtoName}}"] = pk.{{(index .PKFields 0).GoName}}
 	}
+	{{- else}}
+	{{- range $i, $field := .PKFields}}
+	if pk.validFieldNum > {{$i}} {
+		filter["{{$field.ProtoName}}"] = pk.{{$field.GoName}}
+	}
+	{{- end}}
 	{{- end}}
 	return filter
 }
@@ -197,13 +252,18 @@ func (r *{{.RecordName}}) Snapshoot() interface{} {
 }
 
 func (r *{{.RecordName}}) XVersion() int64 {
-	{{- $versionField := findVersionField .Fields}}
-	{{- if $versionField}}
-	return r.{{.MessageName}}.{{$versionField.GoName}}
+	{{- if .Versioned}}
+	return r.{{.MessageName}}.XVersion
 	{{- else}}
 	return 0
 	{{- end}}
 }
+{{- if .Versioned}}
+
+func (r *{{.RecordName}}) SetXVersion(version int64) {
+	r.{{.MessageName}}.XVersion = version
+}
+{{- end}}
 
 func (r *{{.RecordName}}) GetHeader() *xdb.Header {
 	return &r.Header
@@ -259,12 +319,21 @@ func (r *{{.RecordName}}) Delete(ctx context.Context) bool {
 }
 
 func (r *{{.RecordName}}) Commit(ctx context.Context) (xdb.Commitment, xdb.FieldSet) {
+	changes := r.Header.Changes()
 	commitment := &{{.CommitmentName}}{
 		data:      &r.{{.MessageName}},
-		changes:   r.Header.Changes(),
+		changes:   changes,
 		lifecycle: r.Header.Lifecycle(),
+		{{- if .Versioned}}
+		base:      r.{{.MessageName}}.XVersion,
+		version:   r.{{.MessageName}}.XVersion + 1,
+		{{- end}}
 	}
-	return commitment, r.Header.Changes()
+	{{- if .Versioned}}
+	r.{{.MessageName}}.XVersion = commitment.version
+	{{- end}}
+	r.Header.Commit()()
+	return commitment, changes
 }
 
 func (r *{{.RecordName}}) Committing() bool {
@@ -282,6 +351,142 @@ func (r *{{.RecordName}}) SavingIndex() int32 {
 func (r *{{.RecordName}}) SetSavingIndex(idx int32) {
 	r.Header.SavingIndex = idx
 }
+`
+
+	// setterTemplate 字段 setter 模板，修改字段并标记变更，值不变时不标记
+	setterTemplate = `
+{{- range .Fields}}
+{{- if .SetterKind}}
+{{- $check := printf "if err := r.Header.CheckWrite(%q, %v); err != nil {\n\t\treturn err\n\t}" .ProtoName .Readonly}}
+{{- if eq .SetterKind "scalar"}}
+
+// Set{{.GoName}} 设置 {{.ProtoName}}{{if .Readonly}}，只读字段只能在新建的记录上修改{{end}}
+func (r *{{$.RecordName}}) Set{{.GoName}}(v {{.ValueType}}) error {
+	if r.{{.GoName}} == v {
+		return nil
+	}
+	{{$check}}
+	r.{{.GoName}} = v
+	r.Header.SetChanged({{.ConstName}})
+	return nil
+}
+{{- if .Numeric}}
+
+// Add{{.GoName}} {{.ProtoName}} 增加 delta
+func (r *{{$.RecordName}}) Add{{.GoName}}(delta {{.ValueType}}) error {
+	return r.Set{{.GoName}}(r.{{.GoName}} + delta)
+}
+{{- end}}
+{{- else if eq .SetterKind "bytes"}}
+
+// Set{{.GoName}} 设置 {{.ProtoName}}{{if .Readonly}}，只读字段只能在新建的记录上修改{{end}}
+func (r *{{$.RecordName}}) Set{{.GoName}}(v []byte) error {
+	if string(r.{{.GoName}}) == string(v) {
+		return nil
+	}
+	{{$check}}
+	r.{{.GoName}} = v
+	r.Header.SetChanged({{.ConstName}})
+	return nil
+}
+{{- else if eq .SetterKind "optional"}}
+
+// Set{{.GoName}} 设置 {{.ProtoName}}{{if .Readonly}}，只读字段只能在新建的记录上修改{{end}}
+func (r *{{$.RecordName}}) Set{{.GoName}}(v {{.ValueType}}) error {
+	if r.{{.GoName}} != nil && *r.{{.GoName}} == v {
+		return nil
+	}
+	{{$check}}
+	r.{{.GoName}} = &v
+	r.Header.SetChanged({{.ConstName}})
+	return nil
+}
+
+// Clear{{.GoName}} 清除 {{.ProtoName}}
+func (r *{{$.RecordName}}) Clear{{.GoName}}() error {
+	if r.{{.GoName}} == nil {
+		return nil
+	}
+	{{$check}}
+	r.{{.GoName}} = nil
+	r.Header.SetChanged({{.ConstName}})
+	return nil
+}
+{{- else if eq .SetterKind "message"}}
+
+// Set{{.GoName}} 设置 {{.ProtoName}}，按 proto.Equal 判断是否变化{{if .Readonly}}，只读字段只能在新建的记录上修改{{end}}
+func (r *{{$.RecordName}}) Set{{.GoName}}(v {{.ValueType}}) error {
+	if proto.Equal(r.{{.GoName}}, v) {
+		return nil
+	}
+	{{$check}}
+	r.{{.GoName}} = v
+	r.Header.SetChanged({{.ConstName}})
+	return nil
+}
+{{- else if eq .SetterKind "list"}}
+
+// Set{{.GoName}} 设置 {{.ProtoName}}{{if .Readonly}}，只读字段只能在新建的记录上修改{{end}}
+func (r *{{$.RecordName}}) Set{{.GoName}}(v []{{.ValueType}}) error {
+	if xdb.EqualList(r.{{.GoName}}, v) {
+		return nil
+	}
+	{{$check}}
+	r.{{.GoName}} = v
+	r.Header.SetChanged({{.ConstName}})
+	return nil
+}
+
+// Append{{.GoName}} 在 {{.ProtoName}} 末尾追加元素
+func (r *{{$.RecordName}}) Append{{.GoName}}(v ...{{.ValueType}}) error {
+	if len(v) == 0 {
+		return nil
+	}
+	{{$check}}
+	r.{{.GoName}} = append(r.{{.GoName}}, v...)
+	r.Header.SetChanged({{.ConstName}})
+	return nil
+}
+{{- else if eq .SetterKind "map"}}
+
+// Set{{.GoName}} 设置 {{.ProtoName}}{{if .Readonly}}，只读字段只能在新建的记录上修改{{end}}
+func (r *{{$.RecordName}}) Set{{.GoName}}(v map[{{.KeyType}}]{{.ValueType}}) error {
+	if xdb.EqualMap(r.{{.GoName}}, v) {
+		return nil
+	}
+	{{$check}}
+	r.{{.GoName}} = v
+	r.Header.SetChanged({{.ConstName}})
+	return nil
+}
+
+// Put{{.GoName}} 设置 {{.ProtoName}} 中 k 的值
+func (r *{{$.RecordName}}) Put{{.GoName}}(k {{.KeyType}}, v {{.ValueType}}) error {
+	if old, ok := r.{{.GoName}}[k]; ok && xdb.EqualValue(old, v) {
+		return nil
+	}
+	{{$check}}
+	if r.{{.GoName}} == nil {
+		r.{{.GoName}} = map[{{.KeyType}}]{{.ValueType}}{}
+	}
+	r.{{.GoName}}[k] = v
+	r.Header.SetChanged({{.ConstName}})
+	return nil
+}
+
+// Delete{{.GoName}} 删除 {{.ProtoName}} 中的 k
+func (r *{{$.RecordName}}) Delete{{.GoName}}(k {{.KeyType}}) error {
+	if _, ok := r.{{.GoName}}[k]; !ok {
+		return nil
+	}
+	{{$check}}
+	delete(r.{{.GoName}}, k)
+	r.Header.SetChanged({{.ConstName}})
+	return nil
+}
+{{- end}}
+{{- end}}
+{{- end}}
 `
 
 	// sourceTemplate Source 模板
@@ -294,42 +499,72 @@ var {{.SourceName}} = &xdb.Source{
 	DriverName: "{{.DriverName}}",
 	TableName:  "{{.TableName}}",
 	KeySize:    {{.KeySize}},
+	{{- if .Versioned}}
+	Versioned:  true,
+	{{- end}}
+	{{- if .Nested}}
+	Layout:     xdb.LayoutNested,
+	{{- end}}
+	{{- if .ShardField}}
+	ShardField: "{{.ShardField}}",
+	{{- end}}
+	{{- if .History}}
+	History:    true,
+	{{- end}}
+	Fields: []*xdb.FieldDesc{
+		{{- range .Fields}}
+		{Code: {{.ConstName}}, Name: "{{.ProtoName}}"{{if .IsPK}}, Key: true{{end}}},
+		{{- end}}
+	},
+	{{- if .UniqueFields}}
+	Indexes: []*xdb.IndexDesc{
+		{{- range .UniqueFields}}
+		{Name: "uk_{{.ProtoName}}", Columns: []string{"{{.ProtoName}}"}, Unique: true},
+		{{- end}}
+	},
+	{{- end}}
+	FieldSetSave: xdb.MakeFieldSet(
+		{{- range $i, $field := .Fields}}{{if $i}}, {{end}}{{$field.ConstName}}{{end -}}
+	),
+	{{- if .CriticalFields}}
+	FieldSetCritical: xdb.MakeFieldSet(
+		{{- range $i, $field := .CriticalFields}}{{if $i}}, {{end}}{{$field.ConstName}}{{end -}}
+	),
+	{{- end}}
 
+	// 参数按主键字段的类型无损转换，类型不符时返回错误，见 xdb.PKArg
 	PKCreator: func(args []interface{}) (xdb.PK, error) {
+		{{- if gt (len .PKFields) 1}}
+		// 参数少于主键字段数时创建前缀主键
+		if len(args) < 1 || len(args) > {{.KeySize}} {
+			return nil, fmt.Errorf("invalid args count")
+		}
+		pk := &{{.PKName}}{validFieldNum: len(args)}
+		var err error
+		{{- range $i, $field := .PKFields}}
+		if len(args) > {{$i}} {
+			if pk.{{$field.GoName}}, err = xdb.PKArg[{{$field.GoType}}](args, {{$i}}, "{{$field.ProtoName}}"); err != nil {
+				return nil, err
+			}
+		}
+		{{- end}}
+		return pk, nil
+		{{- else}}
 		if len(args) < {{.KeySize}} {
 			return nil, fmt.Errorf("invalid args count")
 		}
-		return &{{.PKName}}{
-			{{- range $i, $field := .PKFields}}
-			{{$field.GoName}}: args[{{$i}}].({{$field.GoType}}),
-			{{- end}}
-			{{- if gt (len .PKFields) 1}}
-			validFieldNum: {{len .PKFields}},
-			{{- end}}
-		}, nil
+		pk := &{{.PKName}}{}
+		var err error
+		{{- range $i, $field := .PKFields}}
+		if pk.{{$field.GoName}}, err = xdb.PKArg[{{$field.GoType}}](args, {{$i}}, "{{$field.ProtoName}}"); err != nil {
+			return nil, err
+		}
+		{{- end}}
+		return pk, nil
+		{{- end}}
 	},
 
 	PKOf: func(obj interface{}) xdb.PK {
-		{{- $hasModel := false}}
-		{{- if $hasModel}}
-		// 支持 Model 类型
-		if m, ok := obj.(xdb.Model); ok {
-			// 通过反射获取嵌入的 Record
-			val := reflect.ValueOf(m).Elem()
-			recordVal := val.FieldByName("{{.RecordName}}")
-			if recordVal.IsValid() {
-				record := recordVal.Interface().({{.RecordName}})
-				return &{{.PKName}}{
-					{{- range .PKFields}}
-					{{.GoName}}: record.{{.GoName}},
-					{{- end}}
-					{{- if gt (len .PKFields) 1}}
-					validFieldNum: {{len .PKFields}},
-					{{- end}}
-				}
-			}
-		}
-		{{- end}}
 		// 支持 Record 类型
 		if r, ok := obj.(*{{.RecordName}}); ok {
 			return &{{.PKName}}{
@@ -341,8 +576,14 @@ var {{.SourceName}} = &xdb.Source{
 				{{- end}}
 			}
 		}
-		// 支持 proto 类型
-		if p, ok := obj.(*{{.MessageName}}); ok {
+		// 支持 proto 类型，以及嵌入 Record 的 Model 类型
+		p, ok := obj.(*{{.MessageName}})
+		if !ok {
+			if r, isRecord := obj.(xdb.Record); isRecord {
+				p, ok = r.Snapshoot().(*{{.MessageName}})
+			}
+		}
+		if ok {
 			return &{{.PKName}}{
 				{{- range .PKFields}}
 				{{.GoName}}: p.{{.GoName}},
@@ -399,6 +640,10 @@ type {{.CommitmentName}} struct {
 	data      *{{.MessageName}}
 	changes   xdb.FieldSet
 	lifecycle xdb.Lifecycle
+	{{- if .Versioned}}
+	base      int64
+	version   int64
+	{{- end}}
 }
 
 func (c *{{.CommitmentName}}) Source() *xdb.Source {
@@ -411,6 +656,9 @@ func (c *{{.CommitmentName}}) Merge(other xdb.Commitment) bool {
 		return false
 	}
 	c.changes = c.changes.Union(otherC.changes)
+	{{- if .Versioned}}
+	c.version = otherC.version
+	{{- end}}
 	return true
 }
 
@@ -434,22 +682,60 @@ func (c *{{.CommitmentName}}) Unmarshal(data []byte) error {
 	c.data = &{{.MessageName}}{}
 	return proto.Unmarshal(data, c.data)
 }
+
+func (c *{{.CommitmentName}}) RecoverMeta(lifecycle xdb.Lifecycle, changes xdb.FieldSet, base int64) {
+	c.lifecycle = lifecycle
+	c.changes = changes
+	{{- if .Versioned}}
+	c.version = c.data.XVersion
+	c.base = base
+	if lifecycle == xdb.LifecycleNew {
+		c.base = 0
+	} else if base < 0 {
+		// 没有记录基准版本时按快照中的版本推算
+		c.base = c.version - 1
+	}
+	{{- end}}
+}
+{{- if .Versioned}}
+
+func (c *{{.CommitmentName}}) BaseVersion() int64 {
+	return c.base
+}
+
+func (c *{{.CommitmentName}}) Version() int64 {
+	return c.version
+}
+
+// Rebase 只修改提交对象的版本，数据是模型持有的消息，由保存协程调用时不能写入
+func (c *{{.CommitmentName}}) Rebase(version int64) {
+	c.base = version
+	c.version = version + 1
+}
+{{- end}}
+`
+
+	// getTemplate 按主键查询模板
+	getTemplate = `
+// Get{{.MessageName}} 按主键获取记录，T 为 *{{.RecordName}} 或注册的 Model 类型，不存在时返回 nil
+func Get{{.MessageName}}[T xdb.Record](ctx context.Context, {{range $i, $field := .PKFields}}{{if $i}}, {{end}}{{$field.ArgName}} {{$field.GoType}}{{end}}) (T, error) {
+	return xdb.Get[T](ctx, New{{.PKName}}({{range $i, $field := .PKFields}}{{if $i}}, {{end}}{{$field.ArgName}}{{end}}))
+}
+`
+
+	// uniqueTemplate 唯一键查询模板
+	uniqueTemplate = `
+{{- range .UniqueFields}}
+// Get{{$.MessageName}}By{{.GoName}} 按全局唯一的 {{.ProtoName}} 获取记录，T 为 *{{$.RecordName}} 或注册的 Model 类型，不存在时返回 nil
+func Get{{$.MessageName}}By{{.GoName}}[T xdb.Record](ctx context.Context, value {{.GoType}}) (T, error) {
+	return xdb.GetByUniqueS[T](ctx, {{$.SourceName}}, "{{.ProtoName}}", value)
+}
+{{- end}}
 `
 
 	// initTemplate 初始化模板
 	initTemplate = `
 func init() {
-	{{.SourceName}}.PKOf = func(obj interface{}) xdb.PK {
-		r := obj.(*{{.RecordName}})
-		return &{{.PKName}}{
-			{{- range .PKFields}}
-			{{.GoName}}: r.{{.GoName}},
-			{{- end}}
-			{{- if gt (len .PKFields) 1}}
-			validFieldNum: {{len .PKFields}},
-			{{- end}}
-		}
-	}
 	xdb.RegisterSource({{.SourceName}})
 }
 `
@@ -478,13 +764,14 @@ func parseTemplate(name, text string) (*template.Template, error) {
 		"sub": func(a, b int) int {
 			return a - b
 		},
-		"findVersionField": func(fields []FieldInfo) *FieldInfo {
-			for i := range fields {
-				if fields[i].ProtoName == "_version" || fields[i].GoName == "XVersion" {
-					return &fields[i]
-				}
+		"zero": func(goType string) string {
+			switch goType {
+			case "string":
+				return `""`
+			case "bool":
+				return "false"
 			}
-			return nil
+			return "0"
 		},
 	}
 	return template.New(name).Funcs(funcMap).Parse(text)
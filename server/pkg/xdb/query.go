@@ -0,0 +1,419 @@
+package xdb
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Op 查询条件的操作符
+type Op int8
+
+const (
+	OpNone Op = iota // 无条件，匹配所有记录
+	OpEq
+	OpIn
+	OpGt
+	OpGte
+	OpLt
+	OpLte
+	OpAnd
+	OpOr
+)
+
+var opNames = [...]string{
+	OpNone: "none",
+	OpEq:   "eq",
+	OpIn:   "in",
+	OpGt:   "gt",
+	OpGte:  "gte",
+	OpLt:   "lt",
+	OpLte:  "lte",
+	OpAnd:  "and",
+	OpOr:   "or",
+}
+
+func (op Op) String() string {
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return fmt.Sprintf("Op(%d)", op)
+}
+
+// Cond 与驱动无关的查询条件，Field 为数据库中的列名（即 proto 字段名）
+type Cond struct {
+	Op     Op
+	Field  string
+	Value  interface{}   // eq/gt/gte/lt/lte 的比较值
+	Values []interface{} // in 的候选值
+	Conds  []Cond        // and/or 的子条件
+}
+
+// Eq 等于
+func Eq(field string, value interface{}) Cond {
+	return Cond{Op: OpEq, Field: field, Value: value}
+}
+
+// In 属于候选值之一，候选值为空时不匹配任何记录
+func In(field string, values ...interface{}) Cond {
+	return Cond{Op: OpIn, Field: field, Values: values}
+}
+
+// Gt 大于
+func Gt(field string, value interface{}) Cond {
+	return Cond{Op: OpGt, Field: field, Value: value}
+}
+
+// Gte 大于等于
+func Gte(field string, value interface{}) Cond {
+	return Cond{Op: OpGte, Field: field, Value: value}
+}
+
+// Lt 小于
+func Lt(field string, value interface{}) Cond {
+	return Cond{Op: OpLt, Field: field, Value: value}
+}
+
+// Lte 小于等于
+func Lte(field string, value interface{}) Cond {
+	return Cond{Op: OpLte, Field: field, Value: value}
+}
+
+// Range 左闭右开区间 [min, max)，任意一端为 nil 表示不限制
+func Range(field string, min, max interface{}) Cond {
+	var conds []Cond
+	if min != nil {
+		conds = append(conds, Gte(field, min))
+	}
+	if max != nil {
+		conds = append(conds, Lt(field, max))
+	}
+	return And(conds...)
+}
+
+// And 所有子条件都满足
+func And(conds ...Cond) Cond {
+	conds = compactConds(conds)
+	if len(conds) == 1 {
+		return conds[0]
+	}
+	if len(conds) == 0 {
+		return Cond{}
+	}
+	return Cond{Op: OpAnd, Conds: conds}
+}
+
+// Or 任意子条件满足
+func Or(conds ...Cond) Cond {
+	return Cond{Op: OpOr, Conds: compactConds(conds)}
+}
+
+// Empty 是否为空条件
+func (c Cond) Empty() bool {
+	return c.Op == OpNone
+}
+
+func compactConds(conds []Cond) []Cond {
+	ret := make([]Cond, 0, len(conds))
+	for _, c := range conds {
+		if !c.Empty() {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// Walk 深度优先遍历所有叶子条件
+func (c Cond) Walk(fn func(leaf Cond) error) error {
+	switch c.Op {
+	case OpNone:
+		return nil
+	case OpAnd, OpOr:
+		for _, sub := range c.Conds {
+			if err := sub.Walk(fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fn(c)
+	}
+}
+
+// Order 排序
+type Order struct {
+	Field string
+	Desc  bool
+}
+
+// Query 与驱动无关的查询，驱动的 Table.Find/Count 负责翻译为各自的查询语言
+type Query struct {
+	Filter Cond
+	Sorts  []Order
+	Size   int // 最多返回的条数，<= 0 表示不限制
+	Skip   int // 跳过的条数
+}
+
+// NewQuery 创建查询，多个条件之间为 and 关系
+func NewQuery(conds ...Cond) *Query {
+	return &Query{Filter: And(conds...)}
+}
+
+// Where 追加条件，与已有条件为 and 关系
+func (q *Query) Where(conds ...Cond) *Query {
+	q.Filter = And(append([]Cond{q.Filter}, conds...)...)
+	return q
+}
+
+// OrderBy 升序排序
+func (q *Query) OrderBy(field string) *Query {
+	q.Sorts = append(q.Sorts, Order{Field: field})
+	return q
+}
+
+// OrderByDesc 降序排序
+func (q *Query) OrderByDesc(field string) *Query {
+	q.Sorts = append(q.Sorts, Order{Field: field, Desc: true})
+	return q
+}
+
+// Limit 限制返回条数
+func (q *Query) Limit(n int) *Query {
+	q.Size = n
+	return q
+}
+
+// Offset 跳过前 n 条
+func (q *Query) Offset(n int) *Query {
+	q.Skip = n
+	return q
+}
+
+// Fields 查询中引用的所有列名，驱动可据此校验
+func (q *Query) Fields() []string {
+	var fields []string
+	_ = q.Filter.Walk(func(leaf Cond) error {
+		fields = append(fields, leaf.Field)
+		return nil
+	})
+	for _, o := range q.Sorts {
+		fields = append(fields, o.Field)
+	}
+	return fields
+}
+
+// Compare 按排序字段比较两个快照，供内存驱动排序使用，无法比较的字段视为相等
+func (q *Query) Compare(a, b interface{}) int {
+	for _, o := range q.Sorts {
+		av, aok := columnValue(a, o.Field)
+		bv, bok := columnValue(b, o.Field)
+		if !aok || !bok {
+			continue
+		}
+
+		r, ok := compareValue(av, bv.Interface())
+		if !ok || r == 0 {
+			continue
+		}
+		if o.Desc {
+			return -r
+		}
+		return r
+	}
+	return 0
+}
+
+// FilterQuery 将 PK.FetchFilter 等返回的过滤条件转换为查询，map 的每一项为等值条件
+func FilterQuery(filter interface{}) (*Query, error) {
+	switch f := filter.(type) {
+	case nil:
+		return NewQuery(), nil
+	case *Query:
+		return f, nil
+	case Cond:
+		return NewQuery(f), nil
+	case map[string]interface{}:
+		fields := make([]string, 0, len(f))
+		for field := range f {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		conds := make([]Cond, len(fields))
+		for i, field := range fields {
+			conds[i] = Eq(field, f[field])
+		}
+		return NewQuery(conds...), nil
+	}
+	return nil, errors.Errorf("unsupported filter type: %T", filter)
+}
+
+// Match 在内存中对快照求值，找不到的列视为满足条件
+func (c Cond) Match(snapshot interface{}) bool {
+	return c.match(snapshot)
+}
+
+// match 在内存中对快照求值，用于校验缓存中的对象是否仍满足条件
+// 找不到的列无法求值，以数据库的结果为准
+func (c Cond) match(snapshot interface{}) bool {
+	switch c.Op {
+	case OpNone:
+		return true
+	case OpAnd:
+		for _, sub := range c.Conds {
+			if !sub.match(snapshot) {
+				return false
+			}
+		}
+		return true
+	case OpOr:
+		for _, sub := range c.Conds {
+			if sub.match(snapshot) {
+				return true
+			}
+		}
+		return false
+	}
+
+	val, ok := columnValue(snapshot, c.Field)
+	if !ok {
+		return true
+	}
+
+	switch c.Op {
+	case OpEq:
+		r, ok := compareValue(val, c.Value)
+		return ok && r == 0
+	case OpIn:
+		for _, v := range c.Values {
+			if r, ok := compareValue(val, v); ok && r == 0 {
+				return true
+			}
+		}
+		return false
+	case OpGt:
+		r, ok := compareValue(val, c.Value)
+		return ok && r > 0
+	case OpGte:
+		r, ok := compareValue(val, c.Value)
+		return ok && r >= 0
+	case OpLt:
+		r, ok := compareValue(val, c.Value)
+		return ok && r < 0
+	case OpLte:
+		r, ok := compareValue(val, c.Value)
+		return ok && r <= 0
+	}
+	return true
+}
+
+// columnValue 按 protobuf 标签中的字段名取值
+func columnValue(snapshot interface{}, column string) (reflect.Value, bool) {
+	v := reflect.ValueOf(snapshot)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if protoFieldName(t.Field(i)) == column {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// protoFieldName 从 protobuf 标签中解析字段名
+func protoFieldName(f reflect.StructField) string {
+	for _, part := range strings.Split(f.Tag.Get("protobuf"), ",") {
+		if strings.HasPrefix(part, "name=") {
+			return part[len("name="):]
+		}
+	}
+	return ""
+}
+
+// compareValue 比较两个标量，数值之间按数值比较，类型不兼容时 ok=false
+func compareValue(a reflect.Value, b interface{}) (int, bool) {
+	bv := reflect.ValueOf(b)
+	if !a.IsValid() || !bv.IsValid() {
+		return 0, false
+	}
+
+	if ai, ok := toInt(a); ok {
+		if bi, ok := toInt(bv); ok {
+			switch {
+			case ai < bi:
+				return -1, true
+			case ai > bi:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+
+	if af, ok := toFloat(a); ok {
+		bf, ok := toFloat(bv)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case af < bf:
+			return -1, true
+		case af > bf:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch a.Kind() {
+	case reflect.String:
+		if bv.Kind() != reflect.String {
+			return 0, false
+		}
+		return strings.Compare(a.String(), bv.String()), true
+	case reflect.Bool:
+		if bv.Kind() != reflect.Bool {
+			return 0, false
+		}
+		if a.Bool() == bv.Bool() {
+			return 0, true
+		}
+		if bv.Bool() {
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+func toFloat(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+func toInt(v reflect.Value) (int64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return int64(v.Uint()), true
+	}
+	return 0, false
+}
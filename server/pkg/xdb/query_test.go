@@ -0,0 +1,68 @@
+package xdb_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"lucky/server/gen/db"
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/memory"
+)
+
+func TestFind_PaginateAfterFilter(t *testing.T) {
+	ctx := setupMemory(t, &memory.Configurator{SyncInterval: time.Hour})
+	for i := int64(1); i <= 5; i++ {
+		player, err := xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: i, Name: string(rune('a' + i)), Level: int32(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		xdb.Sync(ctx, player)
+	}
+
+	levels := func(players []*db.PlayerRecord) []int32 {
+		var ret []int32
+		for _, p := range players {
+			ret = append(ret, p.GetLevel())
+		}
+		return ret
+	}
+
+	err := xdb.RunInUnitOfWork(ctx, func(ctx context.Context) error {
+		// 工作单元内修改为不满足条件的记录被过滤，分页在过滤之后进行
+		player, err := xdb.Get[*db.PlayerRecord](ctx, 1)
+		if err != nil {
+			return err
+		}
+		_ = player.SetLevel(100)
+
+		q := xdb.NewQuery(xdb.Lt("level", 10)).OrderBy("level")
+		found, err := xdb.Find[*db.PlayerRecord](ctx, q.Limit(2))
+		if err != nil {
+			return err
+		}
+		if got := levels(found); len(got) != 2 || got[0] != 2 || got[1] != 3 {
+			t.Errorf("unexpected first page: %v", got)
+		}
+
+		found, err = xdb.Find[*db.PlayerRecord](ctx, q.Limit(2).Offset(2))
+		if err != nil {
+			return err
+		}
+		if got := levels(found); len(got) != 2 || got[0] != 4 || got[1] != 5 {
+			t.Errorf("unexpected second page: %v", got)
+		}
+
+		found, err = xdb.Find[*db.PlayerRecord](ctx, q.Limit(0).Offset(3))
+		if err != nil {
+			return err
+		}
+		if got := levels(found); len(got) != 1 || got[0] != 5 {
+			t.Errorf("unexpected offset only: %v", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
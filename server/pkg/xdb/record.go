@@ -87,6 +87,12 @@ type Listener interface {
 	OnRefresh(ctx context.Context)
 }
 
+// Unloader 可选的监听器接口，模型从 Repo 中逐出后在逐出协程中回调
+// 回调时模型可能仍被所在的 actor 持有，不能修改模型
+type Unloader interface {
+	OnUnload(ctx context.Context)
+}
+
 // SourceInterface 源接口
 type SourceInterface interface {
 	Source() *Source
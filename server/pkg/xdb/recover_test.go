@@ -0,0 +1,120 @@
+package xdb_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lucky/server/gen/db"
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/memory"
+)
+
+// copyDir 复制目录下的所有文件，保留相对路径
+func copyDir(t *testing.T, from, to string) {
+	t.Helper()
+
+	err := filepath.Walk(from, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(from, path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(filepath.Join(to, filepath.Dir(rel)), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(to, rel), data, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedo_RecoverGenerated(t *testing.T) {
+	dir, crashed := t.TempDir(), t.TempDir()
+	conf := &memory.Configurator{SyncInterval: time.Hour, Redo: &xdb.RedoOptions{Dir: dir, Enabled: true}}
+	ctx := setupMemory(t, conf)
+
+	player, err := xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: 1, Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xdb.Save(ctx, player)
+	item, err := xdb.Create[*db.ItemRecord](ctx, &db.Item{PlayerId: 1, ItemId: 2, Count: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xdb.Save(ctx, item)
+	_ = item.AddCount(4)
+	xdb.Save(ctx, item)
+
+	// 保存队列入库前复制分段文件，模拟进程崩溃
+	copyDir(t, dir, crashed)
+	xdb.Stop(ctx)
+	memory.Reset()
+	copyDir(t, crashed, dir)
+
+	// 重新初始化时以生成的数据源解码并重放
+	if err = xdb.Setup(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	if row, ok := memory.Lookup(xdb.PKOf(player)); !ok || row.(*db.Player).Name != "a" {
+		t.Errorf("expected player recovered: %v", row)
+	}
+	if row, ok := memory.Lookup(db.NewItemPK(1, 2)); !ok || row.(*db.Item).Count != 7 {
+		t.Errorf("expected item recovered: %v", row)
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*", "*.redo")); len(segments) != 0 {
+		t.Errorf("expected segments removed: %v", segments)
+	}
+}
+
+func TestRedo_RecoverMergedUpdates(t *testing.T) {
+	dir, crashed := t.TempDir(), t.TempDir()
+	conf := &memory.Configurator{SyncInterval: time.Hour, Redo: &xdb.RedoOptions{Dir: dir, Enabled: true}}
+	ctx := setupMemory(t, conf)
+
+	player, err := xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: 1, Name: "a", Level: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xdb.Save(ctx, player)
+	xdb.Sync(ctx, player)
+
+	// 数据库中是版本 1，分段文件中有两次修改
+	_ = player.SetLevel(2)
+	xdb.Save(ctx, player)
+	_ = player.SetLevel(3)
+	xdb.Save(ctx, player)
+	copyDir(t, dir, crashed)
+	xdb.Stop(ctx)
+	memory.Reset()
+	_ = os.RemoveAll(dir)
+
+	if err = xdb.Setup(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	player, err = xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: 1, Name: "a", Level: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xdb.Save(ctx, player)
+	xdb.Sync(ctx, player)
+	xdb.Stop(ctx)
+
+	// 合并后的提交以第一次修改的基准版本重放，不会被当作冲突放弃
+	copyDir(t, crashed, dir)
+	if err = xdb.Setup(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	if row, ok := memory.Lookup(xdb.PKOf(player)); !ok || row.(*db.Player).Level != 3 || row.(*db.Player).XVersion != 3 {
+		t.Errorf("expected merged updates recovered: %v", row)
+	}
+	if kept, _ := filepath.Glob(filepath.Join(dir, "*", "*")); len(kept) != 0 {
+		t.Errorf("expected segments removed: %v", kept)
+	}
+}
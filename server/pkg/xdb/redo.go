@@ -0,0 +1,408 @@
+package xdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	clog "github.com/cherry-game/cherry/logger"
+	"github.com/pkg/errors"
+)
+
+const redoFileExt = ".redo"
+
+// failedFileExt 重放后仍有提交没有入库的文件
+const failedFileExt = ".failed"
+
+// redoFrameHeaderSize 帧头: 4字节长度 + 4字节 crc32
+const redoFrameHeaderSize = 8
+
+// redoMaxFrameSize 单帧的长度上限，超过时视为损坏的帧
+const redoMaxFrameSize = 64 << 20
+
+var redoSeq uint64
+
+// RecoverableCommitment 可从重做日志恢复元信息的提交对象
+// Marshal 只包含数据快照，生命周期、变更字段和基准版本由重做日志单独记录，恢复时通过 RecoverMeta 写回
+// base < 0 表示没有记录基准版本（不支持乐观锁或旧的分段文件）
+type RecoverableCommitment interface {
+	Commitment
+	RecoverMeta(lifecycle Lifecycle, changes FieldSet, base int64)
+}
+
+// redoLogFile 基于文件的重做日志，每个 CommitmentBatch 对应一个分段文件
+// 批次入库成功后分段文件被删除，进程崩溃后残留的分段在 Setup 时重放
+type redoLogFile struct {
+	mu       sync.Mutex
+	dir      string
+	path     string
+	file     *os.File
+	interval time.Duration
+	timer    *time.Timer
+	dirty    bool
+	retired  bool
+}
+
+func newRedoLogFile() *redoLogFile {
+	return &redoLogFile{}
+}
+
+// Serve 绑定到工作器，分段文件在第一次写入时才创建，避免产生大量空文件
+func (r *redoLogFile) Serve(ctx context.Context, sw *SaveWorker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.dir = redoDir(sw.owner.src)
+	r.interval = redoOptions.SyncInterval
+	r.path = ""
+	r.file = nil
+	r.dirty = false
+	r.retired = false
+}
+
+// Log 追加一条提交记录
+func (r *redoLogFile) Log(ctx context.Context, c Commitment) {
+	payload, err := c.Marshal()
+	if err != nil {
+		clog.Warnf("[xdb] redo marshal failed. [ns = %s, pk = %s, err = %v]", c.Source().Namespace, PKOf(c), err)
+		return
+	}
+
+	// 超长的帧在恢复时会被当作损坏的帧，连同之后的帧一起丢弃，不写入
+	frame := encodeCommitmentFrame(c, payload)
+	if len(frame)-redoFrameHeaderSize > redoMaxFrameSize {
+		clog.Warnf("[xdb] redo frame too large, not logged. [ns = %s, pk = %s, size = %d]", c.Source().Namespace, PKOf(c), len(frame))
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.retired {
+		return
+	}
+
+	if r.file == nil {
+		if err = r.open(); err != nil {
+			clog.Warnf("[xdb] redo open failed. [dir = %s, err = %v]", r.dir, err)
+			return
+		}
+	}
+
+	if _, err = r.file.Write(frame); err != nil {
+		clog.Warnf("[xdb] redo write failed. [file = %s, err = %v]", r.path, err)
+		return
+	}
+
+	switch {
+	case r.interval == 0:
+		r.sync()
+	case r.interval > 0:
+		r.dirty = true
+		if r.timer == nil {
+			r.timer = time.AfterFunc(r.interval, r.onTimer)
+		}
+	}
+}
+
+// Retire 批次进入入库流程，分段文件不再接收写入
+func (r *redoLogFile) Retire(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.retired = true
+	r.close()
+}
+
+// Destroy 批次入库成功，删除分段文件
+func (r *redoLogFile) Destroy() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.close()
+	if r.path != "" {
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			clog.Warnf("[xdb] redo remove failed. [file = %s, err = %v]", r.path, err)
+		}
+		r.path = ""
+	}
+}
+
+func (r *redoLogFile) open() error {
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%020d-%010d%s", time.Now().UnixNano(), atomic.AddUint64(&redoSeq, 1), redoFileExt)
+	path := filepath.Join(r.dir, name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	r.path = path
+	r.file = f
+	return nil
+}
+
+func (r *redoLogFile) onTimer() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.timer = nil
+	if r.file != nil && r.dirty {
+		r.sync()
+	}
+}
+
+func (r *redoLogFile) sync() {
+	if err := r.file.Sync(); err != nil {
+		clog.Warnf("[xdb] redo sync failed. [file = %s, err = %v]", r.path, err)
+	}
+	r.dirty = false
+}
+
+func (r *redoLogFile) close() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+
+	if r.file == nil {
+		return
+	}
+
+	if r.interval >= 0 && r.dirty {
+		r.sync()
+	}
+
+	_ = r.file.Close()
+	r.file = nil
+}
+
+func redoDir(src *Source) string {
+	return filepath.Join(redoOptions.Dir, src.Namespace)
+}
+
+// redoExtChanges 帧体首字节的标记位，变更字段超过 64 号时 8 字节的 lo 后紧跟 2 字节 hi 长度和 hi
+const redoExtChanges = 0x80
+
+// redoBaseVersion 帧体首字节的标记位，变更字段后紧跟 8 字节的基准版本
+// 快照中只有提交后的版本，合并了多次修改的提交无法从快照推算基准版本
+const redoBaseVersion = 0x40
+
+// encodeCommitmentFrame 提交对象的帧，支持乐观锁时记录基准版本
+func encodeCommitmentFrame(c Commitment, payload []byte) []byte {
+	base := int64(-1)
+	if b, _, ok := VersionOf(c); ok {
+		base = b
+	}
+	return encodeRedoFrame(c.Lifecycle(), c.Changes(), base, payload)
+}
+
+func encodeRedoFrame(lifecycle Lifecycle, changes FieldSet, base int64, payload []byte) []byte {
+	body := make([]byte, 1, 19+len(changes.hi)+len(payload))
+	body[0] = byte(lifecycle)
+	body = binary.BigEndian.AppendUint64(body, changes.lo)
+	if changes.hi != "" {
+		body[0] |= redoExtChanges
+		body = binary.BigEndian.AppendUint16(body, uint16(len(changes.hi)))
+		body = append(body, changes.hi...)
+	}
+	if base >= 0 {
+		body[0] |= redoBaseVersion
+		body = binary.BigEndian.AppendUint64(body, uint64(base))
+	}
+	body = append(body, payload...)
+
+	frame := make([]byte, redoFrameHeaderSize+len(body))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(body)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(body))
+	copy(frame[redoFrameHeaderSize:], body)
+	return frame
+}
+
+// redoFrame 解码后的帧，base < 0 表示没有记录基准版本
+type redoFrame struct {
+	lifecycle Lifecycle
+	changes   FieldSet
+	base      int64
+	payload   []byte
+}
+
+// readRedoFrames 读取分段文件中所有完整的帧，末尾被截断、超长或校验失败的帧会被丢弃
+func readRedoFrames(path string, fn func(frame *redoFrame) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	header := make([]byte, redoFrameHeaderSize)
+	for {
+		if _, err = io.ReadFull(reader, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+
+		// 长度来自可能损坏的帧头，超过上限时按截断处理，避免分配过大的内存
+		size := binary.BigEndian.Uint32(header[0:4])
+		if size > redoMaxFrameSize {
+			clog.Warnf("[xdb] redo frame too large, skip the rest. [file = %s, size = %d]", path, size)
+			return nil
+		}
+		body := make([]byte, size)
+		if _, err = io.ReadFull(reader, body); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+
+		if len(body) < 9 || crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+			clog.Warnf("[xdb] redo frame corrupted, skip the rest. [file = %s]", path)
+			return nil
+		}
+
+		frame, ok := decodeRedoBody(body)
+		if !ok {
+			clog.Warnf("[xdb] redo frame corrupted, skip the rest. [file = %s]", path)
+			return nil
+		}
+		if err = fn(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeRedoBody 解码帧体中的生命周期、变更字段、基准版本和快照
+func decodeRedoBody(body []byte) (*redoFrame, bool) {
+	frame := &redoFrame{
+		lifecycle: Lifecycle(body[0] &^ (redoExtChanges | redoBaseVersion)),
+		changes:   FieldSet{lo: binary.BigEndian.Uint64(body[1:9])},
+		base:      -1,
+	}
+
+	n := 9
+	if body[0]&redoExtChanges != 0 {
+		if len(body) < n+2 {
+			return nil, false
+		}
+		end := n + 2 + int(binary.BigEndian.Uint16(body[n:n+2]))
+		if len(body) < end {
+			return nil, false
+		}
+		frame.changes.hi = string(body[n+2 : end])
+		n = end
+	}
+	if body[0]&redoBaseVersion != 0 {
+		if len(body) < n+8 {
+			return nil, false
+		}
+		frame.base = int64(binary.BigEndian.Uint64(body[n : n+8]))
+		n += 8
+	}
+	frame.payload = body[n:]
+	return frame, true
+}
+
+// listRedoSegments 按写入顺序列出数据源残留的分段文件
+func listRedoSegments(src *Source) ([]string, error) {
+	entries, err := os.ReadDir(redoDir(src))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), redoFileExt) {
+			continue
+		}
+		paths = append(paths, filepath.Join(redoDir(src), entry.Name()))
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// keepFailedFile 重放后仍有提交没有入库的文件改名保留，不再自动重放
+func keepFailedFile(path string) error {
+	failed := fmt.Sprintf("%s.%d%s", path, time.Now().UnixNano(), failedFileExt)
+	if err := os.Rename(path, failed); err != nil {
+		return err
+	}
+	clog.Errorf("[xdb] replay incomplete, file kept. [file = %s]", failed)
+	return nil
+}
+
+// loadRedoCommitments 重放分段文件，同一主键的多次提交按顺序合并为一个
+// 合并后的提交以第一次提交的基准版本写入，中间的版本没有入库
+func loadRedoCommitments(src *Source, paths []string) ([]Commitment, error) {
+	var commitments []Commitment
+	var bases []int64
+	indexes := map[string]int{}
+
+	for _, path := range paths {
+		err := readRedoFrames(path, func(frame *redoFrame) error {
+			c := src.CreateCommitment()
+			if err := c.Unmarshal(frame.payload); err != nil {
+				return errors.Wrapf(err, "unmarshal redo commitment [%s] in %s", src.Namespace, path)
+			}
+
+			rc, ok := c.(RecoverableCommitment)
+			if !ok {
+				return errors.Errorf("commitment of [%s] is not recoverable", src.Namespace)
+			}
+
+			key := PKOf(c).String()
+			i, exists := indexes[key]
+			if !exists {
+				rc.RecoverMeta(frame.lifecycle, frame.changes, frame.base)
+				indexes[key] = len(commitments)
+				commitments = append(commitments, c)
+				bases = append(bases, frame.base)
+				return nil
+			}
+
+			prev := Header{lifecycle: commitments[i].Lifecycle(), changes: commitments[i].Changes()}
+			if !prev.Merge(&Header{lifecycle: frame.lifecycle, changes: frame.changes}) {
+				// 已删除的记录又被重新创建
+				prev = Header{lifecycle: frame.lifecycle, changes: frame.changes}
+				bases[i] = frame.base
+			} else if bases[i] < 0 {
+				// 旧的分段文件没有记录基准版本，按第一次提交的快照推算
+				bases[i], _, _ = VersionOf(commitments[i])
+			}
+			rc.RecoverMeta(prev.lifecycle, prev.changes, bases[i])
+			commitments[i] = c
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ret := commitments[:0]
+	for _, c := range commitments {
+		if c.Lifecycle() != LifecycleUnavailable {
+			ret = append(ret, c)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,256 @@
+package xdb
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+type redoTestData struct {
+	Id    int64  `json:"id"`
+	Value string `json:"value"`
+}
+
+type redoTestPK struct {
+	Id int64
+}
+
+func (pk *redoTestPK) Source() *Source          { return redoTestSource }
+func (pk *redoTestPK) String() string           { return fmt.Sprintf("redo_test:%d", pk.Id) }
+func (pk *redoTestPK) HashGroup() int           { return int(pk.Id) }
+func (pk *redoTestPK) Empty() bool              { return pk.Id == 0 }
+func (pk *redoTestPK) PrefixOf(key Key) bool    { return false }
+func (pk *redoTestPK) Full() bool               { return pk.Id != 0 }
+func (pk *redoTestPK) FetchFilter() interface{} { return map[string]interface{}{"id": pk.Id} }
+
+type redoTestCommitment struct {
+	data      *redoTestData
+	changes   FieldSet
+	lifecycle Lifecycle
+	mergeable bool // 为 true 时不检查主键，总是合并
+}
+
+func (c *redoTestCommitment) Source() *Source                          { return redoTestSource }
+func (c *redoTestCommitment) Changes() FieldSet                        { return c.changes }
+func (c *redoTestCommitment) PrepareWrite() (interface{}, interface{}) { return c.data, nil }
+func (c *redoTestCommitment) Lifecycle() Lifecycle                     { return c.lifecycle }
+func (c *redoTestCommitment) Marshal() ([]byte, error)                 { return json.Marshal(c.data) }
+func (c *redoTestCommitment) Unmarshal(data []byte) error {
+	c.data = &redoTestData{}
+	return json.Unmarshal(data, c.data)
+}
+func (c *redoTestCommitment) Merge(other Commitment) bool {
+	if c.mergeable {
+		c.data = other.(*redoTestCommitment).data
+	}
+	return c.mergeable
+}
+func (c *redoTestCommitment) RecoverMeta(lifecycle Lifecycle, changes FieldSet, _ int64) {
+	c.lifecycle = lifecycle
+	c.changes = changes
+}
+
+var redoTestSource = &Source{
+	Namespace: "redo_test",
+	PKOf: func(obj interface{}) PK {
+		return &redoTestPK{Id: obj.(*redoTestCommitment).data.Id}
+	},
+	CreateCommitment: func() Commitment {
+		return &redoTestCommitment{}
+	},
+}
+
+func TestRedoLogFile_Recover(t *testing.T) {
+	redoOptions = &RedoOptions{Dir: t.TempDir(), Enabled: true, SyncInterval: 0}
+	defer func() { redoOptions = nil }()
+
+	ctx := context.Background()
+	sw := &SaveWorker{owner: &Saver{src: redoTestSource}}
+
+	// 已入库的批次，分段文件应被删除
+	flushed := newRedoLogFile()
+	flushed.Serve(ctx, sw)
+	flushed.Log(ctx, &redoTestCommitment{data: &redoTestData{Id: 9, Value: "x"}, lifecycle: LifecycleNew})
+	flushed.Retire(ctx)
+	flushed.Destroy()
+
+	// 未入库的批次，模拟崩溃
+	pending := newRedoLogFile()
+	pending.Serve(ctx, sw)
+	pending.Log(ctx, &redoTestCommitment{data: &redoTestData{Id: 1, Value: "a"}, lifecycle: LifecycleNew})
+	pending.Log(ctx, &redoTestCommitment{data: &redoTestData{Id: 2, Value: "b"}, lifecycle: LifecycleNormal, changes: MakeFieldSet(1)})
+	pending.Log(ctx, &redoTestCommitment{data: &redoTestData{Id: 1, Value: "c"}, lifecycle: LifecycleNormal, changes: MakeFieldSet(1)})
+	pending.Log(ctx, &redoTestCommitment{data: &redoTestData{Id: 3, Value: "d"}, lifecycle: LifecycleNew})
+	pending.Log(ctx, &redoTestCommitment{data: &redoTestData{Id: 3}, lifecycle: LifecycleDeleted})
+	pending.Retire(ctx)
+
+	paths, err := listRedoSegments(redoTestSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 {
+		t.Fatalf("expected 1 segment, got %d", len(paths))
+	}
+
+	// 末尾写入半帧，模拟写入过程中崩溃
+	f, err := os.OpenFile(paths[0], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{0, 0, 0, 42, 1})
+	_ = f.Close()
+
+	commitments, err := loadRedoCommitments(redoTestSource, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commitments) != 2 {
+		t.Fatalf("expected 2 commitments, got %d", len(commitments))
+	}
+
+	first := commitments[0].(*redoTestCommitment)
+	if first.data.Id != 1 || first.data.Value != "c" || first.lifecycle != LifecycleNew {
+		t.Errorf("unexpected merged commitment: %+v %s", first.data, first.lifecycle)
+	}
+
+	second := commitments[1].(*redoTestCommitment)
+	if second.data.Id != 2 || second.lifecycle != LifecycleNormal || !second.changes.Contains(1) {
+		t.Errorf("unexpected commitment: %+v %s", second.data, second.lifecycle)
+	}
+}
+
+// recoverTestTable 恢复时返回预设的错误，dropped 时放弃第一个提交
+type recoverTestTable struct {
+	NoStorageTable
+	err       error
+	dropped   bool
+	recovered []Commitment
+}
+
+func (t *recoverTestTable) Recover(ctx context.Context, commitments []Commitment) error {
+	if t.err != nil {
+		return t.err
+	}
+	if t.dropped {
+		markDropped(ctx, commitments[0])
+		commitments = commitments[1:]
+	}
+	t.recovered = append(t.recovered, commitments...)
+	return nil
+}
+
+func TestSaver_RecoverKeepsSegmentsOnFailure(t *testing.T) {
+	redoOptions = &RedoOptions{Dir: t.TempDir(), Enabled: true}
+	table := &recoverTestTable{err: errors.New("interrupted")}
+	redoTestSource.table = table
+	defer func() {
+		redoOptions = nil
+		redoTestSource.table = nil
+	}()
+
+	ctx := context.Background()
+	s := &Saver{src: redoTestSource}
+	pending := newRedoLogFile()
+	pending.Serve(ctx, &SaveWorker{owner: s})
+	pending.Log(ctx, &redoTestCommitment{data: &redoTestData{Id: 1, Value: "a"}, lifecycle: LifecycleNew})
+	pending.Retire(ctx)
+
+	// 恢复失败时分段文件保留，下次启动继续恢复
+	if err := s.recover(ctx); err == nil {
+		t.Fatal("expected recover error")
+	}
+	if paths, _ := listRedoSegments(redoTestSource); len(paths) != 1 {
+		t.Fatalf("expected segment kept, got %v", paths)
+	}
+
+	table.err = nil
+	if err := s.recover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if paths, _ := listRedoSegments(redoTestSource); len(paths) != 0 || len(table.recovered) != 1 {
+		t.Errorf("expected recovered and removed: %v %d", paths, len(table.recovered))
+	}
+}
+
+func TestSaver_RecoverKeepsSegmentsOnDropped(t *testing.T) {
+	redoOptions = &RedoOptions{Dir: t.TempDir(), Enabled: true}
+	table := &recoverTestTable{dropped: true}
+	redoTestSource.table = table
+	defer func() {
+		redoOptions = nil
+		redoTestSource.table = nil
+	}()
+
+	ctx := context.Background()
+	s := &Saver{src: redoTestSource}
+	pending := newRedoLogFile()
+	pending.Serve(ctx, &SaveWorker{owner: s})
+	pending.Log(ctx, &redoTestCommitment{data: &redoTestData{Id: 1, Value: "a"}, lifecycle: LifecycleNew})
+	pending.Log(ctx, &redoTestCommitment{data: &redoTestData{Id: 2, Value: "b"}, lifecycle: LifecycleNew})
+	pending.Retire(ctx)
+
+	// 有提交因冲突或死信没有入库时分段文件改名保留，不再自动重放
+	if err := s.recover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if paths, _ := listRedoSegments(redoTestSource); len(paths) != 0 || len(table.recovered) != 1 {
+		t.Fatalf("expected segment not replayed again: %v %d", paths, len(table.recovered))
+	}
+	if failed, _ := filepath.Glob(filepath.Join(redoDir(redoTestSource), "*"+failedFileExt)); len(failed) != 1 {
+		t.Errorf("expected segment kept: %v", failed)
+	}
+}
+
+func TestShardTable_RecoverAllShards(t *testing.T) {
+	redoTestSource.ShardField = "id"
+	defer func() { redoTestSource.ShardField = "" }()
+
+	failed := &recoverTestTable{err: errors.New("interrupted")}
+	recovered := &recoverTestTable{}
+	table := newShardTable(redoTestSource, &ShardOptions{Count: 2}, []Table{failed, recovered})
+
+	// 第一个分片恢复失败，第二个分片仍然恢复，返回错误保留分段文件
+	c1 := &redoTestCommitment{data: &redoTestData{Id: 2}, lifecycle: LifecycleNew}
+	c2 := &redoTestCommitment{data: &redoTestData{Id: 1}, lifecycle: LifecycleNew}
+	if err := table.Recover(context.Background(), []Commitment{c1, c2}); err == nil {
+		t.Error("expected recover error")
+	}
+	if len(recovered.recovered) != 1 || recovered.recovered[0] != c2 {
+		t.Errorf("expected other shards recovered: %v", recovered.recovered)
+	}
+}
+
+func TestReadRedoFrames_Oversized(t *testing.T) {
+	// 第二帧的长度被损坏为超过上限的值，读到第一帧后按截断处理
+	valid := encodeRedoFrame(LifecycleNew, FieldSetEmpty, 0, []byte("payload"))
+	corrupted := encodeRedoFrame(LifecycleNew, FieldSetEmpty, 0, []byte("payload"))
+	binary.BigEndian.PutUint32(corrupted[0:4], redoMaxFrameSize+1)
+
+	path := filepath.Join(t.TempDir(), "oversized"+redoFileExt)
+	if err := os.WriteFile(path, append(valid, corrupted...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var before, after runtime.MemStats
+	var frames []*redoFrame
+	runtime.ReadMemStats(&before)
+	err := readRedoFrames(path, func(frame *redoFrame) error {
+		frames = append(frames, frame)
+		return nil
+	})
+	runtime.ReadMemStats(&after)
+	if err != nil || len(frames) != 1 || string(frames[0].payload) != "payload" {
+		t.Fatalf("unexpected frames: %+v %v", frames, err)
+	}
+
+	// 不按损坏的长度分配内存
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated >= redoMaxFrameSize {
+		t.Errorf("unexpected allocation of corrupted frame: %d", allocated)
+	}
+}
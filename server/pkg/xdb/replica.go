@@ -0,0 +1,230 @@
+package xdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	clog "github.com/cherry-game/cherry/logger"
+	"github.com/pkg/errors"
+)
+
+// defaultReplicaSubject 复制通知的默认主题前缀，完整主题为 <prefix>.<namespace>
+const defaultReplicaSubject = "xdb.replica"
+
+// ReplicaTransport 复制通知的传输层，默认实现为 NatsTransport
+type ReplicaTransport interface {
+	Publish(subject string, data []byte) error
+	Subscribe(subject string, handler func(data []byte)) (unsubscribe func(), err error)
+}
+
+// ReplicaOptions 复制通知的选项
+type ReplicaOptions struct {
+	NodeId  string // 本节点标识，用于忽略自己发布的通知，为空时随机生成
+	Subject string // 主题前缀，为空时使用 xdb.replica
+	Reload  bool   // true 收到通知后立即把新的对象加载到缓存，false 仅标记过时，下次访问时加载
+}
+
+// ReplicaEvent 复制通知，在 Replica 数据源的提交入库后发布
+type ReplicaEvent struct {
+	Origin    string    `json:"origin"`
+	Namespace string    `json:"ns"`
+	PK        string    `json:"pk"`
+	Hash      int       `json:"hash"`
+	Version   int64     `json:"version,omitempty"`
+	Lifecycle Lifecycle `json:"lifecycle"`
+	Changes   FieldSet  `json:"changes"`
+}
+
+// ReplicaHandler 收到其他节点的复制通知后的回调，在缓存处理之后调用
+type ReplicaHandler func(ctx context.Context, ev *ReplicaEvent)
+
+type replicator struct {
+	transport ReplicaTransport
+	opts      ReplicaOptions
+	unsubs    []func()
+	handlers  []ReplicaHandler
+}
+
+var (
+	replicaMu sync.RWMutex
+	replica   *replicator
+)
+
+// EnableReplica 开启 Replica 数据源的跨节点通知，需要在 RegisterSource 之后调用
+func EnableReplica(transport ReplicaTransport, opts *ReplicaOptions) error {
+	if transport == nil {
+		return errors.New("replica transport is nil")
+	}
+
+	r := &replicator{transport: transport}
+	if opts != nil {
+		r.opts = *opts
+	}
+	if r.opts.NodeId == "" {
+		r.opts.NodeId = fmt.Sprintf("%x-%x", time.Now().UnixNano(), rand.Int63())
+	}
+	if r.opts.Subject == "" {
+		r.opts.Subject = defaultReplicaSubject
+	}
+
+	for ns, src := range nsSrcMap {
+		if !src.Replica {
+			continue
+		}
+
+		unsub, err := transport.Subscribe(r.subject(ns), r.receive)
+		if err != nil {
+			r.close()
+			return errors.Wrapf(err, "subscribe replica of [%s]", ns)
+		}
+		r.unsubs = append(r.unsubs, unsub)
+	}
+
+	replicaMu.Lock()
+	old := replica
+	replica = r
+	replicaMu.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+	return nil
+}
+
+// DisableReplica 关闭跨节点通知
+func DisableReplica() {
+	replicaMu.Lock()
+	old := replica
+	replica = nil
+	replicaMu.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+}
+
+// OnReplica 注册复制通知回调，需要在 EnableReplica 之后调用
+func OnReplica(fn ReplicaHandler) {
+	replicaMu.Lock()
+	defer replicaMu.Unlock()
+	if replica != nil {
+		replica.handlers = append(replica.handlers, fn)
+	}
+}
+
+func getReplicator() *replicator {
+	replicaMu.RLock()
+	defer replicaMu.RUnlock()
+	return replica
+}
+
+func (r *replicator) subject(ns string) string {
+	return r.opts.Subject + "." + ns
+}
+
+func (r *replicator) close() {
+	for _, unsub := range r.unsubs {
+		unsub()
+	}
+	r.unsubs = nil
+}
+
+// publishReplica 发布已入库的提交，由保存协程在批次写入后调用
+func publishReplica(src *Source, commitments []Commitment) {
+	if !src.Replica {
+		return
+	}
+
+	r := getReplicator()
+	if r == nil {
+		return
+	}
+
+	for _, c := range commitments {
+		pk := PKOf(c)
+		ev := &ReplicaEvent{
+			Origin:    r.opts.NodeId,
+			Namespace: src.Namespace,
+			PK:        keyString(pk),
+			Hash:      pk.HashGroup(),
+			Lifecycle: c.Lifecycle(),
+			Changes:   c.Changes(),
+		}
+		if _, version, ok := VersionOf(c); ok {
+			ev.Version = version
+		}
+
+		data, err := json.Marshal(ev)
+		if err != nil {
+			clog.Warnf("[xdb] replica event marshal failed. [ns = %s, pk = %s, err = %v]", src.Namespace, ev.PK, err)
+			continue
+		}
+		if err = r.transport.Publish(r.subject(src.Namespace), data); err != nil {
+			clog.Warnf("[xdb] replica event publish failed. [ns = %s, pk = %s, err = %v]", src.Namespace, ev.PK, err)
+		}
+	}
+}
+
+func (r *replicator) receive(data []byte) {
+	ev := &ReplicaEvent{}
+	if err := json.Unmarshal(data, ev); err != nil {
+		clog.Warnf("[xdb] replica event unmarshal failed. [err = %v]", err)
+		return
+	}
+	if ev.Origin == r.opts.NodeId {
+		return
+	}
+
+	ctx := context.Background()
+	if err := applyReplica(ctx, ev, r.opts.Reload); err != nil {
+		clog.Warnf("[xdb] replica event apply failed. [ns = %s, pk = %s, err = %v]", ev.Namespace, ev.PK, err)
+	}
+
+	replicaMu.RLock()
+	handlers := r.handlers
+	replicaMu.RUnlock()
+	for _, fn := range handlers {
+		fn(ctx, ev)
+	}
+}
+
+// applyReplica 把缓存中的对象标记为过时，未缓存的对象无需处理
+// 通知在传输层的协程中处理，不访问 actor 持有的模型：过时的对象不再被 Get 返回，对它的 Save 被丢弃并记录错误日志
+func applyReplica(ctx context.Context, ev *ReplicaEvent, reload bool) error {
+	src, err := getNSSource(ev.Namespace)
+	if err != nil {
+		return err
+	}
+	if src.repo == nil || !src.repo.initialized {
+		return nil
+	}
+
+	// 缓存的是不存在记录的占位时直接移除
+	key, ok := src.repo.MarkStale(replicaKey{key: ev.PK, hash: ev.Hash})
+	if !ok || !reload || ev.Lifecycle == LifecycleDeleted {
+		return nil
+	}
+
+	// 重新加载的是新的对象，触发 OnReload
+	pk, ok := key.(PK)
+	if !ok {
+		return nil
+	}
+	_, err = getModel(ctx, src, pk)
+	return err
+}
+
+// replicaKey 以通知中的主键字符串查找缓存
+type replicaKey struct {
+	key  string
+	hash int
+}
+
+func (k replicaKey) HashGroup() int    { return k.hash }
+func (k replicaKey) Empty() bool       { return k.key == "" }
+func (k replicaKey) PrefixOf(Key) bool { return false }
+func (k replicaKey) String() string    { return k.key }
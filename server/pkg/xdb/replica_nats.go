@@ -0,0 +1,36 @@
+package xdb
+
+import (
+	cherryNats "github.com/cherry-game/cherry/net/nats"
+	"github.com/nats-io/nats.go"
+)
+
+// NatsTransport 基于 cherry 集群 NATS 连接的复制通知传输层
+type NatsTransport struct {
+	conn *cherryNats.Conn
+}
+
+// NewNatsTransport 创建 NATS 传输层，conn 为 nil 时使用 cherry 集群的连接（cherryNats.Get()）
+func NewNatsTransport(conn *cherryNats.Conn) *NatsTransport {
+	if conn == nil {
+		conn = cherryNats.Get()
+	}
+	return &NatsTransport{conn: conn}
+}
+
+func (t *NatsTransport) Publish(subject string, data []byte) error {
+	return t.conn.Publish(subject, data)
+}
+
+func (t *NatsTransport) Subscribe(subject string, handler func(data []byte)) (func(), error) {
+	sub, err := t.conn.Subscribe(subject, func(msg *nats.Msg) {
+		handler(msg.Data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		_ = sub.Unsubscribe()
+	}, nil
+}
@@ -0,0 +1,142 @@
+package xdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// replicaTestBus 进程内的传输层，发布时同步调用所有订阅者
+type replicaTestBus struct {
+	subs map[string][]func(data []byte)
+}
+
+func (b *replicaTestBus) Publish(subject string, data []byte) error {
+	for _, fn := range b.subs[subject] {
+		fn(data)
+	}
+	return nil
+}
+
+func (b *replicaTestBus) Subscribe(subject string, handler func(data []byte)) (func(), error) {
+	b.subs[subject] = append(b.subs[subject], handler)
+	return func() { delete(b.subs, subject) }, nil
+}
+
+func TestReplica_ExpireOnRemoteCommit(t *testing.T) {
+	repo := &Repo{}
+	repo.Init(true, &RepoOptions{GroupSize: 4}, "redo_test", nil, &sync.WaitGroup{})
+
+	nsSrcMap[redoTestSource.Namespace] = redoTestSource
+	redoTestSource.Replica = true
+	redoTestSource.repo = repo
+	defer func() {
+		DisableReplica()
+		delete(nsSrcMap, redoTestSource.Namespace)
+		redoTestSource.Replica = false
+		redoTestSource.repo = nil
+	}()
+
+	bus := &replicaTestBus{subs: map[string][]func(data []byte){}}
+	if err := EnableReplica(bus, &ReplicaOptions{NodeId: "local"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []*ReplicaEvent
+	OnReplica(func(ctx context.Context, ev *ReplicaEvent) {
+		events = append(events, ev)
+	})
+
+	// 本节点缓存了不存在记录的占位
+	pk := &redoTestPK{Id: 7}
+	repo.SetOnFetch(pk, nil, true, nil)
+	c := &redoTestCommitment{data: &redoTestData{Id: 7}, lifecycle: LifecycleNew, changes: MakeFieldSet(1)}
+
+	// 自己发布的通知被忽略
+	publishReplica(redoTestSource, []Commitment{c})
+	if !repo.Exists(pk) || len(events) != 0 {
+		t.Fatal("expected own event ignored")
+	}
+
+	// 其他节点发布的通知使缓存过期
+	data, _ := json.Marshal(&ReplicaEvent{Origin: "remote", Namespace: redoTestSource.Namespace, PK: keyString(pk), Hash: pk.HashGroup(), Lifecycle: LifecycleNew, Changes: MakeFieldSet(1)})
+	_ = bus.Publish(defaultReplicaSubject+"."+redoTestSource.Namespace, data)
+	if repo.Exists(pk) {
+		t.Error("expected placeholder expired")
+	}
+	if len(events) != 1 || events[0].Origin != "remote" || events[0].PK != keyString(pk) || !events[0].Changes.Contains(1) {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
+
+func TestReplica_StaleModel(t *testing.T) {
+	repo := &Repo{}
+	repo.Init(true, &RepoOptions{GroupSize: 4}, "redo_test", nil, &sync.WaitGroup{})
+
+	nsSrcMap[redoTestSource.Namespace] = redoTestSource
+	redoTestSource.repo = repo
+	defer func() {
+		delete(nsSrcMap, redoTestSource.Namespace)
+		redoTestSource.repo = nil
+	}()
+
+	pk := &redoTestPK{Id: 7}
+	repo.SetOnFetch(pk, "old", false, nil)
+	ev := &ReplicaEvent{Origin: "remote", Namespace: redoTestSource.Namespace, PK: keyString(pk), Hash: pk.HashGroup(), Lifecycle: LifecycleNormal}
+	if err := applyReplica(context.Background(), ev, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// 过时的对象不再返回，旧引用不能再存储
+	if repo.Exists(pk) {
+		t.Error("expected stale entry hidden")
+	}
+	if repo.SetOnStore(pk, "old", false) {
+		t.Error("expected stale reference rejected")
+	}
+
+	// 重新加载的对象替换过时的对象
+	if v := repo.SetOnFetch(pk, "new", false, nil); v != "new" || !repo.SetOnStore(pk, "new", false) {
+		t.Errorf("expected reloaded entry, got %v", v)
+	}
+}
+
+func TestReplica_PublishWrittenOnly(t *testing.T) {
+	redoOptions = &RedoOptions{Dir: t.TempDir()}
+	nsSrcMap[redoTestSource.Namespace] = redoTestSource
+	redoTestSource.Replica = true
+	defer func() {
+		DisableReplica()
+		redoOptions = nil
+		delete(nsSrcMap, redoTestSource.Namespace)
+		redoTestSource.Replica = false
+	}()
+
+	bus := &replicaTestBus{subs: map[string][]func(data []byte){}}
+	if err := EnableReplica(bus, &ReplicaOptions{NodeId: "local"}); err != nil {
+		t.Fatal(err)
+	}
+	var published []string
+	bus.subs[defaultReplicaSubject+"."+redoTestSource.Namespace] = []func(data []byte){func(data []byte) {
+		ev := &ReplicaEvent{}
+		_ = json.Unmarshal(data, ev)
+		published = append(published, ev.PK)
+	}}
+
+	// 写入死信的提交没有入库，不发布通知
+	table := &failureTestTable{errs: []error{nil, Permanent(errors.New("bad value"))}}
+	c1 := &redoTestCommitment{data: &redoTestData{Id: 1}, lifecycle: LifecycleNew}
+	c2 := &redoTestCommitment{data: &redoTestData{Id: 2}, lifecycle: LifecycleNew}
+	ctx, result := withSaveResult(context.Background())
+	if !table.Save(ctx, []Commitment{c1, c2}, time.Second, time.Millisecond, func() bool { return true }) {
+		t.Fatal("expected save to finish")
+	}
+
+	publishReplica(redoTestSource, result.written([]Commitment{c1, c2}))
+	if len(published) != 1 || published[0] != keyString(PKOf(c1)) {
+		t.Errorf("expected only written commitment published: %v", published)
+	}
+}
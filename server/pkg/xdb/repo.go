@@ -1,21 +1,48 @@
 package xdb
 
 import (
+	"container/list"
+	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
 )
 
+// defaultEntrySize 无法估算大小的对象按此值计入内存预算
+const defaultEntrySize = 256
+
 // RepoOptions 仓库选项
 type RepoOptions struct {
-	GroupSize uint
+	GroupSize    uint
+	MaxEntries   int           // 最大缓存对象数，<= 0 表示不限制
+	MemoryBudget int64         // 缓存对象的内存预算（字节，按快照大小估算），<= 0 表示不限制
+	IdleTTL      time.Duration // 对象空闲超过该时长后被逐出，<= 0 表示不过期
+}
+
+func (o *RepoOptions) bounded() bool {
+	return o.MaxEntries > 0 || o.MemoryBudget > 0 || o.IdleTTL > 0
 }
 
 // Repo 仓库（缓存）
 type Repo struct {
+	name        string
 	opts        RepoOptions
 	initialized bool
 	groups      []group
 	groupMod    uint
 	mu          sync.RWMutex
+	uniques     map[string]Key      // 唯一键缓存，key 为 uniqueKey(索引名, 值)，value 为主键
+	uniqueOf    map[string][]string // 对象当前的唯一键，key 为主键的字符串形式
+	evictions   uint64
+	hits        uint64 // 缓存命中次数，查找时只做原子计数，采集指标时上报增量
+	misses      uint64
+	reportedHit uint64 // 已上报的命中次数
+	reportedMis uint64 // 已上报的未命中次数
+	pressure    chan struct{}
+	closing     chan struct{}
 }
 
 // Init 初始化仓库
@@ -35,18 +62,121 @@ func (r *Repo) Init(canonical bool, opts *RepoOptions, name string, keyComparato
 
 	r.opts.GroupSize = grpSize
 	r.groupMod = grpSize - 1
+	r.name = name
 
 	r.groups = make([]group, r.opts.GroupSize)
 	for i := uint(0); i < r.opts.GroupSize; i++ {
-		r.groups[i].Init(&r.opts, name, keyComparator, wg)
+		r.groups[i].Init(r, &r.opts, name, keyComparator, wg)
 	}
 
+	r.pressure = make(chan struct{}, 1)
 	r.initialized = true
 }
 
+// EvictHooks 逐出回调，Evictable 在持有组锁时检查对象能否逐出，Unload 在对象移除后调用
+type EvictHooks struct {
+	Evictable func(key Key, obj any) bool
+	Unload    func(ctx context.Context, key Key, obj any)
+}
+
+// Run 启动逐出协程
+func (r *Repo) Run(ctx context.Context, wg *sync.WaitGroup, hooks EvictHooks) {
+	if !r.opts.bounded() || r.closing != nil {
+		return
+	}
+
+	interval := time.Second
+	if r.opts.IdleTTL > 0 && r.opts.IdleTTL/4 < interval {
+		interval = r.opts.IdleTTL / 4
+	}
+
+	r.closing = make(chan struct{})
+	closing := r.closing
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-closing:
+				return
+			case <-ticker.C:
+			case <-r.pressure:
+			}
+			r.Evict(ctx, time.Now(), hooks)
+		}
+	}()
+}
+
+// Close 停止逐出协程
+func (r *Repo) Close() {
+	if r.closing != nil {
+		close(r.closing)
+		r.closing = nil
+	}
+}
+
+// Evict 逐出超出容量或空闲过期的对象，返回逐出数量
+func (r *Repo) Evict(ctx context.Context, now time.Time, hooks EvictHooks) int {
+	evicted := 0
+	for i := range r.groups {
+		evicted += r.groups[i].evict(ctx, now, hooks)
+	}
+	atomic.AddUint64(&r.evictions, uint64(evicted))
+	if evicted > 0 {
+		metricInc(MetricEvictions, r.name, float64(evicted))
+	}
+	return evicted
+}
+
+// Evictions 累计逐出数量
+func (r *Repo) Evictions() uint64 {
+	return atomic.LoadUint64(&r.evictions)
+}
+
+// Len 缓存对象数量
+func (r *Repo) Len() int {
+	n := 0
+	for i := range r.groups {
+		n += r.groups[i].Len()
+	}
+	return n
+}
+
 // Get 获取对象
 func (r *Repo) Get(key Key) (interface{}, bool) {
-	return r.getGroup(key).Get(key)
+	val, ok := r.getGroup(key).Get(key)
+	if ok {
+		atomic.AddUint64(&r.hits, 1)
+	} else {
+		atomic.AddUint64(&r.misses, 1)
+	}
+	return val, ok
+}
+
+// Hits 累计缓存命中次数
+func (r *Repo) Hits() uint64 {
+	return atomic.LoadUint64(&r.hits)
+}
+
+// Misses 累计缓存未命中次数
+func (r *Repo) Misses() uint64 {
+	return atomic.LoadUint64(&r.misses)
+}
+
+// collectMetrics 上报上次采集之后的命中、未命中次数
+func (r *Repo) collectMetrics(m Metrics) {
+	report := func(name string, n uint64, reported *uint64) {
+		if delta := n - atomic.SwapUint64(reported, n); delta > 0 {
+			m.Inc(name, r.name, float64(delta))
+		}
+	}
+	report(MetricRepoHits, r.Hits(), &r.reportedHit)
+	report(MetricRepoMisses, r.Misses(), &r.reportedMis)
 }
 
 // SetOnFetch 在获取时设置
@@ -69,20 +199,36 @@ func (r *Repo) GetAll(key Key) ([]interface{}, bool) {
 	return r.getGroup(key).GetAll(key)
 }
 
-// GetMulti 批量获取
-func (r *Repo) GetMulti(keys []Key) ([]interface{}, []Key) {
-	cached := make([]interface{}, 0, len(keys))
-	remain := make([]Key, 0, len(keys))
+// GetMulti 批量获取，返回值与 keys 一一对应，missing 为未命中的下标
+func (r *Repo) GetMulti(keys []Key) ([]interface{}, []int) {
+	vals := make([]interface{}, len(keys))
+	var missing []int
 
-	for _, key := range keys {
+	for i, key := range keys {
 		if val, ok := r.Get(key); ok {
-			cached = append(cached, val)
+			vals[i] = val
 		} else {
-			remain = append(remain, key)
+			missing = append(missing, i)
 		}
 	}
 
-	return cached, remain
+	return vals, missing
+}
+
+// Loaded 前缀下的对象是否已全部加载到缓存
+func (r *Repo) Loaded(prefix Key) bool {
+	return r.getGroup(prefix).Loaded(prefix)
+}
+
+// Removals 前缀所在组的移除计数，加载前获取，传给 MarkLoaded
+func (r *Repo) Removals(prefix Key) uint64 {
+	return r.getGroup(prefix).Removals()
+}
+
+// MarkLoaded 标记前缀下的对象已全部加载，加载期间（since 之后）组内有对象被移除时放弃标记
+// 前缀的 HashGroup 必须与其下所有主键一致
+func (r *Repo) MarkLoaded(prefix Key, since uint64) bool {
+	return r.getGroup(prefix).MarkLoaded(prefix, since)
 }
 
 // Expire 过期
@@ -90,12 +236,79 @@ func (r *Repo) Expire(key Key) {
 	r.getGroup(key).Expire(key)
 }
 
+// MarkStale 把缓存的对象标记为过时，返回缓存中的主键
+// 过时的对象不再被 Get 返回，对它的 SetOnStore 返回 false，下次加载时替换；不存在记录的占位直接移除
+func (r *Repo) MarkStale(key Key) (Key, bool) {
+	return r.getGroup(key).MarkStale(key)
+}
+
 // Exists 检查是否存在
 func (r *Repo) Exists(key Key) bool {
 	_, ok := r.Get(key)
 	return ok
 }
 
+// GetUnique 按唯一键获取主键，缓存的主键对应的对象可能已修改，调用方需要重新校验
+func (r *Repo) GetUnique(index string, value interface{}) (Key, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.uniques[uniqueKey(index, value)]
+	return key, ok
+}
+
+// SetUniques 设置对象的唯一键（key 为索引名），替换该对象原有的唯一键
+func (r *Repo) SetUniques(key Key, values map[string]interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.uniques == nil {
+		r.uniques = map[string]Key{}
+		r.uniqueOf = map[string][]string{}
+	}
+
+	ks := keyString(key)
+	r.deleteUniques(ks)
+	uks := make([]string, 0, len(values))
+	for index, value := range values {
+		uk := uniqueKey(index, value)
+		r.uniques[uk] = key
+		uks = append(uks, uk)
+	}
+	r.uniqueOf[ks] = uks
+}
+
+// DeleteUniques 移除对象的所有唯一键
+func (r *Repo) DeleteUniques(key Key) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deleteUniques(keyString(key))
+}
+
+// DeleteUnique 移除指向 key 的唯一键
+func (r *Repo) DeleteUnique(index string, value interface{}, key Key) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	uk := uniqueKey(index, value)
+	if owner, ok := r.uniques[uk]; ok && keyString(owner) == keyString(key) {
+		delete(r.uniques, uk)
+	}
+}
+
+// deleteUniques 只移除仍指向该对象的唯一键，其他对象后来占用的不受影响
+func (r *Repo) deleteUniques(ks string) {
+	for _, uk := range r.uniqueOf[ks] {
+		if owner, ok := r.uniques[uk]; ok && keyString(owner) == ks {
+			delete(r.uniques, uk)
+		}
+	}
+	delete(r.uniqueOf, ks)
+}
+
+func uniqueKey(index string, value interface{}) string {
+	return fmt.Sprintf("%s:%v", index, value)
+}
+
 // HashGroup 获取哈希组
 func (r *Repo) HashGroup(key Key) int {
 	return int(uint(key.HashGroup()) & r.groupMod)
@@ -105,44 +318,90 @@ func (r *Repo) getGroup(key Key) *group {
 	return &r.groups[r.HashGroup(key)]
 }
 
+func (r *Repo) notifyPressure() {
+	select {
+	case r.pressure <- struct{}{}:
+	default:
+	}
+}
+
+// repoEntry 缓存条目，按访问时间串在 LRU 链表上（表头最近访问）
+type repoEntry struct {
+	key        Key
+	obj        interface{}
+	size       int64
+	lastAccess time.Time
+	elem       *list.Element
+	stale      bool // 其他节点已经修改，持有者不能再保存
+}
+
 // group 组
 type group struct {
 	mu            sync.RWMutex
-	data          map[Key]interface{}
+	owner         *Repo
+	data          map[string]*repoEntry
+	lru           list.List
+	loaded        []Key  // 已全部加载的前缀
+	removals      uint64 // 移除计数，用于判断前缀加载期间是否有对象被逐出
+	bytes         int64
+	maxEntries    int
+	memoryBudget  int64
 	opts          *RepoOptions
 	name          string
 	keyComparator func(interface{}, interface{}) int
 }
 
-func (g *group) Init(opts *RepoOptions, name string, keyComparator func(interface{}, interface{}) int, wg *sync.WaitGroup) {
-	g.data = make(map[Key]interface{})
+func (g *group) Init(owner *Repo, opts *RepoOptions, name string, keyComparator func(interface{}, interface{}) int, wg *sync.WaitGroup) {
+	g.owner = owner
+	g.data = make(map[string]*repoEntry)
+	g.lru.Init()
 	g.opts = opts
 	g.name = name
 	g.keyComparator = keyComparator
+
+	// 容量按组平分
+	groups := int(opts.GroupSize)
+	if opts.MaxEntries > 0 {
+		g.maxEntries = (opts.MaxEntries + groups - 1) / groups
+	}
+	if opts.MemoryBudget > 0 {
+		g.memoryBudget = (opts.MemoryBudget + int64(groups) - 1) / int64(groups)
+	}
 }
 
-func (g *group) Get(key Key) (interface{}, bool) {
+func (g *group) Len() int {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	val, ok := g.data[key]
-	return val, ok
+	return len(g.data)
+}
+
+func (g *group) Get(key Key) (interface{}, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e, ok := g.data[keyString(key)]
+	if !ok || e.stale {
+		return nil, false
+	}
+	g.touch(e)
+	return e.obj, true
 }
 
 func (g *group) SetOnFetch(key Key, obj interface{}, volatile bool, callback func(obj any)) interface{} {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if existing, ok := g.data[key]; ok {
-		return existing
+	if existing, ok := g.data[keyString(key)]; ok && !existing.stale {
+		g.touch(existing)
+		return existing.obj
+	} else if ok {
+		existing.stale = false
+		g.replace(existing, obj)
+	} else {
+		g.put(key, obj)
 	}
 
-	if obj != nil {
-		g.data[key] = obj
-		if callback != nil {
-			callback(obj)
-		}
-	} else {
-		g.data[key] = nil
+	if obj != nil && callback != nil {
+		callback(obj)
 	}
 
 	return obj
@@ -152,11 +411,16 @@ func (g *group) SetOnStore(key Key, obj interface{}, volatile bool) bool {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if _, exists := g.data[key]; exists {
+	if existing, exists := g.data[keyString(key)]; exists {
+		// 同一个对象重复存储，或者替换"不存在"的占位；过时的对象不能再存储
+		if (existing.obj == obj && !existing.stale) || existing.obj == nil {
+			g.replace(existing, obj)
+			return true
+		}
 		return false
 	}
 
-	g.data[key] = obj
+	g.put(key, obj)
 	return true
 }
 
@@ -164,11 +428,48 @@ func (g *group) SetOnDelete(key Key, obj interface{}) bool {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if _, exists := g.data[key]; !exists {
+	e, exists := g.data[keyString(key)]
+	if !exists {
+		return false
+	}
+
+	// 删除的对象不影响前缀的完整性
+	g.drop(e)
+	return true
+}
+
+func (g *group) Loaded(prefix Key) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, loaded := range g.loaded {
+		if loaded.PrefixOf(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *group) Removals() uint64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.removals
+}
+
+func (g *group) MarkLoaded(prefix Key, since uint64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.removals != since {
 		return false
 	}
 
-	delete(g.data, key)
+	// 新的前缀覆盖的旧标记不再需要
+	loaded := g.loaded[:0]
+	for _, k := range g.loaded {
+		if !prefix.PrefixOf(k) {
+			loaded = append(loaded, k)
+		}
+	}
+	g.loaded = append(loaded, prefix)
 	return true
 }
 
@@ -177,9 +478,9 @@ func (g *group) GetAll(key Key) ([]interface{}, bool) {
 	defer g.mu.RUnlock()
 
 	var results []interface{}
-	for k, v := range g.data {
-		if key.PrefixOf(k) {
-			results = append(results, v)
+	for _, e := range g.data {
+		if !e.stale && key.PrefixOf(e.key) {
+			results = append(results, e.obj)
 		}
 	}
 
@@ -189,5 +490,157 @@ func (g *group) GetAll(key Key) ([]interface{}, bool) {
 func (g *group) Expire(key Key) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	delete(g.data, key)
+	if e, ok := g.data[keyString(key)]; ok {
+		g.remove(e)
+	}
+}
+
+func (g *group) MarkStale(key Key) (Key, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	e, ok := g.data[keyString(key)]
+	if !ok || e.stale {
+		return nil, false
+	}
+	if e.obj == nil {
+		g.remove(e)
+		return nil, false
+	}
+
+	e.stale = true
+	g.invalidate(e.key)
+	return e.key, true
+}
+
+func (g *group) put(key Key, obj interface{}) {
+	e := &repoEntry{
+		key:        key,
+		obj:        obj,
+		size:       estimateSize(obj),
+		lastAccess: time.Now(),
+	}
+	e.elem = g.lru.PushFront(e)
+	g.data[keyString(key)] = e
+	g.bytes += e.size
+
+	if g.overflow(0, 0) {
+		g.owner.notifyPressure()
+	}
+}
+
+func (g *group) replace(e *repoEntry, obj interface{}) {
+	size := estimateSize(obj)
+	g.bytes += size - e.size
+	e.obj = obj
+	e.size = size
+	g.touch(e)
+
+	if g.overflow(0, 0) {
+		g.owner.notifyPressure()
+	}
+}
+
+// remove 移除对象，包含该对象的前缀不再完整
+func (g *group) remove(e *repoEntry) {
+	g.drop(e)
+	g.invalidate(e.key)
+}
+
+// invalidate 包含该主键的前缀不再完整
+func (g *group) invalidate(key Key) {
+	g.removals++
+
+	if len(g.loaded) == 0 {
+		return
+	}
+	loaded := g.loaded[:0]
+	for _, k := range g.loaded {
+		if !k.PrefixOf(key) {
+			loaded = append(loaded, k)
+		}
+	}
+	g.loaded = loaded
+}
+
+func (g *group) drop(e *repoEntry) {
+	g.lru.Remove(e.elem)
+	delete(g.data, keyString(e.key))
+	g.bytes -= e.size
+}
+
+func (g *group) touch(e *repoEntry) {
+	e.lastAccess = time.Now()
+	g.lru.MoveToFront(e.elem)
+}
+
+// overflow 扣除 count 个、size 字节待逐出的对象后是否仍超出容量
+func (g *group) overflow(count int, size int64) bool {
+	return (g.maxEntries > 0 && len(g.data)-count > g.maxEntries) ||
+		(g.memoryBudget > 0 && g.bytes-size > g.memoryBudget)
+}
+
+// evict 从 LRU 表尾挑选需要逐出的对象，确认对象未被再次访问并且可以逐出后移除
+func (g *group) evict(ctx context.Context, now time.Time, hooks EvictHooks) int {
+	type victim struct {
+		entry      *repoEntry
+		lastAccess time.Time
+	}
+
+	g.mu.RLock()
+	var victims []victim
+	var count int
+	var size int64
+	for elem := g.lru.Back(); elem != nil; elem = elem.Prev() {
+		e := elem.Value.(*repoEntry)
+		idle := g.opts.IdleTTL > 0 && now.Sub(e.lastAccess) >= g.opts.IdleTTL
+		if !idle && !g.overflow(count, size) {
+			break
+		}
+		victims = append(victims, victim{entry: e, lastAccess: e.lastAccess})
+		count++
+		size += e.size
+	}
+	g.mu.RUnlock()
+
+	evicted := 0
+	for _, v := range victims {
+		g.mu.Lock()
+		obj := v.entry.obj
+		removed := g.data[keyString(v.entry.key)] == v.entry && v.entry.lastAccess.Equal(v.lastAccess) &&
+			(obj == nil || hooks.Evictable == nil || hooks.Evictable(v.entry.key, obj))
+		if removed {
+			g.remove(v.entry)
+		}
+		g.mu.Unlock()
+
+		if removed {
+			evicted++
+			if obj != nil && hooks.Unload != nil {
+				hooks.Unload(ctx, v.entry.key, obj)
+			}
+		}
+	}
+
+	return evicted
+}
+
+// keyString 缓存使用主键的字符串形式作为索引，不同的主键对象只要值相同就指向同一条缓存
+func keyString(key Key) string {
+	if s, ok := key.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprint(key)
+}
+
+func estimateSize(obj interface{}) int64 {
+	r, ok := obj.(Record)
+	if !ok {
+		return defaultEntrySize
+	}
+
+	if m, ok := r.Snapshoot().(proto.Message); ok {
+		return int64(proto.Size(m)) + defaultEntrySize
+	}
+	return defaultEntrySize
 }
@@ -0,0 +1,225 @@
+package xdb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRepo_EvictLRU(t *testing.T) {
+	repo := &Repo{}
+	repo.Init(true, &RepoOptions{GroupSize: 1, MaxEntries: 2}, "repo_test", nil, &sync.WaitGroup{})
+
+	repo.SetOnFetch(&redoTestPK{Id: 1}, "a", false, nil)
+	repo.SetOnFetch(&redoTestPK{Id: 2}, "b", false, nil)
+	repo.SetOnFetch(&redoTestPK{Id: 3}, "c", false, nil)
+
+	// 访问 1 之后，2 成为最久未访问的对象
+	if v, ok := repo.Get(&redoTestPK{Id: 1}); !ok || v != "a" {
+		t.Fatalf("expected cached a, got %v", v)
+	}
+
+	var checked, unloaded []any
+	hooks := EvictHooks{
+		Evictable: func(key Key, obj any) bool { checked = append(checked, obj); return true },
+		Unload:    func(ctx context.Context, key Key, obj any) { unloaded = append(unloaded, obj) },
+	}
+
+	if n := repo.Evict(context.Background(), time.Now(), hooks); n != 1 {
+		t.Fatalf("expected 1 eviction, got %d", n)
+	}
+	if repo.Exists(&redoTestPK{Id: 2}) {
+		t.Error("expected 2 to be evicted")
+	}
+	if len(checked) != 1 || checked[0] != "b" || len(unloaded) != 1 || unloaded[0] != "b" {
+		t.Errorf("unexpected hooks: checked=%v unloaded=%v", checked, unloaded)
+	}
+	if repo.Evictions() != 1 || repo.Len() != 2 {
+		t.Errorf("unexpected stats: evictions=%d len=%d", repo.Evictions(), repo.Len())
+	}
+}
+
+func TestRepo_EvictIdle(t *testing.T) {
+	repo := &Repo{}
+	repo.Init(true, &RepoOptions{GroupSize: 1, IdleTTL: time.Minute}, "repo_test", nil, &sync.WaitGroup{})
+
+	repo.SetOnFetch(&redoTestPK{Id: 1}, "a", false, nil)
+	if n := repo.Evict(context.Background(), time.Now(), EvictHooks{}); n != 0 {
+		t.Fatalf("expected no eviction, got %d", n)
+	}
+
+	if n := repo.Evict(context.Background(), time.Now().Add(2*time.Minute), EvictHooks{}); n != 1 {
+		t.Fatalf("expected 1 eviction, got %d", n)
+	}
+	if repo.Exists(&redoTestPK{Id: 1}) {
+		t.Error("expected idle entry to be evicted")
+	}
+}
+
+func TestRepo_EvictPending(t *testing.T) {
+	repo := &Repo{}
+	repo.Init(true, &RepoOptions{GroupSize: 1, IdleTTL: time.Minute}, "repo_test", nil, &sync.WaitGroup{})
+
+	repo.SetOnFetch(&redoTestPK{Id: 1}, "a", false, nil)
+	repo.SetOnFetch(&redoTestPK{Id: 2}, "b", false, nil)
+
+	// 有提交尚未入库的对象留在缓存中，下一轮再逐出
+	pending := true
+	hooks := EvictHooks{
+		Evictable: func(key Key, obj any) bool { return obj != "a" || !pending },
+	}
+	if n := repo.Evict(context.Background(), time.Now().Add(2*time.Minute), hooks); n != 1 {
+		t.Fatalf("expected 1 eviction, got %d", n)
+	}
+	if !repo.Exists(&redoTestPK{Id: 1}) || repo.Exists(&redoTestPK{Id: 2}) {
+		t.Error("expected only the pending entry kept")
+	}
+
+	pending = false
+	if n := repo.Evict(context.Background(), time.Now().Add(4*time.Minute), hooks); n != 1 || repo.Len() != 0 {
+		t.Errorf("expected pending entry evicted after saving, got %d", n)
+	}
+}
+
+// blockingTestTable 写入阻塞到 release 关闭
+type blockingTestTable struct {
+	NoStorageTable
+	saving  chan struct{}
+	release chan struct{}
+}
+
+func (t *blockingTestTable) Save(ctx context.Context, commitments []Commitment, timeout time.Duration, retryInterval time.Duration, running func() bool) bool {
+	close(t.saving)
+	<-t.release
+	return true
+}
+
+func TestSource_EvictableWhileSaving(t *testing.T) {
+	table := &blockingTestTable{saving: make(chan struct{}), release: make(chan struct{})}
+	redoTestSource.table = table
+	redoTestSource.repo = &Repo{}
+	redoTestSource.repo.Init(true, nil, "redo_test", nil, &sync.WaitGroup{})
+	redoTestSource.saver = NewSaver(redoTestSource, 1, time.Second, time.Hour)
+	defer func() {
+		redoTestSource.table = nil
+		redoTestSource.repo = nil
+		redoTestSource.saver = nil
+	}()
+
+	ctx, wg := context.Background(), &sync.WaitGroup{}
+	redoTestSource.saver.Run(ctx, wg)
+	defer func() {
+		redoTestSource.saver.Close()
+		wg.Wait()
+	}()
+
+	pk := &redoTestPK{Id: 1}
+	if !redoTestSource.evictable(pk, "a") {
+		t.Error("expected evictable without pending commitments")
+	}
+
+	// 排队中和正在入库的提交都不能逐出
+	redoTestSource.saver.Put(ctx, &redoTestCommitment{data: &redoTestData{Id: 1}, lifecycle: LifecycleNew}, -1)
+	if redoTestSource.evictable(pk, "a") {
+		t.Error("expected queued commitment to block eviction")
+	}
+	redoTestSource.saver.Flush(pk)
+	<-table.saving
+	if redoTestSource.evictable(pk, "a") || !redoTestSource.evictable(&redoTestPK{Id: 2}, "b") {
+		t.Error("expected saving commitment to block eviction")
+	}
+
+	close(table.release)
+	redoTestSource.saver.Sync(pk)
+	if !redoTestSource.evictable(pk, "a") {
+		t.Error("expected evictable after saved")
+	}
+}
+
+func TestRepo_GetMulti(t *testing.T) {
+	repo := &Repo{}
+	repo.Init(true, &RepoOptions{GroupSize: 4}, "repo_test", nil, &sync.WaitGroup{})
+
+	repo.SetOnFetch(&redoTestPK{Id: 1}, "a", false, nil)
+	repo.SetOnFetch(&redoTestPK{Id: 3}, nil, true, nil)
+
+	vals, missing := repo.GetMulti([]Key{&redoTestPK{Id: 2}, &redoTestPK{Id: 1}, &redoTestPK{Id: 3}, &redoTestPK{Id: 4}})
+	if len(vals) != 4 || vals[1] != "a" || vals[0] != nil || vals[2] != nil {
+		t.Errorf("unexpected vals: %v", vals)
+	}
+	if len(missing) != 2 || missing[0] != 0 || missing[1] != 3 {
+		t.Errorf("unexpected missing: %v", missing)
+	}
+}
+
+// repoTestKey 两段式主键，n 为有效字段数
+type repoTestKey struct {
+	a, b int
+	n    int
+}
+
+func (k *repoTestKey) String() string { return fmt.Sprintf("%d:%d:%d", k.a, k.b, k.n) }
+func (k *repoTestKey) HashGroup() int { return k.a }
+func (k *repoTestKey) Empty() bool    { return k.n == 0 }
+func (k *repoTestKey) PrefixOf(key Key) bool {
+	other := key.(*repoTestKey)
+	return (k.n < 1 || k.a == other.a) && (k.n < 2 || k.b == other.b) && k.n <= other.n
+}
+
+func TestRepo_PrefixLoaded(t *testing.T) {
+	repo := &Repo{}
+	repo.Init(true, &RepoOptions{GroupSize: 4}, "repo_test", nil, &sync.WaitGroup{})
+
+	prefix := &repoTestKey{a: 1, n: 1}
+	since := repo.Removals(prefix)
+	repo.SetOnFetch(&repoTestKey{a: 1, b: 1, n: 2}, "x", false, nil)
+	repo.SetOnFetch(&repoTestKey{a: 1, b: 2, n: 2}, "y", false, nil)
+	if !repo.MarkLoaded(prefix, since) || !repo.Loaded(prefix) || !repo.Loaded(&repoTestKey{a: 1, b: 3, n: 2}) {
+		t.Fatal("expected prefix to be loaded")
+	}
+
+	// 新保存的对象不影响完整性
+	repo.SetOnStore(&repoTestKey{a: 1, b: 3, n: 2}, "z", false)
+	if vals, _ := repo.GetAll(prefix); len(vals) != 3 || !repo.Loaded(prefix) {
+		t.Errorf("unexpected prefix state: %v", vals)
+	}
+
+	// 逐出或过期后前缀不再完整
+	repo.Expire(&repoTestKey{a: 1, b: 1, n: 2})
+	if repo.Loaded(prefix) {
+		t.Error("expected prefix to be incomplete after expire")
+	}
+
+	// 加载期间有对象被移除，放弃标记
+	since = repo.Removals(prefix)
+	repo.Expire(&repoTestKey{a: 1, b: 2, n: 2})
+	if repo.MarkLoaded(prefix, since) {
+		t.Error("expected mark to be rejected")
+	}
+}
+
+func TestRepo_Uniques(t *testing.T) {
+	repo := &Repo{}
+	repo.Init(true, &RepoOptions{GroupSize: 1}, "repo_test", nil, &sync.WaitGroup{})
+
+	repo.SetUniques(&redoTestPK{Id: 1}, map[string]interface{}{"uk_name": "a"})
+	if key, ok := repo.GetUnique("uk_name", "a"); !ok || key.(*redoTestPK).Id != 1 {
+		t.Fatalf("unexpected unique: %v", key)
+	}
+
+	// 改名后旧的唯一键移除
+	repo.SetUniques(&redoTestPK{Id: 1}, map[string]interface{}{"uk_name": "b"})
+	if _, ok := repo.GetUnique("uk_name", "a"); ok {
+		t.Error("expected old unique removed")
+	}
+
+	// 其他对象占用后，原对象移除唯一键不影响新的占用者
+	repo.SetUniques(&redoTestPK{Id: 2}, map[string]interface{}{"uk_name": "b"})
+	repo.DeleteUniques(&redoTestPK{Id: 1})
+	repo.DeleteUnique("uk_name", "b", &redoTestPK{Id: 1})
+	if key, ok := repo.GetUnique("uk_name", "b"); !ok || key.(*redoTestPK).Id != 2 {
+		t.Errorf("unexpected unique: %v", key)
+	}
+}
@@ -2,8 +2,11 @@ package xdb
 
 import (
 	"context"
+	"os"
 	"sync"
 	"time"
+
+	clog "github.com/cherry-game/cherry/logger"
 )
 
 const BatchSize = int32(256)
@@ -14,6 +17,7 @@ type Saver struct {
 	workers      []SaveWorker
 	timeout      time.Duration
 	SyncInterval time.Duration
+	CriticalSync bool // Flush 同步等待入库
 	src          *Source
 }
 
@@ -43,7 +47,11 @@ func (s *Saver) Run(ctx context.Context, wg *sync.WaitGroup) {
 // Put 放入提交对象
 func (s *Saver) Put(ctx context.Context, c Commitment, i int32) int32 {
 	worker := s.getWorker(PKOf(c))
-	return worker.Put(ctx, c, i)
+	i = worker.Put(ctx, c, i)
+	if i >= 0 {
+		metricInc(MetricQueued, s.src.Namespace, 1)
+	}
+	return i
 }
 
 // Sync 同步
@@ -66,6 +74,17 @@ func (s *Saver) Sync(pk PK) {
 	wg.Wait()
 }
 
+// Pending 主键是否有尚未入库的提交（排队中或正在入库）
+func (s *Saver) Pending(pk PK) bool {
+	return s.getWorker(pk).Pending(pk)
+}
+
+// Flush 立即入库主键所在的保存队列，CriticalSync 时等待入库完成
+func (s *Saver) Flush(pk PK) {
+	metricInc(MetricCritical, s.src.Namespace, 1)
+	s.getWorker(pk).Flush(s.CriticalSync)
+}
+
 // OnGoingCount 获取进行中的数量
 func (s *Saver) OnGoingCount() int32 {
 	ongoing := int32(0)
@@ -82,10 +101,62 @@ func (s *Saver) Close() {
 	}
 }
 
-// Recover 恢复
+// Recover 恢复，重放上次进程退出时残留的重做日志，成功入库后删除分段文件
 func (s *Saver) Recover(ctx context.Context, wg *sync.WaitGroup) {
-	// TODO: 实现重做日志恢复
-	// 这里可以读取重做日志文件并恢复未完成的提交
+	if !isRedoEnabled() {
+		return
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		if err := s.recover(ctx); err != nil {
+			clog.Errorf("[xdb] redo recover failed. [ns = %s, err = %v]", s.src.Namespace, err)
+		}
+	}()
+}
+
+func (s *Saver) recover(ctx context.Context) error {
+	paths, err := listRedoSegments(s.src)
+	if err != nil || len(paths) == 0 {
+		return err
+	}
+
+	commitments, err := loadRedoCommitments(s.src, paths)
+	if err != nil {
+		return err
+	}
+
+	dropped := 0
+	if len(commitments) > 0 {
+		rctx, result := withSaveResult(ctx)
+		if err = s.src.Table().Recover(rctx, commitments); err != nil {
+			return err
+		}
+		written := result.written(commitments)
+		dropped = len(commitments) - len(written)
+		publishChanges(ctx, s.src, written)
+	}
+
+	clog.Infof("[xdb] redo recovered. [ns = %s, segments = %d, commitments = %d, dropped = %d]", s.src.Namespace, len(paths), len(commitments), dropped)
+
+	// 有提交因冲突或死信没有入库时保留分段文件，由人工处理
+	if dropped > 0 {
+		for _, path := range paths {
+			if err = keepFailedFile(path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, path := range paths {
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *Saver) getWorker(pk PK) *SaveWorker {
@@ -100,6 +171,7 @@ type SaveWorker struct {
 	condProd  sync.Cond
 	condCons  sync.Cond
 	receiving *CommitmentBatch
+	consuming *CommitmentBatch // 正在入库的批次
 	syncTimer *time.Timer
 	running   bool
 }
@@ -153,6 +225,26 @@ func (sw *SaveWorker) Put(ctx context.Context, c Commitment, index int32) int32
 	return -1
 }
 
+// Flush 不等待刷新间隔，唤醒消费协程写入当前批次，wait 时等待批次入库，工作器未运行时直接返回
+func (sw *SaveWorker) Flush(wait bool) {
+	var chSync <-chan interface{}
+
+	sw.mu.Lock()
+	if sw.running && sw.receiving != nil {
+		if wait {
+			chSync = sw.receiving.Sync()
+		} else {
+			sw.receiving.overtime = true
+		}
+		sw.condCons.Signal()
+	}
+	sw.mu.Unlock()
+
+	if chSync != nil {
+		<-chSync
+	}
+}
+
 // Close 关闭工作器
 func (sw *SaveWorker) Close() {
 	sw.mu.Lock()
@@ -167,11 +259,26 @@ func (sw *SaveWorker) consume(ctx context.Context) {
 	for {
 		batch, running := sw.poll(ctx)
 		if batch.Len() > 0 {
-			if !sw.owner.src.Table().Save(ctx, batch.entries, sw.owner.timeout, RetryInterval, sw.Running) {
-				break
+			ns := sw.owner.src.Namespace
+			metricObserve(MetricBatchSize, ns, float64(batch.Len()))
+
+			start := time.Now()
+			sctx, result := withSaveResult(ctx)
+			ok := sw.owner.src.Table().Save(sctx, batch.entries, sw.owner.timeout, RetryInterval, sw.Running)
+			metricObserve(MetricFlush, ns, time.Since(start).Seconds())
+			if !ok {
+				sw.release(ctx, batch)
+				return
 			}
+			written := result.written(batch.entries)
+			publishReplica(sw.owner.src, written)
+			publishChanges(ctx, sw.owner.src, written)
+			writeHistory(ctx, sw.owner.src, result.writtenHistory(batch.history))
 		}
 
+		sw.mu.Lock()
+		sw.consuming = nil
+		sw.mu.Unlock()
 		putCommitmentBatch(batch)
 		if !running {
 			break
@@ -179,6 +286,86 @@ func (sw *SaveWorker) consume(ctx context.Context) {
 	}
 }
 
+// release 停止时批次没有入库就退出，唤醒所有等待入库的调用方，没有入库的提交保留在分段文件中，下次启动时恢复
+func (sw *SaveWorker) release(ctx context.Context, batch *CommitmentBatch) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	for _, cb := range []*CommitmentBatch{batch, sw.receiving} {
+		if cb == nil {
+			continue
+		}
+		cb.Retire(ctx)
+		if cb.chSync != nil {
+			close(cb.chSync)
+			cb.chSync = nil
+		}
+	}
+	sw.receiving, sw.consuming = nil, nil
+}
+
+type saveResultKey struct{}
+
+// saveResult 记录批次中没有写入存储的提交（写入死信或版本冲突时放弃），入库后只发布写入的提交
+type saveResult struct {
+	mu      sync.Mutex
+	dropped map[Commitment]struct{}
+}
+
+func withSaveResult(ctx context.Context) (context.Context, *saveResult) {
+	r := &saveResult{}
+	return context.WithValue(ctx, saveResultKey{}, r), r
+}
+
+// markDropped 驱动写入时放弃了提交
+func markDropped(ctx context.Context, c Commitment) {
+	r, _ := ctx.Value(saveResultKey{}).(*saveResult)
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dropped == nil {
+		r.dropped = make(map[Commitment]struct{})
+	}
+	r.dropped[c] = struct{}{}
+}
+
+// written 过滤出已写入存储的提交
+func (r *saveResult) written(commitments []Commitment) []Commitment {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.dropped) == 0 {
+		return commitments
+	}
+
+	ret := make([]Commitment, 0, len(commitments))
+	for _, c := range commitments {
+		if _, ok := r.dropped[c]; !ok {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// writtenHistory 过滤出已写入存储的提交的历史
+func (r *saveResult) writtenHistory(rows []*HistoryRow) []*HistoryRow {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.dropped) == 0 {
+		return rows
+	}
+
+	ret := make([]*HistoryRow, 0, len(rows))
+	for _, row := range rows {
+		if _, ok := r.dropped[row.entry]; !ok {
+			ret = append(ret, row)
+		}
+	}
+	return ret
+}
+
 func (sw *SaveWorker) poll(ctx context.Context) (*CommitmentBatch, bool) {
 	sw.mu.Lock()
 
@@ -196,11 +383,13 @@ func (sw *SaveWorker) poll(ctx context.Context) (*CommitmentBatch, bool) {
 
 		if !sw.running {
 			sw.receiving = nil
+			sw.consuming = current
 			return current, false
 		}
 
 		if current.Consumable() {
 			current.Retire(ctx)
+			sw.consuming = current
 			sw.receiving = getCommitmentBatch(ctx, sw)
 			sw.condProd.Broadcast()
 			return current, true
@@ -227,20 +416,34 @@ func (sw *SaveWorker) Ongoing() int32 {
 	return sw.receiving.Len()
 }
 
-// Sync 同步
+// Pending 主键是否有尚未入库的提交
+func (sw *SaveWorker) Pending(pk PK) bool {
+	sw.mu.RLock()
+	defer sw.mu.RUnlock()
+	ks := keyString(pk)
+	return sw.receiving.contains(ks) || sw.consuming.contains(ks)
+}
+
+// Sync 同步，消费协程已经退出时直接返回
 func (sw *SaveWorker) Sync() {
-	<-(func() <-chan interface{} {
-		sw.mu.Lock()
-		defer sw.mu.Unlock()
-		chSync := sw.receiving.Sync()
+	var chSync <-chan interface{}
+
+	sw.mu.Lock()
+	if sw.receiving != nil {
+		chSync = sw.receiving.Sync()
 		sw.condCons.Signal()
-		return chSync
-	})()
+	}
+	sw.mu.Unlock()
+
+	if chSync != nil {
+		<-chSync
+	}
 }
 
 // CommitmentBatch 提交批次
 type CommitmentBatch struct {
 	entries  []Commitment
+	history  []*HistoryRow // 批次中的提交对应的历史，合并前的每个提交一行
 	redo     RedoLogFile
 	overtime bool
 	chSync   chan interface{}
@@ -270,6 +473,19 @@ func (cb *CommitmentBatch) Len() int32 {
 	return int32(len(cb.entries))
 }
 
+// contains 批次中是否有该主键的提交
+func (cb *CommitmentBatch) contains(ks string) bool {
+	if cb == nil {
+		return false
+	}
+	for _, c := range cb.entries {
+		if keyString(PKOf(c)) == ks {
+			return true
+		}
+	}
+	return false
+}
+
 // Retire 退役
 func (cb *CommitmentBatch) Retire(ctx context.Context) {
 	cb.redo.Retire(ctx)
@@ -279,20 +495,36 @@ func (cb *CommitmentBatch) Retire(ctx context.Context) {
 func (cb *CommitmentBatch) Put(ctx context.Context, c Commitment, index int32) int32 {
 	l := cb.Len()
 
-	if index >= 0 && index < l && cb.entries[index].Merge(c) {
+	// 记录保存的下标可能已过期（批次已入库或是新记录的零值），只与同一主键的提交合并
+	if index >= 0 && index < l && samePK(cb.entries[index], c) && cb.entries[index].Merge(c) {
+		metricInc(MetricMerged, c.Source().Namespace, 1)
 		cb.redo.Log(ctx, c)
+		cb.logHistory(ctx, c, cb.entries[index])
 		return index
 	}
 
 	if l < BatchSize {
 		cb.entries = append(cb.entries, c)
 		cb.redo.Log(ctx, c)
+		cb.logHistory(ctx, c, c)
 		return l
 	}
 
 	return -1
 }
 
+// logHistory 记录提交的历史，entry 为批次中合并了该提交的提交对象，批次入库后写入历史表
+func (cb *CommitmentBatch) logHistory(ctx context.Context, c Commitment, entry Commitment) {
+	if row := newHistoryRow(ctx, c); row != nil {
+		row.entry = entry
+		cb.history = append(cb.history, row)
+	}
+}
+
+func samePK(a, b Commitment) bool {
+	return keyString(PKOf(a)) == keyString(PKOf(b))
+}
+
 var batchPool = sync.Pool{
 	New: func() interface{} {
 		return &CommitmentBatch{
@@ -303,8 +535,10 @@ var batchPool = sync.Pool{
 
 func getCommitmentBatch(ctx context.Context, sw *SaveWorker) *CommitmentBatch {
 	batch := batchPool.Get().(*CommitmentBatch)
-	// 初始化redo（如果未初始化）
-	if batch.redo == nil {
+	// 初始化redo（批次对象会被复用，开关变化时需要重新创建）
+	if _, ok := batch.redo.(*redoLogFile); isRedoEnabled() && !ok {
+		batch.redo = newRedoLogFile()
+	} else if batch.redo == nil || (!isRedoEnabled() && ok) {
 		batch.redo = &simpleRedoLog{}
 	}
 	batch.redo.Serve(ctx, sw)
@@ -319,6 +553,7 @@ func putCommitmentBatch(c *CommitmentBatch) {
 	}
 	c.overtime = false
 	c.entries = c.entries[0:0]
+	c.history = nil
 	batchPool.Put(c)
 }
 
@@ -330,7 +565,7 @@ type RedoLogFile interface {
 	Destroy()
 }
 
-// 简单的重做日志实现（可以后续扩展）
+// 未开启重做日志时使用的空实现
 type simpleRedoLog struct{}
 
 func (s *simpleRedoLog) Serve(ctx context.Context, sw *SaveWorker) {}
@@ -344,6 +579,16 @@ func HashGroupPK(pk PK) int {
 	return src.repo.HashGroup(pk)
 }
 
+// HashString 字符串主键的哈希值（FNV-1a），用于生成的 PK.HashGroup
+func HashString(s string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= 16777619
+	}
+	return h
+}
+
 // HashGroup 获取记录的哈希组
 func HashGroup(v Record) int {
 	return HashGroupPK(v.Source().PKOf(v))
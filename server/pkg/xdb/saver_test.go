@@ -0,0 +1,124 @@
+package xdb
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// historyTestTable 内存中的历史表
+type historyTestTable struct {
+	rows []*HistoryRow
+}
+
+func (t *historyTestTable) Append(ctx context.Context, rows []*HistoryRow) error {
+	t.rows = append(t.rows, rows...)
+	return nil
+}
+
+func (t *historyTestTable) List(ctx context.Context, key string, maxVersion int64, limit int) ([]*HistoryRow, error) {
+	return nil, nil
+}
+
+func TestSaver_HistoryWrittenOnly(t *testing.T) {
+	redoOptions = &RedoOptions{Dir: t.TempDir()}
+	history := &historyTestTable{}
+	redoTestSource.history = history
+	redoTestSource.table = &failureTestTable{errs: []error{Permanent(errors.New("bad value")), nil}}
+	redoTestSource.repo = &Repo{}
+	redoTestSource.repo.Init(true, nil, "redo_test", nil, &sync.WaitGroup{})
+	redoTestSource.saver = NewSaver(redoTestSource, 1, time.Second, time.Hour)
+	defer func() {
+		redoOptions = nil
+		redoTestSource.history = nil
+		redoTestSource.table = nil
+		redoTestSource.repo = nil
+		redoTestSource.saver = nil
+	}()
+
+	ctx, wg := context.Background(), &sync.WaitGroup{}
+	redoTestSource.saver.Run(ctx, wg)
+	defer func() {
+		redoTestSource.saver.Close()
+		wg.Wait()
+	}()
+
+	// 写入死信的提交没有入库，不追加历史
+	redoTestSource.saver.Put(ctx, &redoTestCommitment{data: &redoTestData{Id: 1}, lifecycle: LifecycleNew}, -1)
+	redoTestSource.saver.Put(ctx, &redoTestCommitment{data: &redoTestData{Id: 2}, lifecycle: LifecycleNew}, -1)
+	redoTestSource.saver.Sync(nil)
+
+	if len(history.rows) != 1 || history.rows[0].Key != keyString(&redoTestPK{Id: 2}) {
+		t.Errorf("expected only the written commitment in history: %+v", history.rows)
+	}
+}
+
+func TestCommitmentBatch_PutSamePK(t *testing.T) {
+	ctx := context.Background()
+	cb := &CommitmentBatch{redo: &simpleRedoLog{}}
+	first := &redoTestCommitment{data: &redoTestData{Id: 1, Value: "a"}, lifecycle: LifecycleNew, mergeable: true}
+	if i := cb.Put(ctx, first, -1); i != 0 {
+		t.Fatalf("unexpected index: %d", i)
+	}
+
+	// 模型记录的下标已过期，指向其他主键的提交，不能合并
+	other := &redoTestCommitment{data: &redoTestData{Id: 2, Value: "b"}, lifecycle: LifecycleNew}
+	if i := cb.Put(ctx, other, 0); i != 1 || first.data.Id != 1 {
+		t.Fatalf("expected appended without merging: %d %+v", i, first.data)
+	}
+
+	// 同一主键的提交合并到原下标
+	update := &redoTestCommitment{data: &redoTestData{Id: 1, Value: "c"}, lifecycle: LifecycleNormal}
+	if i := cb.Put(ctx, update, 0); i != 0 || cb.Len() != 2 || first.data.Value != "c" {
+		t.Errorf("expected merged into index 0: %d %d %+v", i, cb.Len(), first.data)
+	}
+}
+
+func TestSaver_CloseReleasesSync(t *testing.T) {
+	redoOptions = &RedoOptions{Dir: t.TempDir(), Enabled: true}
+	// 写入一直失败，停止前批次无法入库
+	errs := make([]error, 100)
+	for i := range errs {
+		errs[i] = errors.New("timeout")
+	}
+	redoTestSource.table = &failureTestTable{errs: errs}
+	redoTestSource.repo = &Repo{}
+	redoTestSource.repo.Init(true, nil, "redo_test", nil, &sync.WaitGroup{})
+	redoTestSource.saver = NewSaver(redoTestSource, 1, time.Second, time.Hour)
+	defer func() {
+		redoOptions = nil
+		redoTestSource.table = nil
+		redoTestSource.repo = nil
+		redoTestSource.saver = nil
+	}()
+
+	ctx, wg := context.Background(), &sync.WaitGroup{}
+	saver := redoTestSource.saver
+	saver.Run(ctx, wg)
+	saver.Put(ctx, &redoTestCommitment{data: &redoTestData{Id: 1}, lifecycle: LifecycleNew}, -1)
+
+	synced := make(chan struct{})
+	go func() {
+		saver.Sync(nil)
+		close(synced)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	saver.Close()
+	wg.Wait()
+
+	// 消费协程退出后等待入库的调用方被唤醒，之后的同步直接返回
+	select {
+	case <-synced:
+	case <-time.After(time.Second):
+		t.Fatal("expected sync released on close")
+	}
+	saver.Sync(nil)
+
+	// 没有入库的提交保留在分段文件中
+	if paths, _ := filepath.Glob(filepath.Join(redoOptions.Dir, "*", "*"+redoFileExt)); len(paths) == 0 {
+		t.Error("expected redo segment kept")
+	}
+}
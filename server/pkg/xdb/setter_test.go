@@ -0,0 +1,42 @@
+package xdb_test
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"lucky/server/gen/db"
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/memory"
+)
+
+func TestSetter_MarkChanges(t *testing.T) {
+	ctx := setupMemory(t, &memory.Configurator{})
+
+	player, err := xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: 1, Name: "a", Level: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = xdb.Sync(ctx, player); err != nil {
+		t.Fatal(err)
+	}
+
+	// 值不变时不标记变更
+	if err = player.SetLevel(1); err != nil || player.Dirty() {
+		t.Fatalf("expected no change: %v", err)
+	}
+	if err = player.AddExp(5); err != nil || !player.GetHeader().Changes().ContainsExact(db.PlayerFieldExp) {
+		t.Fatalf("expected exp changed: %v %v", err, player.GetHeader().Changes())
+	}
+	if err = xdb.Sync(ctx, player); err != nil {
+		t.Fatal(err)
+	}
+	if row, _ := memory.Lookup(xdb.PKOf(player)); row.(*db.Player).Exp != 5 {
+		t.Errorf("unexpected saved player: %v", row)
+	}
+
+	player.GetHeader().EnableReadonly()
+	if err = player.SetLevel(2); !errors.Is(err, xdb.ErrReadonly) || player.Level != 1 {
+		t.Errorf("expected readonly: %v", err)
+	}
+}
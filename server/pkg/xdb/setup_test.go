@@ -0,0 +1,23 @@
+package xdb_test
+
+import (
+	"context"
+	"testing"
+
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/memory"
+)
+
+// setupMemory 所有数据源切换为内存驱动并清空数据后初始化 xdb，测试结束时停止
+func setupMemory(t *testing.T, c *memory.Configurator) context.Context {
+	t.Helper()
+
+	ctx := context.Background()
+	memory.Use()
+	memory.Reset()
+	if err := xdb.Setup(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { xdb.Stop(ctx) })
+	return ctx
+}
@@ -0,0 +1,597 @@
+package xdb
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// ShardOptions 分片选项，按数据源的分片字段（(xdb.shard_hint)）把记录路由到不同的物理表或 DAO
+type ShardOptions struct {
+	Count  int           // 取模分片数，物理表名为 ShardTableName(表名, 序号, Count)
+	Ranges []*ShardRange // 按区间分片，设置时忽略 Count
+}
+
+// ShardRange 区间分片，分片字段的值在 [Min, Max) 内的记录保存在该分片
+type ShardRange struct {
+	Min    int64
+	Max    int64
+	Table  string      // 物理表名，为空时为 ShardTableName(表名, 序号, len(Ranges))
+	DaoKey interface{} // 为空时使用表选项的 DaoKey
+}
+
+// Shard 分片，Source 为物理表的数据源（TableName 为物理表名），只用于创建驱动的表和迁移
+type Shard struct {
+	Source *Source
+	DaoKey interface{}
+}
+
+// ShardTableName 第 i 个分片的物理表名，序号至少两位，例如 item_00..item_31
+func ShardTableName(table string, i int, count int) string {
+	width := len(strconv.Itoa(count - 1))
+	if width < 2 {
+		width = 2
+	}
+	return fmt.Sprintf("%s_%0*d", table, width, i)
+}
+
+// Shards 按分片选项生成各个分片，daoKey 为表选项的 DaoKey
+// 分片字段必须是主键列，按主键读取时才能路由；唯一索引只在各自的物理表内生效，有 (xdb.gk) 字段的数据源不能分片
+func (src *Source) Shards(opts *ShardOptions, daoKey interface{}) ([]*Shard, error) {
+	if src.ShardField == "" {
+		return nil, errors.Errorf("missing shard field [%s]", src.Namespace)
+	}
+	for _, index := range src.Indexes {
+		if index.Unique {
+			return nil, errors.Errorf("unique index %s is not global on shards [%s]", index.Name, src.Namespace)
+		}
+	}
+
+	keys, err := src.KeyColumns()
+	if err != nil {
+		return nil, err
+	}
+	isKey := false
+	for _, key := range keys {
+		isKey = isKey || key.Name == src.ShardField
+	}
+	if !isKey {
+		return nil, errors.Errorf("shard field %s is not a key column [%s]", src.ShardField, src.Namespace)
+	}
+
+	newShard := func(table string, key interface{}) *Shard {
+		shard := &Source{}
+		*shard = *src
+		shard.TableName = table
+		shard.table, shard.repo, shard.saver = nil, nil, nil
+		if key == nil {
+			key = daoKey
+		}
+		return &Shard{Source: shard, DaoKey: key}
+	}
+
+	var shards []*Shard
+	switch {
+	case len(opts.Ranges) > 0:
+		for i, r := range opts.Ranges {
+			if r.Min >= r.Max {
+				return nil, errors.Errorf("invalid shard range [%d, %d) [%s]", r.Min, r.Max, src.Namespace)
+			}
+			table := r.Table
+			if table == "" {
+				table = ShardTableName(src.TableName, i, len(opts.Ranges))
+			}
+			shards = append(shards, newShard(table, r.DaoKey))
+		}
+	case opts.Count > 0:
+		for i := 0; i < opts.Count; i++ {
+			shards = append(shards, newShard(ShardTableName(src.TableName, i, opts.Count), nil))
+		}
+	default:
+		return nil, errors.Errorf("invalid shard options [%s]", src.Namespace)
+	}
+	return shards, nil
+}
+
+// shardTable 分片表，按分片字段的值把读写路由到各个分片的表
+// 不能确定分片的查询在所有分片上执行，有排序或分页时在内存中合并
+type shardTable struct {
+	src    *Source
+	opts   *ShardOptions
+	tables []Table
+}
+
+func newShardTable(src *Source, opts *ShardOptions, tables []Table) *shardTable {
+	return &shardTable{src: src, opts: opts, tables: tables}
+}
+
+// shardOf 分片字段的值所在的分片
+func (t *shardTable) shardOf(value interface{}) (int, error) {
+	n, ok := toInt(reflect.ValueOf(value))
+	if !ok {
+		return 0, errors.Errorf("invalid shard value %v (%T) [%s]", value, value, t.src.Namespace)
+	}
+
+	if len(t.opts.Ranges) > 0 {
+		for i, r := range t.opts.Ranges {
+			if n >= r.Min && n < r.Max {
+				return i, nil
+			}
+		}
+		return 0, errors.Errorf("no shard for %s = %d [%s]", t.src.ShardField, n, t.src.Namespace)
+	}
+
+	i := n % int64(len(t.tables))
+	if i < 0 {
+		i = -i
+	}
+	return int(i), nil
+}
+
+// shardOfPK 主键（或前缀）所在的分片，前缀不包含分片字段时 ok=false
+func (t *shardTable) shardOfPK(pk PK) (int, bool, error) {
+	filter, _ := pk.FetchFilter().(map[string]interface{})
+	value, ok := filter[t.src.ShardField]
+	if !ok {
+		return 0, false, nil
+	}
+	i, err := t.shardOf(value)
+	return i, err == nil, err
+}
+
+// route 条件可能匹配的分片，nil 表示所有分片
+func (t *shardTable) route(c Cond) []int {
+	var values []interface{}
+	switch c.Op {
+	case OpEq:
+		if c.Field != t.src.ShardField {
+			return nil
+		}
+		values = []interface{}{c.Value}
+	case OpIn:
+		if c.Field != t.src.ShardField {
+			return nil
+		}
+		values = c.Values
+	case OpAnd:
+		// 任意一个子条件的分片都包含全部结果，取最少的
+		var ret []int
+		for _, sub := range c.Conds {
+			if shards := t.route(sub); shards != nil && (ret == nil || len(shards) < len(ret)) {
+				ret = shards
+			}
+		}
+		return ret
+	case OpOr:
+		set := map[int]bool{}
+		for _, sub := range c.Conds {
+			shards := t.route(sub)
+			if shards == nil {
+				return nil
+			}
+			for _, i := range shards {
+				set[i] = true
+			}
+		}
+		return sortedShards(set)
+	default:
+		return nil
+	}
+
+	// 不属于任何分片的值不会匹配到记录
+	set := map[int]bool{}
+	for _, v := range values {
+		if i, err := t.shardOf(v); err == nil {
+			set[i] = true
+		}
+	}
+	return sortedShards(set)
+}
+
+func sortedShards(set map[int]bool) []int {
+	ret := make([]int, 0, len(set))
+	for i := range set {
+		ret = append(ret, i)
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+// Recover 按分片分组恢复，某个分片失败时仍然恢复其他分片，返回第一个错误
+func (t *shardTable) Recover(ctx context.Context, commitments []Commitment) error {
+	var ret error
+	groups, _ := t.group(commitments)
+	for i, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		if err := t.tables[i].Recover(ctx, group); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
+
+// Save 按分片分组写入，无法路由的提交为永久错误，写入死信
+// 某个分片被中断时仍然写入其他分片，所有分片都写完才返回 true
+func (t *shardTable) Save(ctx context.Context, commitments []Commitment, writeTimeout time.Duration, retryInterval time.Duration, running func() bool) bool {
+	groups, unrouted := t.group(commitments)
+	for _, c := range unrouted {
+		_, _, err := t.shardOfPK(PKOf(c))
+		if err == nil {
+			// 主键不包含分片字段
+			err = errors.Errorf("no shard for pk %s [%s]", PKOf(c), t.src.Namespace)
+		}
+		SaveOne(ctx, c, writeTimeout, retryInterval, running, func(ctx context.Context) error {
+			return Permanent(err)
+		})
+	}
+
+	ok := true
+	for i, group := range groups {
+		if len(group) > 0 && !t.tables[i].Save(ctx, group, writeTimeout, retryInterval, running) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// group 按分片分组，保持提交的顺序
+func (t *shardTable) group(commitments []Commitment) ([][]Commitment, []Commitment) {
+	groups := make([][]Commitment, len(t.tables))
+	var unrouted []Commitment
+	for _, c := range commitments {
+		i, ok, _ := t.shardOfPK(PKOf(c))
+		if !ok {
+			unrouted = append(unrouted, c)
+			continue
+		}
+		groups[i] = append(groups[i], c)
+	}
+	return groups, unrouted
+}
+
+// shardHistory 分片的历史表，每个分片的历史与物理表在同一个 DAO，按记录的主键路由
+type shardHistory struct {
+	table  *shardTable
+	tables []HistoryTable
+}
+
+// of 主键所在分片的历史表
+func (h *shardHistory) of(pk PK) (HistoryTable, error) {
+	i, ok, err := h.table.shardOfPK(pk)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.Errorf("missing shard field %s in %s", h.table.src.ShardField, pk)
+	}
+	return h.tables[i], nil
+}
+
+// Append 按提交所在的分片分组追加，某个分片失败时仍然追加其他分片，返回第一个错误
+func (h *shardHistory) Append(ctx context.Context, rows []*HistoryRow) error {
+	groups := make([][]*HistoryRow, len(h.tables))
+	for _, row := range rows {
+		i, ok, _ := h.table.shardOfPK(PKOf(row.entry))
+		if ok {
+			groups[i] = append(groups[i], row)
+		}
+	}
+
+	var ret error
+	for i, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		if err := h.tables[i].Append(ctx, group); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
+
+// List 只有主键的字符串时无法路由，依次在各个分片中查找，ListHistory 按主键直接读取所在的分片
+func (h *shardHistory) List(ctx context.Context, key string, maxVersion int64, limit int) ([]*HistoryRow, error) {
+	for _, table := range h.tables {
+		rows, err := table.List(ctx, key, maxVersion, limit)
+		if err != nil || len(rows) > 0 {
+			return rows, err
+		}
+	}
+	return nil, nil
+}
+
+func (t *shardTable) Fetch(ctx context.Context, onlyOne bool, pk PK) (RecordCursor, error) {
+	i, ok, err := t.shardOfPK(pk)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.Errorf("missing shard field %s in %s", t.src.ShardField, pk)
+	}
+	return t.tables[i].Fetch(ctx, onlyOne, pk)
+}
+
+func (t *shardTable) FetchMulti(ctx context.Context, pks []PK) (RecordCursor, error) {
+	groups := make([][]PK, len(t.tables))
+	for _, pk := range pks {
+		i, ok, err := t.shardOfPK(pk)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errors.Errorf("missing shard field %s in %s", t.src.ShardField, pk)
+		}
+		groups[i] = append(groups[i], pk)
+	}
+
+	var cursors []RecordCursor
+	for i, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		curr, err := t.tables[i].FetchMulti(ctx, group)
+		if err != nil {
+			closeCursors(ctx, cursors)
+			return nil, err
+		}
+		cursors = append(cursors, curr)
+	}
+	return &concatCursor{cursors: cursors}, nil
+}
+
+// targets 查询所在的分片，不能确定分片的查询和驱动原生的查询条件在所有分片上执行
+func (t *shardTable) targets(filter interface{}) (interface{}, []int) {
+	var shards []int
+	if q, err := FilterQuery(filter); err == nil {
+		filter, shards = q, t.route(q.Filter)
+	}
+	if shards == nil {
+		shards = make([]int, len(t.tables))
+		for i := range shards {
+			shards[i] = i
+		}
+	}
+	return filter, shards
+}
+
+func (t *shardTable) Find(ctx context.Context, filter interface{}) (RecordCursor, error) {
+	f, shards := t.targets(filter)
+	if len(shards) == 1 {
+		return t.tables[shards[0]].Find(ctx, f)
+	}
+
+	// 有排序或分页时，每个分片取前 Skip+Size 条，合并后再排序分页
+	q, ok := f.(*Query)
+	merge := ok && (len(q.Sorts) > 0 || q.Size > 0 || q.Skip > 0)
+	if merge {
+		sub := &Query{Filter: q.Filter, Sorts: q.Sorts}
+		if q.Size > 0 {
+			sub.Size = q.Skip + q.Size
+		}
+		f = sub
+	}
+
+	cursors := make([]RecordCursor, 0, len(shards))
+	for _, i := range shards {
+		curr, err := t.tables[i].Find(ctx, f)
+		if err != nil {
+			closeCursors(ctx, cursors)
+			return nil, err
+		}
+		cursors = append(cursors, curr)
+	}
+
+	if !merge {
+		return &concatCursor{cursors: cursors}, nil
+	}
+	return t.mergeSorted(ctx, q, cursors)
+}
+
+func (t *shardTable) Count(ctx context.Context, filter interface{}) (int64, error) {
+	f, shards := t.targets(filter)
+	var total int64
+	for _, i := range shards {
+		n, err := t.tables[i].Count(ctx, f)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// mergeSorted 解码各分片的结果，按查询的排序（相同时按主键）合并后分页
+func (t *shardTable) mergeSorted(ctx context.Context, q *Query, cursors []RecordCursor) (RecordCursor, error) {
+	defer closeCursors(ctx, cursors)
+
+	var rows []Record
+	for _, curr := range cursors {
+		for curr.Next(ctx) {
+			r := reflect.New(t.src.RecordType).Interface()
+			if err := curr.Decode(r); err != nil {
+				return nil, err
+			}
+			rows = append(rows, r.(Record))
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if c := q.Compare(rows[i].Snapshoot(), rows[j].Snapshoot()); c != 0 {
+			return c < 0
+		}
+		if t.src.PKComparator != nil {
+			return t.src.PKComparator(t.src.PKOf(rows[i]), t.src.PKOf(rows[j])) < 0
+		}
+		return false
+	})
+
+	if q.Skip >= len(rows) {
+		rows = nil
+	} else {
+		rows = rows[q.Skip:]
+	}
+	if q.Size > 0 && q.Size < len(rows) {
+		rows = rows[:q.Size]
+	}
+	return &recordsCursor{src: t.src, rows: rows}, nil
+}
+
+func closeCursors(ctx context.Context, cursors []RecordCursor) {
+	for _, curr := range cursors {
+		_ = curr.Close(ctx)
+	}
+}
+
+// concatCursor 依次遍历多个游标
+type concatCursor struct {
+	cursors []RecordCursor
+	curr    RecordCursor
+}
+
+func (c *concatCursor) Next(ctx context.Context) bool {
+	for len(c.cursors) > 0 {
+		if c.cursors[0].Next(ctx) {
+			c.curr = c.cursors[0]
+			return true
+		}
+		_ = c.cursors[0].Close(ctx)
+		c.cursors = c.cursors[1:]
+	}
+	c.curr = nil
+	return false
+}
+
+func (c *concatCursor) Decode(val interface{}) error {
+	if c.curr == nil {
+		return errors.New("no current row")
+	}
+	return c.curr.Decode(val)
+}
+
+func (c *concatCursor) All(ctx context.Context, results interface{}) error {
+	return allRecords(ctx, c, results)
+}
+
+func (c *concatCursor) Close(ctx context.Context) error {
+	closeCursors(ctx, c.cursors)
+	c.cursors, c.curr = nil, nil
+	return nil
+}
+
+// recordsCursor 遍历已解码的记录，解码时复制记录中的 proto
+type recordsCursor struct {
+	src  *Source
+	rows []Record
+	curr Record
+}
+
+func (c *recordsCursor) Next(ctx context.Context) bool {
+	if len(c.rows) == 0 {
+		c.curr = nil
+		return false
+	}
+	c.curr, c.rows = c.rows[0], c.rows[1:]
+	return true
+}
+
+func (c *recordsCursor) Decode(val interface{}) error {
+	if c.curr == nil {
+		return errors.New("no current row")
+	}
+
+	target := embeddedProto(reflect.ValueOf(val), c.src.ProtoType)
+	if !target.IsValid() {
+		return errors.Errorf("failed to find proto %s in %T", c.src.ProtoType, val)
+	}
+	if m, ok := target.Addr().Interface().(proto.Message); ok {
+		proto.Reset(m)
+		proto.Merge(m, c.curr.Snapshoot().(proto.Message))
+	} else {
+		target.Set(reflect.ValueOf(c.curr.Snapshoot()).Elem())
+	}
+	return nil
+}
+
+func (c *recordsCursor) All(ctx context.Context, results interface{}) error {
+	return allRecords(ctx, c, results)
+}
+
+func (c *recordsCursor) Close(ctx context.Context) error {
+	c.rows, c.curr = nil, nil
+	return nil
+}
+
+// allRecords 解码游标中剩余的所有记录，results 为指向切片的指针，元素为指针类型
+func allRecords(ctx context.Context, curr RecordCursor, results interface{}) error {
+	rv := reflect.ValueOf(results)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice || rv.Elem().Type().Elem().Kind() != reflect.Ptr {
+		return errors.Errorf("results must be a pointer to a slice of pointers: %T", results)
+	}
+
+	slice := rv.Elem()
+	elemType := slice.Type().Elem().Elem()
+	for curr.Next(ctx) {
+		elem := reflect.New(elemType)
+		if err := curr.Decode(elem.Interface()); err != nil {
+			return err
+		}
+		slice = reflect.Append(slice, elem)
+	}
+	rv.Elem().Set(slice)
+	return nil
+}
+
+// embeddedProto 查找 v 中（包括匿名嵌入的字段）类型为 protoType 的结构体
+func embeddedProto(v reflect.Value, protoType reflect.Type) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	if v.Type() == protoType {
+		return v
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Type().Field(i).Anonymous {
+			continue
+		}
+		if found := embeddedProto(v.Field(i), protoType); found.IsValid() {
+			return found
+		}
+	}
+	return reflect.Value{}
+}
+
+// shardOptions 把分片的数据源替换为各个分片，用于按 DAO 迁移物理表
+func shardOptions(options map[*Source]*TableOptions, shards map[*Source][]*Shard) map[*Source]*TableOptions {
+	if len(shards) == 0 {
+		return options
+	}
+
+	ret := make(map[*Source]*TableOptions, len(options))
+	for src, opts := range options {
+		if len(shards[src]) == 0 {
+			ret[src] = opts
+			continue
+		}
+		for _, shard := range shards[src] {
+			shardOpts := *opts
+			shardOpts.DaoKey = shard.DaoKey
+			ret[shard.Source] = &shardOpts
+		}
+	}
+	return ret
+}
@@ -0,0 +1,63 @@
+package xdb_test
+
+import (
+	"testing"
+
+	"lucky/server/gen/db"
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/memory"
+)
+
+func TestShard_Route(t *testing.T) {
+	c := &memory.Configurator{Shards: map[string]*xdb.ShardOptions{"item": {Count: 4}}}
+	ctx := setupMemory(t, c)
+
+	for _, id := range []int64{1, 2, 5, 6} {
+		item, err := xdb.Create[*db.ItemRecord](ctx, &db.Item{PlayerId: id, ItemId: 1, Count: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		xdb.Save(ctx, item)
+	}
+
+	xdb.Stop(ctx)
+	if err := xdb.Setup(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	// 按 player_id 取模路由到物理表
+	if rows := memory.Rows("item_01"); len(rows) != 2 || rows[0].(*db.Item).PlayerId != 1 || rows[1].(*db.Item).PlayerId != 5 {
+		t.Errorf("unexpected item_01 rows: %v", rows)
+	}
+	if rows := memory.Rows("item_02"); len(rows) != 2 {
+		t.Errorf("unexpected item_02 rows: %v", rows)
+	}
+
+	item, err := xdb.Get[*db.ItemRecord](ctx, int64(6), int32(1))
+	if err != nil || item == nil || item.Count != 6 {
+		t.Fatalf("unexpected get: %v %v", item, err)
+	}
+
+	multi, err := xdb.GetMulti[*db.ItemRecord](ctx, db.NewItemPK(5, 1), db.NewItemPK(2, 1))
+	if err != nil || multi[0] == nil || multi[0].Count != 5 || multi[1] == nil || multi[1].Count != 2 {
+		t.Fatalf("unexpected get multi: %v %v", multi, err)
+	}
+
+	// 不带分片字段的查询在所有分片上执行，合并后排序分页
+	items, err := xdb.Find[*db.ItemRecord](ctx, xdb.NewQuery(xdb.Gte("count", 2)).OrderByDesc("count").Offset(1).Limit(2))
+	if err != nil || len(items) != 2 || items[0].PlayerId != 5 || items[1].PlayerId != 2 {
+		t.Fatalf("unexpected find: %v %v", items, err)
+	}
+
+	n, err := xdb.Count[*db.ItemRecord](ctx, xdb.NewQuery(xdb.In("player_id", int64(1), int64(2))))
+	if err != nil || n != 2 {
+		t.Fatalf("unexpected count: %d %v", n, err)
+	}
+}
+
+func TestShard_RejectUnique(t *testing.T) {
+	// uk_name 只在各自的物理表内唯一，有全局唯一键的数据源不能分片
+	if _, err := (&db.PlayerRecord{}).Source().Shards(&xdb.ShardOptions{Count: 2}, nil); err == nil {
+		t.Error("expected sharding with unique index rejected")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"reflect"
 	"sync"
+
+	"github.com/pkg/errors"
 )
 
 var typeSrcMap = make(map[reflect.Type]*Source)
@@ -163,6 +165,88 @@ type FieldDesc struct {
 	Key  bool
 }
 
+// IndexDesc 索引描述
+type IndexDesc struct {
+	Name    string
+	Columns []string // 列名，即 proto 字段名
+	Unique  bool
+}
+
+// KeyColumn 主键列
+type KeyColumn struct {
+	Name  string // 列名，即 proto 字段名
+	Field string // PK 结构体中的字段名
+	index int
+}
+
+// KeyColumns 由 Fields 中标记为 Key 的字段推导主键列，顺序与字段定义一致
+// 没有字段描述时使用 PK 结构体的导出字段，列名按 proto 标签对应；任一列无法对应到 PK 结构体字段时返回错误
+func (src *Source) KeyColumns() ([]*KeyColumn, error) {
+	if src.PKType == nil || src.PKType.Kind() != reflect.Struct {
+		return nil, errors.Errorf("invalid pk type of %s: %v", src.Namespace, src.PKType)
+	}
+
+	// proto 字段名 <-> Go 字段名
+	goNames, protoNames := map[string]string{}, map[string]string{}
+	if src.ProtoType != nil {
+		for i := 0; i < src.ProtoType.NumField(); i++ {
+			f := src.ProtoType.Field(i)
+			if name := protoFieldName(f); name != "" {
+				goNames[name], protoNames[f.Name] = f.Name, name
+			}
+		}
+	}
+
+	var names []string
+	for _, field := range src.Fields {
+		if field.Key {
+			names = append(names, field.Name)
+		}
+	}
+	if len(src.Fields) == 0 {
+		for i := 0; i < src.PKType.NumField(); i++ {
+			if f := src.PKType.Field(i); f.IsExported() {
+				names = append(names, protoNames[f.Name])
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.Errorf("no key field in %s", src.Namespace)
+	}
+
+	columns := make([]*KeyColumn, 0, len(names))
+	for _, name := range names {
+		goName, ok := goNames[name]
+		if !ok {
+			return nil, errors.Errorf("key field %s not found in proto of %s", name, src.Namespace)
+		}
+		f, ok := src.PKType.FieldByName(goName)
+		if !ok || !f.IsExported() || len(f.Index) != 1 {
+			return nil, errors.Errorf("key field %s not found in pk of %s", name, src.Namespace)
+		}
+		columns = append(columns, &KeyColumn{Name: name, Field: goName, index: f.Index[0]})
+	}
+
+	if src.KeySize > 0 && len(columns) != src.KeySize {
+		return nil, errors.Errorf("key size of %s mismatch: %d against to %d fields", src.Namespace, src.KeySize, len(columns))
+	}
+	return columns, nil
+}
+
+// KeyValues 按主键列取 PK 的值
+func KeyValues(pk PK, columns []*KeyColumn) []interface{} {
+	v := reflect.ValueOf(pk)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		values[i] = v.Field(column.index).Interface()
+	}
+	return values
+}
+
 // PK 主键接口
 type PK interface {
 	SourceInterface
@@ -191,7 +275,7 @@ type Source struct {
 	TableName        string
 	KeySize          int
 	PKComparator     func(interface{}, interface{}) int
-	PKCreator        func([]interface{}) (PK, error)
+	PKCreator        func([]interface{}) (PK, error) // 根据主键字段的值创建主键，通过 CreatePK 调用
 	PKOf             func(interface{}) PK
 	Part             func(interface{}, FieldSet) (interface{}, FieldSet)
 	TicketExpected   func(message interface{}) bool
@@ -200,11 +284,19 @@ type Source struct {
 	Options          interface{}
 	Namespace        string
 	Replica          bool // 同一个数据源的对象在其他服务中修改，需要自动通知
+	Versioned        bool // 是否启用基于 _version 的乐观锁
+	OnConflict       ConflictHandler
 	FieldSetSave     FieldSet
+	FieldSetCritical FieldSet // (xdb.critical) 字段，修改它们的提交立即入库
 	Fields           []*FieldDesc
+	Indexes          []*IndexDesc // 主键以外的索引，迁移表结构时创建
+	Layout           Layout       // 子消息字段的存储布局
+	ShardField       string       // (xdb.shard_hint) 字段的列名，表选项配置分片时按它路由
+	History          bool         // (xdb.history) 每个提交追加一行历史，可以列出和恢复历史版本
 	table            Table
 	repo             *Repo
 	saver            *Saver
+	history          HistoryTable
 }
 
 // GetLegalType 获取合法类型
@@ -220,9 +312,18 @@ func (src *Source) Init(opts *TableOptions, table Table, dryRun bool) {
 	src.table = table
 	if !dryRun {
 		src.saver = NewSaver(src, opts.Concurrence, opts.SaveTimeout, opts.SyncInterval)
+		src.saver.CriticalSync = opts.CriticalSync
 	}
 }
 
+// Critical 提交是否涉及关键字段，有关键字段的数据源新建和删除记录也视为涉及
+func (src *Source) Critical(lif Lifecycle, changes FieldSet) bool {
+	if src.FieldSetCritical == FieldSetEmpty {
+		return false
+	}
+	return lif != LifecycleNormal || changes.Intersect(src.FieldSetCritical) != FieldSetEmpty
+}
+
 // Recover 恢复数据
 func (src *Source) Recover(ctx context.Context, wg *sync.WaitGroup) {
 	if src.saver != nil {
@@ -235,6 +336,29 @@ func (src *Source) RunSavers(ctx context.Context, wg *sync.WaitGroup) {
 	if src.saver != nil {
 		src.saver.Run(ctx, wg)
 	}
+
+	if src.repo != nil {
+		src.repo.Run(ctx, wg, EvictHooks{
+			Evictable: src.evictable,
+			Unload:    src.unloadOnEvict,
+		})
+	}
+}
+
+// evictable 有提交尚未入库的对象不逐出，否则逐出后重新加载会读到旧数据
+// 逐出协程不访问模型本身，模型只属于所在的 actor
+func (src *Source) evictable(key Key, obj any) bool {
+	pk, ok := key.(PK)
+	return !ok || src.saver == nil || !src.saver.Pending(pk)
+}
+
+// unloadOnEvict 模型被逐出后移除唯一键缓存
+// 逐出协程不修改模型：Repo 中还没有重新加载时，持有者之后的 Save 会把它放回 Repo；已经重新加载时 Save 返回 ErrStale
+func (src *Source) unloadOnEvict(ctx context.Context, key Key, obj any) {
+	src.repo.DeleteUniques(key)
+	if u, ok := obj.(Unloader); ok {
+		u.OnUnload(ctx)
+	}
 }
 
 // Table 获取表接口
@@ -242,6 +366,11 @@ func (src *Source) Table() Table {
 	return src.table
 }
 
+// Repo 获取缓存仓库
+func (src *Source) Repo() *Repo {
+	return src.repo
+}
+
 // NoStorage 检查是否无存储
 func (src *Source) NoStorage() bool {
 	var table NoStorageTable
@@ -51,7 +51,9 @@ type Table interface {
 	Save(ctx context.Context, commitments []Commitment, writeTimeout time.Duration, retryInterval time.Duration, running func() bool) bool
 	Fetch(ctx context.Context, onlyOne bool, pk PK) (RecordCursor, error)
 	FetchMulti(ctx context.Context, pks []PK) (RecordCursor, error)
+	// Find filter 为 *Query 时由驱动翻译，其他类型按驱动原生的查询条件处理
 	Find(ctx context.Context, filter interface{}) (RecordCursor, error)
+	Count(ctx context.Context, filter interface{}) (int64, error)
 }
 
 // noStorage 无存储驱动
@@ -100,6 +102,10 @@ func (t NoStorageTable) Find(context.Context, interface{}) (RecordCursor, error)
 	return NoStorageRecordCursor{}, nil
 }
 
+func (t NoStorageTable) Count(context.Context, interface{}) (int64, error) {
+	return 0, nil
+}
+
 // NoStorageRecordCursor 无存储记录游标
 type NoStorageRecordCursor struct {
 }
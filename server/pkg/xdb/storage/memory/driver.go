@@ -0,0 +1,233 @@
+package memory
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"lucky/server/pkg/xdb"
+)
+
+// DriverOptions 内存驱动选项
+type DriverOptions struct {
+}
+
+// DaoOptions 内存 DAO 选项
+type DaoOptions struct {
+}
+
+var driver = &Driver{tables: map[string]*Table{}, histories: map[string]*History{}}
+
+func init() {
+	xdb.RegisterDriver(driver)
+}
+
+// Driver 内存驱动，数据按表名保存在进程内，xdb 重新 Setup 后仍然可以读到，用于测试
+type Driver struct {
+	mu        sync.Mutex
+	tables    map[string]*Table
+	histories map[string]*History
+}
+
+func (d *Driver) Name() string {
+	return "memory"
+}
+
+func (d *Driver) Init(ctx context.Context, config interface{}) error {
+	return nil
+}
+
+func (d *Driver) Validate(src *xdb.Source) error {
+	if src.DriverName != d.Name() {
+		return errors.Errorf("driver name not match: %s against to %s in %s", src.DriverName, d.Name(), src.Namespace)
+	}
+	return nil
+}
+
+func (d *Driver) NewDao(ctx context.Context, option interface{}) (xdb.Dao, error) {
+	if _, ok := option.(*DaoOptions); !ok {
+		return nil, errors.Errorf("invalid %s options", d.Name())
+	}
+	return &Dao{}, nil
+}
+
+func (d *Driver) ExtendType(base reflect.Type, extension reflect.Type) {
+}
+
+func (d *Driver) table(src *xdb.Source) *Table {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name := tableName(src)
+	t, ok := d.tables[name]
+	if !ok {
+		t = &Table{src: src, columns: src.Columns(), rows: map[string]*row{}}
+		d.tables[name] = t
+	}
+	return t
+}
+
+// tableName 数据源的表名，分片的数据源为物理表名，没有表名时使用命名空间
+func tableName(src *xdb.Source) string {
+	if src.TableName != "" {
+		return src.TableName
+	}
+	return src.Namespace
+}
+
+func (d *Driver) lookup(table string) *Table {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.tables[table]
+}
+
+// Dao 内存数据访问对象
+type Dao struct{}
+
+func (d *Dao) Table(src *xdb.Source) xdb.Table {
+	return driver.table(src)
+}
+
+// Use 将数据源切换为内存驱动，不指定命名空间时切换所有已注册的数据源，需要在 xdb.Setup 之前调用
+func Use(nss ...string) {
+	if len(nss) == 0 {
+		for _, src := range xdb.Sources() {
+			src.DriverName = driver.Name()
+		}
+		return
+	}
+
+	for _, ns := range nss {
+		if src := xdb.GetSourceByNS(ns); src != nil {
+			src.DriverName = driver.Name()
+		}
+	}
+}
+
+// Reset 清空所有数据和计数
+func Reset() {
+	driver.mu.Lock()
+	defer driver.mu.Unlock()
+
+	for _, t := range driver.tables {
+		t.mu.Lock()
+		t.rows = map[string]*row{}
+		t.saves = 0
+		t.mu.Unlock()
+	}
+	for _, h := range driver.histories {
+		h.mu.Lock()
+		h.rows = nil
+		h.mu.Unlock()
+	}
+}
+
+// Rows 表中保存的所有记录（proto 的副本），按主键排序，分片的数据源按物理表名读取
+func Rows(table string) []interface{} {
+	t := driver.lookup(table)
+	if t == nil {
+		return nil
+	}
+
+	rows := t.match(xdb.NewQuery())
+	ret := make([]interface{}, len(rows))
+	for i, r := range rows {
+		ret[i] = r.data
+	}
+	return ret
+}
+
+// Lookup 按主键读取保存的记录（proto 的副本），不支持分片的数据源
+func Lookup(pk xdb.PK) (interface{}, bool) {
+	t := driver.lookup(tableName(pk.Source()))
+	if t == nil {
+		return nil, false
+	}
+
+	rows := t.get(pk)
+	if len(rows) == 0 {
+		return nil, false
+	}
+	return rows[0].data, true
+}
+
+// Saves 表已写入的提交数
+func Saves(table string) int64 {
+	t := driver.lookup(table)
+	if t == nil {
+		return 0
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.saves
+}
+
+// HistoryRows 历史表中的行数，分片的数据源每个分片一个历史表（如 item_01_history）
+func HistoryRows(table string) int {
+	driver.mu.Lock()
+	h := driver.histories[table]
+	driver.mu.Unlock()
+	if h == nil {
+		return 0
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.rows)
+}
+
+// Tables 有数据的表名
+func Tables() []string {
+	driver.mu.Lock()
+	defer driver.mu.Unlock()
+
+	nss := make([]string, 0, len(driver.tables))
+	for ns := range driver.tables {
+		nss = append(nss, ns)
+	}
+	sort.Strings(nss)
+	return nss
+}
+
+// Configurator 所有数据源使用同一个内存 DAO 的配置
+type Configurator struct {
+	SyncInterval time.Duration                // 保存队列刷新间隔，默认 10ms
+	Shards       map[string]*xdb.ShardOptions // 按命名空间配置分片
+	CriticalSync bool                         // 修改关键字段的提交同步入库
+	Redo         *xdb.RedoOptions             // 重做日志选项，为空时不开启
+}
+
+func (c *Configurator) RedoOptions() *xdb.RedoOptions {
+	return c.Redo
+}
+
+func (c *Configurator) DriverOptions(driver string) interface{} {
+	return &DriverOptions{}
+}
+
+func (c *Configurator) DaoOptions(daoKey interface{}) interface{} {
+	return &DaoOptions{}
+}
+
+func (c *Configurator) TableOptions(driver string, table string) *xdb.TableOptions {
+	interval := c.SyncInterval
+	if interval <= 0 {
+		interval = 10 * time.Millisecond
+	}
+	return &xdb.TableOptions{
+		DaoKey:       "memory",
+		Concurrence:  1,
+		SaveTimeout:  time.Second,
+		SyncInterval: interval,
+		Shard:        c.Shards[table],
+		CriticalSync: c.CriticalSync,
+	}
+}
+
+func (c *Configurator) DryRun() bool {
+	return false
+}
@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"lucky/server/pkg/xdb"
+)
+
+// History 内存历史表，按追加顺序保存
+type History struct {
+	mu   sync.RWMutex
+	rows []*xdb.HistoryRow
+}
+
+func (d *Dao) History(ctx context.Context, src *xdb.Source) (xdb.HistoryTable, error) {
+	return driver.history(src), nil
+}
+
+func (d *Driver) history(src *xdb.Source) *History {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name := xdb.HistoryTableName(src)
+	h, ok := d.histories[name]
+	if !ok {
+		h = &History{}
+		d.histories[name] = h
+	}
+	return h
+}
+
+func (h *History) Append(ctx context.Context, rows []*xdb.HistoryRow) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, r := range rows {
+		saved := *r
+		saved.Snapshot = nil
+		h.rows = append(h.rows, &saved)
+	}
+	return nil
+}
+
+func (h *History) List(ctx context.Context, key string, maxVersion int64, limit int) ([]*xdb.HistoryRow, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var ret []*xdb.HistoryRow
+	for i := len(h.rows) - 1; i >= 0 && (limit <= 0 || len(ret) < limit); i-- {
+		r := h.rows[i]
+		if r.Key != key || maxVersion > 0 && r.Version > maxVersion {
+			continue
+		}
+		copied := *r
+		ret = append(ret, &copied)
+	}
+	return ret, nil
+}
@@ -0,0 +1,352 @@
+package memory
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+
+	"lucky/server/pkg/xdb"
+)
+
+// row 保存的记录
+type row struct {
+	data    interface{} // proto 的副本
+	version int64
+}
+
+// Table 内存表，按主键保存已提交的 proto
+type Table struct {
+	src     *xdb.Source
+	columns []*xdb.Column // 唯一索引按列取值
+	mu      sync.RWMutex
+	rows    map[string]*row
+	saves   int64
+}
+
+func (t *Table) Recover(ctx context.Context, commitments []xdb.Commitment) error {
+	// 写入被中断时返回错误，分段文件保留到下次恢复
+	if !t.Save(ctx, commitments, time.Second, xdb.RetryInterval, func() bool { return ctx.Err() == nil }) {
+		return errors.Errorf("redo recover of [%s] interrupted", t.src.Namespace)
+	}
+	return nil
+}
+
+func (t *Table) Save(ctx context.Context, commitments []xdb.Commitment, writeTimeout time.Duration, retryInterval time.Duration, running func() bool) bool {
+	for _, commitment := range commitments {
+		c := commitment
+		ok := xdb.SaveOne(ctx, c, writeTimeout, retryInterval, running, func(ctx context.Context) error {
+			for attempt := 1; ; attempt++ {
+				conflict, err := t.saveOne(c)
+				if err != nil {
+					return err
+				}
+
+				// 版本冲突时由数据源的冲突回调决定是否以最新版本重试
+				if !conflict || !xdb.ResolveConflict(ctx, c, attempt) {
+					return nil
+				}
+			}
+		})
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// saveOne 按生命周期写入单个提交，语义与 SQL 驱动一致：
+// 新建已存在的记录为永久错误，启用乐观锁时版本不一致或更新不存在的记录视为冲突
+func (t *Table) saveOne(c xdb.Commitment) (bool, error) {
+	data, _ := c.PrepareWrite()
+	key := xdb.PKOf(c).String()
+	base, version, versioned := xdb.VersionOf(c)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r, exists := t.rows[key]
+	switch c.Lifecycle() {
+	case xdb.LifecycleNew:
+		if exists {
+			return false, xdb.Permanent(errors.Wrapf(xdb.ErrDup, "duplicated [%s]: %s", t.src.Namespace, key))
+		}
+		if err := t.checkUniques(key, data); err != nil {
+			return false, err
+		}
+		t.rows[key] = &row{data: clone(data), version: version}
+
+	case xdb.LifecycleNormal:
+		if !exists || (versioned && r.version != base) {
+			return versioned, nil
+		}
+		if err := t.checkUniques(key, data); err != nil {
+			return false, err
+		}
+		r.data = clone(data)
+		r.version = version
+
+	case xdb.LifecycleDeleted:
+		if !exists {
+			return false, nil
+		}
+		if versioned && r.version != base {
+			return true, nil
+		}
+		delete(t.rows, key)
+
+	default:
+		return false, nil
+	}
+
+	t.saves++
+	return false, nil
+}
+
+// checkUniques 检查唯一索引是否已被其他记录占用，调用方持有写锁
+func (t *Table) checkUniques(key string, data interface{}) error {
+	for _, index := range t.src.Indexes {
+		if !index.Unique {
+			continue
+		}
+
+		var conds []xdb.Cond
+		var values []interface{}
+		for _, name := range index.Columns {
+			column := t.column(name)
+			if column == nil {
+				return xdb.Permanent(errors.Errorf("unknown column %s in index %s [%s]", name, index.Name, t.src.Namespace))
+			}
+			v, err := column.Value(reflect.ValueOf(data))
+			if err != nil {
+				return xdb.Permanent(err)
+			}
+			conds = append(conds, xdb.Eq(name, v))
+			values = append(values, v)
+		}
+
+		cond := xdb.And(conds...)
+		for other, r := range t.rows {
+			if other != key && cond.Match(r.data) {
+				dup := &xdb.DupError{Namespace: t.src.Namespace, Index: index.Name, Value: values}
+				if len(values) == 1 {
+					dup.Value = values[0]
+				}
+				return xdb.Permanent(dup)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Table) column(name string) *xdb.Column {
+	for _, column := range t.columns {
+		if column.Name == name {
+			return column
+		}
+	}
+	return nil
+}
+
+func (t *Table) Fetch(ctx context.Context, onlyOne bool, pk xdb.PK) (xdb.RecordCursor, error) {
+	return &RecordCursor{src: t.src, rows: t.get(pk)}, nil
+}
+
+func (t *Table) FetchMulti(ctx context.Context, pks []xdb.PK) (xdb.RecordCursor, error) {
+	return &RecordCursor{src: t.src, rows: t.get(pks...)}, nil
+}
+
+func (t *Table) Find(ctx context.Context, filter interface{}) (xdb.RecordCursor, error) {
+	q, err := xdb.FilterQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordCursor{src: t.src, rows: t.match(q)}, nil
+}
+
+func (t *Table) Count(ctx context.Context, filter interface{}) (int64, error) {
+	q, err := xdb.FilterQuery(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	q = &xdb.Query{Filter: q.Filter}
+	return int64(len(t.match(q))), nil
+}
+
+// get 按主键读取，返回副本
+func (t *Table) get(pks ...xdb.PK) []*row {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var rows []*row
+	for _, pk := range pks {
+		if r, ok := t.rows[pk.String()]; ok {
+			rows = append(rows, &row{data: clone(r.data), version: r.version})
+		}
+	}
+	return rows
+}
+
+// match 按条件过滤、排序和分页，没有排序条件时按主键排序，返回副本
+func (t *Table) match(q *xdb.Query) []*row {
+	t.mu.RLock()
+	keys := make([]string, 0, len(t.rows))
+	rows := make([]*row, 0, len(t.rows))
+	for key, r := range t.rows {
+		if q.Filter.Match(r.data) {
+			keys = append(keys, key)
+			rows = append(rows, &row{data: clone(r.data), version: r.version})
+		}
+	}
+	t.mu.RUnlock()
+
+	sort.Sort(&rowSorter{src: t.src, q: q, keys: keys, rows: rows})
+
+	if q.Skip > 0 {
+		if q.Skip >= len(rows) {
+			return nil
+		}
+		rows = rows[q.Skip:]
+	}
+	if q.Size > 0 && q.Size < len(rows) {
+		rows = rows[:q.Size]
+	}
+	return rows
+}
+
+type rowSorter struct {
+	src  *xdb.Source
+	q    *xdb.Query
+	keys []string
+	rows []*row
+}
+
+func (s *rowSorter) Len() int { return len(s.rows) }
+
+func (s *rowSorter) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.rows[i], s.rows[j] = s.rows[j], s.rows[i]
+}
+
+func (s *rowSorter) Less(i, j int) bool {
+	if r := s.q.Compare(s.rows[i].data, s.rows[j].data); r != 0 {
+		return r < 0
+	}
+	if s.src.PKComparator != nil && s.src.PKOf != nil {
+		return s.src.PKComparator(s.src.PKOf(s.rows[i].data), s.src.PKOf(s.rows[j].data)) < 0
+	}
+	return s.keys[i] < s.keys[j]
+}
+
+// RecordCursor 内存记录游标
+type RecordCursor struct {
+	src  *xdb.Source
+	rows []*row
+	curr *row
+}
+
+func (r *RecordCursor) Next(ctx context.Context) bool {
+	if len(r.rows) == 0 {
+		r.curr = nil
+		return false
+	}
+	r.curr, r.rows = r.rows[0], r.rows[1:]
+	return true
+}
+
+// Decode 解码到记录或模型中嵌入的 proto
+func (r *RecordCursor) Decode(val interface{}) error {
+	if r.curr == nil {
+		return errors.New("no current row")
+	}
+
+	target := findProto(reflect.ValueOf(val), reflect.TypeOf(r.curr.data).Elem())
+	if !target.IsValid() {
+		return errors.Errorf("failed to find proto %T in %T", r.curr.data, val)
+	}
+
+	if m, ok := target.Addr().Interface().(proto.Message); ok {
+		proto.Reset(m)
+		proto.Merge(m, r.curr.data.(proto.Message))
+	} else {
+		target.Set(reflect.ValueOf(r.curr.data).Elem())
+	}
+
+	if vs, ok := val.(xdb.VersionSetter); ok && r.src.Versioned {
+		vs.SetXVersion(r.curr.version)
+	}
+	return nil
+}
+
+// All 解码剩余的所有记录，results 为指向切片的指针，元素为指针类型
+func (r *RecordCursor) All(ctx context.Context, results interface{}) error {
+	rv := reflect.ValueOf(results)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice || rv.Elem().Type().Elem().Kind() != reflect.Ptr {
+		return errors.Errorf("results must be a pointer to a slice of pointers: %T", results)
+	}
+
+	slice := rv.Elem()
+	elemType := slice.Type().Elem().Elem()
+	for r.Next(ctx) {
+		elem := reflect.New(elemType)
+		if err := r.Decode(elem.Interface()); err != nil {
+			return err
+		}
+		slice = reflect.Append(slice, elem)
+	}
+	rv.Elem().Set(slice)
+	return nil
+}
+
+func (r *RecordCursor) Close(ctx context.Context) error {
+	r.rows = nil
+	r.curr = nil
+	return nil
+}
+
+// clone 复制提交中的数据，提交的数据与记录共享，不能直接保存
+func clone(data interface{}) interface{} {
+	if m, ok := data.(proto.Message); ok {
+		return proto.Clone(m)
+	}
+
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Ptr {
+		return data
+	}
+	c := reflect.New(v.Type().Elem())
+	c.Elem().Set(v.Elem())
+	return c.Interface()
+}
+
+// findProto 在对象（以及嵌入的 Record）中查找 proto 结构体
+func findProto(v reflect.Value, protoType reflect.Type) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	if v.Type() == protoType {
+		return v
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Type().Field(i).Anonymous {
+			continue
+		}
+		if found := findProto(v.Field(i), protoType); found.IsValid() {
+			return found
+		}
+	}
+	return reflect.Value{}
+}
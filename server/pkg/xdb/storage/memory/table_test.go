@@ -0,0 +1,102 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"lucky/server/gen/db"
+	"lucky/server/pkg/xdb"
+)
+
+func TestMemory_PersistAcrossSetup(t *testing.T) {
+	ctx := context.Background()
+	Use()
+	Reset()
+
+	// 保存队列只在停止时刷新，便于断言合并后的写入次数
+	if err := xdb.Setup(ctx, &Configurator{SyncInterval: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	player, err := xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: 1, Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xdb.Save(ctx, player)
+
+	for _, id := range []int32{3, 1, 2} {
+		item, err := xdb.Create[*db.ItemRecord](ctx, &db.Item{PlayerId: 1, ItemId: id, Count: int64(id)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		xdb.Save(ctx, item)
+	}
+
+	if err = player.SetName("b"); err != nil {
+		t.Fatal(err)
+	}
+	xdb.Save(ctx, player)
+
+	// 停止时保存队列全部入库，重新初始化后从内存表加载
+	xdb.Stop(ctx)
+	if err = xdb.Setup(ctx, &Configurator{}); err != nil {
+		t.Fatal(err)
+	}
+	defer xdb.Stop(ctx)
+
+	loaded, err := xdb.Get[*db.PlayerRecord](ctx, int64(1))
+	if err != nil || loaded == nil || loaded.Name != "b" || loaded.XVersion() != 2 {
+		t.Fatalf("unexpected player: %v %v", loaded, err)
+	}
+
+	// 新建和修改在保存队列中合并为一次写入
+	if n := Saves("player"); n != 1 {
+		t.Errorf("expected 1 player save, got %d", n)
+	}
+
+	rows := Rows("item")
+	if len(rows) != 3 || rows[0].(*db.Item).ItemId != 1 || rows[2].(*db.Item).ItemId != 3 {
+		t.Errorf("unexpected item rows: %v", rows)
+	}
+
+	items, err := xdb.Find[*db.ItemRecord](ctx, xdb.NewQuery(xdb.Gte("count", 2)).OrderByDesc("count"))
+	if err != nil || len(items) != 2 || items[0].ItemId != 3 {
+		t.Fatalf("unexpected find: %v %v", items, err)
+	}
+
+	multi, err := xdb.GetMulti[*db.ItemRecord](ctx, &db.ItemPK{PlayerId: 1, ItemId: 2}, &db.ItemPK{PlayerId: 1, ItemId: 9})
+	if err != nil || multi[0] == nil || multi[0].Count != 2 || multi[1] != nil {
+		t.Fatalf("unexpected get multi: %v %v", multi, err)
+	}
+}
+
+func TestMemory_UniqueRejected(t *testing.T) {
+	ctx := context.Background()
+	Use()
+	Reset()
+
+	if err := xdb.Setup(ctx, &Configurator{}); err != nil {
+		t.Fatal(err)
+	}
+	defer xdb.Stop(ctx)
+
+	player, err := xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: 1, Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = xdb.Sync(ctx, player); err != nil {
+		t.Fatal(err)
+	}
+
+	// 驱动拒绝违反唯一约束的写入
+	other := &db.PlayerRecord{}
+	_ = other.Init(ctx, &db.Player{PlayerId: 3, Name: "a"})
+	c, _ := other.Commit(ctx)
+	var dup *xdb.DupError
+	if _, err = driver.table(other.Source()).saveOne(c); !errors.As(err, &dup) || !xdb.IsPermanent(err) {
+		t.Errorf("expected duplicated name rejected: %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	clog "github.com/cherry-game/cherry/logger"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"lucky/server/pkg/xdb"
+)
+
+// batchable 批次中同一主键只出现一次时才能乱序写入，否则按顺序逐个写入
+func batchable(commitments []xdb.Commitment) bool {
+	if len(commitments) < 2 {
+		return false
+	}
+
+	seen := make(map[string]struct{}, len(commitments))
+	for _, c := range commitments {
+		key := xdb.PKOf(c).String()
+		if _, ok := seen[key]; ok {
+			return false
+		}
+		seen[key] = struct{}{}
+	}
+	return true
+}
+
+// saveBatch 以一次 ordered=false 的 BulkWrite 写入整个批次，返回需要逐个重新写入的提交：
+// 写入失败的提交（按 BulkWriteException 中的序号对应）和版本冲突的提交
+func (t *Table) saveBatch(ctx context.Context, commitments []xdb.Commitment, timeout time.Duration) ([]xdb.Commitment, error) {
+	var redo []xdb.Commitment
+	models := make([]mongo.WriteModel, 0, len(commitments))
+	owners := make([]xdb.Commitment, 0, len(commitments))
+	for _, c := range commitments {
+		model, err := t.writeModel(c)
+		if err != nil {
+			// 逐个写入时按永久错误写入死信
+			redo = append(redo, c)
+			continue
+		}
+		if model != nil {
+			models = append(models, model)
+			owners = append(owners, c)
+		}
+	}
+	if len(models) == 0 {
+		return redo, nil
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	result, err := t.executor.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	failed, err := writeErrors(err, len(owners))
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range owners {
+		if failed[i] != nil {
+			redo = append(redo, c)
+			clog.Warnf("[xdb] mongo bulk write error, retry single write. [ns = %s, pk = %s, err = %v]", t.src.Namespace, xdb.PKOf(c), failed[i])
+		}
+	}
+
+	// 匹配、新建和删除的文档数少于成功的写入数时，逐个确认启用乐观锁的写入是否冲突
+	if result != nil && result.MatchedCount+result.UpsertedCount+result.DeletedCount < int64(len(owners)-len(failed)) {
+		for i, c := range owners {
+			if failed[i] != nil {
+				continue
+			}
+			conflict, err := t.conflicted(ctx, c)
+			if err != nil {
+				return nil, err
+			}
+			if conflict {
+				redo = append(redo, c)
+			}
+		}
+	}
+	return redo, nil
+}
+
+// writeErrors 乱序写入中失败的写入，key 为写入的序号，其余写入已执行
+// 不是 BulkWriteException 或有写关注错误时整批的结果不确定，返回错误
+func writeErrors(err error, n int) (map[int]error, error) {
+	if err == nil {
+		return nil, nil
+	}
+
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || bwe.WriteConcernError != nil || len(bwe.WriteErrors) == 0 {
+		return nil, errors.Wrap(err, "failed to bulk write")
+	}
+
+	failed := make(map[int]error, len(bwe.WriteErrors))
+	for _, we := range bwe.WriteErrors {
+		if we.Index < 0 || we.Index >= n {
+			return nil, errors.Wrap(err, "failed to bulk write")
+		}
+		failed[we.Index] = we.WriteError
+	}
+	return failed, nil
+}
+
+// writeModel 提交对应的写入，新建时替换整个文档，更新只 $set 变更的字段并 $unset 已清空的键，
+// 启用乐观锁的更新和删除附加期望版本，没有需要写入的内容时返回 nil
+func (t *Table) writeModel(c xdb.Commitment) (mongo.WriteModel, error) {
+	data, _ := c.PrepareWrite()
+	filter := bson.M{"_id": t.documentID(xdb.PKOf(c))}
+	base, version, versioned := xdb.VersionOf(c)
+
+	switch c.Lifecycle() {
+	case xdb.LifecycleNew:
+		doc, err := toDocument(data)
+		if err != nil {
+			return nil, xdb.Permanent(err)
+		}
+		flatten(doc, t.flat)
+		if versioned {
+			doc[xdb.VersionColumn] = version
+		}
+		// 重做日志重放已入库的新建记录时覆盖
+		return mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(doc).SetUpsert(true), nil
+
+	case xdb.LifecycleNormal:
+		update, err := t.updateDocument(c.Changes(), data)
+		if err != nil {
+			return nil, xdb.Permanent(err)
+		}
+		if versioned {
+			set, _ := update["$set"].(bson.M)
+			if set == nil {
+				set = bson.M{}
+				update["$set"] = set
+			}
+			set[xdb.VersionColumn] = version
+			filter[xdb.VersionColumn] = base
+		}
+		if len(update) == 0 {
+			return nil, nil
+		}
+		return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update), nil
+
+	case xdb.LifecycleDeleted:
+		if versioned {
+			filter[xdb.VersionColumn] = base
+		}
+		return mongo.NewDeleteOneModel().SetFilter(filter), nil
+	}
+	return nil, nil
+}
+
+// updateDocument 变更字段的 $set 和 $unset，扁平布局下清空的子消息对应的展开键被 $unset
+func (t *Table) updateDocument(changes xdb.FieldSet, data interface{}) (bson.M, error) {
+	doc, err := toDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	flatten(doc, t.flat)
+
+	set, unset := bson.M{}, bson.M{}
+	for _, column := range t.columns {
+		if !t.changedColumn(changes, column.Field) {
+			continue
+		}
+		key := flatKey(column)
+		if v, ok := doc[key]; ok && v != nil {
+			set[key] = v
+		} else {
+			unset[key] = ""
+		}
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update, nil
+}
+
+// changedColumn 列所属的字段是否变更，主键列不会更新
+func (t *Table) changedColumn(changes xdb.FieldSet, field string) bool {
+	if len(t.changed) == 0 {
+		return true
+	}
+	code, ok := t.changed[field]
+	return ok && changes.Contains(code)
+}
+
+// conflicted 启用乐观锁的更新没有写入期望的版本，或删除后文档仍然存在时视为冲突
+func (t *Table) conflicted(ctx context.Context, c xdb.Commitment) (bool, error) {
+	_, version, versioned := xdb.VersionOf(c)
+	if !versioned {
+		return false, nil
+	}
+
+	filter := bson.M{"_id": t.documentID(xdb.PKOf(c))}
+	switch c.Lifecycle() {
+	case xdb.LifecycleNormal:
+		filter[xdb.VersionColumn] = version
+		n, err := t.executor.CountDocuments(ctx, filter)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to check version")
+		}
+		return n == 0, nil
+
+	case xdb.LifecycleDeleted:
+		n, err := t.executor.CountDocuments(ctx, filter)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to check version")
+		}
+		return n > 0, nil
+	}
+	return false, nil
+}
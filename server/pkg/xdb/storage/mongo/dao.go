@@ -1,11 +1,18 @@
 package mongo
 
 import (
+	"context"
+	"time"
+
+	clog "github.com/cherry-game/cherry/logger"
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"lucky/server/pkg/xdb"
 )
 
+// provisionTimeout 创建集合索引的超时时间
+const provisionTimeout = 10 * time.Second
+
 // Dao MongoDB 数据访问对象
 type Dao struct {
 	client          *mongo.Client
@@ -63,9 +70,42 @@ func (db *Database) Collection(tableName string) *mongo.Collection {
 	return coll
 }
 
+// Table 集合对应的表，第一次使用时补齐数据源声明的索引
 func (db *Database) Table(src *xdb.Source) *Table {
-	return &Table{
+	table := db.table(src)
+	if table.provisioned {
+		return table
+	}
+	table.provisioned = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), provisionTimeout)
+	defer cancel()
+	if err := table.provision(ctx); err != nil {
+		clog.Warnf("[xdb] failed to create mongo indexes. [ns = %s, err = %v]", src.Namespace, err)
+	}
+	return table
+}
+
+// table 集合对应的表，不创建索引
+func (db *Database) table(src *xdb.Source) *Table {
+	if table, ok := db.tableMap[src.TableName]; ok {
+		return table
+	}
+
+	keys, _ := src.KeyColumns()
+	table := &Table{
 		src:      src,
 		executor: db.Collection(src.TableName),
+		keys:     keys,
+		columns:  src.Columns(),
+		flat:     flatColumns(src),
+		changed:  make(map[string]xdb.Field, len(src.Fields)),
+	}
+	for _, field := range src.Fields {
+		if !field.Key && src.FieldSetSave.Contains(field.Code) {
+			table.changed[field.Name] = field.Code
+		}
 	}
+	db.tableMap[src.TableName] = table
+	return table
 }
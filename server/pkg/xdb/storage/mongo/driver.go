@@ -47,7 +47,22 @@ func (d *Driver) Validate(src *xdb.Source) error {
 	if src.DriverName != d.Name() {
 		return errors.Errorf("driver name not match: %s against to %s in %s", src.DriverName, d.Name(), src.Namespace)
 	}
-	// 可以添加更多验证逻辑
+	// 文档 _id 由主键列生成，主键必须能对应到文档字段
+	if _, err := src.KeyColumns(); err != nil {
+		return errors.Wrap(err, "invalid primary key")
+	}
+	// 索引在创建表时补齐，索引的列必须是入库的列
+	columns := map[string]bool{}
+	for _, column := range src.Columns() {
+		columns[column.Name] = true
+	}
+	for _, index := range indexes(src) {
+		for _, column := range index.Columns {
+			if !columns[column] {
+				return errors.Errorf("unknown column %s in index %s [%s]", column, index.Name, src.Namespace)
+			}
+		}
+	}
 	return nil
 }
 
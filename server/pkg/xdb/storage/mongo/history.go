@@ -0,0 +1,103 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"lucky/server/pkg/xdb"
+)
+
+// historyDocument 历史集合中的文档，ctime 为毫秒时间戳
+type historyDocument struct {
+	PK        string `bson:"pk"`
+	Version   int64  `bson:"version"`
+	Lifecycle int8   `bson:"lifecycle"`
+	Changes   []byte `bson:"changes"` // FieldSet.MarshalBinary 的编码
+	Diff      string `bson:"diff"`
+	Data      []byte `bson:"data"`
+	Ctime     int64  `bson:"ctime"`
+	Origin    string `bson:"origin"`
+}
+
+// History MongoDB 历史集合
+type History struct {
+	executor *mongo.Collection
+}
+
+func (d *Dao) History(ctx context.Context, src *xdb.Source) (xdb.HistoryTable, error) {
+	h := &History{executor: d.database(src.Namespace).Collection(xdb.HistoryTableName(src))}
+	model := mongo.IndexModel{
+		Keys:    bson.D{{Key: "pk", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetName("idx_pk_version"),
+	}
+	if _, err := h.executor.Indexes().CreateOne(ctx, model); err != nil {
+		return nil, errors.Wrapf(err, "failed to create index of %s", h.executor.Name())
+	}
+	return h, nil
+}
+
+func (h *History) Append(ctx context.Context, rows []*xdb.HistoryRow) error {
+	docs := make([]interface{}, len(rows))
+	for i, r := range rows {
+		changes, _ := r.Changes.MarshalBinary()
+		docs[i] = &historyDocument{
+			PK:        r.Key,
+			Version:   r.Version,
+			Lifecycle: int8(r.Lifecycle),
+			Changes:   changes,
+			Diff:      r.Diff,
+			Data:      r.Data,
+			Ctime:     r.Time.UnixMilli(),
+			Origin:    r.Origin,
+		}
+	}
+
+	if _, err := h.executor.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false)); err != nil {
+		return errors.Wrapf(err, "failed to insert into %s", h.executor.Name())
+	}
+	return nil
+}
+
+func (h *History) List(ctx context.Context, key string, maxVersion int64, limit int) ([]*xdb.HistoryRow, error) {
+	filter := bson.M{"pk": key}
+	if maxVersion > 0 {
+		filter["version"] = bson.M{"$lte": maxVersion}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "ctime", Value: -1}, {Key: "_id", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+
+	cursor, err := h.executor.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find from %s", h.executor.Name())
+	}
+	var docs []*historyDocument
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode %s", h.executor.Name())
+	}
+
+	ret := make([]*xdb.HistoryRow, len(docs))
+	for i, doc := range docs {
+		var changes xdb.FieldSet
+		if err = changes.UnmarshalBinary(doc.Changes); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode %s", h.executor.Name())
+		}
+		ret[i] = &xdb.HistoryRow{
+			Key:       doc.PK,
+			Version:   doc.Version,
+			Lifecycle: xdb.Lifecycle(doc.Lifecycle),
+			Changes:   changes,
+			Diff:      doc.Diff,
+			Data:      doc.Data,
+			Time:      time.UnixMilli(doc.Ctime),
+			Origin:    doc.Origin,
+		}
+	}
+	return ret, nil
+}
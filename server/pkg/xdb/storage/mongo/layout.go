@@ -0,0 +1,88 @@
+package mongo
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"lucky/server/pkg/xdb"
+)
+
+// flatColumns 扁平布局下展开的子消息字段，嵌套布局时子消息直接保存为子文档
+func flatColumns(src *xdb.Source) []*xdb.Column {
+	var columns []*xdb.Column
+	for _, column := range src.Columns() {
+		if len(column.GoPath) > 1 {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// docPath 列在子文档中的路径，驱动默认使用小写的 Go 字段名
+func docPath(column *xdb.Column) []string {
+	path := make([]string, len(column.GoPath))
+	for i, name := range column.GoPath {
+		path[i] = strings.ToLower(name)
+	}
+	return path
+}
+
+// flatKey 展开后的键 <键>_<子键>
+func flatKey(column *xdb.Column) string {
+	return strings.Join(docPath(column), "_")
+}
+
+// flatten 把子文档中的字段提升为展开后的键，为空的子消息不生成任何键
+func flatten(doc bson.M, columns []*xdb.Column) {
+	for _, column := range columns {
+		if v, ok := lookup(doc, docPath(column)); ok {
+			doc[flatKey(column)] = v
+		}
+	}
+	for _, column := range columns {
+		delete(doc, docPath(column)[0])
+	}
+}
+
+// unflatten 把展开后的键还原为子文档
+func unflatten(doc bson.M, columns []*xdb.Column) {
+	for _, column := range columns {
+		key := flatKey(column)
+		v, ok := doc[key]
+		if !ok {
+			continue
+		}
+		delete(doc, key)
+
+		path := docPath(column)
+		parent := doc
+		for _, name := range path[:len(path)-1] {
+			sub, ok := parent[name].(bson.M)
+			if !ok {
+				sub = bson.M{}
+				parent[name] = sub
+			}
+			parent = sub
+		}
+		parent[path[len(path)-1]] = v
+	}
+}
+
+func lookup(doc bson.M, path []string) (interface{}, bool) {
+	var v interface{} = doc
+	for _, name := range path {
+		switch d := v.(type) {
+		case bson.M:
+			v = d[name]
+		case bson.D:
+			v = d.Map()[name]
+		default:
+			return nil, false
+		}
+		if v == nil {
+			return nil, false
+		}
+	}
+	return v, true
+}
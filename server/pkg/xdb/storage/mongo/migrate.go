@@ -0,0 +1,213 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	clog "github.com/cherry-game/cherry/logger"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"lucky/server/pkg/xdb"
+)
+
+// indexChange 集合的索引变更，SQL 为等价的 mongo shell 命令
+type indexChange struct {
+	*xdb.SchemaChange
+	apply func(ctx context.Context) error
+}
+
+// Migrate 为数据源的索引创建集合索引，集合和字段不需要迁移
+// 新增索引为非破坏性变更，修改已有的同名索引为破坏性变更，集合中多出的索引被忽略
+func (d *Dao) Migrate(ctx context.Context, srcs []*xdb.Source, opts *xdb.MigrateOptions) ([]*xdb.SchemaChange, error) {
+	var changes []*indexChange
+	for _, src := range srcs {
+		if src.TableName == "" || len(indexes(src)) == 0 {
+			continue
+		}
+
+		table := d.database(src.Namespace).table(src)
+		have, err := listIndexes(ctx, table.executor)
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range indexes(src) {
+			change, err := diffIndex(table, index, have[index.Name])
+			if err != nil {
+				return nil, err
+			}
+			if change != nil {
+				changes = append(changes, change)
+			}
+		}
+	}
+
+	ret := make([]*xdb.SchemaChange, len(changes))
+	var destructive []string
+	for i, change := range changes {
+		ret[i] = change.SchemaChange
+		if change.Destructive {
+			destructive = append(destructive, change.SQL)
+		}
+	}
+
+	if opts.DryRun {
+		return ret, nil
+	}
+	if !opts.AllowDestructive && len(destructive) > 0 {
+		return ret, errors.Wrapf(xdb.ErrDestructive, "%s", strings.Join(destructive, "; "))
+	}
+
+	for _, change := range changes {
+		if err := change.apply(ctx); err != nil {
+			return ret, errors.Wrapf(err, "command: %s", change.SQL)
+		}
+	}
+	return ret, nil
+}
+
+// pkIndex 联合主键的索引名，文档 _id 为子文档，按主键前缀查询时使用展开的主键字段
+const pkIndex = "pk"
+
+// indexes 数据源需要的集合索引，包括 (xdb.gk) 等声明的索引和联合主键的索引
+func indexes(src *xdb.Source) []*xdb.IndexDesc {
+	ret := src.Indexes
+	if keys, err := src.KeyColumns(); err == nil && len(keys) > 1 {
+		index := &xdb.IndexDesc{Name: pkIndex}
+		for _, key := range keys {
+			index.Columns = append(index.Columns, key.Name)
+		}
+		ret = append(append([]*xdb.IndexDesc{}, ret...), index)
+	}
+	return ret
+}
+
+// provision 创建集合中缺少的索引，已有的同名索引不一致时只告警，由迁移处理
+func (t *Table) provision(ctx context.Context) error {
+	if len(indexes(t.src)) == 0 {
+		return nil
+	}
+
+	have, err := listIndexes(ctx, t.executor)
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes(t.src) {
+		change, err := diffIndex(t, index, have[index.Name])
+		if err != nil {
+			return err
+		}
+		switch {
+		case change == nil:
+		case change.Destructive:
+			clog.Warnf("[xdb] mongo index changed, run migration to apply. [ns = %s, cmd = %s]", t.src.Namespace, change.SQL)
+		default:
+			if err = change.apply(ctx); err != nil {
+				return errors.Wrapf(err, "command: %s", change.SQL)
+			}
+		}
+	}
+	return nil
+}
+
+// listIndexes 集合中已有的索引，key 为索引名
+func listIndexes(ctx context.Context, coll *mongo.Collection) (map[string]bson.M, error) {
+	cursor, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list indexes")
+	}
+
+	var docs []bson.M
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, errors.Wrap(err, "failed to list indexes")
+	}
+
+	indexes := make(map[string]bson.M, len(docs))
+	for _, doc := range docs {
+		if name, ok := doc["name"].(string); ok {
+			indexes[name] = doc
+		}
+	}
+	return indexes, nil
+}
+
+// diffIndex 生成把集合中的索引 have 变为 index 的变更，一致时返回 nil
+func diffIndex(t *Table, index *xdb.IndexDesc, have bson.M) (*indexChange, error) {
+	keys := make(bson.D, 0, len(index.Columns))
+	for _, column := range index.Columns {
+		key, err := t.fieldKey(column)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid index %s", index.Name)
+		}
+		keys = append(keys, bson.E{Key: key, Value: 1})
+	}
+
+	if have != nil && sameIndex(keys, index.Unique, have) {
+		return nil, nil
+	}
+
+	coll := t.executor
+	model := mongo.IndexModel{Keys: keys, Options: options.Index().SetName(index.Name).SetUnique(index.Unique)}
+	cmd := fmt.Sprintf("db.%s.createIndex(%s, {name: %q, unique: %v})", coll.Name(), formatKeys(keys), index.Name, index.Unique)
+	if have == nil {
+		return &indexChange{
+			SchemaChange: &xdb.SchemaChange{Table: t.src.TableName, SQL: cmd},
+			apply: func(ctx context.Context) error {
+				_, err := coll.Indexes().CreateOne(ctx, model)
+				return err
+			},
+		}, nil
+	}
+
+	return &indexChange{
+		SchemaChange: &xdb.SchemaChange{
+			Table:       t.src.TableName,
+			SQL:         fmt.Sprintf("db.%s.dropIndex(%q); %s", coll.Name(), index.Name, cmd),
+			Destructive: true,
+		},
+		apply: func(ctx context.Context) error {
+			if _, err := coll.Indexes().DropOne(ctx, index.Name); err != nil {
+				return err
+			}
+			_, err := coll.Indexes().CreateOne(ctx, model)
+			return err
+		},
+	}, nil
+}
+
+// sameIndex 集合中的索引与期望的键和唯一性是否一致
+func sameIndex(keys bson.D, unique bool, have bson.M) bool {
+	if u, _ := have["unique"].(bool); u != unique {
+		return false
+	}
+
+	var got bson.D
+	switch k := have["key"].(type) {
+	case bson.D:
+		got = k
+	case bson.M:
+		for name, v := range k {
+			got = append(got, bson.E{Key: name, Value: v})
+		}
+	}
+	if len(got) != len(keys) {
+		return false
+	}
+	for i := range keys {
+		if got[i].Key != keys[i].Key || fmt.Sprint(got[i].Value) != fmt.Sprint(keys[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func formatKeys(keys bson.D) string {
+	parts := make([]string, len(keys))
+	for i, e := range keys {
+		parts[i] = fmt.Sprintf("%q: %v", e.Key, e.Value)
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
@@ -0,0 +1,131 @@
+package mongo
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"lucky/server/pkg/xdb"
+)
+
+// buildFilter 将查询条件翻译为 BSON 过滤器
+func (t *Table) buildFilter(c xdb.Cond) (bson.M, error) {
+	switch c.Op {
+	case xdb.OpNone:
+		return bson.M{}, nil
+
+	case xdb.OpAnd, xdb.OpOr:
+		if len(c.Conds) == 0 {
+			if c.Op == xdb.OpOr {
+				// 空的 or 不匹配任何记录
+				return bson.M{"_id": bson.M{"$in": bson.A{}}}, nil
+			}
+			return bson.M{}, nil
+		}
+
+		subs := make(bson.A, 0, len(c.Conds))
+		for _, sub := range c.Conds {
+			m, err := t.buildFilter(sub)
+			if err != nil {
+				return nil, err
+			}
+			subs = append(subs, m)
+		}
+
+		if c.Op == xdb.OpOr {
+			return bson.M{"$or": subs}, nil
+		}
+		return bson.M{"$and": subs}, nil
+	}
+
+	key, err := t.fieldKey(c.Field)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.Op {
+	case xdb.OpEq:
+		return bson.M{key: c.Value}, nil
+	case xdb.OpIn:
+		return bson.M{key: bson.M{"$in": bson.A(c.Values)}}, nil
+	case xdb.OpGt:
+		return bson.M{key: bson.M{"$gt": c.Value}}, nil
+	case xdb.OpGte:
+		return bson.M{key: bson.M{"$gte": c.Value}}, nil
+	case xdb.OpLt:
+		return bson.M{key: bson.M{"$lt": c.Value}}, nil
+	case xdb.OpLte:
+		return bson.M{key: bson.M{"$lte": c.Value}}, nil
+	}
+	return nil, errors.Errorf("unsupported query op: %s", c.Op)
+}
+
+// buildFindOptions 将排序和分页翻译为查询选项，分页时按 _id（由主键生成）补充排序，顺序确定时分段读取才不会重复或遗漏
+func (t *Table) buildFindOptions(q *xdb.Query) (*options.FindOptions, error) {
+	opts := options.Find()
+	sort := make(bson.D, 0, len(q.Sorts)+1)
+	for _, o := range q.Sorts {
+		key, err := t.fieldKey(o.Field)
+		if err != nil {
+			return nil, err
+		}
+
+		dir := 1
+		if o.Desc {
+			dir = -1
+		}
+		sort = append(sort, bson.E{Key: key, Value: dir})
+	}
+	if q.Size > 0 || q.Skip > 0 {
+		sort = append(sort, bson.E{Key: "_id", Value: 1})
+	}
+	if len(sort) > 0 {
+		opts.SetSort(sort)
+	}
+
+	if q.Size > 0 {
+		opts.SetLimit(int64(q.Size))
+	}
+	if q.Skip > 0 {
+		opts.SetSkip(int64(q.Skip))
+	}
+	return opts, nil
+}
+
+// fieldKey 将列名（proto 字段名）映射为文档中的键，proto 结构体没有 bson 标签，驱动默认使用小写的 Go 字段名
+func (t *Table) fieldKey(name string) (string, error) {
+	if name == "" || strings.HasPrefix(name, "$") {
+		return "", errors.Errorf("invalid query field: %q", name)
+	}
+
+	if name == xdb.VersionColumn || t.src.ProtoType == nil {
+		return name, nil
+	}
+
+	// 扁平布局展开的子消息字段
+	for _, column := range t.flat {
+		if column.Name == name {
+			return flatKey(column), nil
+		}
+	}
+
+	for i := 0; i < t.src.ProtoType.NumField(); i++ {
+		f := t.src.ProtoType.Field(i)
+		if protoFieldName(f) == name {
+			return strings.ToLower(f.Name), nil
+		}
+	}
+	return name, nil
+}
+
+func protoFieldName(f reflect.StructField) string {
+	for _, part := range strings.Split(f.Tag.Get("protobuf"), ",") {
+		if strings.HasPrefix(part, "name=") {
+			return part[len("name="):]
+		}
+	}
+	return ""
+}
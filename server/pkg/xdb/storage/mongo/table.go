@@ -2,13 +2,15 @@ package mongo
 
 import (
 	"context"
-	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
 	"time"
 
+	clog "github.com/cherry-game/cherry/logger"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"lucky/server/pkg/xdb"
 )
@@ -17,11 +19,19 @@ import (
 type Table struct {
 	src      *xdb.Source
 	executor *mongo.Collection
+	keys     []*xdb.KeyColumn     // 主键列，Validate 保证可以推导
+	columns  []*xdb.Column        // 入库的列
+	flat     []*xdb.Column        // 扁平布局下展开的子消息字段
+	changed  map[string]xdb.Field // 顶层字段名对应的字段编号，用于只更新变更的字段
+
+	provisioned bool // 已补齐索引
 }
 
 func (t *Table) Recover(ctx context.Context, commitments []xdb.Commitment) error {
-	// 恢复逻辑：从重做日志恢复数据
-	// 这里可以添加恢复逻辑
+	// 写入被中断时返回错误，分段文件保留到下次恢复
+	if !t.Save(ctx, commitments, 5*time.Second, xdb.RetryInterval, func() bool { return ctx.Err() == nil }) {
+		return errors.Errorf("redo recover of [%s] interrupted", t.src.Namespace)
+	}
 	return nil
 }
 
@@ -30,61 +40,132 @@ func (t *Table) Save(ctx context.Context, commitments []xdb.Commitment, writeTim
 		return true
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
-	defer cancel()
+	// 整批以一次 BulkWrite 写入，失败和冲突的提交逐个写入，由 SaveOne 对每个提交重试或写入死信
+	if batchable(commitments) {
+		redo, err := t.saveBatch(ctx, commitments, writeTimeout)
+		if err == nil {
+			commitments = redo
+		} else {
+			clog.Warnf("[xdb] mongo bulk write failed, fallback to single writes. [ns = %s, size = %d, err = %v]", t.src.Namespace, len(commitments), err)
+		}
+	}
 
+	return t.saveEach(ctx, commitments, writeTimeout, retryInterval, running)
+}
+
+// saveEach 逐个写入提交
+func (t *Table) saveEach(ctx context.Context, commitments []xdb.Commitment, writeTimeout time.Duration, retryInterval time.Duration, running func() bool) bool {
 	for _, commitment := range commitments {
-		if !running() {
+		c := commitment
+		ok := xdb.SaveOne(ctx, c, writeTimeout, retryInterval, running, func(ctx context.Context) error {
+			for attempt := 1; ; attempt++ {
+				conflict, err := t.saveOne(ctx, c)
+				if err != nil {
+					return t.classify(err)
+				}
+
+				// 版本冲突时由数据源的冲突回调决定是否以最新版本重试
+				if !conflict || !xdb.ResolveConflict(ctx, c, attempt) {
+					return nil
+				}
+			}
+		})
+		if !ok {
 			return false
 		}
+	}
 
-		data, _ := commitment.PrepareWrite()
-		lifecycle := commitment.Lifecycle()
-
-		switch lifecycle {
-		case xdb.LifecycleNew, xdb.LifecycleNormal:
-			// 插入或更新
-			filter := bson.M{"_id": t.getDocumentID(commitment)}
-			update := bson.M{"$set": data}
-			opts := options.Update().SetUpsert(true)
-			_, err := t.executor.UpdateOne(ctx, filter, update, opts)
-			if err != nil {
-				// 记录错误，但继续处理其他记录
-				fmt.Printf("Failed to save commitment: %v\n", err)
-				continue
-			}
+	return true
+}
+
+// saveOne 写入单个提交，启用乐观锁时没有匹配到期望版本的文档视为冲突
+func (t *Table) saveOne(ctx context.Context, commitment xdb.Commitment) (bool, error) {
+	model, err := t.writeModel(commitment)
+	if err != nil || model == nil {
+		return false, err
+	}
 
+	result, err := t.executor.BulkWrite(ctx, []mongo.WriteModel{model})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to save commitment")
+	}
+
+	if _, _, versioned := xdb.VersionOf(commitment); versioned {
+		switch commitment.Lifecycle() {
+		case xdb.LifecycleNormal:
+			return result.MatchedCount == 0, nil
 		case xdb.LifecycleDeleted:
-			// 删除
-			filter := bson.M{"_id": t.getDocumentID(commitment)}
-			_, err := t.executor.DeleteOne(ctx, filter)
-			if err != nil {
-				fmt.Printf("Failed to delete commitment: %v\n", err)
-				continue
+			return result.DeletedCount == 0, nil
+		}
+	}
+	return false, nil
+}
+
+// dupKey 重复键错误信息中的索引名和冲突的值
+var dupKey = regexp.MustCompile(`index: (\S+) dup key: \{ [^:]*: (.*) \}`)
+
+// classify 区分永久错误和临时错误，文档本身导致的写入错误重试也不会成功，违反唯一约束时返回 *xdb.DupError
+func (t *Table) classify(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		dup := &xdb.DupError{Namespace: t.src.Namespace}
+		if m := dupKey.FindStringSubmatch(err.Error()); m != nil {
+			dup.Index = m[1]
+			dup.Value = m[2]
+			if s, uerr := strconv.Unquote(m[2]); uerr == nil {
+				dup.Value = s
 			}
 		}
+		return xdb.Permanent(dup)
 	}
 
-	return true
+	var we mongo.WriteException
+	if errors.As(err, &we) && len(we.WriteErrors) > 0 {
+		return xdb.Permanent(err)
+	}
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && len(bwe.WriteErrors) > 0 {
+		return xdb.Permanent(err)
+	}
+	return err
+}
+
+// toDocument 将数据转换为文档，版本号由驱动单独维护
+func toDocument(data interface{}) (bson.M, error) {
+	raw, err := bson.Marshal(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal document")
+	}
+
+	doc := bson.M{}
+	if err = bson.Unmarshal(raw, &doc); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal document")
+	}
+	delete(doc, "xversion")
+	return doc, nil
 }
 
-func (t *Table) getDocumentID(commitment xdb.Commitment) string {
-	// 使用 Source 的 PKOf 方法获取主键
-	pk := commitment.Source().PKOf(commitment)
-	if pk != nil {
-		return pk.String()
+// documentID 由主键列生成文档 _id，单列主键直接使用列值，联合主键为按列顺序排列的子文档
+func (t *Table) documentID(pk xdb.PK) interface{} {
+	values := xdb.KeyValues(pk, t.keys)
+	if len(values) == 1 {
+		return values[0]
 	}
-	return ""
+
+	id := make(bson.D, len(values))
+	for i, key := range t.keys {
+		id[i] = bson.E{Key: key.Name, Value: values[i]}
+	}
+	return id
 }
 
 func (t *Table) Fetch(ctx context.Context, onlyOne bool, pk xdb.PK) (xdb.RecordCursor, error) {
-	filter := bson.M{"_id": pk.String()}
+	filter := bson.M{"_id": t.documentID(pk)}
 	cursor, err := t.executor.Find(ctx, filter)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to fetch from MongoDB")
 	}
 
-	return newRecordCursor(cursor, t.src), nil
+	return newRecordCursor(cursor, t), nil
 }
 
 func (t *Table) FetchMulti(ctx context.Context, pks []xdb.PK) (xdb.RecordCursor, error) {
@@ -92,9 +173,9 @@ func (t *Table) FetchMulti(ctx context.Context, pks []xdb.PK) (xdb.RecordCursor,
 		return &RecordCursor{cursor: nil, src: t.src}, nil
 	}
 
-	ids := make([]string, len(pks))
+	ids := make([]interface{}, len(pks))
 	for i, pk := range pks {
-		ids[i] = pk.String()
+		ids[i] = t.documentID(pk)
 	}
 
 	filter := bson.M{"_id": bson.M{"$in": ids}}
@@ -103,28 +184,69 @@ func (t *Table) FetchMulti(ctx context.Context, pks []xdb.PK) (xdb.RecordCursor,
 		return nil, errors.Wrap(err, "failed to fetch multi from MongoDB")
 	}
 
-	return newRecordCursor(cursor, t.src), nil
+	return newRecordCursor(cursor, t), nil
 }
 
 func (t *Table) Find(ctx context.Context, filter interface{}) (xdb.RecordCursor, error) {
-	cursor, err := t.executor.Find(ctx, filter)
+	q, ok := filter.(*xdb.Query)
+	if !ok {
+		// 原生的 BSON 过滤器
+		cursor, err := t.executor.Find(ctx, filter)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to find from MongoDB")
+		}
+		return newRecordCursor(cursor, t), nil
+	}
+
+	m, err := t.buildFilter(q.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := t.buildFindOptions(q)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := t.executor.Find(ctx, m, opts)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to find from MongoDB")
 	}
 
-	return newRecordCursor(cursor, t.src), nil
+	return newRecordCursor(cursor, t), nil
+}
+
+func (t *Table) Count(ctx context.Context, filter interface{}) (int64, error) {
+	if q, ok := filter.(*xdb.Query); ok {
+		m, err := t.buildFilter(q.Filter)
+		if err != nil {
+			return 0, err
+		}
+		filter = m
+	}
+	if filter == nil {
+		filter = bson.M{}
+	}
+
+	count, err := t.executor.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count from MongoDB")
+	}
+	return count, nil
 }
 
 // RecordCursor MongoDB 记录游标
 type RecordCursor struct {
 	cursor *mongo.Cursor
 	src    *xdb.Source
+	flat   []*xdb.Column
 }
 
-func newRecordCursor(cursor *mongo.Cursor, src *xdb.Source) *RecordCursor {
+func newRecordCursor(cursor *mongo.Cursor, table *Table) *RecordCursor {
 	return &RecordCursor{
 		cursor: cursor,
-		src:    src,
+		src:    table.src,
+		flat:   table.flat,
 	}
 }
 
@@ -139,14 +261,64 @@ func (r *RecordCursor) Decode(val interface{}) error {
 	if r.cursor == nil {
 		return errors.New("cursor is nil")
 	}
-	return r.cursor.Decode(val)
+	if err := r.decode(val); err != nil {
+		return err
+	}
+
+	// 乐观锁版本号保存在 _version 字段中
+	if vs, ok := val.(xdb.VersionSetter); ok {
+		if v, err := r.cursor.Current.LookupErr(xdb.VersionColumn); err == nil {
+			if version, ok := v.AsInt64OK(); ok {
+				vs.SetXVersion(version)
+			}
+		}
+	}
+	return nil
+}
+
+// decode 扁平布局下先把展开的键还原为子文档
+func (r *RecordCursor) decode(val interface{}) error {
+	if len(r.flat) == 0 {
+		return r.cursor.Decode(val)
+	}
+
+	doc := bson.M{}
+	if err := r.cursor.Decode(&doc); err != nil {
+		return err
+	}
+	unflatten(doc, r.flat)
+
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal document")
+	}
+	return bson.Unmarshal(raw, val)
 }
 
 func (r *RecordCursor) All(ctx context.Context, results interface{}) error {
 	if r.cursor == nil {
 		return nil
 	}
-	return r.cursor.All(ctx, results)
+	if len(r.flat) == 0 {
+		return r.cursor.All(ctx, results)
+	}
+
+	rv := reflect.ValueOf(results)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice || rv.Elem().Type().Elem().Kind() != reflect.Ptr {
+		return errors.Errorf("results must be a pointer to a slice of pointers: %T", results)
+	}
+
+	defer r.cursor.Close(ctx)
+	slice := rv.Elem()
+	for r.cursor.Next(ctx) {
+		elem := reflect.New(slice.Type().Elem().Elem())
+		if err := r.decode(elem.Interface()); err != nil {
+			return err
+		}
+		slice = reflect.Append(slice, elem)
+	}
+	rv.Elem().Set(slice)
+	return r.cursor.Err()
 }
 
 func (r *RecordCursor) Close(ctx context.Context) error {
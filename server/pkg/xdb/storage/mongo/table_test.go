@@ -0,0 +1,120 @@
+package mongo
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"lucky/server/gen/db"
+	"lucky/server/pkg/xdb"
+)
+
+type testReward struct {
+	ItemId int32 `protobuf:"varint,1,opt,name=item_id,proto3"`
+	Count  int64 `protobuf:"varint,2,opt,name=count,proto3"`
+}
+
+type testProto struct {
+	Id     int64       `protobuf:"varint,1,opt,name=id,proto3"`
+	Name   string      `protobuf:"bytes,2,opt,name=name,proto3"`
+	Reward *testReward `protobuf:"bytes,3,opt,name=reward,proto3"`
+}
+
+// testDatabase 未连接的数据库，只用于构造写入
+func testDatabase(t *testing.T) *Database {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Disconnect(context.Background()) })
+	return newDatabase(client.Database("test"))
+}
+
+func TestTable_WriteModel(t *testing.T) {
+	ctx := context.Background()
+	table := testDatabase(t).table((&db.PlayerRecord{}).Source())
+
+	player := &db.PlayerRecord{}
+	_ = player.Init(ctx, &db.Player{PlayerId: 1, Name: "a"})
+	c, _ := player.Commit(ctx)
+	model, _ := table.writeModel(c)
+	if replace, ok := model.(*mongo.ReplaceOneModel); !ok || !*replace.Upsert || replace.Replacement.(bson.M)["name"] != "a" {
+		t.Fatalf("unexpected insert: %#v", model)
+	}
+
+	// 更新只 $set 变更的字段，附加期望版本
+	_ = player.SetLevel(3)
+	c, _ = player.Commit(ctx)
+	model, _ = table.writeModel(c)
+	update, ok := model.(*mongo.UpdateOneModel)
+	if !ok {
+		t.Fatalf("unexpected update: %#v", model)
+	}
+	set := update.Update.(bson.M)["$set"].(bson.M)
+	if len(set) != 2 || set["level"] != int32(3) || set[xdb.VersionColumn] != int64(2) || update.Filter.(bson.M)[xdb.VersionColumn] != int64(1) {
+		t.Errorf("unexpected update: %v %v", update.Filter, update.Update)
+	}
+}
+
+func TestTable_UpdateDocument(t *testing.T) {
+	src := &xdb.Source{
+		ProtoType:    reflect.TypeOf(testProto{}),
+		Fields:       []*xdb.FieldDesc{{Code: 0, Name: "id", Key: true}, {Code: 1, Name: "name"}, {Code: 2, Name: "reward"}},
+		FieldSetSave: xdb.MakeFieldSet(0, 1, 2),
+	}
+	table := testDatabase(t).table(src)
+
+	// 扁平布局下清空的子消息 $unset 展开的键，未变更的字段不写入
+	update, err := table.updateDocument(xdb.MakeFieldSet(2), &testProto{Id: 1, Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unset, _ := update["$unset"].(bson.M)
+	if _, ok := update["$set"]; ok || len(unset) != 2 || unset["reward_itemid"] == nil || unset["reward_count"] == nil {
+		t.Errorf("unexpected update: %v", update)
+	}
+
+	update, _ = table.updateDocument(xdb.MakeFieldSet(1, 2), &testProto{Id: 1, Name: "b", Reward: &testReward{ItemId: 3}})
+	set, _ := update["$set"].(bson.M)
+	if len(set) != 3 || set["name"] != "b" || set["reward_itemid"] != int32(3) || update["$unset"] != nil {
+		t.Errorf("unexpected update: %v", update)
+	}
+}
+
+func TestTable_FindPageOrder(t *testing.T) {
+	table := testDatabase(t).table((&db.PlayerRecord{}).Source())
+
+	// 分页时追加 _id 排序
+	opts, err := table.buildFindOptions(xdb.NewQuery().OrderByDesc("level").Limit(10).Offset(5))
+	if err != nil || !reflect.DeepEqual(opts.Sort, bson.D{{Key: "level", Value: -1}, {Key: "_id", Value: 1}}) {
+		t.Errorf("unexpected sort: %v %v", opts.Sort, err)
+	}
+
+	if opts, _ = table.buildFindOptions(xdb.NewQuery()); opts.Sort != nil {
+		t.Errorf("unexpected sort: %v", opts.Sort)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	err := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+		{WriteError: mongo.WriteError{Index: 1, Code: 11000, Message: "E11000 duplicate key error"}},
+	}}
+	failed, rerr := writeErrors(errors.WithStack(err), 3)
+	if rerr != nil || len(failed) != 1 || failed[1] == nil || !mongo.IsDuplicateKeyError(failed[1]) {
+		t.Fatalf("unexpected write errors: %v %v", failed, rerr)
+	}
+
+	// 写关注错误时整批的结果不确定
+	err.WriteConcernError = &mongo.WriteConcernError{Code: 64}
+	if _, rerr = writeErrors(err, 3); rerr == nil {
+		t.Error("expected write concern error")
+	}
+	if _, rerr = writeErrors(errors.New("network"), 3); rerr == nil {
+		t.Error("expected batch error")
+	}
+}
@@ -0,0 +1,203 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/sqlbuilder"
+)
+
+// maxPlaceholders 单条语句的占位符上限
+const maxPlaceholders = 65535
+
+// statement 待执行的语句
+type statement struct {
+	query string
+	args  []interface{}
+}
+
+// batchable 批次中同一主键只出现一次时才能分组写入，否则按顺序逐个写入
+func batchable(commitments []xdb.Commitment) bool {
+	if len(commitments) < 2 {
+		return false
+	}
+
+	seen := make(map[string]struct{}, len(commitments))
+	for _, c := range commitments {
+		key := xdb.PKOf(c).String()
+		if _, ok := seen[key]; ok {
+			return false
+		}
+		seen[key] = struct{}{}
+	}
+	return true
+}
+
+// saveBatch 在一个事务中写入整个批次，返回版本冲突（没有写入）的提交
+// 新建合并为多行 INSERT，更新只写变更的列，未启用乐观锁的删除合并为一条 DELETE
+func (t *Table) saveBatch(ctx context.Context, commitments []xdb.Commitment, timeout time.Duration) (conflicts []xdb.Commitment, err error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	tx, err := t.dao.client.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	exec := func(c xdb.Commitment, query string, args []interface{}) error {
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return errors.Wrapf(err, "SQL: %s, Args: %v", query, args)
+		}
+
+		conflict, err := t.conflicted(c, result)
+		if conflict {
+			conflicts = append(conflicts, c)
+		}
+		return err
+	}
+
+	var inserts, deletes []xdb.Commitment
+	for _, c := range commitments {
+		switch c.Lifecycle() {
+		case xdb.LifecycleNew:
+			inserts = append(inserts, c)
+
+		case xdb.LifecycleNormal:
+			data, _ := c.PrepareWrite()
+			var query string
+			var args []interface{}
+			if query, args, err = t.builder.Update(c, data); err != nil {
+				return nil, xdb.Permanent(err)
+			}
+			if query != "" {
+				if err = exec(c, query, args); err != nil {
+					return nil, err
+				}
+			}
+
+		case xdb.LifecycleDeleted:
+			// 启用乐观锁的删除需要逐行检查版本
+			if _, _, versioned := xdb.VersionOf(c); !versioned {
+				deletes = append(deletes, c)
+				continue
+			}
+			if query, args := t.builder.Delete(c); query != "" {
+				if err = exec(c, query, args); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	stmts, err := t.buildBatchInsertSQL(inserts)
+	if err != nil {
+		return nil, xdb.Permanent(err)
+	}
+	stmts = append(stmts, t.buildBatchDeleteSQL(deletes)...)
+	for _, stmt := range stmts {
+		if _, err = tx.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+			return nil, errors.Wrapf(err, "SQL: %s", stmt.query)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "failed to commit transaction")
+	}
+	return conflicts, nil
+}
+
+// buildBatchInsertSQL 多行 INSERT，主键或唯一索引冲突时整条语句失败，由调用方回滚后逐个写入以定位冲突的提交
+func (t *Table) buildBatchInsertSQL(commitments []xdb.Commitment) ([]statement, error) {
+	if len(commitments) == 0 {
+		return nil, nil
+	}
+
+	data, _ := commitments[0].PrepareWrite()
+	fields, _, err := t.builder.InsertRow(commitments[0], data)
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+
+	head := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES ", t.src.TableName, strings.Join(fields, ", "))
+	row := "(" + sqlbuilder.Marks(len(fields)) + ")"
+
+	var stmts []statement
+	for _, chunk := range chunks(commitments, maxPlaceholders/len(fields)) {
+		rows := make([]string, len(chunk))
+		args := make([]interface{}, 0, len(chunk)*len(fields))
+		for i, c := range chunk {
+			data, _ := c.PrepareWrite()
+			_, values, err := t.builder.InsertRow(c, data)
+			if err != nil {
+				return nil, err
+			}
+			rows[i] = row
+			args = append(args, values...)
+		}
+		stmts = append(stmts, statement{query: head + strings.Join(rows, ", "), args: args})
+	}
+	return stmts, nil
+}
+
+// buildBatchDeleteSQL 按主键合并删除，联合主键使用行构造器 (a, b) IN ((?, ?), ...)
+func (t *Table) buildBatchDeleteSQL(commitments []xdb.Commitment) []statement {
+	if len(commitments) == 0 {
+		return nil
+	}
+
+	var stmts []statement
+	for _, chunk := range chunks(commitments, maxPlaceholders/max(len(t.builder.Keys), 1)) {
+		pks := make([]xdb.PK, len(chunk))
+		for i, c := range chunk {
+			pks[i] = xdb.PKOf(c)
+		}
+
+		where, args := t.builder.InPKs(pks)
+		if where == "" {
+			return nil
+		}
+		stmts = append(stmts, statement{query: fmt.Sprintf("DELETE FROM `%s` WHERE %s", t.src.TableName, where), args: args})
+	}
+	return stmts
+}
+
+// conflicted 启用乐观锁时，更新或删除没有命中期望版本的行视为冲突
+func (t *Table) conflicted(commitment xdb.Commitment, result sql.Result) (bool, error) {
+	if _, _, versioned := xdb.VersionOf(commitment); !versioned || commitment.Lifecycle() == xdb.LifecycleNew {
+		return false, nil
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get rows affected")
+	}
+	return affected == 0, nil
+}
+
+func chunks(commitments []xdb.Commitment, size int) [][]xdb.Commitment {
+	if size <= 0 {
+		size = 1
+	}
+
+	var ret [][]xdb.Commitment
+	for len(commitments) > size {
+		ret = append(ret, commitments[:size])
+		commitments = commitments[size:]
+	}
+	return append(ret, commitments)
+}
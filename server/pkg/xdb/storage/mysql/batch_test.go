@@ -0,0 +1,301 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+
+	"lucky/server/gen/db"
+	"lucky/server/pkg/xdb"
+)
+
+func TestBuildBatchSQL(t *testing.T) {
+	ctx := context.Background()
+	table := newTableMgr().CreateTable(nil, (&db.ItemRecord{}).Source())
+
+	var created []xdb.Commitment
+	var items []*db.ItemRecord
+	for _, id := range []int32{1, 2} {
+		item := &db.ItemRecord{}
+		_ = item.Init(ctx, &db.Item{PlayerId: 7, ItemId: id, Count: 1})
+		c, _ := item.Commit(ctx)
+		created = append(created, c)
+		items = append(items, item)
+	}
+
+	// 新建不使用 ON DUPLICATE KEY UPDATE，冲突时报错而不是覆盖已有的行
+	stmts, _ := table.buildBatchInsertSQL(created)
+	expected := "INSERT INTO `item` (`player_id`, `item_id`, `count`, `ctime`, `mtime`, `_version`) VALUES (?, ?, ?, ?, ?, ?), (?, ?, ?, ?, ?, ?)"
+	if len(stmts) != 1 || stmts[0].query != expected || len(stmts[0].args) != 12 {
+		t.Fatalf("unexpected insert: %+v", stmts)
+	}
+
+	// 只更新变更的列
+	items[0].Count = 5
+	items[0].GetHeader().SetChanged(db.ItemFieldCount)
+	c, _ := items[0].Commit(ctx)
+	data, _ := c.PrepareWrite()
+	query, args, _ := table.builder.Update(c, data)
+	if query != "UPDATE `item` SET `count` = ?, `_version` = ? WHERE `player_id` = ? AND `item_id` = ? AND `_version` = ?" || args[0] != int64(5) || args[3] != int32(1) {
+		t.Errorf("unexpected update: %s %v", query, args)
+	}
+
+	stmts = table.buildBatchDeleteSQL(created)
+	if len(stmts) != 1 || stmts[0].query != "DELETE FROM `item` WHERE (`player_id`, `item_id`) IN ((?, ?), (?, ?))" || len(stmts[0].args) != 4 {
+		t.Errorf("unexpected delete: %+v", stmts)
+	}
+
+	if batchable(append(created, created[0])) {
+		t.Error("expected duplicated pk not batchable")
+	}
+}
+
+func TestDriver_ValidateKey(t *testing.T) {
+	item := (&db.ItemRecord{}).Source()
+	src := &xdb.Source{ProtoType: item.ProtoType, PKType: item.PKType, Namespace: item.Namespace, DriverName: driver.Name(), KeySize: item.KeySize, Fields: item.Fields}
+	if err := driver.Validate(src); err != nil {
+		t.Fatal(err)
+	}
+
+	// 主键字段无法对应到 PK 结构体
+	src.Fields = []*xdb.FieldDesc{{Code: db.ItemFieldPlayerId, Name: "player_id", Key: true}, {Code: db.ItemFieldCount, Name: "count", Key: true}}
+	if err := driver.Validate(src); err == nil {
+		t.Error("expected unmappable key rejected")
+	}
+}
+
+func TestTable_ClassifyDup(t *testing.T) {
+	table := newTableMgr().CreateTable(nil, (&db.PlayerRecord{}).Source())
+
+	err := table.classify(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'bob' for key 'player.uk_name'"})
+	var dup *xdb.DupError
+	if !xdb.IsPermanent(err) || !errors.Is(err, xdb.ErrDup) || !errors.As(err, &dup) || dup.Index != "uk_name" || dup.Value != "bob" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !isDupEntry(errors.Wrap(&mysql.MySQLError{Number: 1062}, "SQL")) || isDupEntry(&mysql.MySQLError{Number: 1213}) {
+		t.Error("expected wrapped 1062 detected as duplicate entry")
+	}
+
+	if err = table.classify(&mysql.MySQLError{Number: 1213, Message: "Deadlock found"}); xdb.IsPermanent(err) {
+		t.Errorf("expected deadlock retried: %v", err)
+	}
+}
+
+// uniqueTestDB 模拟 player 表的主键和 uk_name 唯一索引，只支持 INSERT，违反约束时整条语句失败
+// 带 ON DUPLICATE KEY UPDATE 时和 MySQL 一样更新已有的行（这里只有主键和 name 两列，等同于忽略）
+type uniqueTestDB struct {
+	mu       sync.Mutex
+	rows     map[interface{}]interface{} // player_id -> name
+	versions map[interface{}]interface{} // player_id -> _version，只支持按主键查询
+	execs    []string
+}
+
+func (d *uniqueTestDB) Connect(ctx context.Context) (sqldriver.Conn, error) {
+	return &uniqueTestConn{db: d}, nil
+}
+func (d *uniqueTestDB) Driver() sqldriver.Driver                 { return d }
+func (d *uniqueTestDB) Open(name string) (sqldriver.Conn, error) { return &uniqueTestConn{db: d}, nil }
+
+// insert 在 rows 的副本上插入，全部成功时返回新的 rows
+func (d *uniqueTestDB) insert(rows map[interface{}]interface{}, query string, args []sqldriver.NamedValue) (map[interface{}]interface{}, error) {
+	columns := strings.Split(query[strings.Index(query, "(")+1:strings.Index(query, ") VALUES")], ", ")
+	ret := make(map[interface{}]interface{}, len(rows))
+	names := map[interface{}]bool{}
+	for id, name := range rows {
+		ret[id] = name
+		names[name] = true
+	}
+
+	for i := 0; i < len(args); i += len(columns) {
+		var id, name interface{}
+		for j, column := range columns {
+			switch column {
+			case "`player_id`":
+				id = args[i+j].Value
+			case "`name`":
+				name = args[i+j].Value
+			}
+		}
+		_, taken := ret[id]
+		if (taken || names[name]) && strings.Contains(query, "ON DUPLICATE KEY UPDATE") {
+			continue
+		}
+		if taken {
+			return nil, &mysql.MySQLError{Number: 1062, Message: fmt.Sprintf("Duplicate entry '%v' for key 'player.PRIMARY'", id)}
+		}
+		if names[name] {
+			return nil, &mysql.MySQLError{Number: 1062, Message: fmt.Sprintf("Duplicate entry '%v' for key 'player.uk_name'", name)}
+		}
+		ret[id], names[name] = name, true
+	}
+	return ret, nil
+}
+
+type uniqueTestConn struct {
+	db *uniqueTestDB
+	tx *uniqueTestTx
+}
+
+func (c *uniqueTestConn) QueryContext(ctx context.Context, query string, args []sqldriver.NamedValue) (sqldriver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	if query != "SELECT `_version` FROM `player` WHERE `player_id` = ?" {
+		return nil, errors.Errorf("unexpected query: %s", query)
+	}
+
+	rows := &uniqueTestRows{}
+	if _, ok := c.db.rows[args[0].Value]; ok {
+		rows.values = append(rows.values, c.db.versions[args[0].Value])
+	}
+	return rows, nil
+}
+
+func (c *uniqueTestConn) Prepare(query string) (sqldriver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+func (c *uniqueTestConn) Close() error { return nil }
+func (c *uniqueTestConn) Begin() (sqldriver.Tx, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	rows := make(map[interface{}]interface{}, len(c.db.rows))
+	for id, name := range c.db.rows {
+		rows[id] = name
+	}
+	c.tx = &uniqueTestTx{conn: c, rows: rows}
+	return c.tx, nil
+}
+
+func (c *uniqueTestConn) ExecContext(ctx context.Context, query string, args []sqldriver.NamedValue) (sqldriver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.execs = append(c.db.execs, query)
+	if !strings.HasPrefix(query, "INSERT INTO `player`") {
+		return nil, errors.Errorf("unexpected query: %s", query)
+	}
+
+	target := &c.db.rows
+	if c.tx != nil {
+		target = &c.tx.rows
+	}
+	rows, err := c.db.insert(*target, query, args)
+	if err != nil {
+		return nil, err
+	}
+	n := len(rows) - len(*target)
+	*target = rows
+	return sqldriver.RowsAffected(n), nil
+}
+
+type uniqueTestTx struct {
+	conn *uniqueTestConn
+	rows map[interface{}]interface{}
+}
+
+func (tx *uniqueTestTx) Commit() error {
+	tx.conn.db.mu.Lock()
+	defer tx.conn.db.mu.Unlock()
+	tx.conn.db.rows, tx.conn.tx = tx.rows, nil
+	return nil
+}
+
+func (tx *uniqueTestTx) Rollback() error {
+	tx.conn.tx = nil
+	return nil
+}
+
+type uniqueTestRows struct {
+	values []interface{}
+}
+
+func (r *uniqueTestRows) Columns() []string { return []string{xdb.VersionColumn} }
+func (r *uniqueTestRows) Close() error      { return nil }
+func (r *uniqueTestRows) Next(dest []sqldriver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], r.values = r.values[0], r.values[1:]
+	return nil
+}
+
+func TestTable_SaveBatchDup(t *testing.T) {
+	ctx := context.Background()
+	fake := &uniqueTestDB{rows: map[interface{}]interface{}{}}
+	client := sql.OpenDB(fake)
+	defer client.Close()
+	table := newTableMgr().CreateTable(&Dao{client: client}, (&db.PlayerRecord{}).Source())
+
+	var failures []*xdb.SaveFailure
+	xdb.OnSaveError(func(ctx context.Context, f *xdb.SaveFailure) {
+		failures = append(failures, f)
+	})
+
+	var commitments []xdb.Commitment
+	for _, id := range []int64{1, 2} {
+		player := &db.PlayerRecord{}
+		_ = player.Init(ctx, &db.Player{PlayerId: id, Name: "a"})
+		c, _ := player.Commit(ctx)
+		commitments = append(commitments, c)
+	}
+	if !table.Save(ctx, commitments, time.Second, time.Millisecond, func() bool { return true }) {
+		t.Fatal("expected save finished")
+	}
+
+	// 同名的两个新建在一个批次中，批量插入失败后逐个写入，第二个返回 DupError 而不是覆盖第一个的行
+	var dup *xdb.DupError
+	if len(failures) != 1 || !errors.As(failures[0].Err, &dup) || dup.Index != "uk_name" || xdb.PKOf(failures[0].Commitment).String() != xdb.PKOf(commitments[1]).String() {
+		t.Fatalf("expected duplicated name of the second player: %+v", failures)
+	}
+	if len(fake.rows) != 1 || fake.rows[int64(1)] != "a" || len(fake.execs) != 3 {
+		t.Errorf("unexpected rows: %v %q", fake.rows, fake.execs)
+	}
+}
+
+func TestTable_RecoverApplied(t *testing.T) {
+	ctx := context.Background()
+	fake := &uniqueTestDB{rows: map[interface{}]interface{}{}, versions: map[interface{}]interface{}{}}
+	client := sql.OpenDB(fake)
+	defer client.Close()
+	table := newTableMgr().CreateTable(&Dao{client: client}, (&db.PlayerRecord{}).Source())
+
+	var failures []*xdb.SaveFailure
+	xdb.OnSaveError(func(ctx context.Context, f *xdb.SaveFailure) {
+		failures = append(failures, f)
+	})
+
+	var commitments []xdb.Commitment
+	for _, id := range []int64{1, 2} {
+		player := &db.PlayerRecord{}
+		_ = player.Init(ctx, &db.Player{PlayerId: id, Name: fmt.Sprint(id)})
+		c, _ := player.Commit(ctx)
+		commitments = append(commitments, c)
+	}
+
+	// 中断前已经入库了第一个新建，重放时跳过它并写入第二个
+	_, version, _ := xdb.VersionOf(commitments[0])
+	fake.rows[int64(1)], fake.versions[int64(1)] = "1", version
+	if err := table.Recover(ctx, commitments); err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 0 || len(fake.rows) != 2 {
+		t.Fatalf("expected replayed insert applied: %+v %v", failures, fake.rows)
+	}
+
+	// 版本号不同的行是另一条记录，仍然违反主键
+	fake.versions[int64(1)] = version + 1
+	if err := table.Recover(ctx, commitments[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 1 || !errors.Is(failures[0].Err, xdb.ErrDup) {
+		t.Fatalf("expected duplicated pk: %+v", failures)
+	}
+}
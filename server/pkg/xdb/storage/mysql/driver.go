@@ -48,6 +48,10 @@ func (d *Driver) Validate(src *xdb.Source) error {
 	if src.DriverName != d.Name() {
 		return errors.Errorf("driver name not match: %s against to %s in %s", src.DriverName, d.Name(), src.Namespace)
 	}
+	// 更新和删除按完整主键匹配，主键必须能对应到表的列
+	if _, err := src.KeyColumns(); err != nil {
+		return errors.Wrap(err, "invalid primary key")
+	}
 	// MySQL 不需要 DBName（使用 DaoOptions 中的 DBName）
 	return nil
 }
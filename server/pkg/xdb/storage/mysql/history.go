@@ -0,0 +1,100 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"lucky/server/pkg/xdb"
+)
+
+// historyDDL 历史表的建表语句，与 protoc-gen-xdb 为 (xdb.history) 生成的一致
+const historyDDL = "CREATE TABLE IF NOT EXISTS `%s` (" +
+	"\n    `id` BIGINT(20) NOT NULL AUTO_INCREMENT," +
+	"\n    `pk` VARCHAR(255) NOT NULL DEFAULT ''," +
+	"\n    `version` BIGINT(20) NOT NULL DEFAULT 0," +
+	"\n    `lifecycle` TINYINT(4) NOT NULL DEFAULT 0," +
+	"\n    `changes` VARBINARY(136) NOT NULL DEFAULT ''," +
+	"\n    `diff` TEXT NOT NULL," +
+	"\n    `data` MEDIUMBLOB NOT NULL," +
+	"\n    `ctime` BIGINT(20) NOT NULL DEFAULT 0," +
+	"\n    `origin` VARCHAR(255) NOT NULL DEFAULT ''," +
+	"\n    PRIMARY KEY(`id`)," +
+	"\n    KEY `idx_pk_version` (`pk`, `version`)" +
+	"\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+
+const historyColumns = "`pk`, `version`, `lifecycle`, `changes`, `diff`, `data`, `ctime`, `origin`"
+
+// History MySQL 历史表，ctime 为毫秒时间戳
+type History struct {
+	client *sql.DB
+	name   string
+}
+
+func (d *Dao) History(ctx context.Context, src *xdb.Source) (xdb.HistoryTable, error) {
+	h := &History{client: d.client, name: xdb.HistoryTableName(src)}
+	if _, err := d.client.ExecContext(ctx, fmt.Sprintf(historyDDL, h.name)); err != nil {
+		return nil, errors.Wrapf(err, "failed to create table %s", h.name)
+	}
+	return h, nil
+}
+
+func (h *History) Append(ctx context.Context, rows []*xdb.HistoryRow) error {
+	var buf strings.Builder
+	buf.WriteString("INSERT INTO `" + h.name + "` (" + historyColumns + ") VALUES ")
+
+	args := make([]interface{}, 0, len(rows)*8)
+	for i, r := range rows {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString("(?, ?, ?, ?, ?, ?, ?, ?)")
+		changes, _ := r.Changes.MarshalBinary()
+		args = append(args, r.Key, r.Version, int8(r.Lifecycle), changes, r.Diff, r.Data, r.Time.UnixMilli(), r.Origin)
+	}
+
+	if _, err := h.client.ExecContext(ctx, buf.String(), args...); err != nil {
+		return errors.Wrapf(err, "failed to insert into %s", h.name)
+	}
+	return nil
+}
+
+func (h *History) List(ctx context.Context, key string, maxVersion int64, limit int) ([]*xdb.HistoryRow, error) {
+	query := "SELECT " + historyColumns + " FROM `" + h.name + "` WHERE `pk` = ?"
+	args := []interface{}{key}
+	if maxVersion > 0 {
+		query += " AND `version` <= ?"
+		args = append(args, maxVersion)
+	}
+	query += " ORDER BY `id` DESC"
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := h.client.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to query %s", h.name)
+	}
+	defer rows.Close()
+
+	var ret []*xdb.HistoryRow
+	for rows.Next() {
+		r := &xdb.HistoryRow{}
+		var lifecycle int8
+		var changes []byte
+		var ctime int64
+		if err = rows.Scan(&r.Key, &r.Version, &lifecycle, &changes, &r.Diff, &r.Data, &ctime, &r.Origin); err != nil {
+			return nil, errors.Wrapf(err, "failed to scan %s", h.name)
+		}
+		if err = r.Changes.UnmarshalBinary(changes); err != nil {
+			return nil, errors.Wrapf(err, "failed to scan %s", h.name)
+		}
+		r.Lifecycle, r.Time = xdb.Lifecycle(lifecycle), time.UnixMilli(ctime)
+		ret = append(ret, r)
+	}
+	return ret, errors.Wrapf(rows.Err(), "failed to query %s", h.name)
+}
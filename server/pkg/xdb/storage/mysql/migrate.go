@@ -0,0 +1,329 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"lucky/server/pkg/xdb"
+)
+
+// columnDef 列定义，typ 为列类型，与 information_schema.COLUMNS.COLUMN_TYPE 比较
+type columnDef struct {
+	name  string
+	typ   string
+	extra string
+}
+
+func (c *columnDef) String() string {
+	return "`" + c.name + "` " + c.typ + " " + c.extra
+}
+
+// indexDef 索引定义
+type indexDef struct {
+	columns []string
+	unique  bool
+}
+
+// schema 表结构，主键不在 indexes 中
+type schema struct {
+	table   string
+	columns []*columnDef
+	primary []string
+	indexes map[string]*indexDef
+	order   []string // 索引名，按定义顺序
+}
+
+// Migrate 比较数据源与数据库中的表结构，新增表、列和索引为非破坏性变更；
+// 删除列、修改列类型和修改主键为破坏性变更，数据库中多出的索引被忽略
+func (d *Dao) Migrate(ctx context.Context, srcs []*xdb.Source, opts *xdb.MigrateOptions) ([]*xdb.SchemaChange, error) {
+	var changes []*xdb.SchemaChange
+	seen := map[string]bool{}
+	for _, src := range srcs {
+		if src.TableName == "" || seen[src.TableName] {
+			continue
+		}
+		seen[src.TableName] = true
+
+		want, err := schemaOf(src)
+		if err != nil {
+			return nil, err
+		}
+		have, err := d.loadSchema(ctx, src.TableName)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, diffSchema(want, have)...)
+	}
+
+	if opts.DryRun {
+		return changes, nil
+	}
+
+	if !opts.AllowDestructive {
+		var destructive []string
+		for _, change := range changes {
+			if change.Destructive {
+				destructive = append(destructive, change.SQL)
+			}
+		}
+		if len(destructive) > 0 {
+			return changes, errors.Wrapf(xdb.ErrDestructive, "%s", strings.Join(destructive, "; "))
+		}
+	}
+
+	for _, change := range changes {
+		if _, err := d.client.ExecContext(ctx, change.SQL); err != nil {
+			return changes, errors.Wrapf(err, "SQL: %s", change.SQL)
+		}
+	}
+	return changes, nil
+}
+
+// loadSchema 从 information_schema 读取当前库中的表结构，表不存在时返回 nil
+func (d *Dao) loadSchema(ctx context.Context, table string) (*schema, error) {
+	rows, err := d.client.QueryContext(ctx,
+		"SELECT COLUMN_NAME, COLUMN_TYPE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION", table)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query columns")
+	}
+	defer rows.Close()
+
+	s := &schema{table: table, indexes: map[string]*indexDef{}}
+	for rows.Next() {
+		c := &columnDef{}
+		if err = rows.Scan(&c.name, &c.typ); err != nil {
+			return nil, errors.Wrap(err, "failed to scan column")
+		}
+		s.columns = append(s.columns, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to query columns")
+	}
+	if len(s.columns) == 0 {
+		return nil, nil
+	}
+
+	rows, err = d.client.QueryContext(ctx,
+		"SELECT INDEX_NAME, NON_UNIQUE, COLUMN_NAME FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY INDEX_NAME, SEQ_IN_INDEX", table)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query indexes")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, column string
+		var nonUnique int
+		if err = rows.Scan(&name, &nonUnique, &column); err != nil {
+			return nil, errors.Wrap(err, "failed to scan index")
+		}
+		if name == "PRIMARY" {
+			s.primary = append(s.primary, column)
+			continue
+		}
+		index, ok := s.indexes[name]
+		if !ok {
+			index = &indexDef{unique: nonUnique == 0}
+			s.indexes[name] = index
+			s.order = append(s.order, name)
+		}
+		index.columns = append(index.columns, column)
+	}
+	return s, errors.Wrap(rows.Err(), "failed to query indexes")
+}
+
+// schemaOf 数据源期望的表结构，列与布局一致，列类型与 protoc-gen-xdb 生成的建表语句一致
+func schemaOf(src *xdb.Source) (*schema, error) {
+	if src.ProtoType == nil {
+		return nil, errors.Errorf("missing proto type of %s", src.Namespace)
+	}
+
+	keys, err := src.KeyColumns()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &schema{table: src.TableName, indexes: map[string]*indexDef{}}
+	for _, key := range keys {
+		s.primary = append(s.primary, key.Name)
+	}
+
+	for _, column := range src.Columns() {
+		c := &columnDef{name: column.Name}
+		if column.Encoded {
+			c.typ, c.extra = kindType(protoreflect.MessageKind)
+		} else {
+			c.typ, c.extra = goType(column.Type)
+		}
+		s.columns = append(s.columns, c)
+	}
+	if src.Versioned {
+		s.columns = append(s.columns, &columnDef{name: xdb.VersionColumn, typ: "BIGINT(20)", extra: "NOT NULL DEFAULT 0"})
+	}
+
+	for _, index := range src.Indexes {
+		s.indexes[index.Name] = &indexDef{columns: index.Columns, unique: index.Unique}
+		s.order = append(s.order, index.Name)
+	}
+	return s, nil
+}
+
+func kindType(kind protoreflect.Kind) (string, string) {
+	switch kind {
+	case protoreflect.FloatKind:
+		return "FLOAT", "NOT NULL"
+	case protoreflect.DoubleKind:
+		return "DOUBLE", "NOT NULL"
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return "BIGINT(20)", "NOT NULL DEFAULT 0"
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind, protoreflect.EnumKind:
+		return "INT(11)", "NOT NULL DEFAULT 0"
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return "BIGINT(20) UNSIGNED", "NOT NULL DEFAULT 0"
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return "INT(11) UNSIGNED", "NOT NULL DEFAULT 0"
+	case protoreflect.BoolKind:
+		return "TINYINT", "NOT NULL DEFAULT 0"
+	case protoreflect.StringKind:
+		return "VARCHAR(255)", "NOT NULL DEFAULT ''"
+	case protoreflect.BytesKind:
+		return "BLOB", "NOT NULL"
+	}
+	return "TEXT", "NOT NULL"
+}
+
+func goType(typ reflect.Type) (string, string) {
+	switch typ.Kind() {
+	case reflect.Float32:
+		return kindType(protoreflect.FloatKind)
+	case reflect.Float64:
+		return kindType(protoreflect.DoubleKind)
+	case reflect.Int64:
+		return kindType(protoreflect.Int64Kind)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return kindType(protoreflect.Int32Kind)
+	case reflect.Uint64:
+		return kindType(protoreflect.Uint64Kind)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return kindType(protoreflect.Uint32Kind)
+	case reflect.Bool:
+		return kindType(protoreflect.BoolKind)
+	case reflect.String:
+		return kindType(protoreflect.StringKind)
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return kindType(protoreflect.BytesKind)
+		}
+	}
+	return kindType(protoreflect.MessageKind)
+}
+
+// diffSchema 生成把 have 变为 want 的语句，have 为 nil 时建表
+func diffSchema(want *schema, have *schema) []*xdb.SchemaChange {
+	if have == nil {
+		return []*xdb.SchemaChange{{Table: want.table, SQL: createSQL(want)}}
+	}
+
+	var changes []*xdb.SchemaChange
+	alter := func(destructive bool, format string, args ...interface{}) {
+		changes = append(changes, &xdb.SchemaChange{
+			Table:       want.table,
+			SQL:         fmt.Sprintf("ALTER TABLE `%s` ", want.table) + fmt.Sprintf(format, args...),
+			Destructive: destructive,
+		})
+	}
+
+	existing := make(map[string]*columnDef, len(have.columns))
+	for _, c := range have.columns {
+		existing[c.name] = c
+	}
+
+	wanted := make(map[string]bool, len(want.columns))
+	for i, c := range want.columns {
+		wanted[c.name] = true
+		old, ok := existing[c.name]
+		switch {
+		case !ok && i == 0:
+			alter(false, "ADD COLUMN %s FIRST", c)
+		case !ok:
+			alter(false, "ADD COLUMN %s AFTER `%s`", c, want.columns[i-1].name)
+		case normalizeType(old.typ) != normalizeType(c.typ):
+			alter(true, "MODIFY COLUMN %s", c)
+		}
+	}
+	for _, c := range have.columns {
+		if !wanted[c.name] {
+			alter(true, "DROP COLUMN `%s`", c.name)
+		}
+	}
+
+	if !sameColumns(want.primary, have.primary) && len(want.primary) > 0 {
+		if len(have.primary) == 0 {
+			alter(false, "ADD PRIMARY KEY (%s)", quote(want.primary))
+		} else {
+			alter(true, "DROP PRIMARY KEY, ADD PRIMARY KEY (%s)", quote(want.primary))
+		}
+	}
+
+	for _, name := range want.order {
+		index := want.indexes[name]
+		old, ok := have.indexes[name]
+		switch {
+		case !ok:
+			alter(false, "ADD %s", indexClause(name, index))
+		case old.unique != index.unique || !sameColumns(old.columns, index.columns):
+			alter(true, "DROP INDEX `%s`, ADD %s", name, indexClause(name, index))
+		}
+	}
+	return changes
+}
+
+// createSQL 建表语句，与 protoc-gen-xdb 生成的语句一致
+func createSQL(s *schema) string {
+	var defs []string
+	for _, c := range s.columns {
+		defs = append(defs, c.String())
+	}
+	if len(s.primary) > 0 {
+		defs = append(defs, "PRIMARY KEY("+quote(s.primary)+")")
+	}
+	for _, name := range s.order {
+		defs = append(defs, indexClause(name, s.indexes[name]))
+	}
+	return fmt.Sprintf("CREATE TABLE `%s` (\n    %s\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4", s.table, strings.Join(defs, ",\n    "))
+}
+
+func indexClause(name string, index *indexDef) string {
+	if index.unique {
+		return fmt.Sprintf("UNIQUE INDEX `%s` (%s)", name, quote(index.columns))
+	}
+	return fmt.Sprintf("INDEX `%s` (%s)", name, quote(index.columns))
+}
+
+func quote(columns []string) string {
+	return "`" + strings.Join(columns, "`, `") + "`"
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// displayWidth 整数类型的显示宽度，MySQL 8.0.19 之后的 COLUMN_TYPE 不再包含
+var displayWidth = regexp.MustCompile(`^(tinyint|smallint|mediumint|int|bigint)\(\d+\)`)
+
+func normalizeType(typ string) string {
+	return displayWidth.ReplaceAllString(strings.ToLower(strings.TrimSpace(typ)), "$1")
+}
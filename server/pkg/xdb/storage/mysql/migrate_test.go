@@ -0,0 +1,59 @@
+package mysql
+
+import (
+	"testing"
+
+	"lucky/server/gen/db"
+)
+
+func TestDiffSchema(t *testing.T) {
+	want, err := schemaOf((&db.ItemRecord{}).Source())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := diffSchema(want, nil)
+	expected := "CREATE TABLE `item` (\n    `player_id` BIGINT(20) NOT NULL DEFAULT 0,\n    `item_id` INT(11) NOT NULL DEFAULT 0,\n" +
+		"    `count` BIGINT(20) NOT NULL DEFAULT 0,\n    `ctime` BIGINT(20) NOT NULL DEFAULT 0,\n    `mtime` BIGINT(20) NOT NULL DEFAULT 0,\n" +
+		"    `_version` BIGINT(20) NOT NULL DEFAULT 0,\n    PRIMARY KEY(`player_id`, `item_id`)\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+	if len(changes) != 1 || changes[0].SQL != expected {
+		t.Fatalf("unexpected create: %+v", changes)
+	}
+
+	// MySQL 8 的 COLUMN_TYPE 没有显示宽度，不视为变更
+	have := &schema{
+		table: "item",
+		columns: []*columnDef{
+			{name: "player_id", typ: "bigint"},
+			{name: "item_id", typ: "bigint"},
+			{name: "ctime", typ: "bigint(20)"},
+			{name: "mtime", typ: "bigint"},
+			{name: "_version", typ: "bigint"},
+			{name: "legacy", typ: "int"},
+		},
+		primary: []string{"player_id"},
+	}
+	var sqls []string
+	var destructive int
+	for _, change := range diffSchema(want, have) {
+		sqls = append(sqls, change.SQL)
+		if change.Destructive {
+			destructive++
+		}
+	}
+
+	expectedSQLs := []string{
+		"ALTER TABLE `item` MODIFY COLUMN `item_id` INT(11) NOT NULL DEFAULT 0",
+		"ALTER TABLE `item` ADD COLUMN `count` BIGINT(20) NOT NULL DEFAULT 0 AFTER `item_id`",
+		"ALTER TABLE `item` DROP COLUMN `legacy`",
+		"ALTER TABLE `item` DROP PRIMARY KEY, ADD PRIMARY KEY (`player_id`, `item_id`)",
+	}
+	if len(sqls) != len(expectedSQLs) || destructive != 3 {
+		t.Fatalf("unexpected changes: %q", sqls)
+	}
+	for i := range sqls {
+		if sqls[i] != expectedSQLs[i] {
+			t.Errorf("change %d: expected %q, got %q", i, expectedSQLs[i], sqls[i])
+		}
+	}
+}
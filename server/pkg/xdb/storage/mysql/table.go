@@ -5,36 +5,22 @@ import (
 	"database/sql"
 	"fmt"
 	"reflect"
-	"strings"
+	"regexp"
 	"time"
-	"unicode"
 
 	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/sqlbuilder"
 
+	clog "github.com/cherry-game/cherry/logger"
+	"github.com/go-sql-driver/mysql"
 	"github.com/pkg/errors"
 )
 
-// toSnakeCase 将驼峰命名转换为蛇形命名
-func toSnakeCase(s string) string {
-	var result strings.Builder
-	for i, r := range s {
-		if unicode.IsUpper(r) {
-			if i > 0 {
-				result.WriteByte('_')
-			}
-			result.WriteRune(unicode.ToLower(r))
-		} else {
-			result.WriteRune(r)
-		}
-	}
-	return result.String()
-}
-
 // Table MySQL 表实现
 type Table struct {
-	src       *xdb.Source
-	dao       *Dao
-	sqlFields string
+	src     *xdb.Source
+	dao     *Dao
+	builder *sqlbuilder.Builder
 }
 
 func (tm *TableMgr) CreateTable(dao *Dao, src *xdb.Source) *Table {
@@ -46,274 +32,149 @@ func (tm *TableMgr) CreateTable(dao *Dao, src *xdb.Source) *Table {
 		return table
 	}
 
-	// 构建 SQL 字段列表
-	var buf strings.Builder
-	names := src.FieldNames(false)
-	// 如果 Fields 为空，使用反射获取字段名
-	if len(names) == 0 {
-		// 从 ProtoType 获取字段名
-		protoType := src.ProtoType
-		if protoType != nil {
-			for i := 0; i < protoType.NumField(); i++ {
-				field := protoType.Field(i)
-				// 跳过未导出的字段和特殊字段（如 state, sizeCache, unknownFields, XVersion）
-				if !field.IsExported() ||
-					field.Name == "state" ||
-					field.Name == "sizeCache" ||
-					field.Name == "unknownFields" ||
-					field.Name == "XVersion" {
-					continue
-				}
-				if buf.Len() > 0 {
-					buf.WriteString(", ")
-				}
-				buf.WriteString("`")
-				// 转换字段名为数据库字段名（snake_case）
-				dbName := toSnakeCase(field.Name)
-				buf.WriteString(dbName)
-				buf.WriteString("`")
-			}
-		}
-	} else {
-		for i, name := range names {
-			if i > 0 {
-				buf.WriteString(", ")
-			}
-			buf.WriteString("`")
-			buf.WriteString(name)
-			buf.WriteString("`")
-		}
-	}
-
 	table := &Table{
-		src:       src,
-		dao:       dao,
-		sqlFields: buf.String(),
+		src:     src,
+		dao:     dao,
+		builder: sqlbuilder.New(sqlbuilder.MySQL, src),
 	}
 	tm.tables[tableName] = table
 	return table
 }
 
 func (t *Table) Recover(ctx context.Context, commitments []xdb.Commitment) error {
-	// 恢复逻辑：从重做日志恢复数据
-	// 这里可以添加恢复逻辑
-	_ = t.Save(ctx, commitments, 5*time.Second, 100*time.Millisecond, func() bool { return true })
+	// 写入被中断时返回错误，分段文件保留到下次恢复
+	if !t.save(ctx, commitments, 5*time.Second, xdb.RetryInterval, func() bool { return ctx.Err() == nil }, true) {
+		return errors.Errorf("redo recover of [%s] interrupted", t.src.Namespace)
+	}
 	return nil
 }
 
 func (t *Table) Save(ctx context.Context, commitments []xdb.Commitment, writeTimeout time.Duration, retryInterval time.Duration, running func() bool) bool {
+	return t.save(ctx, commitments, writeTimeout, retryInterval, running, false)
+}
+
+// save replay 为 true 时是重放 redo 日志，中断前可能已经入库的新建不视为冲突
+func (t *Table) save(ctx context.Context, commitments []xdb.Commitment, writeTimeout time.Duration, retryInterval time.Duration, running func() bool, replay bool) bool {
 	if len(commitments) == 0 {
 		return true
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
-	defer cancel()
-
-	for _, commitment := range commitments {
-		if !running() {
-			return false
-		}
-
-		data, _ := commitment.PrepareWrite()
-		lifecycle := commitment.Lifecycle()
-
-		// 构建 SQL 语句
-		var sql string
-		var args []interface{}
-
-		switch lifecycle {
-		case xdb.LifecycleNew:
-			// INSERT
-			sql, args = t.buildInsertSQL(data)
-		case xdb.LifecycleNormal:
-			// UPDATE
-			sql, args = t.buildUpdateSQL(commitment, data)
-		case xdb.LifecycleDeleted:
-			// DELETE
-			sql, args = t.buildDeleteSQL(commitment)
-		default:
-			continue
-		}
-
-		_, err := t.dao.client.ExecContext(ctx, sql, args...)
-		if err != nil {
-			// 记录错误，但继续处理其他记录
-			fmt.Printf("Failed to save commitment: %v, SQL: %s, Args: %v\n", err, sql, args)
-			continue
+	// 整批在一个事务中写入，失败时回滚并逐个写入，由 SaveOne 对每个提交重试或写入死信
+	if batchable(commitments) {
+		conflicts, err := t.saveBatch(ctx, commitments, writeTimeout)
+		if err == nil {
+			// 版本冲突的提交没有写入，逐个处理
+			commitments = conflicts
+		} else if isDupEntry(err) {
+			// 新建违反主键或唯一约束，逐个写入后由冲突的提交返回 DupError
+			clog.Infof("[xdb] mysql batch insert duplicated, fallback to single writes. [ns = %s, size = %d, err = %v]", t.src.Namespace, len(commitments), err)
+		} else {
+			clog.Warnf("[xdb] mysql batch save failed, fallback to single writes. [ns = %s, size = %d, err = %v]", t.src.Namespace, len(commitments), err)
 		}
 	}
 
-	return true
+	return t.saveEach(ctx, commitments, writeTimeout, retryInterval, running, replay)
 }
 
-func (t *Table) buildInsertSQL(data interface{}) (string, []interface{}) {
-	// 从 data 中提取字段值
-	protoType := t.src.ProtoType
-	if protoType == nil {
-		return "", nil
-	}
-
-	// 使用反射获取字段值
-	val := reflect.ValueOf(data)
-	if val.Kind() == reflect.Ptr {
-		val = val.Elem()
-	}
-
-	var fields []string
-	var placeholders []string
-	var args []interface{}
-
-	for i := 0; i < protoType.NumField(); i++ {
-		field := protoType.Field(i)
-		// 跳过特殊字段
-		if !field.IsExported() ||
-			field.Name == "state" ||
-			field.Name == "sizeCache" ||
-			field.Name == "unknownFields" ||
-			field.Name == "XVersion" {
-			continue
-		}
-
-		dbName := toSnakeCase(field.Name)
-		fields = append(fields, "`"+dbName+"`")
-		placeholders = append(placeholders, "?")
+// saveEach 逐个写入提交
+func (t *Table) saveEach(ctx context.Context, commitments []xdb.Commitment, writeTimeout time.Duration, retryInterval time.Duration, running func() bool, replay bool) bool {
+	for _, commitment := range commitments {
+		c := commitment
+		ok := xdb.SaveOne(ctx, c, writeTimeout, retryInterval, running, func(ctx context.Context) error {
+			for attempt := 1; ; attempt++ {
+				conflict, err := t.saveOne(ctx, c)
+				if err != nil && replay && isDupEntry(err) && t.applied(ctx, c) {
+					clog.Infof("[xdb] mysql replayed insert already applied. [ns = %s, pk = %s]", t.src.Namespace, xdb.PKOf(c))
+					return nil
+				}
+				if err != nil {
+					return t.classify(err)
+				}
 
-		// 获取字段值
-		fieldVal := val.Field(i)
-		if fieldVal.IsValid() {
-			args = append(args, fieldVal.Interface())
-		} else {
-			args = append(args, nil)
+				// 版本冲突时由数据源的冲突回调决定是否以最新版本重试
+				if !conflict || !xdb.ResolveConflict(ctx, c, attempt) {
+					return nil
+				}
+			}
+		})
+		if !ok {
+			return false
 		}
 	}
 
-	sql := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s)",
-		t.src.TableName,
-		strings.Join(fields, ", "),
-		strings.Join(placeholders, ", "))
-	return sql, args
+	return true
 }
 
-func (t *Table) buildUpdateSQL(commitment xdb.Commitment, data interface{}) (string, []interface{}) {
-	// 构建 UPDATE SQL
-	pk := commitment.Source().PKOf(commitment)
-	if pk == nil {
-		return "", nil
-	}
-
-	// 获取变更的字段
-	changes := commitment.Changes()
-	if changes == 0 {
-		return "", nil
-	}
-
-	// 从 data 中提取字段值
-	protoType := t.src.ProtoType
-	if protoType == nil {
-		return "", nil
-	}
-
-	val := reflect.ValueOf(data)
-	if val.Kind() == reflect.Ptr {
-		val = val.Elem()
-	}
+// saveOne 写入单个提交，启用乐观锁时没有命中期望版本的行视为冲突
+func (t *Table) saveOne(ctx context.Context, commitment xdb.Commitment) (bool, error) {
+	data, _ := commitment.PrepareWrite()
+	lifecycle := commitment.Lifecycle()
 
-	var setParts []string
+	// 构建 SQL 语句
+	var sql string
 	var args []interface{}
 
-	// 根据 Source 的 Fields 信息构建 SET 子句
-	for i := 0; i < protoType.NumField(); i++ {
-		field := protoType.Field(i)
-		// 跳过特殊字段
-		if !field.IsExported() ||
-			field.Name == "state" ||
-			field.Name == "sizeCache" ||
-			field.Name == "unknownFields" ||
-			field.Name == "XVersion" {
-			continue
-		}
-
-		// 检查字段是否在变更集合中（简化处理：假设所有字段都变更）
-		// TODO: 根据 FieldSet 精确判断哪些字段变更了
-		dbName := toSnakeCase(field.Name)
-		setParts = append(setParts, "`"+dbName+"` = ?")
-
-		fieldVal := val.Field(i)
-		if fieldVal.IsValid() {
-			args = append(args, fieldVal.Interface())
-		} else {
-			args = append(args, nil)
-		}
+	var err error
+	switch lifecycle {
+	case xdb.LifecycleNew:
+		// INSERT
+		sql, args, err = t.builder.Insert(commitment, data)
+	case xdb.LifecycleNormal:
+		// UPDATE
+		sql, args, err = t.builder.Update(commitment, data)
+	case xdb.LifecycleDeleted:
+		// DELETE
+		sql, args = t.builder.Delete(commitment)
+	default:
+		return false, nil
 	}
 
-	// 构建 WHERE 子句（主键）
-	whereClause, pkArgs := t.buildWhereClause(pk)
-	args = append(args, pkArgs...)
-
-	sql := fmt.Sprintf("UPDATE `%s` SET %s WHERE %s",
-		t.src.TableName,
-		strings.Join(setParts, ", "),
-		whereClause)
-	return sql, args
-}
-
-func (t *Table) buildWhereClause(pk xdb.PK) (string, []interface{}) {
-	// 使用反射获取主键值
-	pkType := reflect.TypeOf(pk)
-	if pkType.Kind() == reflect.Ptr {
-		pkType = pkType.Elem()
+	if err != nil {
+		return false, xdb.Permanent(err)
 	}
-	pkVal := reflect.ValueOf(pk)
-	if pkVal.Kind() == reflect.Ptr {
-		pkVal = pkVal.Elem()
+	if sql == "" {
+		return false, nil
 	}
 
-	// 获取第一个字段作为主键值（简化处理）
-	if pkType.NumField() > 0 {
-		field := pkType.Field(0)
-		fieldVal := pkVal.Field(0)
-		if fieldVal.IsValid() {
-			dbName := toSnakeCase(field.Name)
-			return "`" + dbName + "` = ?", []interface{}{fieldVal.Interface()}
-		}
+	result, err := t.dao.client.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return false, errors.Wrapf(err, "SQL: %s, Args: %v", sql, args)
 	}
 
-	return "", nil
+	return t.conflicted(commitment, result)
 }
 
-func (t *Table) buildDeleteSQL(commitment xdb.Commitment) (string, []interface{}) {
-	// 构建 DELETE SQL
-	pk := commitment.Source().PKOf(commitment)
-	if pk == nil {
-		return "", nil
+// applied 重放的新建违反主键时，已有的行版本号相同说明中断前已经入库
+func (t *Table) applied(ctx context.Context, commitment xdb.Commitment) bool {
+	_, version, versioned := xdb.VersionOf(commitment)
+	if !versioned || commitment.Lifecycle() != xdb.LifecycleNew {
+		return false
 	}
 
-	whereClause, args := t.buildWhereClause(pk)
-	if whereClause == "" {
-		return "", nil
+	sql, args := t.builder.Version(xdb.PKOf(commitment))
+	if sql == "" {
+		return false
 	}
 
-	sql := fmt.Sprintf("DELETE FROM `%s` WHERE %s", t.src.TableName, whereClause)
-	return sql, args
+	var current int64
+	if err := t.dao.client.QueryRowContext(ctx, sql, args...).Scan(&current); err != nil {
+		return false
+	}
+	return current == version
 }
 
 func (t *Table) Fetch(ctx context.Context, onlyOne bool, pk xdb.PK) (xdb.RecordCursor, error) {
-	whereClause, args := t.buildWhereClause(pk)
-	if whereClause == "" {
+	sql, args := t.builder.Fetch(onlyOne, pk)
+	if sql == "" {
 		return nil, errors.New("failed to build where clause")
 	}
 
-	sql := fmt.Sprintf("SELECT %s FROM `%s` WHERE %s", t.sqlFields, t.src.TableName, whereClause)
-	if onlyOne {
-		sql += " LIMIT 1"
-	}
-
 	rows, err := t.dao.client.QueryContext(ctx, sql, args...)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to fetch from MySQL")
 	}
 
-	return newRecordCursor(rows, t.src), nil
+	return newRecordCursor(rows, t), nil
 }
 
 func (t *Table) FetchMulti(ctx context.Context, pks []xdb.PK) (xdb.RecordCursor, error) {
@@ -321,53 +182,59 @@ func (t *Table) FetchMulti(ctx context.Context, pks []xdb.PK) (xdb.RecordCursor,
 		return &RecordCursor{rows: nil, src: t.src}, nil
 	}
 
-	// 构建 IN 查询
-	placeholders := make([]string, len(pks))
-	args := make([]interface{}, len(pks))
-	for i, pk := range pks {
-		placeholders[i] = "?"
-		args[i] = pk.Full()
+	// 联合主键使用行构造器 (a, b) IN ((?, ?), ...)
+	sql, args := t.builder.FetchMulti(pks)
+	if sql == "" {
+		return nil, errors.New("failed to build where clause")
 	}
 
-	sql := fmt.Sprintf("SELECT %s FROM `%s` WHERE `player_id` IN (%s)",
-		t.sqlFields, t.src.TableName, strings.Join(placeholders, ", "))
-
 	rows, err := t.dao.client.QueryContext(ctx, sql, args...)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to fetch multi from MySQL")
 	}
 
-	return newRecordCursor(rows, t.src), nil
+	return newRecordCursor(rows, t), nil
 }
 
 func (t *Table) Find(ctx context.Context, filter interface{}) (xdb.RecordCursor, error) {
-	// 简化实现：假设 filter 是 SQL WHERE 子句
-	where := "1=1"
-	args := []interface{}{}
-
-	if filter != nil {
-		// TODO: 根据 filter 类型构建 WHERE 子句
+	sql, args, err := t.builder.Find(filter)
+	if err != nil {
+		return nil, err
 	}
 
-	sql := fmt.Sprintf("SELECT %s FROM `%s` WHERE %s", t.sqlFields, t.src.TableName, where)
 	rows, err := t.dao.client.QueryContext(ctx, sql, args...)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to find from MySQL")
 	}
 
-	return newRecordCursor(rows, t.src), nil
+	return newRecordCursor(rows, t), nil
+}
+
+func (t *Table) Count(ctx context.Context, filter interface{}) (int64, error) {
+	sql, args, err := t.builder.Count(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if err = t.dao.client.QueryRowContext(ctx, sql, args...).Scan(&count); err != nil {
+		return 0, errors.Wrap(err, "failed to count from MySQL")
+	}
+	return count, nil
 }
 
 // RecordCursor MySQL 记录游标
 type RecordCursor struct {
-	rows *sql.Rows
-	src  *xdb.Source
+	rows    *sql.Rows
+	src     *xdb.Source
+	columns []*xdb.Column
 }
 
-func newRecordCursor(rows *sql.Rows, src *xdb.Source) *RecordCursor {
+func newRecordCursor(rows *sql.Rows, table *Table) *RecordCursor {
 	return &RecordCursor{
-		rows: rows,
-		src:  src,
+		rows:    rows,
+		src:     table.src,
+		columns: table.builder.Columns,
 	}
 }
 
@@ -496,6 +363,15 @@ func (r *RecordCursor) Decode(val interface{}) error {
 		}
 	}
 
+	// 乐观锁版本号不在 proto 的持久化字段中，单独写回
+	if colIdx, ok := indexOf(columns, xdb.VersionColumn); ok && values[colIdx] != nil {
+		if vs, ok := val.(xdb.VersionSetter); ok {
+			if version, ok := toInt64(values[colIdx]); ok {
+				vs.SetXVersion(version)
+			}
+		}
+	}
+
 	if !targetVal.IsValid() || protoType == nil {
 		return errors.New(fmt.Sprintf("failed to find target proto type for decoding, valType: %v, RecordType: %v", valType, r.src.RecordType))
 	}
@@ -506,31 +382,11 @@ func (r *RecordCursor) Decode(val interface{}) error {
 		columnMap[col] = i
 	}
 
-	// 填充字段值
-	for i := 0; i < protoType.NumField(); i++ {
-		field := protoType.Field(i)
-		if !field.IsExported() ||
-			field.Name == "state" ||
-			field.Name == "sizeCache" ||
-			field.Name == "unknownFields" ||
-			field.Name == "XVersion" {
-			continue
-		}
-
-		dbName := toSnakeCase(field.Name)
-		if colIdx, ok := columnMap[dbName]; ok {
-			fieldVal := targetVal.Field(i)
-			if fieldVal.IsValid() && fieldVal.CanSet() {
-				value := values[colIdx]
-				if value != nil {
-					// 类型转换
-					rv := reflect.ValueOf(value)
-					if rv.Type().AssignableTo(fieldVal.Type()) {
-						fieldVal.Set(rv)
-					} else if rv.Type().ConvertibleTo(fieldVal.Type()) {
-						fieldVal.Set(rv.Convert(fieldVal.Type()))
-					}
-				}
+	// 填充字段值，子消息按布局展开或解码
+	for _, column := range r.columns {
+		if colIdx, ok := columnMap[column.Name]; ok {
+			if err := column.SetValue(targetVal, values[colIdx]); err != nil {
+				return err
 			}
 		}
 	}
@@ -553,3 +409,68 @@ func (r *RecordCursor) Close(ctx context.Context) error {
 	}
 	return r.rows.Close()
 }
+
+// permanentErrors 数据本身导致的错误码，重试也不会成功
+var permanentErrors = map[uint16]struct{}{
+	1048: {}, // ER_BAD_NULL_ERROR
+	1054: {}, // ER_BAD_FIELD_ERROR
+	1062: {}, // ER_DUP_ENTRY
+	1136: {}, // ER_WRONG_VALUE_COUNT_ON_ROW
+	1146: {}, // ER_NO_SUCH_TABLE
+	1264: {}, // ER_WARN_DATA_OUT_OF_RANGE
+	1292: {}, // ER_TRUNCATED_WRONG_VALUE
+	1366: {}, // ER_TRUNCATED_WRONG_VALUE_FOR_FIELD
+	1406: {}, // ER_DATA_TOO_LONG
+	1452: {}, // ER_NO_REFERENCED_ROW_2
+}
+
+// dupEntry ER_DUP_ENTRY 的错误信息，MySQL 8.0 的索引名带表名前缀
+var dupEntry = regexp.MustCompile(`^Duplicate entry '(.*)' for key '(?:[^']*\.)?([^'.]*)'$`)
+
+// classify 区分永久错误和临时错误，连接、超时、死锁等错误可以重试，违反唯一约束时返回 *xdb.DupError
+func (t *Table) classify(err error) error {
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		if m := dupEntry.FindStringSubmatch(me.Message); me.Number == 1062 && m != nil {
+			return xdb.Permanent(&xdb.DupError{Namespace: t.src.Namespace, Index: m[2], Value: m[1]})
+		}
+		if _, ok := permanentErrors[me.Number]; ok {
+			return xdb.Permanent(err)
+		}
+	}
+	return err
+}
+
+// isDupEntry 是否违反主键或唯一约束
+func isDupEntry(err error) bool {
+	var me *mysql.MySQLError
+	return errors.As(err, &me) && me.Number == 1062
+}
+
+func indexOf(columns []string, name string) (int, bool) {
+	for i, col := range columns {
+		if col == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int32:
+		return int64(n), true
+	case int:
+		return int64(n), true
+	case uint64:
+		return int64(n), true
+	case []byte:
+		var ret int64
+		_, err := fmt.Sscan(string(n), &ret)
+		return ret, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,300 @@
+package sqlbuilder
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"lucky/server/pkg/xdb"
+)
+
+// Builder 按数据源生成表的 SQL 语句，列与 protoc-gen-xdb 生成的 MySQL 表结构一致
+type Builder struct {
+	*Dialect
+	Src     *xdb.Source
+	Columns []*xdb.Column        // 子消息按布局展开或编码
+	Keys    []*xdb.KeyColumn     // 主键列，驱动的 Validate 保证可以推导
+	Fields  string               // 查询的列，启用乐观锁时最后一列为版本号
+	changed map[string]xdb.Field // 顶层字段名对应的字段编号，用于只更新变更的列
+}
+
+// New 按方言创建数据源的语句构造器
+func New(dialect *Dialect, src *xdb.Source) *Builder {
+	b := &Builder{
+		Dialect: dialect,
+		Src:     src,
+		Columns: src.Columns(),
+		changed: make(map[string]xdb.Field, len(src.Fields)),
+	}
+	b.Keys, _ = src.KeyColumns()
+
+	fields := make([]string, 0, len(b.Columns)+1)
+	for _, column := range b.Columns {
+		fields = append(fields, "`"+column.Name+"`")
+	}
+	// 乐观锁版本号列
+	if src.Versioned {
+		fields = append(fields, "`"+xdb.VersionColumn+"`")
+	}
+	b.Fields = strings.Join(fields, ", ")
+
+	for _, field := range src.Fields {
+		if !field.Key && src.FieldSetSave.Contains(field.Code) {
+			b.changed[field.Name] = field.Code
+		}
+	}
+	return b
+}
+
+// Insert 新建记录的 INSERT
+func (b *Builder) Insert(commitment xdb.Commitment, data interface{}) (string, []interface{}, error) {
+	fields, args, err := b.InsertRow(commitment, data)
+	if err != nil || len(fields) == 0 {
+		return "", nil, err
+	}
+
+	query := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s)",
+		b.Src.TableName,
+		strings.Join(fields, ", "),
+		Marks(len(fields)))
+	return query, args, nil
+}
+
+// InsertRow 新建记录的列名（已加引号）和值
+func (b *Builder) InsertRow(commitment xdb.Commitment, data interface{}) ([]string, []interface{}, error) {
+	val := reflect.ValueOf(data)
+	fields := make([]string, 0, len(b.Columns)+1)
+	args := make([]interface{}, 0, len(b.Columns)+1)
+	for _, column := range b.Columns {
+		arg, err := column.Value(val)
+		if err != nil {
+			return nil, nil, err
+		}
+		fields = append(fields, "`"+column.Name+"`")
+		args = append(args, arg)
+	}
+
+	if _, version, ok := xdb.VersionOf(commitment); ok {
+		fields = append(fields, "`"+xdb.VersionColumn+"`")
+		args = append(args, version)
+	}
+
+	return fields, args, nil
+}
+
+// Update 只更新变更的列（通过 Source.Fields 映射），没有字段描述时更新所有列；没有可更新的列时返回空语句
+func (b *Builder) Update(commitment xdb.Commitment, data interface{}) (string, []interface{}, error) {
+	pk := xdb.PKOf(commitment)
+	if pk == nil {
+		return "", nil, nil
+	}
+
+	val := reflect.ValueOf(data)
+	changes := commitment.Changes()
+	var setParts []string
+	var args []interface{}
+
+	for _, column := range b.Columns {
+		if !b.changedColumn(changes, column.Field) {
+			continue
+		}
+		arg, err := column.Value(val)
+		if err != nil {
+			return "", nil, err
+		}
+		setParts = append(setParts, "`"+column.Name+"` = ?")
+		args = append(args, arg)
+	}
+
+	base, version, versioned := xdb.VersionOf(commitment)
+	if versioned {
+		setParts = append(setParts, "`"+xdb.VersionColumn+"` = ?")
+		args = append(args, version)
+	}
+	if len(setParts) == 0 {
+		return "", nil, nil
+	}
+
+	// WHERE 子句（主键，启用乐观锁时附加期望版本）
+	whereClause, pkArgs := b.WherePK(pk)
+	args = append(args, pkArgs...)
+	if versioned {
+		whereClause += " AND `" + xdb.VersionColumn + "` = ?"
+		args = append(args, base)
+	}
+
+	query := fmt.Sprintf("UPDATE `%s` SET %s WHERE %s",
+		b.Src.TableName,
+		strings.Join(setParts, ", "),
+		whereClause)
+	return query, args, nil
+}
+
+// changedColumn 列所属的字段是否变更，主键列不会更新
+func (b *Builder) changedColumn(changes xdb.FieldSet, field string) bool {
+	if len(b.changed) == 0 {
+		return true
+	}
+	code, ok := b.changed[field]
+	return ok && changes.Contains(code)
+}
+
+// Delete 按主键删除，启用乐观锁时附加期望版本
+func (b *Builder) Delete(commitment xdb.Commitment) (string, []interface{}) {
+	pk := xdb.PKOf(commitment)
+	if pk == nil {
+		return "", nil
+	}
+
+	whereClause, args := b.WherePK(pk)
+	if whereClause == "" {
+		return "", nil
+	}
+
+	if base, _, ok := xdb.VersionOf(commitment); ok {
+		whereClause += " AND `" + xdb.VersionColumn + "` = ?"
+		args = append(args, base)
+	}
+
+	return fmt.Sprintf("DELETE FROM `%s` WHERE %s", b.Src.TableName, whereClause), args
+}
+
+// WherePK 按所有主键列匹配，联合主键为 `a` = ? AND `b` = ?
+func (b *Builder) WherePK(pk xdb.PK) (string, []interface{}) {
+	columns, values := b.PKColumns(pk)
+	if len(columns) == 0 {
+		return "", nil
+	}
+
+	parts := make([]string, len(columns))
+	for i, column := range columns {
+		parts[i] = column + " = ?"
+	}
+	return strings.Join(parts, " AND "), values
+}
+
+// PKColumns 主键的列名（已加引号）和值
+func (b *Builder) PKColumns(pk xdb.PK) ([]string, []interface{}) {
+	columns := make([]string, len(b.Keys))
+	for i, key := range b.Keys {
+		columns[i] = "`" + key.Name + "`"
+	}
+	return columns, xdb.KeyValues(pk, b.Keys)
+}
+
+// InPKs 按主键批量匹配，联合主键使用行值 (a, b) IN ((?, ?), ...)
+func (b *Builder) InPKs(pks []xdb.PK) (string, []interface{}) {
+	columns, _ := b.PKColumns(pks[0])
+	if len(columns) == 0 {
+		return "", nil
+	}
+
+	target, placeholder := columns[0], "?"
+	if len(columns) > 1 {
+		target = "(" + strings.Join(columns, ", ") + ")"
+		placeholder = "(" + Marks(len(columns)) + ")"
+	}
+
+	placeholders := make([]string, len(pks))
+	args := make([]interface{}, 0, len(pks)*len(columns))
+	for i, pk := range pks {
+		_, values := b.PKColumns(pk)
+		placeholders[i] = placeholder
+		args = append(args, values...)
+	}
+	return target + " IN (" + strings.Join(placeholders, ", ") + ")", args
+}
+
+// Fetch 按主键查询
+func (b *Builder) Fetch(onlyOne bool, pk xdb.PK) (string, []interface{}) {
+	whereClause, args := b.WherePK(pk)
+	if whereClause == "" {
+		return "", nil
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM `%s` WHERE %s", b.Fields, b.Src.TableName, whereClause)
+	if onlyOne {
+		query += " LIMIT 1"
+	}
+	return query, args
+}
+
+// Version 按主键查询版本号
+func (b *Builder) Version(pk xdb.PK) (string, []interface{}) {
+	whereClause, args := b.WherePK(pk)
+	if whereClause == "" {
+		return "", nil
+	}
+	return fmt.Sprintf("SELECT `%s` FROM `%s` WHERE %s", xdb.VersionColumn, b.Src.TableName, whereClause), args
+}
+
+// FetchMulti 按多个主键查询
+func (b *Builder) FetchMulti(pks []xdb.PK) (string, []interface{}) {
+	whereClause, args := b.InPKs(pks)
+	if whereClause == "" {
+		return "", nil
+	}
+	return fmt.Sprintf("SELECT %s FROM `%s` WHERE %s", b.Fields, b.Src.TableName, whereClause), args
+}
+
+// Find 按条件查询，filter 见 xdb.FilterQuery
+// 分页时按主键列补充排序，顺序确定时 OFFSET 分段读取才不会重复或遗漏
+func (b *Builder) Find(filter interface{}) (string, []interface{}, error) {
+	q, err := xdb.FilterQuery(filter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	where, args, err := Where(q.Filter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	tail, err := b.OrderLimit(b.stable(q))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return fmt.Sprintf("SELECT %s FROM `%s` WHERE %s%s", b.Fields, b.Src.TableName, where, tail), args, nil
+}
+
+// stable 分页查询追加没有参与排序的主键列，排序值相同的行按主键排序
+func (b *Builder) stable(q *xdb.Query) *xdb.Query {
+	if q.Size <= 0 && q.Skip <= 0 {
+		return q
+	}
+
+	sorted := make(map[string]bool, len(q.Sorts))
+	for _, o := range q.Sorts {
+		sorted[o.Field] = true
+	}
+
+	ret := *q
+	ret.Sorts = append([]xdb.Order(nil), q.Sorts...)
+	for _, key := range b.Keys {
+		if !sorted[key.Name] {
+			ret.Sorts = append(ret.Sorts, xdb.Order{Field: key.Name})
+		}
+	}
+	return &ret
+}
+
+// Count 按条件计数
+func (b *Builder) Count(filter interface{}) (string, []interface{}, error) {
+	q, err := xdb.FilterQuery(filter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	where, args, err := Where(q.Filter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return fmt.Sprintf("SELECT COUNT(*) FROM `%s` WHERE %s", b.Src.TableName, where), args, nil
+}
+
+// Marks n 个以逗号分隔的占位符
+func Marks(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,30 @@
+package sqlbuilder
+
+import (
+	"testing"
+
+	"lucky/server/gen/db"
+	"lucky/server/pkg/xdb"
+)
+
+func TestBuilder_FindPageOrder(t *testing.T) {
+	b := New(MySQL, (&db.ItemRecord{}).Source())
+	fields := "SELECT `player_id`, `item_id`, `count`, `ctime`, `mtime`, `_version` FROM `item` WHERE "
+
+	cases := []struct {
+		q    *xdb.Query
+		want string
+	}{
+		// 不分页时不排序
+		{xdb.NewQuery(xdb.Eq("player_id", 1)), "`player_id` = ?"},
+		// 分页时按主键排序，已有的排序之后追加没有参与排序的主键列
+		{xdb.NewQuery(xdb.Eq("player_id", 1)).Limit(10).Offset(5), "`player_id` = ? ORDER BY `player_id` ASC, `item_id` ASC LIMIT 10 OFFSET 5"},
+		{xdb.NewQuery().OrderByDesc("count").OrderBy("player_id").Limit(10), "1=1 ORDER BY `count` DESC, `player_id` ASC, `item_id` ASC LIMIT 10"},
+	}
+	for _, c := range cases {
+		query, _, err := b.Find(c.q)
+		if err != nil || query != fields+c.want {
+			t.Errorf("unexpected find: %s %v", query, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package sqlbuilder
+
+import (
+	"strconv"
+	"strings"
+
+	"lucky/server/pkg/xdb"
+
+	"github.com/pkg/errors"
+)
+
+// Dialect SQL 方言的差异
+type Dialect struct {
+	NoLimit string // OFFSET 必须和 LIMIT 一起使用，只有 OFFSET 时不限制行数的 LIMIT 值
+}
+
+var (
+	MySQL  = &Dialect{NoLimit: "18446744073709551615"}
+	SQLite = &Dialect{NoLimit: "-1"}
+)
+
+// Where 将查询条件翻译为 WHERE 子句
+func Where(c xdb.Cond) (string, []interface{}, error) {
+	var buf strings.Builder
+	var args []interface{}
+	if err := writeCond(&buf, &args, c); err != nil {
+		return "", nil, err
+	}
+	return buf.String(), args, nil
+}
+
+func writeCond(buf *strings.Builder, args *[]interface{}, c xdb.Cond) error {
+	switch c.Op {
+	case xdb.OpNone:
+		buf.WriteString("1=1")
+		return nil
+
+	case xdb.OpAnd, xdb.OpOr:
+		if len(c.Conds) == 0 {
+			// 空的 or 不匹配任何记录
+			if c.Op == xdb.OpOr {
+				buf.WriteString("1=0")
+			} else {
+				buf.WriteString("1=1")
+			}
+			return nil
+		}
+
+		sep := " AND "
+		if c.Op == xdb.OpOr {
+			sep = " OR "
+		}
+		buf.WriteString("(")
+		for i, sub := range c.Conds {
+			if i > 0 {
+				buf.WriteString(sep)
+			}
+			if err := writeCond(buf, args, sub); err != nil {
+				return err
+			}
+		}
+		buf.WriteString(")")
+		return nil
+	}
+
+	column, err := quoteColumn(c.Field)
+	if err != nil {
+		return err
+	}
+
+	switch c.Op {
+	case xdb.OpEq:
+		buf.WriteString(column + " = ?")
+	case xdb.OpGt:
+		buf.WriteString(column + " > ?")
+	case xdb.OpGte:
+		buf.WriteString(column + " >= ?")
+	case xdb.OpLt:
+		buf.WriteString(column + " < ?")
+	case xdb.OpLte:
+		buf.WriteString(column + " <= ?")
+	case xdb.OpIn:
+		if len(c.Values) == 0 {
+			buf.WriteString("1=0")
+			return nil
+		}
+		buf.WriteString(column + " IN (")
+		for i, v := range c.Values {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString("?")
+			*args = append(*args, v)
+		}
+		buf.WriteString(")")
+		return nil
+	default:
+		return errors.Errorf("unsupported query op: %s", c.Op)
+	}
+
+	*args = append(*args, c.Value)
+	return nil
+}
+
+// OrderLimit 将排序和分页翻译为 ORDER BY/LIMIT 子句
+func (d *Dialect) OrderLimit(q *xdb.Query) (string, error) {
+	var buf strings.Builder
+	for i, o := range q.Sorts {
+		column, err := quoteColumn(o.Field)
+		if err != nil {
+			return "", err
+		}
+
+		if i == 0 {
+			buf.WriteString(" ORDER BY ")
+		} else {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(column)
+		if o.Desc {
+			buf.WriteString(" DESC")
+		} else {
+			buf.WriteString(" ASC")
+		}
+	}
+
+	switch {
+	case q.Size > 0:
+		buf.WriteString(" LIMIT " + strconv.Itoa(q.Size))
+	case q.Skip > 0:
+		buf.WriteString(" LIMIT " + d.NoLimit)
+	}
+	if q.Skip > 0 {
+		buf.WriteString(" OFFSET " + strconv.Itoa(q.Skip))
+	}
+
+	return buf.String(), nil
+}
+
+func quoteColumn(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, "`\x00") {
+		return "", errors.Errorf("invalid query field: %q", name)
+	}
+	return "`" + name + "`", nil
+}
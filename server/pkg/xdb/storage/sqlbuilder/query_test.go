@@ -0,0 +1,49 @@
+package sqlbuilder
+
+import (
+	"reflect"
+	"testing"
+
+	"lucky/server/pkg/xdb"
+)
+
+func TestBuildQuery(t *testing.T) {
+	q := xdb.NewQuery(
+		xdb.Eq("name", "a"),
+		xdb.Or(xdb.In("level", 1, 2), xdb.Range("exp", 10, 20)),
+	).OrderByDesc("level").OrderBy("player_id").Limit(10).Offset(5)
+
+	where, args, err := Where(q.Filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "(`name` = ? AND (`level` IN (?, ?) OR (`exp` >= ? AND `exp` < ?)))"
+	if where != expected {
+		t.Errorf("unexpected where: %s", where)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"a", 1, 2, 10, 20}) {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	tail, err := MySQL.OrderLimit(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tail != " ORDER BY `level` DESC, `player_id` ASC LIMIT 10 OFFSET 5" {
+		t.Errorf("unexpected tail: %s", tail)
+	}
+
+	// 只有 OFFSET 时按方言补上不限制行数的 LIMIT
+	if tail, _ = SQLite.OrderLimit(xdb.NewQuery().Offset(5)); tail != " LIMIT -1 OFFSET 5" {
+		t.Errorf("unexpected sqlite tail: %s", tail)
+	}
+
+	if where, _, _ = Where(xdb.In("level")); where != "1=0" {
+		t.Errorf("empty in should match nothing: %s", where)
+	}
+
+	if _, _, err = Where(xdb.Eq("na`me", 1)); err == nil {
+		t.Error("expected invalid field error")
+	}
+}
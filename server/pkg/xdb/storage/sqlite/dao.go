@@ -0,0 +1,101 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	clog "github.com/cherry-game/cherry/logger"
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite"
+
+	"lucky/server/pkg/xdb"
+)
+
+// Dao SQLite 数据访问对象
+type Dao struct {
+	client   *sql.DB
+	opts     *DaoOptions
+	tableMgr *TableMgr
+}
+
+func newDao(ctx context.Context, opts *DaoOptions) (*Dao, error) {
+	if opts.Path == "" {
+		opts.Path = ":memory:"
+	}
+	if opts.QueryTimeout == 0 {
+		opts.QueryTimeout = 5 * time.Second
+	}
+
+	db, err := sql.Open("sqlite", opts.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open SQLite database")
+	}
+
+	// SQLite 只允许一个写入者，内存数据库每个连接都是独立的库，因此只使用一个连接
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+
+	ctx, cancel := context.WithTimeout(ctx, opts.QueryTimeout)
+	defer cancel()
+
+	if _, err = db.ExecContext(ctx, "PRAGMA busy_timeout = 5000"); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to configure SQLite")
+	}
+
+	return &Dao{
+		client:   db,
+		opts:     opts,
+		tableMgr: newTableMgr(),
+	}, nil
+}
+
+func (d *Dao) Table(src *xdb.Source) xdb.Table {
+	return d.tableMgr.CreateTable(d, src)
+}
+
+// Close 关闭数据库
+func (d *Dao) Close() error {
+	return d.client.Close()
+}
+
+// TableMgr 表管理器
+type TableMgr struct {
+	tables map[string]*Table
+	mu     sync.Mutex
+}
+
+func newTableMgr() *TableMgr {
+	return &TableMgr{
+		tables: make(map[string]*Table),
+	}
+}
+
+func (tm *TableMgr) CreateTable(dao *Dao, src *xdb.Source) *Table {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if table, ok := tm.tables[src.TableName]; ok {
+		return table
+	}
+
+	table := newTable(dao, src)
+	if !dao.opts.NoAutoCreate {
+		ctx, cancel := context.WithTimeout(context.Background(), dao.opts.QueryTimeout)
+		defer cancel()
+
+		if _, err := dao.client.ExecContext(ctx, table.createSQL()); err != nil {
+			clog.Errorf("[xdb] sqlite create table failed. [ns = %s, err = %v]", src.Namespace, err)
+		}
+		for _, stmt := range table.indexSQL() {
+			if _, err := dao.client.ExecContext(ctx, stmt); err != nil {
+				clog.Errorf("[xdb] sqlite create index failed. [ns = %s, err = %v]", src.Namespace, err)
+			}
+		}
+	}
+
+	tm.tables[src.TableName] = table
+	return table
+}
@@ -0,0 +1,65 @@
+package sqlite
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+	"lucky/server/pkg/xdb"
+)
+
+// DriverOptions SQLite 驱动选项
+type DriverOptions struct {
+	// 可以添加驱动级别的配置
+}
+
+// DaoOptions SQLite DAO 选项
+type DaoOptions struct {
+	Path         string        // 数据库文件路径，":memory:" 为内存数据库
+	QueryTimeout time.Duration // 查询超时时间
+	NoAutoCreate bool          // true 时不自动建表
+}
+
+var driver = &Driver{}
+
+func init() {
+	xdb.RegisterDriver(driver)
+}
+
+// Driver SQLite 驱动，用于单机开发和集成测试，列映射与 MySQL 表一致
+type Driver struct{}
+
+func (d *Driver) Name() string {
+	return "sqlite"
+}
+
+func (d *Driver) Init(ctx context.Context, config interface{}) error {
+	return nil
+}
+
+func (d *Driver) Validate(src *xdb.Source) error {
+	if src.DriverName != d.Name() {
+		return errors.Errorf("driver name not match: %s against to %s in %s", src.DriverName, d.Name(), src.Namespace)
+	}
+	if src.ProtoType == nil || src.PKType == nil {
+		return errors.Errorf("missing proto or pk type in %s", src.Namespace)
+	}
+	// 更新和删除按完整主键匹配，主键必须能对应到表的列
+	if _, err := src.KeyColumns(); err != nil {
+		return errors.Wrap(err, "invalid primary key")
+	}
+	return nil
+}
+
+func (d *Driver) NewDao(ctx context.Context, option interface{}) (xdb.Dao, error) {
+	opts, ok := option.(*DaoOptions)
+	if !ok {
+		return nil, errors.Errorf("invalid %s options", d.Name())
+	}
+
+	return newDao(ctx, opts)
+}
+
+func (d *Driver) ExtendType(base reflect.Type, extension reflect.Type) {
+}
@@ -0,0 +1,107 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"lucky/server/pkg/xdb"
+)
+
+// historyDDL 历史表的建表语句
+const historyDDL = "CREATE TABLE IF NOT EXISTS `%s` (" +
+	"\n  `id` INTEGER PRIMARY KEY AUTOINCREMENT," +
+	"\n  `pk` TEXT NOT NULL DEFAULT ''," +
+	"\n  `version` INTEGER NOT NULL DEFAULT 0," +
+	"\n  `lifecycle` INTEGER NOT NULL DEFAULT 0," +
+	"\n  `changes` BLOB," +
+	"\n  `diff` TEXT NOT NULL DEFAULT ''," +
+	"\n  `data` BLOB," +
+	"\n  `ctime` INTEGER NOT NULL DEFAULT 0," +
+	"\n  `origin` TEXT NOT NULL DEFAULT ''" +
+	"\n)"
+
+const historyColumns = "`pk`, `version`, `lifecycle`, `changes`, `diff`, `data`, `ctime`, `origin`"
+
+// History SQLite 历史表，ctime 为毫秒时间戳
+type History struct {
+	client *sql.DB
+	name   string
+}
+
+func (d *Dao) History(ctx context.Context, src *xdb.Source) (xdb.HistoryTable, error) {
+	h := &History{client: d.client, name: xdb.HistoryTableName(src)}
+	if d.opts.NoAutoCreate {
+		return h, nil
+	}
+
+	stmts := []string{
+		fmt.Sprintf(historyDDL, h.name),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS `idx_%s_pk_version` ON `%s` (`pk`, `version`)", h.name, h.name),
+	}
+	for _, stmt := range stmts {
+		if _, err := d.client.ExecContext(ctx, stmt); err != nil {
+			return nil, errors.Wrapf(err, "failed to create table %s", h.name)
+		}
+	}
+	return h, nil
+}
+
+func (h *History) Append(ctx context.Context, rows []*xdb.HistoryRow) error {
+	var buf strings.Builder
+	buf.WriteString("INSERT INTO `" + h.name + "` (" + historyColumns + ") VALUES ")
+
+	args := make([]interface{}, 0, len(rows)*8)
+	for i, r := range rows {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString("(?, ?, ?, ?, ?, ?, ?, ?)")
+		changes, _ := r.Changes.MarshalBinary()
+		args = append(args, r.Key, r.Version, int8(r.Lifecycle), changes, r.Diff, r.Data, r.Time.UnixMilli(), r.Origin)
+	}
+
+	if _, err := h.client.ExecContext(ctx, buf.String(), args...); err != nil {
+		return errors.Wrapf(err, "failed to insert into %s", h.name)
+	}
+	return nil
+}
+
+func (h *History) List(ctx context.Context, key string, maxVersion int64, limit int) ([]*xdb.HistoryRow, error) {
+	query := "SELECT " + historyColumns + " FROM `" + h.name + "` WHERE `pk` = ?"
+	args := []interface{}{key}
+	if maxVersion > 0 {
+		query += " AND `version` <= ?"
+		args = append(args, maxVersion)
+	}
+	query += " ORDER BY `id` DESC"
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := h.client.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to query %s", h.name)
+	}
+	defer rows.Close()
+
+	var ret []*xdb.HistoryRow
+	for rows.Next() {
+		r := &xdb.HistoryRow{}
+		var lifecycle, ctime int64
+		var changes []byte
+		if err = rows.Scan(&r.Key, &r.Version, &lifecycle, &changes, &r.Diff, &r.Data, &ctime, &r.Origin); err != nil {
+			return nil, errors.Wrapf(err, "failed to scan %s", h.name)
+		}
+		if err = r.Changes.UnmarshalBinary(changes); err != nil {
+			return nil, errors.Wrapf(err, "failed to scan %s", h.name)
+		}
+		r.Lifecycle, r.Time = xdb.Lifecycle(lifecycle), time.UnixMilli(ctime)
+		ret = append(ret, r)
+	}
+	return ret, errors.Wrapf(rows.Err(), "failed to query %s", h.name)
+}
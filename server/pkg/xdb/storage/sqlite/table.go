@@ -0,0 +1,363 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/sqlbuilder"
+)
+
+// Table SQLite 表实现
+type Table struct {
+	src     *xdb.Source
+	dao     *Dao
+	builder *sqlbuilder.Builder
+}
+
+func newTable(dao *Dao, src *xdb.Source) *Table {
+	return &Table{src: src, dao: dao, builder: sqlbuilder.New(sqlbuilder.SQLite, src)}
+}
+
+// createSQL 按数据源生成建表语句，类型与生成的 MySQL 表结构对应
+func (t *Table) createSQL() string {
+	var buf strings.Builder
+	buf.WriteString("CREATE TABLE IF NOT EXISTS `" + t.src.TableName + "` (\n")
+	for _, col := range t.builder.Columns {
+		typ, def := columnType(col.Type)
+		if col.Encoded {
+			typ, def = "TEXT", "''"
+		}
+		fmt.Fprintf(&buf, "    `%s` %s NOT NULL DEFAULT %s,\n", col.Name, typ, def)
+	}
+	if t.src.Versioned {
+		buf.WriteString("    `" + xdb.VersionColumn + "` INTEGER NOT NULL DEFAULT 0,\n")
+	}
+
+	keys := make([]string, len(t.builder.Keys))
+	for i, key := range t.builder.Keys {
+		keys[i] = "`" + key.Name + "`"
+	}
+	buf.WriteString("    PRIMARY KEY(" + strings.Join(keys, ", ") + ")\n)")
+	return buf.String()
+}
+
+// indexSQL 建索引语句，SQLite 的索引名在库内唯一，加上表名前缀
+func (t *Table) indexSQL() []string {
+	var stmts []string
+	for _, index := range t.src.Indexes {
+		unique := ""
+		if index.Unique {
+			unique = "UNIQUE "
+		}
+		stmts = append(stmts, fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS `%s_%s` ON `%s` (`%s`)",
+			unique, t.src.TableName, index.Name, t.src.TableName, strings.Join(index.Columns, "`, `")))
+	}
+	return stmts
+}
+
+func columnType(typ reflect.Type) (string, string) {
+	switch typ.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "INTEGER", "0"
+	case reflect.Float32, reflect.Float64:
+		return "REAL", "0"
+	case reflect.String:
+		return "TEXT", "''"
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return "BLOB", "X''"
+		}
+	}
+	return "TEXT", "''"
+}
+
+func (t *Table) Recover(ctx context.Context, commitments []xdb.Commitment) error {
+	// 写入被中断时返回错误，分段文件保留到下次恢复
+	if !t.Save(ctx, commitments, 5*time.Second, xdb.RetryInterval, func() bool { return ctx.Err() == nil }) {
+		return errors.Errorf("redo recover of [%s] interrupted", t.src.Namespace)
+	}
+	return nil
+}
+
+func (t *Table) Save(ctx context.Context, commitments []xdb.Commitment, writeTimeout time.Duration, retryInterval time.Duration, running func() bool) bool {
+	for _, commitment := range commitments {
+		c := commitment
+		ok := xdb.SaveOne(ctx, c, writeTimeout, retryInterval, running, func(ctx context.Context) error {
+			for attempt := 1; ; attempt++ {
+				conflict, err := t.saveOne(ctx, c)
+				if err != nil {
+					return t.classify(err)
+				}
+
+				// 版本冲突时由数据源的冲突回调决定是否以最新版本重试
+				if !conflict || !xdb.ResolveConflict(ctx, c, attempt) {
+					return nil
+				}
+			}
+		})
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// saveOne 写入单个提交，启用乐观锁时没有命中期望版本的行视为冲突
+func (t *Table) saveOne(ctx context.Context, commitment xdb.Commitment) (bool, error) {
+	data, _ := commitment.PrepareWrite()
+	lifecycle := commitment.Lifecycle()
+
+	var query string
+	var args []interface{}
+	var err error
+
+	switch lifecycle {
+	case xdb.LifecycleNew:
+		query, args, err = t.builder.Insert(commitment, data)
+	case xdb.LifecycleNormal:
+		query, args, err = t.builder.Update(commitment, data)
+	case xdb.LifecycleDeleted:
+		query, args = t.builder.Delete(commitment)
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return false, xdb.Permanent(err)
+	}
+	if query == "" {
+		return false, nil
+	}
+
+	result, err := t.dao.client.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, errors.Wrapf(err, "SQL: %s, Args: %v", query, args)
+	}
+
+	if _, _, versioned := xdb.VersionOf(commitment); !versioned || lifecycle == xdb.LifecycleNew {
+		return false, nil
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get rows affected")
+	}
+	return affected == 0, nil
+}
+
+func (t *Table) Fetch(ctx context.Context, onlyOne bool, pk xdb.PK) (xdb.RecordCursor, error) {
+	query, args := t.builder.Fetch(onlyOne, pk)
+	if query == "" {
+		return nil, errors.New("failed to build where clause")
+	}
+
+	rows, err := t.dao.client.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch from SQLite")
+	}
+
+	return newRecordCursor(rows, t), nil
+}
+
+func (t *Table) FetchMulti(ctx context.Context, pks []xdb.PK) (xdb.RecordCursor, error) {
+	if len(pks) == 0 {
+		return newRecordCursor(nil, t), nil
+	}
+
+	// 联合主键使用行值 (a, b) IN ((?, ?), ...)
+	query, args := t.builder.FetchMulti(pks)
+	if query == "" {
+		return nil, errors.New("failed to build where clause")
+	}
+
+	rows, err := t.dao.client.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch multi from SQLite")
+	}
+
+	return newRecordCursor(rows, t), nil
+}
+
+func (t *Table) Find(ctx context.Context, filter interface{}) (xdb.RecordCursor, error) {
+	query, args, err := t.builder.Find(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := t.dao.client.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find from SQLite")
+	}
+
+	return newRecordCursor(rows, t), nil
+}
+
+func (t *Table) Count(ctx context.Context, filter interface{}) (int64, error) {
+	query, args, err := t.builder.Count(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if err = t.dao.client.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, errors.Wrap(err, "failed to count from SQLite")
+	}
+	return count, nil
+}
+
+// RecordCursor SQLite 记录游标
+type RecordCursor struct {
+	rows  *sql.Rows
+	table *Table
+}
+
+func newRecordCursor(rows *sql.Rows, table *Table) *RecordCursor {
+	return &RecordCursor{
+		rows:  rows,
+		table: table,
+	}
+}
+
+func (r *RecordCursor) Next(ctx context.Context) bool {
+	if r.rows == nil {
+		return false
+	}
+	return r.rows.Next()
+}
+
+// Decode 解码到记录或模型中嵌入的 proto
+func (r *RecordCursor) Decode(val interface{}) error {
+	if r.rows == nil {
+		return errors.New("rows is nil")
+	}
+
+	src := r.table.src
+	target := findProto(reflect.ValueOf(val), src.ProtoType)
+	if !target.IsValid() {
+		return errors.Errorf("failed to find proto %v in %T", src.ProtoType, val)
+	}
+
+	columns, err := r.rows.Columns()
+	if err != nil {
+		return errors.Wrap(err, "failed to get columns")
+	}
+
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	if err = r.rows.Scan(valuePtrs...); err != nil {
+		return errors.Wrap(err, "failed to scan row")
+	}
+
+	// 查询的列与 builder.Columns 顺序一致，最后一列可能是版本号
+	for i, col := range r.table.builder.Columns {
+		if err = col.SetValue(target, values[i]); err != nil {
+			return err
+		}
+	}
+
+	if src.Versioned && len(values) > len(r.table.builder.Columns) {
+		if vs, ok := val.(xdb.VersionSetter); ok {
+			if version, ok := values[len(r.table.builder.Columns)].(int64); ok {
+				vs.SetXVersion(version)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *RecordCursor) All(ctx context.Context, results interface{}) error {
+	if r.rows == nil {
+		return nil
+	}
+	defer r.rows.Close()
+	return errors.New("All not implemented yet")
+}
+
+func (r *RecordCursor) Close(ctx context.Context) error {
+	if r.rows == nil {
+		return nil
+	}
+	return r.rows.Close()
+}
+
+// findProto 在对象（以及嵌入的 Record）中查找 proto 结构体
+func findProto(v reflect.Value, protoType reflect.Type) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	if v.Type() == protoType {
+		return v
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Type().Field(i).Anonymous {
+			continue
+		}
+		if found := findProto(v.Field(i), protoType); found.IsValid() {
+			return found
+		}
+	}
+	return reflect.Value{}
+}
+
+// uniqueFailed 违反唯一约束的错误信息，之后为逗号分隔的 <表名>.<列名>
+const uniqueFailed = "UNIQUE constraint failed: "
+
+// failedColumn 违反约束的列 <表名>.<列名>，错误信息末尾可能带有扩展错误码
+var failedColumn = regexp.MustCompile(`\w+\.(\w+)`)
+
+// uniqueIndex 按冲突的列找到索引名，主键冲突时为 PRIMARY
+func (t *Table) uniqueIndex(failed string) string {
+	var columns []string
+	for _, m := range failedColumn.FindAllStringSubmatch(failed, -1) {
+		columns = append(columns, m[1])
+	}
+	for _, index := range t.src.Indexes {
+		if index.Unique && strings.Join(index.Columns, ",") == strings.Join(columns, ",") {
+			return index.Name
+		}
+	}
+	return "PRIMARY"
+}
+
+// permanentErrors 数据本身导致的错误，重试也不会成功
+var permanentErrors = []string{
+	"constraint failed",
+	"no such table",
+	"no such column",
+	"datatype mismatch",
+	"too many SQL variables",
+}
+
+// classify 区分永久错误和临时错误，数据库被锁等错误可以重试，违反唯一约束时返回 *xdb.DupError
+func (t *Table) classify(err error) error {
+	msg := err.Error()
+	if i := strings.Index(msg, uniqueFailed); i >= 0 {
+		return xdb.Permanent(&xdb.DupError{Namespace: t.src.Namespace, Index: t.uniqueIndex(msg[i+len(uniqueFailed):])})
+	}
+	for _, s := range permanentErrors {
+		if strings.Contains(msg, s) {
+			return xdb.Permanent(err)
+		}
+	}
+	return err
+}
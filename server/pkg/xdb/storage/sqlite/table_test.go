@@ -0,0 +1,112 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"lucky/server/gen/db"
+	"lucky/server/pkg/xdb"
+)
+
+func TestTable_SaveFetch(t *testing.T) {
+	ctx := context.Background()
+	dao, err := newDao(ctx, &DaoOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dao.Close()
+
+	running := func() bool { return true }
+	save := func(table *Table, r xdb.MutableRecord) {
+		c, _ := r.Commit(ctx)
+		if !table.Save(ctx, []xdb.Commitment{c}, time.Second, time.Millisecond, running) {
+			t.Fatal("save failed")
+		}
+	}
+
+	players := dao.Table((&db.PlayerRecord{}).Source()).(*Table)
+	player := &db.PlayerRecord{}
+	_ = player.Init(ctx, &db.Player{PlayerId: 1, Name: "a", Level: 3})
+	save(players, player)
+
+	player.Name = "b"
+	player.GetHeader().SetChanged(db.PlayerFieldName)
+	save(players, player)
+
+	curr, err := players.Fetch(ctx, true, &db.PlayerPK{PlayerId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := &db.PlayerRecord{}
+	if !curr.Next(ctx) || curr.Decode(loaded) != nil {
+		t.Fatal("expected player")
+	}
+	_ = curr.Close(ctx)
+	if loaded.Name != "b" || loaded.Level != 3 || loaded.XVersion() != 2 {
+		t.Errorf("unexpected player: %s", loaded.Player.String())
+	}
+
+	// 联合主键
+	items := dao.Table((&db.ItemRecord{}).Source()).(*Table)
+	for _, id := range []int32{1, 2, 3} {
+		item := &db.ItemRecord{}
+		_ = item.Init(ctx, &db.Item{PlayerId: 1, ItemId: id, Count: int64(id) * 10})
+		save(items, item)
+	}
+
+	curr, err = items.FetchMulti(ctx, []xdb.PK{&db.ItemPK{PlayerId: 1, ItemId: 1}, &db.ItemPK{PlayerId: 1, ItemId: 3}, &db.ItemPK{PlayerId: 2, ItemId: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var counts []int64
+	for curr.Next(ctx) {
+		item := &db.ItemRecord{}
+		if err = curr.Decode(item); err != nil {
+			t.Fatal(err)
+		}
+		counts = append(counts, item.Count)
+	}
+	_ = curr.Close(ctx)
+	if len(counts) != 2 {
+		t.Errorf("unexpected fetch multi: %v", counts)
+	}
+
+	n, err := items.Count(ctx, xdb.And(xdb.Eq("player_id", int64(1)), xdb.Gte("count", 20)))
+	if err != nil || n != 2 {
+		t.Errorf("unexpected count: %d %v", n, err)
+	}
+
+	// 唯一键冲突
+	other := &db.PlayerRecord{}
+	_ = other.Init(ctx, &db.Player{PlayerId: 2, Name: "b"})
+	c, _ := other.Commit(ctx)
+	_, err = players.saveOne(ctx, c)
+	var dup *xdb.DupError
+	if err = players.classify(err); !xdb.IsPermanent(err) || !errors.As(err, &dup) || dup.Index != "uk_name" {
+		t.Errorf("expected duplicated name: %v", err)
+	}
+
+	// 删除
+	player.Delete(ctx)
+	save(players, player)
+	if n, _ = players.Count(ctx, nil); n != 0 {
+		t.Errorf("expected player deleted, got %d", n)
+	}
+}
+
+func TestDriver_ValidateKey(t *testing.T) {
+	item := (&db.ItemRecord{}).Source()
+	src := &xdb.Source{ProtoType: item.ProtoType, PKType: item.PKType, Namespace: item.Namespace, DriverName: driver.Name(), KeySize: item.KeySize, Fields: item.Fields}
+	if err := driver.Validate(src); err != nil {
+		t.Fatal(err)
+	}
+
+	// 主键字段无法对应到 PK 结构体
+	src.Fields = []*xdb.FieldDesc{{Code: db.ItemFieldPlayerId, Name: "player_id", Key: true}, {Code: db.ItemFieldCount, Name: "count", Key: true}}
+	if err := driver.Validate(src); err == nil {
+		t.Error("expected unmappable key rejected")
+	}
+}
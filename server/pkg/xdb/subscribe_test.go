@@ -0,0 +1,45 @@
+package xdb_test
+
+import (
+	"context"
+	"testing"
+
+	"lucky/server/gen/db"
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/memory"
+)
+
+func TestSubscribe_Committed(t *testing.T) {
+	var events []*xdb.ChangeEvent
+	unsub := xdb.Subscribe((&db.PlayerRecord{}).Source().Namespace, func(ctx context.Context, ev *xdb.ChangeEvent) {
+		events = append(events, ev)
+	})
+	defer unsub()
+
+	ctx := setupMemory(t, &memory.Configurator{})
+	player, err := xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: 1, Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = xdb.Sync(ctx, player); err != nil {
+		t.Fatal(err)
+	}
+	_ = player.AddExp(5)
+	if err = xdb.Sync(ctx, player); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sync 返回时入库的提交已经回调
+	if len(events) != 2 || events[0].Lifecycle != xdb.LifecycleNew || events[1].Lifecycle != xdb.LifecycleNormal {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	if !events[1].Changes.ContainsExact(db.PlayerFieldExp) || events[1].Snapshot.(*db.Player).Exp != 5 || events[1].Key != xdb.PKOf(player).String() {
+		t.Errorf("unexpected update event: %+v", events[1])
+	}
+
+	// 事件持有快照的副本，之后的修改不影响已发布的事件
+	_ = player.AddExp(1)
+	if events[0].Snapshot.(*db.Player).Exp != 0 || events[1].Snapshot.(*db.Player).Exp != 5 {
+		t.Errorf("expected snapshots detached from model: %v %v", events[0].Snapshot, events[1].Snapshot)
+	}
+}
@@ -0,0 +1,42 @@
+package xdb_test
+
+import (
+	"testing"
+
+	"lucky/server/gen/db"
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/memory"
+)
+
+func TestPK_Typed(t *testing.T) {
+	ctx := setupMemory(t, &memory.Configurator{})
+
+	item, err := xdb.Create[*db.ItemRecord](ctx, &db.Item{PlayerId: 1, ItemId: 2, Count: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = xdb.Sync(ctx, item); err != nil {
+		t.Fatal(err)
+	}
+
+	// 类型化的查询、整数参数的转换和现成的主键得到同一条记录
+	typed, err := db.GetItem[*db.ItemRecord](ctx, 1, 2)
+	if err != nil || typed == nil || typed.Count != 3 {
+		t.Fatalf("unexpected typed get: %v %v", typed, err)
+	}
+	for _, args := range [][]any{{1, 2}, {int32(1), int64(2)}, {db.NewItemPK(1, 2)}} {
+		if got, err := xdb.Get[*db.ItemRecord](ctx, args...); err != nil || got == nil || got.ItemId != 2 {
+			t.Errorf("unexpected get by %v: %v %v", args, got, err)
+		}
+	}
+	if items, err := xdb.GetAll[*db.ItemRecord](ctx, uint8(1)); err != nil || len(items) != 1 {
+		t.Errorf("unexpected get all: %v %v", items, err)
+	}
+
+	// 类型不符、超出范围或其他数据源的主键返回错误而不是 panic
+	for _, args := range [][]any{{"1", 2}, {1, int64(1) << 40}, {db.NewPlayerPK(1)}, {1.5, 2}} {
+		if _, err := xdb.Get[*db.ItemRecord](ctx, args...); err == nil {
+			t.Errorf("expected error of %v", args)
+		}
+	}
+}
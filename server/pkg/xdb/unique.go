@@ -0,0 +1,125 @@
+package xdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// DupError 写入违反唯一约束，errors.Is(err, ErrDup) 为 true
+type DupError struct {
+	Namespace string
+	Index     string      // 冲突的索引名
+	Value     interface{} // 冲突的值，驱动无法解析时为 nil
+}
+
+func (e *DupError) Error() string {
+	if e.Value == nil {
+		return fmt.Sprintf("duplicated [%s]: %s", e.Namespace, e.Index)
+	}
+	return fmt.Sprintf("duplicated [%s]: %s = %v", e.Namespace, e.Index, e.Value)
+}
+
+func (e *DupError) Is(target error) bool { return target == ErrDup }
+
+// UniqueIndex 字段上的单列唯一索引，即 (xdb.gk) 字段生成的索引，没有时返回 nil
+func (src *Source) UniqueIndex(field string) *IndexDesc {
+	for _, index := range src.Indexes {
+		if index.Unique && len(index.Columns) == 1 && index.Columns[0] == field {
+			return index
+		}
+	}
+	return nil
+}
+
+// uniqueValues 对象的单列唯一键，key 为索引名
+func (src *Source) uniqueValues(obj interface{}) map[string]interface{} {
+	var values map[string]interface{}
+	for _, index := range src.Indexes {
+		if !index.Unique || len(index.Columns) != 1 {
+			continue
+		}
+		if values == nil {
+			values = map[string]interface{}{}
+		}
+		if v, ok := columnValue(obj.(Record).Snapshoot(), index.Columns[0]); ok {
+			values[index.Name] = v.Interface()
+		}
+	}
+	return values
+}
+
+// indexUniques 更新缓存中模型的唯一键，已删除的模型移除唯一键
+func (src *Source) indexUniques(m Model) {
+	if src.repo == nil {
+		return
+	}
+
+	values := src.uniqueValues(m)
+	if values == nil {
+		return
+	}
+
+	if m.Lifecycle() == LifecycleDeleted {
+		src.repo.DeleteUniques(src.PKOf(m))
+	} else {
+		src.repo.SetUniques(src.PKOf(m), values)
+	}
+}
+
+// GetByUnique 按唯一键获取记录，field 为 (xdb.gk) 字段的列名，记录不存在时返回 nil
+// 先按缓存的唯一键找到主键，未命中或缓存的对象已修改时按条件查询数据库
+func GetByUnique[T Record](ctx context.Context, field string, value interface{}) (T, error) {
+	return GetByUniqueS[T](ctx, getTypeSource[T](), field, value)
+}
+
+// GetByUniqueS 根据源按唯一键获取记录
+func GetByUniqueS[T any](ctx context.Context, src *Source, field string, value interface{}) (T, error) {
+	index := src.UniqueIndex(field)
+	if index == nil {
+		return zero[T](), errors.Errorf("no unique index on %s [%s]", field, src.Namespace)
+	}
+
+	cond := Eq(field, value)
+	if key, ok := src.repo.GetUnique(index.Name, value); ok {
+		ret, err := GetS[T](ctx, src, key.(PK))
+		if err != nil {
+			return ret, err
+		}
+		if r, ok := any(ret).(Record); ok && !isNil(r) && r.Lifecycle() != LifecycleDeleted && cond.Match(r.Snapshoot()) {
+			return ret, nil
+		}
+		src.repo.DeleteUnique(index.Name, value, key)
+	}
+
+	rets, err := FindS[T](ctx, src, NewQuery(cond).Limit(1))
+	if err != nil || len(rets) == 0 {
+		return zero[T](), err
+	}
+	return rets[0], nil
+}
+
+// checkUniques 新建记录前检查唯一键是否已被其他记录占用，安全模式下和主键一样不检查，由数据库的唯一约束在写入时拒绝
+// 未赋值（零值）的唯一键不检查，避免把尚未命名的记录误判为重复
+func checkUniques(ctx context.Context, src *Source, proto interface{}, pk PK) error {
+	for _, index := range src.Indexes {
+		if !index.Unique || len(index.Columns) != 1 {
+			continue
+		}
+		v, ok := columnValue(proto, index.Columns[0])
+		if !ok || v.IsZero() {
+			continue
+		}
+
+		value := v.Interface()
+		other, err := GetByUniqueS[any](ctx, src, index.Columns[0], value)
+		if err != nil {
+			return err
+		}
+		if !isNil(other) && keyString(src.PKOf(other)) != keyString(pk) {
+			return &DupError{Namespace: src.Namespace, Index: index.Name, Value: value}
+		}
+	}
+	return nil
+}
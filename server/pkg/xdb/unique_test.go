@@ -0,0 +1,54 @@
+package xdb_test
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"lucky/server/gen/db"
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/memory"
+)
+
+func TestUnique_GetByAndDup(t *testing.T) {
+	ctx := setupMemory(t, &memory.Configurator{})
+
+	player, err := xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: 1, Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = xdb.Sync(ctx, player); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := db.GetPlayerByName[*db.PlayerRecord](ctx, "a")
+	if err != nil || found == nil || found.PlayerId != 1 {
+		t.Fatalf("unexpected lookup: %v %v", found, err)
+	}
+	if found, err = db.GetPlayerByName[*db.PlayerRecord](ctx, "b"); err != nil || found != nil {
+		t.Fatalf("unexpected lookup: %v %v", found, err)
+	}
+
+	_, err = xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: 2, Name: "a"})
+	var dup *xdb.DupError
+	if !errors.As(err, &dup) || !errors.Is(err, xdb.ErrDup) || dup.Index != "uk_name" || dup.Value != "a" {
+		t.Fatalf("expected duplicated name: %v", err)
+	}
+}
+
+func TestUnique_SkipZero(t *testing.T) {
+	ctx := setupMemory(t, &memory.Configurator{})
+
+	player, err := xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = xdb.Sync(ctx, player); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未赋值的唯一键不参与创建前的检查
+	if _, err = xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: 2}); err != nil {
+		t.Fatalf("unexpected create: %v", err)
+	}
+}
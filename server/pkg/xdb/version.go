@@ -0,0 +1,137 @@
+package xdb
+
+import (
+	"context"
+	"reflect"
+
+	clog "github.com/cherry-game/cherry/logger"
+	"github.com/pkg/errors"
+)
+
+// VersionColumn 版本号在数据库中的列名
+const VersionColumn = "_version"
+
+// maxConflictRetry 单个提交因版本冲突重试的最大次数
+const maxConflictRetry = 3
+
+var ErrVersionConflict = errors.New("version conflict")
+
+// ConflictAction 版本冲突的处理方式
+type ConflictAction int8
+
+const (
+	ConflictDiscard ConflictAction = iota // 放弃本地提交，以数据库为准
+	ConflictRetry                         // 以数据库中的最新版本为基准重试，本地变更的字段覆盖数据库
+)
+
+// ConflictHandler 版本冲突回调，current 为数据库中的最新记录（已被删除时为 nil）
+type ConflictHandler func(ctx context.Context, c Commitment, current Record) ConflictAction
+
+// VersionedCommitment 支持乐观锁的提交对象
+type VersionedCommitment interface {
+	Commitment
+	BaseVersion() int64   // 提交时数据库中应有的版本
+	Version() int64       // 提交成功后的版本
+	Rebase(version int64) // 以新的基准版本重新提交
+}
+
+// VersionSetter 可以写入版本号的记录，驱动解码时使用
+type VersionSetter interface {
+	SetXVersion(version int64)
+}
+
+// VersionOf 获取提交对象的基准版本与新版本，不支持乐观锁的提交返回 ok=false
+func VersionOf(c Commitment) (base int64, version int64, ok bool) {
+	if !c.Source().Versioned {
+		return 0, 0, false
+	}
+
+	vc, ok := c.(VersionedCommitment)
+	if !ok {
+		return 0, 0, false
+	}
+	return vc.BaseVersion(), vc.Version(), true
+}
+
+// ResolveConflict 驱动检测到版本冲突时调用，返回 true 表示提交已经以最新版本为基准，需要重新写入
+func ResolveConflict(ctx context.Context, c Commitment, attempt int) (rebased bool) {
+	src := c.Source()
+	pk := PKOf(c)
+
+	// 放弃的提交没有写入存储，不发布通知
+	defer func() {
+		if !rebased {
+			markDropped(ctx, c)
+		}
+	}()
+
+	vc, ok := c.(VersionedCommitment)
+	if !ok {
+		return false
+	}
+	base := vc.BaseVersion()
+
+	// 放弃提交时模型中的版本已经过时：缓存标记为过时，模型所在的 actor 通过 OnSaveError 得知冲突，重新 Get 时加载最新版本
+	// 以最新版本重新写入成功时模型仍然有效，之后的冲突同样由 OnConflict 处理
+	defer func() {
+		if rebased {
+			return
+		}
+		if src.repo != nil {
+			src.repo.MarkStale(pk)
+		}
+		notifySaveError(ctx, &SaveFailure{
+			Commitment: c,
+			Err:        errors.Wrapf(ErrVersionConflict, "[%s] %s base %d", src.Namespace, pk, base),
+			Attempt:    attempt,
+			Discarded:  true,
+		})
+	}()
+
+	current, err := fetchRecord(ctx, src, pk)
+	if err != nil {
+		clog.Warnf("[xdb] reload on conflict failed. [ns = %s, pk = %s, err = %v]", src.Namespace, pk, err)
+		return false
+	}
+
+	action := ConflictDiscard
+	if src.OnConflict != nil {
+		action = src.OnConflict(ctx, c, current)
+	}
+
+	if action != ConflictRetry || current == nil || attempt >= maxConflictRetry {
+		clog.Warnf("[xdb] commitment discarded on version conflict. [ns = %s, pk = %s, base = %d, attempt = %d]",
+			src.Namespace, pk, base, attempt)
+		return false
+	}
+
+	vc.Rebase(current.XVersion())
+	return true
+}
+
+// fetchRecord 绕过缓存从数据库读取记录
+func fetchRecord(ctx context.Context, src *Source, pk PK) (Record, error) {
+	curr, err := src.Table().Fetch(ctx, true, pk)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = curr.Close(ctx)
+	}()
+
+	if !curr.Next(ctx) {
+		return nil, nil
+	}
+
+	val := reflect.New(src.RecordType)
+	if err = curr.Decode(val.Interface()); err != nil {
+		return nil, err
+	}
+
+	record, ok := val.Interface().(Record)
+	if !ok {
+		return nil, errors.Errorf("invalid record type: %s", src.RecordType)
+	}
+	return record, nil
+}
@@ -0,0 +1,142 @@
+package xdb_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"lucky/server/gen/db"
+	"lucky/server/gen/db/center"
+	"lucky/server/pkg/xdb"
+	"lucky/server/pkg/xdb/storage/memory"
+)
+
+// setupConflict 新建 player 入库后模拟其他节点写入新版本，返回本地持有的旧版本和冲突通知
+func setupConflict(t *testing.T) (context.Context, *db.PlayerRecord, *[]*xdb.SaveFailure) {
+	t.Helper()
+
+	ctx := setupMemory(t, &memory.Configurator{SyncInterval: time.Hour})
+	var failures []*xdb.SaveFailure
+	xdb.OnSaveError(func(ctx context.Context, f *xdb.SaveFailure) {
+		if errors.Is(f.Err, xdb.ErrVersionConflict) {
+			failures = append(failures, f)
+		}
+	})
+
+	player, err := xdb.Create[*db.PlayerRecord](ctx, &db.Player{PlayerId: 1, Name: "a", Level: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xdb.Save(ctx, player)
+	xdb.Sync(ctx, player)
+
+	remote := &db.PlayerRecord{}
+	_ = remote.Init(ctx, &db.Player{PlayerId: 1, Name: "a", Level: 1, Exp: 9, XVersion: player.XVersion()})
+	remote.GetHeader().Init(xdb.LifecycleNormal)
+	_ = remote.SetExp(9)
+	c, _ := remote.Commit(ctx)
+	if !c.Source().Table().Save(ctx, []xdb.Commitment{c}, time.Second, time.Millisecond, func() bool { return true }) {
+		t.Fatal("expected remote write")
+	}
+	return ctx, player, &failures
+}
+
+func TestVersion_ConflictDiscard(t *testing.T) {
+	ctx, player, failures := setupConflict(t)
+
+	// 默认放弃本地提交，以数据库为准
+	_ = player.SetLevel(3)
+	xdb.Save(ctx, player)
+	xdb.Sync(ctx, player)
+
+	if row, _ := memory.Lookup(xdb.PKOf(player)); row.(*db.Player).Level != 1 || row.(*db.Player).Exp != 9 {
+		t.Errorf("expected remote version kept: %v", row)
+	}
+	if len(*failures) != 1 || !(*failures)[0].Discarded {
+		t.Fatalf("expected discarded conflict reported: %+v", *failures)
+	}
+
+	// 重新加载最新版本后可以继续修改
+	reloaded, err := xdb.Get[*db.PlayerRecord](ctx, 1)
+	if err != nil || reloaded.XVersion() != 2 {
+		t.Fatalf("unexpected reloaded: %v %v", reloaded, err)
+	}
+	_ = reloaded.SetLevel(4)
+	xdb.Save(ctx, reloaded)
+	xdb.Sync(ctx, reloaded)
+	if row, _ := memory.Lookup(xdb.PKOf(player)); row.(*db.Player).Level != 4 || len(*failures) != 1 {
+		t.Errorf("expected save after reload: %v %d", row, len(*failures))
+	}
+}
+
+func TestVersion_ConflictRebase(t *testing.T) {
+	ctx, player, failures := setupConflict(t)
+	src := xdb.PKOf(player).Source()
+	src.OnConflict = func(ctx context.Context, c xdb.Commitment, current xdb.Record) xdb.ConflictAction {
+		return xdb.ConflictRetry
+	}
+	defer func() { src.OnConflict = nil }()
+
+	// 以数据库中的最新版本为基准重试，只修改提交对象的版本
+	_ = player.SetLevel(3)
+	xdb.Save(ctx, player)
+	committed := player.XVersion()
+	xdb.Sync(ctx, player)
+
+	if row, _ := memory.Lookup(xdb.PKOf(player)); row.(*db.Player).Level != 3 {
+		t.Errorf("expected local change written: %v", row)
+	}
+	if player.XVersion() != committed {
+		t.Errorf("expected model version untouched by rebase, got %d", player.XVersion())
+	}
+	if len(*failures) != 0 {
+		t.Fatalf("expected rebased conflict not reported: %+v", *failures)
+	}
+
+	reloaded, err := xdb.Get[*db.PlayerRecord](ctx, 1)
+	if err != nil || reloaded.XVersion() != 3 || reloaded.GetLevel() != 3 {
+		t.Errorf("unexpected reloaded: %v %v", reloaded, err)
+	}
+}
+
+func TestVersion_RebaseKeepsModel(t *testing.T) {
+	ctx := setupMemory(t, &memory.Configurator{SyncInterval: time.Hour})
+	model, err := xdb.Create[*evictTestModel](ctx, &center.Uuid{Name: "a", Value: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	xdb.Save(ctx, model)
+	xdb.Sync(ctx, model)
+
+	src := xdb.PKOf(model).Source()
+	src.OnConflict = func(ctx context.Context, c xdb.Commitment, current xdb.Record) xdb.ConflictAction {
+		return xdb.ConflictRetry
+	}
+	defer func() { src.OnConflict = nil }()
+
+	// 其他节点写入新版本
+	remote := &center.UuidRecord{}
+	_ = remote.Init(ctx, &center.Uuid{Name: "a", Value: 1, XVersion: model.XVersion()})
+	remote.GetHeader().Init(xdb.LifecycleNormal)
+	_ = remote.SetValue(9)
+	c, _ := remote.Commit(ctx)
+	if !src.Table().Save(ctx, []xdb.Commitment{c}, time.Second, time.Millisecond, func() bool { return true }) {
+		t.Fatal("expected remote write")
+	}
+
+	// 以最新版本重新写入成功，缓存中的模型没有标记为过时，可以继续修改
+	for _, value := range []int64{2, 3} {
+		_ = model.SetValue(value)
+		if err = xdb.Save(ctx, model); err != nil {
+			t.Fatalf("expected rebased model saved: %v", err)
+		}
+		xdb.Sync(ctx, model)
+		if row, _ := memory.Lookup(xdb.PKOf(model)); row.(*center.Uuid).Value != value {
+			t.Fatalf("unexpected row: %v", row)
+		}
+	}
+	if cached, err := xdb.Get[*evictTestModel](ctx, "a"); err != nil || cached != model {
+		t.Errorf("expected cached model kept: %v", err)
+	}
+}
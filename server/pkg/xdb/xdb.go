@@ -4,19 +4,29 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"sort"
 	"time"
 
+	clog "github.com/cherry-game/cherry/logger"
 	"github.com/pkg/errors"
 )
 
 var ErrDup = errors.New("duplicated")
 
+// ErrReadonly 修改只读的记录或 (xdb.readonly) 字段
+var ErrReadonly = errors.New("readonly")
+
+// ErrStale 保存过时的模型：模型被逐出后已经重新加载，或者版本冲突后被标记为过时，需要重新 Get 后再修改
+var ErrStale = errors.New("stale model")
+
 // TableOptions 表选项
 type TableOptions struct {
 	DaoKey       interface{}
 	Concurrence  uint32        // 存储并发协程数
 	SaveTimeout  time.Duration // 存储超时时间
 	SyncInterval time.Duration // 存储队列刷新间隔
+	Shard        *ShardOptions // 分片选项，为空时不分片
+	CriticalSync bool          // 修改关键字段的提交同步等待入库，否则只立即刷新保存队列
 }
 
 // DatabaseConfig 数据库配置接口（可选）
@@ -30,7 +40,7 @@ type DatabaseConfig interface {
 type Configurator interface {
 	RedoOptions() *RedoOptions
 	DriverOptions(driver string) interface{}
-	DaoOptions(daoKey interface{}) interface{} // *storage_mongo.DaoOptions OR *storage_mysql.DaoOptions
+	DaoOptions(daoKey interface{}) interface{} // *storage_mongo.DaoOptions OR *storage_mysql.DaoOptions OR *storage_sqlite.DaoOptions
 	TableOptions(driver string, table string) *TableOptions
 	// DryRun 返回true则数据的修改不入库，生产环境以及通常的开发和测试环境应该返回false
 	DryRun() bool
@@ -47,9 +57,14 @@ type daoCreation struct {
 // Setup 初始化 xdb
 func Setup(ctx context.Context, c Configurator) (err error) {
 	// 如果已经初始化，先清理所有 Source 的 repo
+	// 注册了模型的数据源不会在下面重新创建 repo，按 RegisterModel 的选项换成空的 repo
 	if initialized {
 		for _, src := range nsSrcMap {
-			if src.repo != nil {
+			if src.repo != nil && src.ModelType != nil {
+				opts := src.repo.opts
+				src.repo = &Repo{}
+				src.repo.Init(true, &opts, src.Namespace, src.PKComparator, &waitGroup)
+			} else if src.repo != nil {
 				src.repo = nil
 			}
 		}
@@ -57,6 +72,7 @@ func Setup(ctx context.Context, c Configurator) (err error) {
 	}
 
 	redoOptions = c.RedoOptions()
+	logDeadLetterDir()
 
 	// 如果配置器实现了 DatabaseConfig 接口，先初始化数据库
 	if dbConfig, ok := c.(DatabaseConfig); ok {
@@ -69,6 +85,7 @@ func Setup(ctx context.Context, c Configurator) (err error) {
 	options := map[*Source]*TableOptions{}
 	drivers := map[Driver]string{}
 	creations := map[interface{}]*daoCreation{}
+	shards := map[*Source][]*Shard{}
 
 	for _, src := range nsSrcMap {
 		if src.DriverName == "none" {
@@ -119,6 +136,22 @@ func Setup(ctx context.Context, c Configurator) (err error) {
 			driver:  driver,
 			daoOpts: daoOpts,
 		}
+
+		if opts.Shard != nil {
+			if shards[src], err = src.Shards(opts.Shard, opts.DaoKey); err != nil {
+				return err
+			}
+			for _, shard := range shards[src] {
+				if _, ok := creations[shard.DaoKey]; ok {
+					continue
+				}
+				shardDaoOpts := c.DaoOptions(shard.DaoKey)
+				if shardDaoOpts == nil {
+					return errors.Errorf("missing driver options DaoKey: %s for driver: %s table src: %s", shard.DaoKey, src.DriverName, shard.Source.TableName)
+				}
+				creations[shard.DaoKey] = &daoCreation{driver: driver, daoOpts: shardDaoOpts}
+			}
+		}
 	}
 
 	// init all drivers by user options.
@@ -148,15 +181,40 @@ func Setup(ctx context.Context, c Configurator) (err error) {
 		}
 	}
 
+	// migrate table schemas.
+	if mc, ok := c.(MigrateConfig); ok {
+		if opts := mc.MigrateOptions(); opts != nil {
+			if err = migrate(ctx, opts, shardOptions(options, shards), creations); err != nil {
+				return err
+			}
+		}
+	}
+
 	// init all tables and table source.
 	for _, src := range nsSrcMap {
 		if src.DriverName == "none" {
 			src.Init(nil, NoStorageTable{}, true)
 		} else {
 			tableOpts := options[src]
-			creation := creations[tableOpts.DaoKey]
-			table := creation.dao.Table(src)
+			var table Table
+			if len(shards[src]) > 0 {
+				tables := make([]Table, len(shards[src]))
+				for i, shard := range shards[src] {
+					tables[i] = creations[shard.DaoKey].dao.Table(shard.Source)
+				}
+				table = newShardTable(src, tableOpts.Shard, tables)
+			} else {
+				table = creations[tableOpts.DaoKey].dao.Table(src)
+			}
 			src.Init(tableOpts, table, c.DryRun())
+			historyShards := shards[src]
+			if len(historyShards) == 0 {
+				historyShards = []*Shard{{Source: src, DaoKey: tableOpts.DaoKey}}
+			}
+			daoOf := func(key interface{}) Dao { return creations[key].dao }
+			if err = src.initHistory(ctx, historyShards, daoOf, c.DryRun()); err != nil {
+				return err
+			}
 		}
 
 		// src.ModelType 不为 null的repo在RegisterModel的时候已经初始化
@@ -226,6 +284,10 @@ func createS[T MutableRecord](ctx context.Context, src *Source, proto interface{
 		if !isNil(v) {
 			return v, errors.Wrapf(ErrDup, "duplicated [%s]: %s", src.Namespace, pk)
 		}
+
+		if err = checkUniques(ctx, src, proto, pk); err != nil {
+			return v, err
+		}
 	}
 
 	for {
@@ -242,6 +304,17 @@ func createS[T MutableRecord](ctx context.Context, src *Source, proto interface{
 }
 
 func create[T MutableRecord](ctx context.Context, src *Source, proto interface{}, tmp bool, mirror bool) (T, error) {
+	ret, err := newRecord[T](ctx, src, proto, tmp, mirror)
+	if err == nil {
+		// 工作单元内新建的记录对后续读取立即可见
+		if cc := getCacheContext(ctx); cc != nil {
+			cc.Put(src.PKOf(proto), ret)
+		}
+	}
+	return ret, err
+}
+
+func newRecord[T MutableRecord](ctx context.Context, src *Source, proto interface{}, tmp bool, mirror bool) (T, error) {
 	legalType := src.GetLegalType()
 	// 如果是指针类型，获取指向的类型
 	if legalType.Kind() == reflect.Ptr {
@@ -268,10 +341,13 @@ func create[T MutableRecord](ctx context.Context, src *Source, proto interface{}
 // Get 获取记录
 func Get[T Record](ctx context.Context, args ...any) (T, error) {
 	src := getTypeSource[T]()
-	pk, err := src.PKCreator(args)
+	pk, err := src.CreatePK(args)
 	if err != nil {
 		return zero[T](), err
 	}
+	if !pk.Full() {
+		return zero[T](), errors.Errorf("incomplete primary key [%s]: %s", src.Namespace, pk)
+	}
 	return GetS[T](ctx, src, pk)
 }
 
@@ -283,10 +359,13 @@ func GetNS[T any](ctx context.Context, ns string, args []any) (T, error) {
 		return empty, err
 	}
 
-	pk, err := src.PKCreator(args)
+	pk, err := src.CreatePK(args)
 	if err != nil {
 		return empty, err
 	}
+	if !pk.Full() {
+		return empty, errors.Errorf("incomplete primary key [%s]: %s", src.Namespace, pk)
+	}
 
 	return GetS[T](ctx, src, pk)
 }
@@ -296,11 +375,24 @@ func GetS[T any](ctx context.Context, s *Source, pk PK) (T, error) {
 	var ret any
 	var err error
 
-	// 如果注册了 Model 类型，总是返回 Model 类型（以注册的 Model 为准）
-	if s.ModelType != nil {
-		ret, err = getModel(ctx, s, pk)
-	} else {
-		ret, err = getNonModel(ctx, s, pk)
+	// 工作单元内优先使用请求级别的读缓存
+	cc := getCacheContext(ctx)
+	cached := false
+	if cc != nil {
+		ret, cached = cc.Get(pk)
+	}
+
+	if !cached {
+		// 如果注册了 Model 类型，总是返回 Model 类型（以注册的 Model 为准）
+		if s.ModelType != nil {
+			ret, err = getModel(ctx, s, pk)
+		} else {
+			ret, err = getNonModel(ctx, s, pk)
+		}
+
+		if cc != nil && err == nil {
+			cc.Put(pk, ret)
+		}
 	}
 
 	if ret == nil || err != nil {
@@ -403,7 +495,11 @@ func getModel(ctx context.Context, src *Source, pk PK) (any, error) {
 	}
 
 	// val 是指向 PlayerModel 的指针，需要转换为 *PlayerModel
-	ret = val.Interface()
+	return attachModel(ctx, src, pk, val.Interface()), nil
+}
+
+// attachModel 将从数据库加载的对象放入缓存，缓存中已有对象时以缓存为准
+func attachModel(ctx context.Context, src *Source, pk PK, ret any) any {
 	var volatile bool
 	if vol, ok := ret.(Volatile); ok {
 		volatile = vol.IsVolatile()
@@ -416,47 +512,418 @@ func getModel(ctx context.Context, src *Source, pk PK) (any, error) {
 		header.LoadComplete()
 		// 设置生命周期为 Normal（从数据库读取的记录应该是 Normal 状态）
 		header.Init(LifecycleNormal)
+		src.indexUniques(m)
 		if src.Replica {
 			m.OnReload(ctx)
 		} else {
 			m.OnLoad(ctx)
 		}
-	}), nil
+	})
+}
+
+// fetchMultiBatch 批量加载时单次查询的最大主键数
+const fetchMultiBatch = 500
+
+// GetMulti 批量获取记录，结果与 pks 一一对应，不存在的记录为 nil
+// 缓存命中的直接返回，未命中的通过一次（超过 fetchMultiBatch 时分批）驱动查询加载
+func GetMulti[T Record](ctx context.Context, pks ...PK) ([]T, error) {
+	return GetMultiS[T](ctx, getTypeSource[T](), pks)
+}
+
+// GetMultiS 根据源批量获取记录
+func GetMultiS[T any](ctx context.Context, src *Source, pks []PK) ([]T, error) {
+	vals := make([]any, len(pks))
+
+	// 工作单元内优先使用请求级别的读缓存
+	cc := getCacheContext(ctx)
+	var keys []Key
+	var idx []int
+	for i, pk := range pks {
+		if cc != nil {
+			if v, ok := cc.Get(pk); ok {
+				vals[i] = v
+				continue
+			}
+		}
+		keys = append(keys, pk)
+		idx = append(idx, i)
+	}
+
+	if src.ModelType != nil && len(keys) > 0 {
+		cached, missing := src.repo.GetMulti(keys)
+		for i, v := range cached {
+			vals[idx[i]] = v
+		}
+
+		remain := make([]Key, len(missing))
+		remainIdx := make([]int, len(missing))
+		for i, j := range missing {
+			remain[i] = keys[j]
+			remainIdx[i] = idx[j]
+		}
+		keys, idx = remain, remainIdx
+	}
+
+	if len(keys) > 0 {
+		if err := fetchMulti(ctx, src, keys, idx, vals); err != nil {
+			return nil, err
+		}
+	}
+
+	rets := make([]T, len(pks))
+	for i, v := range vals {
+		if cc != nil {
+			cc.Put(pks[i], v)
+		}
+		if isNil(v) {
+			continue
+		}
+
+		ret, ok := v.(T)
+		if !ok {
+			return nil, errors.Errorf("invalid result type %T for [%s]", v, src.Namespace)
+		}
+		rets[i] = ret
+	}
+	return rets, nil
+}
+
+// fetchMulti 从数据库加载未命中缓存的记录，重复的主键只查询一次
+func fetchMulti(ctx context.Context, src *Source, keys []Key, idx []int, vals []any) error {
+	pending := make(map[string][]int, len(keys))
+	var pks []PK
+	for i, key := range keys {
+		pk := key.(PK)
+		k := pk.String()
+		if _, ok := pending[k]; !ok {
+			pks = append(pks, pk)
+		}
+		pending[k] = append(pending[k], idx[i])
+	}
+
+	for start := 0; start < len(pks); start += fetchMultiBatch {
+		end := start + fetchMultiBatch
+		if end > len(pks) {
+			end = len(pks)
+		}
+
+		if err := fetchBatch(ctx, src, pks[start:end], pending, vals); err != nil {
+			return err
+		}
+	}
+
+	// 数据库中不存在的记录，缓存"不存在"的占位
+	if src.ModelType != nil {
+		for _, pk := range pks {
+			if _, ok := pending[pk.String()]; ok {
+				src.repo.SetOnFetch(pk, nil, true, nil)
+			}
+		}
+	}
+	return nil
+}
+
+func fetchBatch(ctx context.Context, src *Source, pks []PK, pending map[string][]int, vals []any) error {
+	curr, err := src.Table().FetchMulti(ctx, pks)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = curr.Close(ctx)
+	}()
+
+	for curr.Next(ctx) {
+		v, err := decodeFound(ctx, src, curr)
+		if err != nil {
+			return err
+		}
+
+		k := src.PKOf(v).String()
+		for _, i := range pending[k] {
+			vals[i] = v
+		}
+		delete(pending, k)
+	}
+	return nil
+}
+
+// GetAll 按主键前缀获取所有记录，例如 GetAll[*ItemModel](ctx, playerId) 获取玩家的所有道具
+// 前缀第一次加载后被标记为完整，之后的调用（包括期间新建的记录）直接由缓存返回
+func GetAll[T Record](ctx context.Context, prefix ...any) ([]T, error) {
+	src := getTypeSource[T]()
+	pk, err := src.CreatePK(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return GetAllS[T](ctx, src, pk)
+}
+
+// GetAllS 根据源按主键前缀获取所有记录，结果按主键排序
+func GetAllS[T any](ctx context.Context, src *Source, prefix PK) ([]T, error) {
+	if prefix.Empty() {
+		return nil, errors.Errorf("empty prefix [%s]", src.Namespace)
+	}
+
+	var vals []any
+	if src.ModelType == nil || !src.repo.Loaded(prefix) {
+		var since uint64
+		if src.ModelType != nil {
+			since = src.repo.Removals(prefix)
+		}
+
+		loaded, err := fetchPrefix(ctx, src, prefix)
+		if err != nil {
+			return nil, err
+		}
+		vals = loaded
+
+		if src.ModelType != nil {
+			src.repo.MarkLoaded(prefix, since)
+		}
+	}
+
+	// 合并缓存中已保存但尚未入库的记录
+	if src.ModelType != nil {
+		cached, _ := src.repo.GetAll(prefix)
+		seen := make(map[any]struct{}, len(vals))
+		for _, v := range vals {
+			seen[v] = struct{}{}
+		}
+		for _, v := range cached {
+			if _, ok := seen[v]; !ok && v != nil {
+				vals = append(vals, v)
+			}
+		}
+	}
+
+	if src.PKComparator != nil {
+		sort.Slice(vals, func(i, j int) bool {
+			return src.PKComparator(src.PKOf(vals[i]), src.PKOf(vals[j])) < 0
+		})
+	}
+
+	rets := make([]T, 0, len(vals))
+	for _, v := range vals {
+		if r, ok := v.(Record); ok && r.Lifecycle() == LifecycleDeleted {
+			continue
+		}
+
+		ret, ok := v.(T)
+		if !ok {
+			return nil, errors.Errorf("invalid result type %T for [%s]", v, src.Namespace)
+		}
+		rets = append(rets, ret)
+	}
+	return rets, nil
+}
+
+func fetchPrefix(ctx context.Context, src *Source, prefix PK) ([]any, error) {
+	q, err := FilterQuery(prefix.FetchFilter())
+	if err != nil {
+		return nil, err
+	}
+
+	curr, err := src.Table().Find(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = curr.Close(ctx)
+	}()
+
+	var vals []any
+	for curr.Next(ctx) {
+		v, err := decodeFound(ctx, src, curr)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+// Find 按条件查询记录
+// 缓存中已有的对象直接返回缓存实例，并在内存中重新校验条件，本地已修改为不满足条件或已删除的对象不会返回；
+// 只查询数据库，本地新建或修改为满足条件、尚未入库的对象不会返回
+func Find[T Record](ctx context.Context, q *Query) ([]T, error) {
+	return FindS[T](ctx, getTypeSource[T](), q)
+}
+
+// FindS 根据源按条件查询记录
+// 以数据库中的数据为准，尚未入库的新建或修改不会被查到；缓存中的对象在内存中重新校验条件，
+// 不再满足条件的被过滤，分页在过滤之后进行，不足一页时继续向驱动读取下一段
+func FindS[T any](ctx context.Context, src *Source, q *Query) ([]T, error) {
+	if q == nil {
+		q = NewQuery()
+	}
+
+	// 向驱动查询时不跳过，每段读取 Skip+Size 条
+	page := *q
+	page.Skip = 0
+	if q.Size > 0 {
+		page.Size = q.Skip + q.Size
+	}
+
+	var rets []T
+	skip := q.Skip
+	for {
+		fetched, err := findPage(ctx, src, &page, func(ret T) bool {
+			if skip > 0 {
+				skip--
+				return true
+			}
+			rets = append(rets, ret)
+			return q.Size <= 0 || len(rets) < q.Size
+		})
+		if err != nil {
+			return nil, err
+		}
+		if q.Size <= 0 || len(rets) >= q.Size || fetched < page.Size {
+			return rets, nil
+		}
+		page.Skip += fetched
+	}
+}
+
+// findPage 读取一段查询结果，校验条件后交给 yield，yield 返回 false 时停止，返回从驱动读取的条数
+func findPage[T any](ctx context.Context, src *Source, q *Query, yield func(T) bool) (int, error) {
+	curr, err := src.Table().Find(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		_ = curr.Close(ctx)
+	}()
+
+	cc := getCacheContext(ctx)
+	fetched := 0
+	for curr.Next(ctx) {
+		fetched++
+		v, err := decodeFound(ctx, src, curr)
+		if err != nil {
+			return fetched, err
+		}
+
+		// 工作单元内优先使用请求级别的读缓存
+		if cc != nil {
+			pk := src.PKOf(v)
+			if cached, ok := cc.Get(pk); ok && cached != nil {
+				v = cached
+			} else {
+				cc.Put(pk, v)
+			}
+		}
+
+		r := v.(Record)
+		if r.Lifecycle() == LifecycleDeleted || !q.Filter.match(r.Snapshoot()) {
+			continue
+		}
+
+		ret, ok := v.(T)
+		if !ok {
+			return fetched, errors.Errorf("invalid result type %T for [%s]", v, src.Namespace)
+		}
+		if !yield(ret) {
+			break
+		}
+	}
+
+	return fetched, nil
+}
+
+// decodeFound 解码查询结果，Model 类型在缓存未命中时放入缓存
+func decodeFound(ctx context.Context, src *Source, curr RecordCursor) (any, error) {
+	if src.ModelType == nil {
+		val := reflect.New(src.RecordType)
+		if err := curr.Decode(val.Interface()); err != nil {
+			return nil, err
+		}
+
+		if record, ok := val.Interface().(Record); ok {
+			header := record.GetHeader()
+			header.LoadComplete()
+			header.Init(LifecycleNormal)
+		}
+		return val.Interface(), nil
+	}
+
+	val := reflect.New(src.ModelType.Elem())
+	if err := curr.Decode(val.Interface()); err != nil {
+		return nil, err
+	}
+
+	ret := val.Interface()
+	pk := src.PKOf(ret)
+	if cached, ok := src.repo.Get(pk); ok {
+		if cached != nil {
+			return cached, nil
+		}
+		// 缓存中"不存在"的占位已经过时
+		src.repo.Expire(pk)
+	}
+	return attachModel(ctx, src, pk, ret), nil
 }
 
-// Save 保存记录
-func Save(ctx context.Context, v MutableRecord) {
-	suc, lif, changes := save(ctx, v, false)
+// Count 按条件统计记录数，以数据库中的数据为准，尚未入库的修改不计入
+func Count[T Record](ctx context.Context, q *Query) (int64, error) {
+	return CountS(ctx, getTypeSource[T](), q)
+}
 
-	if suc {
-		// 可以在这里触发保存事件
-		_ = lif
-		_ = changes
+// CountS 根据源按条件统计记录数
+func CountS(ctx context.Context, src *Source, q *Query) (int64, error) {
+	if q == nil {
+		q = NewQuery()
 	}
+	return src.Table().Count(ctx, q)
 }
 
-func save(ctx context.Context, v MutableRecord, locked bool) (bool, Lifecycle, FieldSet) {
+// Save 保存记录，模型已经过时时返回 ErrStale，修改不会入库
+// 如果 ctx 绑定了工作单元，记录只会被暂存，在 Flush 时统一提交
+func Save(ctx context.Context, v MutableRecord) error {
+	if getBufferContext(ctx).buffering() {
+		Stash(ctx, v)
+		return nil
+	}
+
+	// 变更事件在提交入库后由保存协程发布，见 Subscribe
+	return save(ctx, v, false)
+}
+
+func save(ctx context.Context, v MutableRecord, locked bool) error {
 	src := v.Source()
 	if m, ok := v.(Model); ok {
 		// 在 actor 模型中不需要锁，直接检查过期状态
 		if m.GetHeader().IsExpired() {
-			return false, 0, 0
+			return nil
 		}
 
-		if !m.GetHeader().IsMirror() && m.Lifecycle() == LifecycleNormal {
+		if !m.GetHeader().IsMirror() && (m.Lifecycle() == LifecycleNew || m.Lifecycle() == LifecycleNormal) {
 			var volatile bool
 			if vol, ok := m.(Volatile); ok {
 				volatile = vol.IsVolatile()
 			}
 
 			if !src.repo.SetOnStore(src.PKOf(v), m, volatile) {
-				panic(errors.Wrapf(ErrDup, "duplicated [%s]: %s", src.Namespace, PKOf(m)))
+				if m.Lifecycle() == LifecycleNew {
+					panic(errors.Wrapf(ErrDup, "duplicated [%s]: %s", src.Namespace, PKOf(m)))
+				}
+
+				// 逐出后仍被持有的旧引用（Repo 中已经是重新加载的对象），或者已被标记为过时
+				clog.Errorf("[xdb] save of stale model rejected, reload it before saving. [ns = %s, pk = %s]", src.Namespace, PKOf(m))
+				return errors.Wrapf(ErrStale, "[%s] %s", src.Namespace, PKOf(m))
 			}
 		}
+
+		if !m.GetHeader().IsMirror() && v.Dirty() {
+			src.indexUniques(m)
+		}
 	}
 
 	if !v.Dirty() {
-		return false, 0, 0
+		return nil
 	}
 
 	lif := v.Lifecycle()
@@ -467,9 +934,14 @@ func save(ctx context.Context, v MutableRecord, locked bool) (bool, Lifecycle, F
 		if si >= -1 {
 			v.SetSavingIndex(si)
 		}
+
+		// 关键字段不等待刷新间隔，节点宕机时不会丢失
+		if src.Critical(lif, changes) {
+			src.saver.Flush(PKOf(v))
+		}
 	}
 
-	return true, lif, changes
+	return nil
 }
 
 // Sync 同步等待数据入库
@@ -479,7 +951,14 @@ func Sync(ctx context.Context, v Record) error {
 		return errors.Errorf("unregistered RecordSource for %s", reflect.TypeOf(v).Name())
 	}
 
-	Save(ctx, v.(MutableRecord))
+	// 同步入库不经过工作单元的缓冲
+	mr := v.(MutableRecord)
+	if b := getBufferContext(ctx); b != nil {
+		b.Unstash(mr)
+	}
+	if err := save(ctx, mr, false); err != nil {
+		return err
+	}
 	if src.saver != nil {
 		src.saver.Sync(PKOf(v))
 	}
@@ -492,6 +971,9 @@ func Stop(ctx context.Context) {
 		if src.saver != nil {
 			src.saver.Close()
 		}
+		if src.repo != nil {
+			src.repo.Close()
+		}
 	}
 
 	waitGroup.Wait()